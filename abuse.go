@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 封禁原因
+const (
+	banReasonErrorRate = "error_rate" // 错误率超过阈值
+	banReasonMalformed = "malformed"  // 反复发送无效请求体
+	banReasonHoneypot  = "honeypot"   // 访问蜜罐路径
+	banReasonManual    = "manual"     // 通过管理接口封禁
+)
+
+// AbuseGuard 按客户端 IP 统计事件端点的请求结果，超过阈值时临时封禁
+type AbuseGuard struct {
+	window         time.Duration
+	errorRate      float64 // 窗口内 4xx 响应占比阈值，0 表示不检查
+	minRequests    int     // 计算错误率所需的最少请求数
+	malformedLimit int     // 窗口内 400/422 响应数阈值，0 表示不检查
+	banDuration    time.Duration
+	honeypots      []string
+	logger         *slog.Logger
+
+	mu       sync.Mutex
+	counters map[string]*abuseCounter
+	bans     map[string]*ban
+
+	bansTotal    atomic.Int64
+	blockedTotal atomic.Int64
+}
+
+// abuseCounter 单个 IP 在当前窗口内的请求统计
+type abuseCounter struct {
+	windowStart time.Time
+	requests    int
+	errors      int
+	malformed   int
+}
+
+// ban 封禁记录
+type ban struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// newAbuseGuard 从环境变量创建滥用检测，未配置任何阈值或蜜罐路径时返回 nil
+func newAbuseGuard(logger *slog.Logger) (*AbuseGuard, error) {
+	errorRate, err := strconv.ParseFloat(getEnv("ABUSE_ERROR_RATE", "0"), 64)
+	if err != nil || errorRate < 0 || errorRate > 1 {
+		return nil, fmt.Errorf("ABUSE_ERROR_RATE 无效: %q（应为 0～1）", getEnv("ABUSE_ERROR_RATE", ""))
+	}
+	malformed, err := strconv.Atoi(getEnv("ABUSE_MALFORMED_LIMIT", "0"))
+	if err != nil || malformed < 0 {
+		return nil, fmt.Errorf("ABUSE_MALFORMED_LIMIT 无效: %q", getEnv("ABUSE_MALFORMED_LIMIT", ""))
+	}
+	honeypots := splitList(getEnv("ABUSE_HONEYPOT_PATHS", ""))
+	if errorRate == 0 && malformed == 0 && len(honeypots) == 0 {
+		return nil, nil
+	}
+
+	minRequests, err := strconv.Atoi(getEnv("ABUSE_MIN_REQUESTS", "20"))
+	if err != nil || minRequests <= 0 {
+		return nil, fmt.Errorf("ABUSE_MIN_REQUESTS 无效: %q", getEnv("ABUSE_MIN_REQUESTS", ""))
+	}
+	window, err := parsePositiveSeconds("ABUSE_WINDOW_SECONDS", "60")
+	if err != nil {
+		return nil, err
+	}
+	banDuration, err := parsePositiveSeconds("ABUSE_BAN_SECONDS", "600")
+	if err != nil {
+		return nil, err
+	}
+
+	return &AbuseGuard{
+		window:         window,
+		errorRate:      errorRate,
+		minRequests:    minRequests,
+		malformedLimit: malformed,
+		banDuration:    banDuration,
+		honeypots:      honeypots,
+		logger:         logger,
+		counters:       make(map[string]*abuseCounter),
+		bans:           make(map[string]*ban),
+	}, nil
+}
+
+// middleware 拒绝已封禁 IP 的请求，并按响应状态更新统计
+func (g *AbuseGuard) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if g.banned(ip) {
+			g.blockedTotal.Add(1)
+			abortWithError(c, http.StatusForbidden, errCodeForbidden, "Forbidden", nil)
+			return
+		}
+		c.Next()
+		g.record(ip, c.Writer.Status())
+	}
+}
+
+// honeypot 蜜罐路径处理函数：正常客户端不会访问，访问者直接封禁
+// 响应与未注册路径相同，不暴露蜜罐
+func (g *AbuseGuard) honeypot(c *gin.Context) {
+	g.Ban(c.ClientIP(), banReasonHoneypot, g.banDuration)
+	notFound(c)
+}
+
+// banned 判断 IP 是否处于封禁期
+func (g *AbuseGuard) banned(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.bans[ip]
+	if !ok {
+		return false
+	}
+	if time.Now().After(b.ExpiresAt) {
+		delete(g.bans, ip)
+		return false
+	}
+	return true
+}
+
+// record 记录一次请求结果，超过阈值时封禁
+// 429（配额）和 5xx 不是客户端的问题，不计入错误
+func (g *AbuseGuard) record(ip string, status int) {
+	now := time.Now()
+	isError := status >= 400 && status < 500 && status != http.StatusTooManyRequests
+	isMalformed := status == http.StatusBadRequest || status == http.StatusUnprocessableEntity
+
+	g.mu.Lock()
+	cnt, ok := g.counters[ip]
+	if !ok || now.Sub(cnt.windowStart) >= g.window {
+		if !ok {
+			// 新 IP 进入时顺带清理过期的统计
+			for k, v := range g.counters {
+				if now.Sub(v.windowStart) >= g.window {
+					delete(g.counters, k)
+				}
+			}
+		}
+		cnt = &abuseCounter{windowStart: now}
+		g.counters[ip] = cnt
+	}
+	cnt.requests++
+	if isError {
+		cnt.errors++
+	}
+	if isMalformed {
+		cnt.malformed++
+	}
+
+	var reason string
+	switch {
+	case g.malformedLimit > 0 && cnt.malformed >= g.malformedLimit:
+		reason = banReasonMalformed
+	case g.errorRate > 0 && cnt.requests >= g.minRequests && float64(cnt.errors)/float64(cnt.requests) >= g.errorRate:
+		reason = banReasonErrorRate
+	}
+	if reason != "" {
+		delete(g.counters, ip)
+	}
+	g.mu.Unlock()
+
+	if reason != "" {
+		g.Ban(ip, reason, g.banDuration)
+	}
+}
+
+// Ban 封禁 IP，已封禁时更新原因和到期时间
+func (g *AbuseGuard) Ban(ip, reason string, d time.Duration) {
+	expires := time.Now().Add(d)
+	g.mu.Lock()
+	g.bans[ip] = &ban{IP: ip, Reason: reason, ExpiresAt: expires}
+	g.mu.Unlock()
+	g.bansTotal.Add(1)
+	g.logger.Warn("封禁客户端 IP", "ip", ip, "reason", reason, "until", expires.Format(time.DateTime))
+}
+
+// Unban 解除封禁，IP 未被封禁时返回 false
+func (g *AbuseGuard) Unban(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.bans[ip]
+	delete(g.bans, ip)
+	delete(g.counters, ip)
+	return ok
+}
+
+// Bans 返回当前生效的封禁，按到期时间排序
+func (g *AbuseGuard) Bans() []ban {
+	now := time.Now()
+	g.mu.Lock()
+	bans := make([]ban, 0, len(g.bans))
+	for ip, b := range g.bans {
+		if now.After(b.ExpiresAt) {
+			delete(g.bans, ip)
+			continue
+		}
+		bans = append(bans, *b)
+	}
+	g.mu.Unlock()
+	sort.Slice(bans, func(i, j int) bool { return bans[i].ExpiresAt.Before(bans[j].ExpiresAt) })
+	return bans
+}
+
+// Stats 返回滥用检测统计
+func (g *AbuseGuard) Stats() gin.H {
+	g.mu.Lock()
+	tracked := len(g.counters)
+	g.mu.Unlock()
+	return gin.H{
+		"banned":         len(g.Bans()),
+		"tracked_ips":    tracked,
+		"bans_total":     g.bansTotal.Load(),
+		"blocked_total":  g.blockedTotal.Load(),
+		"ban_seconds":    int(g.banDuration / time.Second),
+		"window_seconds": int(g.window / time.Second),
+	}
+}
+
+// bansHandler 返回当前封禁列表
+func (app *App) bansHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"bans": app.abuse.Bans()})
+}
+
+// banHandler 手动封禁 IP，duration_seconds 默认使用 ABUSE_BAN_SECONDS
+func (app *App) banHandler(c *gin.Context) {
+	var req struct {
+		IP              string `json:"ip"`
+		DurationSeconds int    `json:"duration_seconds"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || net.ParseIP(req.IP) == nil || req.DurationSeconds < 0 {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body: ip is required and duration_seconds must be non-negative", nil)
+		return
+	}
+	d := app.abuse.banDuration
+	if req.DurationSeconds > 0 {
+		d = time.Duration(req.DurationSeconds) * time.Second
+	}
+	app.abuse.Ban(req.IP, banReasonManual, d)
+	c.JSON(http.StatusOK, gin.H{"message": "IP banned."})
+}
+
+// unbanHandler 解除 IP 封禁
+func (app *App) unbanHandler(c *gin.Context) {
+	if !app.abuse.Unban(c.Param("ip")) {
+		abortWithError(c, http.StatusNotFound, errCodeNotFound, "IP is not banned", nil)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "IP unbanned."})
+}
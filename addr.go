@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// normalizeBEAddrs 校验 BE 地址并为没有协议前缀的地址添加 http://
+// IPv6 地址需要用方括号括起（如 [::1]:8040、http://[fd00::12]:8040），链路本地地址的 zone 可直接写 [fe80::1%eth0]:8040
+func normalizeBEAddrs(addrs []string) ([]string, error) {
+	out := make([]string, len(addrs))
+	for i, addr := range addrs {
+		normalized, err := normalizeBEAddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = normalized
+	}
+	return out, nil
+}
+
+func normalizeBEAddr(addr string) (string, error) {
+	raw := strings.TrimSpace(addr)
+	if !strings.HasPrefix(raw, "http://") && !strings.HasPrefix(raw, "https://") {
+		if strings.Contains(raw, "://") {
+			return "", fmt.Errorf("BE 地址 %q 的协议无效（可选 http、https）", addr)
+		}
+		raw = "http://" + raw
+	}
+	// URL 中 zone 的 % 需要编码为 %25
+	if i := strings.Index(raw, "%"); i >= 0 && strings.Contains(raw, "[") && !strings.HasPrefix(raw[i:], "%25") {
+		raw = raw[:i] + "%25" + raw[i+1:]
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("BE 地址无效: %q", addr)
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("BE 地址 %q 不能包含用户名、查询参数或片段", addr)
+	}
+	// 没有方括号时无法区分 IPv6 地址和端口，如 ::1:8040
+	if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+		return "", fmt.Errorf("BE 地址 %q 中的 IPv6 地址需要用方括号括起，如 [::1]:8040", addr)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return "", fmt.Errorf("BE 地址 %q 缺少主机名", addr)
+	}
+	if strings.HasPrefix(u.Host, "[") {
+		ip, _, _ := strings.Cut(host, "%")
+		if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() != nil {
+			return "", fmt.Errorf("BE 地址 %q 的方括号中不是 IPv6 地址", addr)
+		}
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("BE 地址 %q 的端口无效", addr)
+		}
+	} else if strings.HasSuffix(u.Host, ":") {
+		return "", fmt.Errorf("BE 地址 %q 的端口无效", addr)
+	}
+	// 去掉末尾的 /，请求路径直接拼接在地址之后
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// listenConfig 返回监听的网络和地址，LISTEN_ADDR 默认为 :8080
+// 主机为空时同时监听 IPv4 和 IPv6（双栈），IPv4 地址（如 0.0.0.0:8080）只监听 IPv4，IPv6 地址（如 [::]:8080）只监听 IPv6
+func listenConfig() (network, addr string, err error) {
+	addr = getEnv("LISTEN_ADDR", listenPort)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("无效: %q（IPv6 地址需要用方括号括起，如 [::]:8080）", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", "", fmt.Errorf("端口无效: %q", addr)
+	}
+	ip, _, _ := strings.Cut(host, "%")
+	switch parsed := net.ParseIP(ip); {
+	case parsed == nil:
+		// 主机为空或为主机名（按解析结果监听）
+		return "tcp", addr, nil
+	case parsed.To4() != nil:
+		return "tcp4", addr, nil
+	default:
+		return "tcp6", addr, nil
+	}
+}
+
+// localURL 返回通过 ln 的监听地址访问本服务的 URL，通配地址使用 localhost
+func localURL(ln net.Addr, path string) string {
+	tcp, ok := ln.(*net.TCPAddr)
+	if !ok {
+		return path
+	}
+	host := "localhost"
+	if tcp.IP != nil && !tcp.IP.IsUnspecified() {
+		host = tcp.IP.String()
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(tcp.Port)) + path
+}
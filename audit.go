@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"doris-webhook/dorisload"
+)
+
+// 审计记录的输出方式
+const (
+	auditSinkFile  = "file"  // 以 NDJSON 追加写入本地文件
+	auditSinkDoris = "doris" // 缓冲后写入同一数据库中的审计表
+)
+
+// 审计记录的写入结果
+const (
+	auditOutcomeSuccess   = "success"   // 事务已提交
+	auditOutcomeDuplicate = "duplicate" // label 已存在，数据此前已提交
+	auditOutcomeFailed    = "failed"
+)
+
+// auditMaxBuffered 写入审计表失败时最多缓冲的记录数，超过时丢弃最早的记录
+const auditMaxBuffered = 100000
+
+// auditColumns 审计表的列，与 auditRecord 的 JSON 字段一致
+var auditColumns = []string{
+	"audit_time", "request_id", "principal", "endpoint", "target_table", "label", "txn_id",
+	"attempt", "rows", "loaded_rows", "outcome", "error", "duration_ms",
+}
+
+// auditRecord 一次 Stream Load 尝试的审计记录
+// 批量写入时一次 Stream Load 包含多个请求，request_id、principal、endpoint 为去重后逗号分隔的列表
+type auditRecord struct {
+	Time       string `json:"audit_time"`
+	RequestID  string `json:"request_id"`
+	Principal  string `json:"principal"`
+	Endpoint   string `json:"endpoint"`
+	Table      string `json:"target_table"`
+	Label      string `json:"label"`
+	TxnID      int64  `json:"txn_id"`
+	Attempt    int    `json:"attempt"`
+	Rows       int    `json:"rows"`
+	LoadedRows int64  `json:"loaded_rows"`
+	Outcome    string `json:"outcome"`
+	Error      string `json:"error"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// loadSource 写入的来源，随上下文传递到 Stream Load 尝试，用于审计
+type loadSource struct {
+	RequestID string
+	Principal string // 调用方标识，见 principalKey
+	Endpoint  string
+}
+
+type loadSourceKey struct{}
+
+// withLoadSource 返回携带写入来源的上下文
+func withLoadSource(ctx context.Context, src loadSource) context.Context {
+	return context.WithValue(ctx, loadSourceKey{}, src)
+}
+
+// loadSources 返回写入的来源：批量写入时为批次中各请求的来源，否则为上下文携带的来源
+// WAL 回放等没有来源的写入返回空
+func loadSources(ctx context.Context) []loadSource {
+	ctxs := dorisload.SubmitContexts(ctx)
+	if ctxs == nil {
+		ctxs = []context.Context{ctx}
+	}
+	var sources []loadSource
+	for _, c := range ctxs {
+		if src, ok := c.Value(loadSourceKey{}).(loadSource); ok {
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+// AuditLog 写入审计：每次 Stream Load 尝试（含重试和失败）记录谁在何时写入了哪张表的哪个事务
+type AuditLog struct {
+	sink   string
+	logger *slog.Logger
+
+	mu   sync.Mutex
+	path string
+	file *os.File // file 输出
+
+	// doris 输出
+	table    *dorisload.Table
+	interval time.Duration
+	dc       *dorisload.Client
+	buf      [][]byte
+	dropped  int64
+	done     chan struct{}
+	exit     chan struct{}
+}
+
+// newAuditLog 按 AUDIT_SINK 创建审计日志，未设置时返回 nil
+func newAuditLog(logger *slog.Logger) (*AuditLog, error) {
+	a, err := loadAuditConfig(logger)
+	if a == nil || err != nil {
+		return nil, err
+	}
+	if a.sink == auditSinkFile {
+		f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("打开审计文件失败: %w", err)
+		}
+		a.file = f
+	}
+	return a, nil
+}
+
+// loadAuditConfig 读取 AUDIT_* 环境变量，不打开审计文件；未设置 AUDIT_SINK 时返回 nil
+func loadAuditConfig(logger *slog.Logger) (*AuditLog, error) {
+	a := &AuditLog{sink: getEnv("AUDIT_SINK", ""), logger: logger}
+	switch a.sink {
+	case "":
+		return nil, nil
+	case auditSinkFile:
+		a.path = getEnv("AUDIT_FILE", "")
+		if a.path == "" {
+			return nil, fmt.Errorf("AUDIT_SINK=file 需要设置 AUDIT_FILE")
+		}
+	case auditSinkDoris:
+		name := getEnv("AUDIT_TABLE", "")
+		if name == "" {
+			return nil, fmt.Errorf("AUDIT_SINK=doris 需要设置 AUDIT_TABLE")
+		}
+		if err := dorisload.ValidateIdentifier(name); err != nil {
+			return nil, fmt.Errorf("AUDIT_TABLE 无效: %w", err)
+		}
+		interval, err := parsePositiveSeconds("AUDIT_FLUSH_INTERVAL", "5")
+		if err != nil {
+			return nil, err
+		}
+		a.table = &dorisload.Table{Name: name, Columns: auditColumns}
+		a.interval = interval
+		a.done = make(chan struct{})
+		a.exit = make(chan struct{})
+	default:
+		return nil, fmt.Errorf("AUDIT_SINK 无效: %q（可选 file、doris）", a.sink)
+	}
+	return a, nil
+}
+
+// Start 启动写入审计表的后台任务，file 输出时无操作
+func (a *AuditLog) Start(dc *dorisload.Client) {
+	if a.sink != auditSinkDoris {
+		return
+	}
+	a.dc = dc
+	go a.run()
+}
+
+// middleware 在请求上下文中记录写入来源，放在鉴权之后以取得调用方标识
+func (a *AuditLog) middleware(ep *Endpoint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(withLoadSource(c.Request.Context(), loadSource{
+			RequestID: c.GetString(requestIDKey),
+			Principal: c.GetString(principalKey),
+			Endpoint:  ep.Name,
+		}))
+		c.Next()
+	}
+}
+
+// Record 记录一次 Stream Load 尝试，作为 dorisload.Config.OnAttempt
+func (a *AuditLog) Record(ctx context.Context, at *dorisload.Attempt) {
+	// 审计表自身的写入不记录，避免递归
+	if a.table != nil && at.Table == a.table.Name {
+		return
+	}
+
+	rec := auditRecord{
+		Time:       time.Now().Format(dorisDatetimeFormat),
+		Table:      at.Table,
+		Label:      at.Label,
+		Attempt:    at.Number,
+		Rows:       at.Rows,
+		Outcome:    auditOutcomeSuccess,
+		DurationMs: at.Duration.Milliseconds(),
+	}
+	var requestIDs, principals, endpoints []string
+	for _, src := range loadSources(ctx) {
+		requestIDs = appendUnique(requestIDs, src.RequestID)
+		principals = appendUnique(principals, src.Principal)
+		endpoints = appendUnique(endpoints, src.Endpoint)
+	}
+	rec.RequestID = strings.Join(requestIDs, ",")
+	rec.Principal = strings.Join(principals, ",")
+	rec.Endpoint = strings.Join(endpoints, ",")
+	if at.Resp != nil {
+		rec.TxnID = at.Resp.TxnID
+		rec.LoadedRows = at.Resp.NumberLoadedRows
+	}
+	switch {
+	case errors.Is(at.Err, dorisload.ErrLabelAlreadyExists):
+		rec.Outcome = auditOutcomeDuplicate
+	case at.Err != nil:
+		rec.Outcome = auditOutcomeFailed
+		rec.Error = at.Err.Error()
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		a.logger.Error("序列化审计记录失败", "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		if _, err := a.file.Write(line); err != nil {
+			a.logger.Error("写入审计文件失败", "label", at.Label, "error", err)
+		}
+		return
+	}
+	a.buf = append(a.buf, line)
+	if len(a.buf) > auditMaxBuffered {
+		a.dropped += int64(len(a.buf) - auditMaxBuffered)
+		a.buf = slices.Delete(a.buf, 0, len(a.buf)-auditMaxBuffered)
+	}
+}
+
+// appendUnique 追加非空且不重复的值
+func appendUnique(values []string, v string) []string {
+	if v == "" || slices.Contains(values, v) {
+		return values
+	}
+	return append(values, v)
+}
+
+// run 定期将缓冲的审计记录写入审计表
+func (a *AuditLog) run() {
+	defer close(a.exit)
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.flush()
+		case <-a.done:
+			a.flush()
+			return
+		}
+	}
+}
+
+// flush 写入缓冲的审计记录，失败时放回缓冲区等待下次写入
+func (a *AuditLog) flush() {
+	a.mu.Lock()
+	lines, dropped := a.buf, a.dropped
+	a.buf, a.dropped = nil, 0
+	a.mu.Unlock()
+	if dropped > 0 {
+		a.logger.Error("审计记录缓冲已满，丢弃最早的记录", "dropped", dropped)
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	// 失败的分片放回缓冲区，下次随新的记录以新的 label 写入
+	label := "audit-" + uuid.New().String()
+	for _, ch := range a.dc.WriteLinesSplit(context.Background(), a.table, label, lines, a.logger) {
+		if ch.Err != nil && !errors.Is(ch.Err, dorisload.ErrLabelAlreadyExists) {
+			a.logger.Error("写入审计表失败，稍后重试", "table", a.table.Name, "rows", ch.End-ch.Start, "error", ch.Err)
+			a.mu.Lock()
+			a.buf = append(lines[ch.Start:ch.End:ch.End], a.buf...)
+			a.mu.Unlock()
+		}
+	}
+}
+
+// Close 写入剩余的审计记录并关闭输出
+func (a *AuditLog) Close() error {
+	if a.file != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return a.file.Close()
+	}
+	if a.dc != nil {
+		close(a.done)
+		<-a.exit
+	}
+	return nil
+}
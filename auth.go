@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 端点鉴权方式
+const (
+	authNone   = "none"    // 不鉴权（默认）
+	authAPIKey = "api_key" // 请求头携带预共享的 API Key
+	authHMAC   = "hmac"    // 请求头携带请求体的 HMAC-SHA256 签名
+	authJWT    = "jwt"     // Authorization: Bearer <JWT>
+	authSigned = "signed"  // 第一方 SDK 请求签名：时间戳 + nonce + HMAC，防重放
+)
+
+// principalKey 鉴权通过后调用方标识在 gin 上下文中的键，用于审计
+// api_key 为 Key 的 SHA-256 指纹（前 12 位十六进制，不记录 Key 本身），signed 为密钥 ID，jwt 为 sub 声明；
+// hmac 为共享密钥，没有调用方标识
+const principalKey = "principal"
+
+// AuthConfig 端点的鉴权配置，密钥从环境变量读取
+type AuthConfig struct {
+	Type string `yaml:"type" json:"type"` // none、api_key、hmac、jwt、signed
+
+	Header    string `yaml:"header,omitempty" json:"header,omitempty"`         // api_key/hmac 的请求头，默认 X-API-Key/X-Signature
+	KeysEnv   string `yaml:"keys_env,omitempty" json:"keys_env,omitempty"`     // api_key：存放 Key 列表（逗号分隔）的环境变量名；signed：key_id:secret 列表
+	MaxSkew   string `yaml:"max_skew,omitempty" json:"max_skew,omitempty"`     // signed：允许的时钟偏差，默认 5m
+	SecretEnv string `yaml:"secret_env,omitempty" json:"secret_env,omitempty"` // hmac/jwt(HS256)：存放密钥的环境变量名
+
+	PublicKeyFile string   `yaml:"public_key_file,omitempty" json:"public_key_file,omitempty"` // jwt：RS256/ES256 公钥（PEM）
+	Issuer        string   `yaml:"issuer,omitempty" json:"issuer,omitempty"`
+	Audience      string   `yaml:"audience,omitempty" json:"audience,omitempty"`
+	Algorithms    []string `yaml:"algorithms,omitempty" json:"algorithms,omitempty"` // 默认按密钥类型选择
+}
+
+// validateAuth 校验端点的鉴权配置
+func validateAuth(a *AuthConfig) error {
+	switch a.Type {
+	case authNone:
+	case authAPIKey:
+		if a.KeysEnv == "" {
+			return fmt.Errorf("auth: api_key 需要设置 keys_env")
+		}
+	case authHMAC:
+		if a.SecretEnv == "" {
+			return fmt.Errorf("auth: hmac 需要设置 secret_env")
+		}
+	case authJWT:
+		if (a.SecretEnv == "") == (a.PublicKeyFile == "") {
+			return fmt.Errorf("auth: jwt 需要设置 secret_env 或 public_key_file 之一")
+		}
+	case authSigned:
+		if a.KeysEnv == "" {
+			return fmt.Errorf("auth: signed 需要设置 keys_env")
+		}
+	default:
+		return fmt.Errorf("auth: type 无效: %q（可选 none、api_key、hmac、jwt、signed）", a.Type)
+	}
+	return nil
+}
+
+// newEndpointAuth 创建端点鉴权中间件，不需要鉴权时返回 nil
+// 密钥在启动时读取，缺失时返回错误
+func newEndpointAuth(a *AuthConfig, nonces NonceStore) (gin.HandlerFunc, error) {
+	if a == nil {
+		return nil, nil
+	}
+	switch a.Type {
+	case authAPIKey:
+		keys := splitList(os.Getenv(a.KeysEnv))
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("环境变量 %s 未设置", a.KeysEnv)
+		}
+		return apiKeyAuth(defaultString(a.Header, "X-API-Key"), keys), nil
+	case authHMAC:
+		secret := os.Getenv(a.SecretEnv)
+		if secret == "" {
+			return nil, fmt.Errorf("环境变量 %s 未设置", a.SecretEnv)
+		}
+		return hmacAuth(defaultString(a.Header, "X-Signature"), []byte(secret)), nil
+	case authJWT:
+		return newJWTAuth(a)
+	case authSigned:
+		return newSignedAuth(a, nonces)
+	}
+	return nil, nil
+}
+
+// unauthorized 返回 401
+func unauthorized(c *gin.Context) {
+	abortWithError(c, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized", nil)
+}
+
+// apiKeyAuth 校验请求头中的 API Key，支持配置多个 Key 以便轮换
+func apiKeyAuth(header string, keys []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got := []byte(c.GetHeader(header))
+		for _, key := range keys {
+			if subtle.ConstantTimeCompare(got, []byte(key)) == 1 {
+				c.Set(principalKey, "api_key:"+sha256Hex([]byte(key))[:12])
+				c.Next()
+				return
+			}
+		}
+		unauthorized(c)
+	}
+}
+
+// hmacAuth 校验请求体的 HMAC-SHA256 签名，签名为十六进制，可带 sha256= 前缀
+func hmacAuth(header string, secret []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sig, err := hex.DecodeString(strings.TrimPrefix(c.GetHeader(header), "sha256="))
+		if err != nil || len(sig) == 0 {
+			unauthorized(c)
+			return
+		}
+		body, ok := readBody(c)
+		if !ok {
+			return
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			unauthorized(c)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+// newJWTAuth 创建 JWT 鉴权中间件：HS256 使用 secret_env，RS256/ES256 使用 public_key_file
+// 设置 issuer/audience 时校验对应声明，exp/nbf 存在时校验有效期
+func newJWTAuth(a *AuthConfig) (gin.HandlerFunc, error) {
+	var (
+		key  any
+		algs = a.Algorithms
+	)
+	if a.SecretEnv != "" {
+		secret := os.Getenv(a.SecretEnv)
+		if secret == "" {
+			return nil, fmt.Errorf("环境变量 %s 未设置", a.SecretEnv)
+		}
+		key = []byte(secret)
+		if len(algs) == 0 {
+			algs = []string{"HS256"}
+		}
+	} else {
+		pem, err := os.ReadFile(a.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 public_key_file 失败: %w", err)
+		}
+		if k, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+			key = k
+			if len(algs) == 0 {
+				algs = []string{"RS256"}
+			}
+		} else if k, err := jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+			key = k
+			if len(algs) == 0 {
+				algs = []string{"ES256"}
+			}
+		} else {
+			return nil, fmt.Errorf("public_key_file 不是有效的 RSA/EC 公钥")
+		}
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(algs)}
+	if a.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.Issuer))
+	}
+	if a.Audience != "" {
+		opts = append(opts, jwt.WithAudience(a.Audience))
+	}
+	parser := jwt.NewParser(opts...)
+	keyFunc := func(*jwt.Token) (any, error) { return key, nil }
+
+	return func(c *gin.Context) {
+		raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			unauthorized(c)
+			return
+		}
+		token, err := parser.Parse(raw, keyFunc)
+		if err != nil {
+			unauthorized(c)
+			return
+		}
+		if sub, _ := token.Claims.GetSubject(); sub != "" {
+			c.Set(principalKey, "jwt:"+sub)
+		}
+		c.Next()
+	}, nil
+}
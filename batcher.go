@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"doris-webhook/dorisload"
+)
+
+// newBatchers 为每张目标表创建批量写入器，clients 返回目标表所属集群的客户端；未启用 BATCH_ENABLED 时返回 nil
+func newBatchers(clients func(*dorisload.Table) *dorisload.Client, limiter *LoadLimiter, tables []*dorisload.Table, logger *slog.Logger) (map[string]*dorisload.Batcher, error) {
+	if getEnv("BATCH_ENABLED", "false") != "true" {
+		return nil, nil
+	}
+	batchers := make(map[string]*dorisload.Batcher, len(tables))
+	for _, table := range tables {
+		b, err := newBatcher(clients(table), limiter, table, logger)
+		if err != nil {
+			return nil, err
+		}
+		batchers[table.Name] = b
+	}
+	return batchers, nil
+}
+
+// newBatcher 根据环境变量创建写入指定表的批量写入器
+func newBatcher(dc *dorisload.Client, limiter *LoadLimiter, table *dorisload.Table, logger *slog.Logger) (*dorisload.Batcher, error) {
+	ints := map[string]int{
+		"BATCH_MIN_ROWS":   1,
+		"BATCH_MAX_ROWS":   1000,
+		"BATCH_QUEUE_SIZE": 10000,
+	}
+	for key, def := range ints {
+		v, err := strconv.Atoi(getEnv(key, strconv.Itoa(def)))
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("%s 无效: %q", key, getEnv(key, ""))
+		}
+		ints[key] = v
+	}
+	// 不带单位时按毫秒计
+	durations := map[string]string{
+		"BATCH_MIN_INTERVAL_MS":   "10",
+		"BATCH_MAX_INTERVAL_MS":   "1000",
+		"BATCH_TARGET_LATENCY_MS": "200",
+	}
+	intervals := make(map[string]time.Duration, len(durations))
+	for key, def := range durations {
+		d, err := envDuration(key, def, time.Millisecond, time.Millisecond, time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		intervals[key] = d
+	}
+	if ints["BATCH_MIN_ROWS"] > ints["BATCH_MAX_ROWS"] {
+		return nil, fmt.Errorf("BATCH_MIN_ROWS 不能大于 BATCH_MAX_ROWS")
+	}
+	if intervals["BATCH_MIN_INTERVAL_MS"] > intervals["BATCH_MAX_INTERVAL_MS"] {
+		return nil, fmt.Errorf("BATCH_MIN_INTERVAL_MS 不能大于 BATCH_MAX_INTERVAL_MS")
+	}
+	fair, weights, keyQueueSize, err := loadBatchFairness(ints["BATCH_QUEUE_SIZE"])
+	if err != nil {
+		return nil, err
+	}
+
+	return dorisload.NewBatcher(dc, table, dorisload.BatchOptions{
+		MinRows:       ints["BATCH_MIN_ROWS"],
+		MaxRows:       ints["BATCH_MAX_ROWS"],
+		MinInterval:   intervals["BATCH_MIN_INTERVAL_MS"],
+		MaxInterval:   intervals["BATCH_MAX_INTERVAL_MS"],
+		TargetLatency: intervals["BATCH_TARGET_LATENCY_MS"],
+		QueueSize:     ints["BATCH_QUEUE_SIZE"],
+		Fair:          fair,
+		Weights:       weights,
+		KeyQueueSize:  keyQueueSize,
+		Acquire: func(ctx context.Context) (func(), bool) {
+			return limiter.Acquire(ctx, PriorityHigh)
+		},
+	}, logger)
+}
+
+// loadBatchFairness 读取按项目公平调度的配置：BATCH_FAIRNESS 为 true 时批量写入队列按事件的 project 分队列，
+// 按 BATCH_PROJECT_WEIGHTS（如 billing:4,default:1）加权轮询组成批次，单个项目最多排队 BATCH_PROJECT_QUEUE_SIZE 个请求
+func loadBatchFairness(queueSize int) (bool, map[string]int, int, error) {
+	if getEnv("BATCH_FAIRNESS", "false") != "true" {
+		return false, nil, 0, nil
+	}
+	weights := make(map[string]int)
+	for _, entry := range splitList(getEnv("BATCH_PROJECT_WEIGHTS", "")) {
+		project, raw, ok := strings.Cut(entry, ":")
+		w, err := strconv.Atoi(strings.TrimSpace(raw))
+		if !ok || strings.TrimSpace(project) == "" || err != nil || w <= 0 || w > 1000 {
+			return false, nil, 0, fmt.Errorf("BATCH_PROJECT_WEIGHTS 无效: %q（格式为 {项目}:{1～1000 的权重}）", entry)
+		}
+		weights[strings.TrimSpace(project)] = w
+	}
+	keyQueueSize, err := strconv.Atoi(getEnv("BATCH_PROJECT_QUEUE_SIZE", strconv.Itoa(max(queueSize/2, 1))))
+	if err != nil || keyQueueSize <= 0 || keyQueueSize > queueSize {
+		return false, nil, 0, fmt.Errorf("BATCH_PROJECT_QUEUE_SIZE 无效: %q（应在 1 到 BATCH_QUEUE_SIZE 之间）", getEnv("BATCH_PROJECT_QUEUE_SIZE", ""))
+	}
+	return true, weights, keyQueueSize, nil
+}
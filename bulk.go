@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"doris-webhook/dorisload"
+)
+
+// 批量写入模式
+const (
+	bulkModeReject  = "reject"  // 任一事件无效时拒绝整个请求，有效的批次可能拆分为多次写入（默认）
+	bulkModeAtomic  = "atomic"  // 同 reject，且整批在一个 Stream Load 事务中写入，任一行被 Doris 过滤时整批失败
+	bulkModePartial = "partial" // 跳过校验失败的事件，写入其余事件，响应中逐条报告被跳过的事件
+)
+
+// bulkEnvelope 批量写入的请求信封，也可以直接发送事件数组
+type bulkEnvelope struct {
+	SentAt     any              `json:"sent_at"`     // 客户端发送时间，日期时间字符串或 epoch 秒/毫秒
+	SDKVersion string           `json:"sdk_version"` // 写入 source 为 sdk_version 的列
+	Events     []map[string]any `json:"events"`
+}
+
+// bulkEventError 批量请求中的某个事件无效
+type bulkEventError struct {
+	Index int
+	Err   error
+}
+
+func (e *bulkEventError) Error() string { return fmt.Sprintf("events[%d]: %v", e.Index, e.Err) }
+
+func (e *bulkEventError) Unwrap() error { return e.Err }
+
+// decodeBulk 解析批量请求体：事件数组或 {sent_at, sdk_version, events} 信封
+func decodeBulk(data []byte) (*bulkEnvelope, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var env bulkEnvelope
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := dec.Decode(&env.Events); err != nil {
+			return nil, err
+		}
+		return &env, nil
+	}
+	if err := dec.Decode(&env); err != nil {
+		return nil, err
+	}
+	if env.Events == nil {
+		return nil, fmt.Errorf("events is required")
+	}
+	return &env, nil
+}
+
+// bulkHandler 返回端点的批量写入处理函数
+// 所有事件校验通过后作为一个批次写入，任一事件无效时整个请求被拒绝；partial 模式下跳过无效事件，全部无效时才拒绝
+// 信封带 sent_at 时，以服务器时间与 sent_at 之差修正 correct_skew 列的客户端时钟偏差
+func (app *App) bulkHandler(ep *Endpoint, maxEvents int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw, ok := readBody(c)
+		if !ok {
+			return
+		}
+		raw, err := ep.sanitizeBody(raw)
+		if err != nil {
+			app.rejectInvalid(c, ep, err)
+			return
+		}
+		env, err := decodeBulk(raw)
+		if err != nil {
+			app.rejectInvalid(c, ep, err)
+			return
+		}
+		if len(env.Events) == 0 {
+			app.rejectInvalid(c, ep, fmt.Errorf("events must not be empty"))
+			return
+		}
+		if len(env.Events) > maxEvents {
+			abortWithError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge,
+				fmt.Sprintf("Too many events: %d (max %d)", len(env.Events), maxEvents), gin.H{"max_events": maxEvents})
+			return
+		}
+
+		rc := RowContext{Now: time.Now(), SDKVersion: env.SDKVersion}
+		if env.SentAt != nil {
+			sentAt, ok := parseDatetime(env.SentAt)
+			if !ok {
+				app.rejectInvalid(c, ep, fmt.Errorf("sent_at: cannot parse %s as datetime", describeValue(env.SentAt)))
+				return
+			}
+			rc.ClockSkew = rc.Now.Sub(sentAt)
+		}
+
+		bulk := &bulkResult{mode: ep.BulkMode}
+		events := make([]eventRow, 0, len(env.Events))
+		for i, body := range env.Events {
+			var ev eventRow
+			err := errors.New("must be a JSON object")
+			if body != nil {
+				ev, err = app.buildEvent(c, ep, body, rc)
+			}
+			if err != nil {
+				if bulk.mode != bulkModePartial {
+					app.rejectInvalid(c, ep, &bulkEventError{Index: i, Err: err})
+					return
+				}
+				bulk.reject(i, err)
+				continue
+			}
+			events = append(events, ev)
+		}
+		if len(events) == 0 {
+			app.logger.Warn("请求验证失败", "endpoint", ep.Name, "rejected", len(bulk.rejected))
+			abortWithError(c, http.StatusUnprocessableEntity, errCodeSchemaInvalid, "All events rejected",
+				gin.H{"accepted": 0, "rejected": bulk.rejected})
+			return
+		}
+		if len(bulk.rejected) > 0 {
+			app.logger.Warn("批量请求部分事件验证失败", "endpoint", ep.Name, "accepted", len(events), "rejected", len(bulk.rejected))
+		}
+		// 信封格式与单条写入不同，http 输出目标改为接收 NDJSON 行
+		app.ingestRows(c, ep, events, nil, bulk)
+	}
+}
+
+// bulkResult 批量请求的写入模式和被跳过的事件，单条写入时为 nil
+type bulkResult struct {
+	mode     string
+	rejected []bulkRejection // partial 模式下校验失败被跳过的事件，按下标排列
+}
+
+// bulkRejection partial 模式下被跳过的事件
+type bulkRejection struct {
+	Index int    `json:"index"`
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// reject 记录一个被跳过的事件，错误码与单独写入该事件时相同
+func (b *bulkResult) reject(index int, err error) {
+	_, code, _, _ := classifyInvalid(err)
+	b.rejected = append(b.rejected, bulkRejection{Index: index, Code: code, Error: err.Error()})
+}
+
+// loadAtomic 将整批事件在一个 Stream Load 事务中写入目标表（max_filter_ratio=0、strict_mode），不合并、不拆分、不写入 WAL
+// 批次超过单次 Stream Load 上限时返回 413，无法立即写入时返回 503；写入失败时已写出错误响应，返回 false
+func (app *App) loadAtomic(c *gin.Context, ep *Endpoint, batch *SinkBatch) bool {
+	table := batch.Table
+	data := dorisload.JoinLines(batch.Lines)
+	if limit := app.config.MaxLoadBytes; int64(len(data)) > limit {
+		abortWithError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge,
+			fmt.Sprintf("Atomic batch too large: %d bytes (max %d)", len(data), limit), gin.H{"committed": false, "max_bytes": limit})
+		return false
+	}
+	if app.degraded.Load() || app.toggles.Paused(ep, table.Name) {
+		abortWithRetry(c, http.StatusServiceUnavailable, errCodeDorisUnavailable, "Atomic batch cannot be buffered, please retry later", app.recheck, retryStrategyExponential, gin.H{"committed": false})
+		return false
+	}
+
+	ctx := c.Request.Context()
+	release, ok := app.limiter.Acquire(ctx, batch.Priority)
+	if !ok {
+		abortWithRetry(c, http.StatusServiceUnavailable, errCodeOverloaded, "Service overloaded, please retry later", time.Second, retryStrategyExponential, gin.H{"committed": false})
+		return false
+	}
+	defer release()
+
+	zero := 0.0
+	batch.Label = "atomic-" + uuid.New().String()
+	resp, err := app.clusters.For(table).WriteWithOptions(ctx, table, batch.Label, data, dorisload.LoadOptions{
+		StrictMode:     true,
+		MaxFilterRatio: &zero,
+	}, app.logger)
+	if err == nil {
+		app.committed(table, data)
+		return true
+	}
+
+	details := gin.H{"committed": false, "label": batch.Label}
+	switch {
+	case ctx.Err() != nil:
+		// 超时或客户端断开时事务可能已提交，响应带上 label 便于核对
+		app.logger.Warn("原子批量写入中断", "table", table.Name, "label", batch.Label, "error", err)
+		abortWithError(c, http.StatusGatewayTimeout, errCodeTimeout, "Request timed out, transaction state unknown", gin.H{"label": batch.Label})
+	case resp != nil && resp.Status == dorisload.StatusFail:
+		// Doris 拒绝了整批（如有行被过滤），事务未提交
+		details["doris_status"], details["doris_message"] = resp.Status, resp.Message
+		if resp.ErrorURL != "" {
+			details["error_url"] = resp.ErrorURL
+		}
+		app.logger.Warn("原子批量写入被 Doris 拒绝", "table", table.Name, "label", batch.Label, "message", resp.Message)
+		abortWithError(c, http.StatusUnprocessableEntity, errCodeSchemaInvalid, "Atomic batch rejected by Doris: "+resp.Message, details)
+	case errors.Is(err, dorisload.ErrShuttingDown):
+		abortWithRetry(c, http.StatusServiceUnavailable, errCodeShuttingDown, "Service shutting down, please retry later", time.Second, retryStrategyFixed, details)
+	default:
+		app.logger.Error("原子批量写入失败", "table", table.Name, "label", batch.Label, "error", err)
+		abortWithError(c, http.StatusBadGateway, errCodeDorisUnavailable, fmt.Sprintf("Doris write failed: %v", err), details)
+	}
+	return false
+}
+
+// respondBulk 写出批量请求的成功响应：atomic 模式返回事务结果，partial 模式返回写入和跳过的事件，其他模式按端点的 response 配置
+// 直接写入拆分为多个事务时（chunks 不为空）响应体另带各分片的 label、行数和结果，不受端点 response 配置影响
+func respondBulk(c *gin.Context, ep *Endpoint, bulk *bulkResult, buffered bool, label string, rows int, chunks []loadChunk) {
+	status := http.StatusOK
+	if buffered {
+		status = http.StatusAccepted
+	}
+	switch {
+	case bulk.mode == bulkModeAtomic:
+		c.Header(loadLabelHeader, label)
+		c.JSON(http.StatusOK, gin.H{"committed": true, "label": label, "rows": rows})
+	case bulk.mode == bulkModePartial:
+		resp := gin.H{"accepted": rows, "rejected": bulk.rejected, "buffered": buffered}
+		if bulk.rejected == nil {
+			resp["rejected"] = []bulkRejection{}
+		}
+		if label != "" {
+			c.Header(loadLabelHeader, label)
+			resp["label"] = label
+		}
+		if chunks != nil {
+			resp["chunks"] = chunks
+		}
+		c.JSON(status, resp)
+	case chunks != nil:
+		c.Header(loadLabelHeader, label)
+		c.JSON(status, gin.H{"buffered": buffered, "label": label, "chunks": chunks})
+	default:
+		respondAccepted(c, ep, buffered, label)
+	}
+}
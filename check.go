@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkDNSTimeout 自检时解析单个 BE 域名的超时
+const checkDNSTimeout = 5 * time.Second
+
+// runCheck 自检模式（--check）：加载并校验全部配置、端点定义和路由，解析 BE 域名后退出
+// 不监听端口、不连接 Doris、不创建 WAL 目录或审计文件；报告发现的所有问题，有问题时返回 1，供 CD 流水线在发布前校验配置
+func runCheck(logger *slog.Logger) int {
+	failed := 0
+	check := func(name string, err error) {
+		if err != nil {
+			// 配置问题逐条输出，每条带环境变量名或字段路径
+			var errs configErrors
+			if errors.As(err, &errs) {
+				failed += len(errs)
+			} else {
+				failed++
+			}
+			logConfigErrors(logger.With("check", name).Error, "自检失败", err)
+			return
+		}
+		logger.Info("自检通过", "check", name)
+	}
+
+	cfg, err := loadConfig()
+	check("Doris 配置", err)
+	if cfg != nil {
+		check("BE 域名解析", resolveBEs(cfg.BEHTTP))
+	}
+
+	registry, err := loadRegistry()
+	check("端点配置", err)
+	if registry == nil {
+		logger.Error("自检未通过", "problems", failed)
+		return 1
+	}
+
+	// 配置文件中的集群：密码环境变量、TLS 证书和 BE 域名
+	if cfg != nil {
+		for _, cc := range registry.Clusters {
+			ccfg, err := cc.dorisConfig(cfg)
+			if err == nil {
+				err = resolveBEs(ccfg.BEHTTP)
+			}
+			check("Doris 集群 "+cc.Name, err)
+		}
+	}
+	// 端点 credentials 的密码环境变量
+	clusters := []string{defaultClusterName}
+	for _, cc := range registry.Clusters {
+		clusters = append(clusters, cc.Name)
+	}
+	for _, cluster := range clusters {
+		_, err := registry.TableCredentials(cluster)
+		check("目标表的 Doris 用户（"+cluster+"）", err)
+	}
+
+	geoip, err := newGeoIP(registry)
+	check("GeoIP", err)
+	if geoip != nil {
+		geoip.Close()
+	}
+	abuse, err := newAbuseGuard(logger)
+	check("滥用检测", err)
+	nonces, err := newNonceStore()
+	check("请求签名", err)
+	uploads, err := newUploader(registry)
+	check("文件上传", err)
+	_, err = newJobScheduler(registry, logger)
+	check("定时任务", err)
+	_, err = newSDKConfigServer(registry)
+	check("SDK 远程配置", err)
+	_, err = newQuotaManager()
+	check("配额", err)
+	wal, err := loadWALConfig(logger)
+	check("WAL", err)
+	if slices.ContainsFunc(registry.Clusters, func(cc *ClusterConfig) bool { return cc.ReplicaOf != "" }) {
+		_, _, err = loadReplicaConfig()
+		if err == nil && wal == nil {
+			err = fmt.Errorf("集群设置了 replica_of，需要设置 WAL_DIR 保存副本积压")
+		}
+		check("集群复制", err)
+	}
+	_, err = loadJournalConfig(registry, wal)
+	check("请求日志", err)
+	_, err = newLoadLimiter()
+	check("并发限制", err)
+	_, err = newPriorityRules(wal != nil)
+	check("优先级", err)
+	_, err = newToggles(registry, wal != nil, logger)
+	check("运行时开关", err)
+	_, err = newHedgePolicy()
+	check("对冲写入", err)
+	_, err = newHealthCheck()
+	check("BE 健康检查", err)
+	_, err = loadAuditConfig(logger)
+	check("审计", err)
+	_, err = loadUsageConfig(logger)
+	check("用量统计", err)
+	_, err = newExemplars()
+	check("指标 exemplar", err)
+	statsd, err := newStatsD(logger)
+	check("StatsD", err)
+	if statsd != nil {
+		statsd.conn.Close()
+	}
+	_, err = newSchemaSampler()
+	check("字段采样", err)
+	if cfg != nil {
+		_, err = newDebugLog(registry, cfg.DebugMaxBytes, logger)
+		check("调试日志", err)
+		history, err := loadHistoryConfig()
+		check("健康历史", err)
+		sinks, err := newSinks(registry.Sinks, cfg, history, logger)
+		check("输出目标", err)
+		for _, s := range sinks {
+			s.Close()
+		}
+	}
+
+	// 路由：CORS、请求超时、端点鉴权的密钥和蜜罐路径
+	gin.SetMode(gin.ReleaseMode)
+	app := &App{config: cfg, logger: logger, registry: registry, abuse: abuse, nonces: nonces, uploads: uploads}
+	_, err = app.setupRouter()
+	check("路由", err)
+	_, err = loadServerConfig()
+	check("HTTP 服务", err)
+
+	if failed > 0 {
+		logger.Error("自检未通过", "problems", failed)
+		return 1
+	}
+	logger.Info("自检通过", "endpoints", len(registry.Endpoints), "tables", len(registry.Tables))
+	return 0
+}
+
+// resolveBEs 解析 BE 地址中的域名，IP 地址不解析
+func resolveBEs(addrs []string) error {
+	for _, addr := range addrs {
+		u, err := url.Parse(addr)
+		if err != nil || u.Hostname() == "" {
+			return fmt.Errorf("BE 地址无效: %q", addr)
+		}
+		host := u.Hostname()
+		if net.ParseIP(host) != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), checkDNSTimeout)
+		_, err = net.DefaultResolver.LookupHost(ctx, host)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("解析 BE %s 失败: %w", addr, err)
+		}
+	}
+	return nil
+}
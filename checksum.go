@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求体校验和的请求头
+const (
+	contentMD5Header     = "Content-MD5"       // RFC 1864：请求体 MD5 的 base64
+	checksumSHA256Header = "X-Checksum-SHA256" // 请求体 SHA-256 的十六进制或 base64
+)
+
+// bodyChecksum 请求头中的一种校验和
+type bodyChecksum struct {
+	header string
+	size   int
+	sum    func([]byte) []byte
+	hexOK  bool // 是否接受十六进制
+}
+
+var bodyChecksums = []bodyChecksum{
+	{header: contentMD5Header, size: md5.Size, sum: func(b []byte) []byte { s := md5.Sum(b); return s[:] }},
+	{header: checksumSHA256Header, size: sha256.Size, sum: func(b []byte) []byte { s := sha256.Sum256(b); return s[:] }, hexOK: true},
+}
+
+// decode 解析请求头中的校验和，长度不符或无法解码时返回 nil
+func (bc bodyChecksum) decode(value string) []byte {
+	if bc.hexOK && len(value) == hex.EncodedLen(bc.size) {
+		if sum, err := hex.DecodeString(value); err == nil {
+			return sum
+		}
+	}
+	sum, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sum) != bc.size {
+		return nil
+	}
+	return sum
+}
+
+// verifyChecksum 请求带 Content-MD5 或 X-Checksum-SHA256 时校验请求体，不一致时返回 400 CHECKSUM_MISMATCH，
+// 避免移动网络或有问题的代理截断、改写的请求体被写入 Doris；两个请求头都带时都要一致，都不带时不校验
+// 校验的是收到的原始字节，请求体经过压缩时校验和应按压缩后的内容计算
+func verifyChecksum(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var checks []bodyChecksum
+		for _, bc := range bodyChecksums {
+			if c.GetHeader(bc.header) != "" {
+				checks = append(checks, bc)
+			}
+		}
+		if len(checks) == 0 {
+			c.Next()
+			return
+		}
+
+		body, ok := readBody(c)
+		if !ok {
+			return
+		}
+		for _, bc := range checks {
+			want := bc.decode(c.GetHeader(bc.header))
+			if want == nil {
+				abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid "+bc.header+" header", gin.H{"header": bc.header})
+				return
+			}
+			if subtle.ConstantTimeCompare(want, bc.sum(body)) != 1 {
+				logger.Warn("请求体校验和不一致", "path", c.FullPath(), "header", bc.header, "bytes", len(body), "client_ip", c.ClientIP())
+				// 请求体在传输中损坏，重新发送即可
+				abortWithRetry(c, http.StatusBadRequest, errCodeChecksumMismatch, "Request body does not match "+bc.header, time.Second, retryStrategyExponential, gin.H{
+					"header": bc.header,
+					"bytes":  len(body),
+				})
+				return
+			}
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+
+	"doris-webhook/dorisload"
+)
+
+// defaultClusterName 由 DORIS_* 环境变量定义的集群，端点未设置 cluster 时写入该集群
+const defaultClusterName = "default"
+
+// ClusterConfig 配置文件中的 Doris 集群定义，写入预算、重试和调试选项沿用 DORIS_* 环境变量
+type ClusterConfig struct {
+	Name        string      `yaml:"name" json:"name"`
+	BEHTTP      []string    `yaml:"be_http" json:"be_http"`
+	Database    string      `yaml:"database,omitempty" json:"database,omitempty"` // 默认使用 DORIS_DATABASE
+	User        string      `yaml:"user,omitempty" json:"user,omitempty"`         // 默认使用 DORIS_USER
+	PasswordEnv string      `yaml:"password_env" json:"password_env"`             // 存放密码的环境变量名
+	TLS         *ClusterTLS `yaml:"tls,omitempty" json:"tls,omitempty"`           // 连接 https:// BE 的 TLS 配置，默认使用系统 CA
+	Proxy       string      `yaml:"proxy,omitempty" json:"proxy,omitempty"`       // 连接 BE 使用的代理，格式同 DORIS_PROXY，默认使用 DORIS_PROXY
+
+	// ReplicaOf 作为指定集群的副本：写入该集群的每批数据同时尽力写入本集群，端点不能直接选择副本集群
+	ReplicaOf string `yaml:"replica_of,omitempty" json:"replica_of,omitempty"`
+}
+
+// DorisCredentials 端点写入的表使用的 Doris 用户，用于各表属于不同用户、按最小权限授权的部署
+// 凭证属于表：写入同一张表的端点使用相同的用户，未配置的端点写入这些表时也使用该用户
+type DorisCredentials struct {
+	User        string `yaml:"user" json:"user"`
+	PasswordEnv string `yaml:"password_env" json:"password_env"` // 存放密码的环境变量名
+}
+
+// ClusterTLS 连接 BE 的 TLS 配置
+type ClusterTLS struct {
+	CAFile             string `yaml:"ca_file,omitempty" json:"ca_file,omitempty"`     // 校验 BE 证书的 CA，默认使用系统 CA
+	CertFile           string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"` // 客户端证书（mTLS），需同时设置 key_file
+	KeyFile            string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty" json:"server_name,omitempty"` // 覆盖校验证书使用的主机名
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"`
+}
+
+// validateClusters 校验集群定义，返回可引用的名称集合（含 default）
+func validateClusters(configs []*ClusterConfig) (map[string]bool, error) {
+	names := map[string]bool{defaultClusterName: true}
+	for i, cc := range configs {
+		if cc.Name == "" {
+			return nil, fmt.Errorf("clusters[%d]: name 必须设置", i)
+		}
+		if names[cc.Name] {
+			return nil, fmt.Errorf("clusters[%d]: 集群名称重复或与内置名称冲突: %s", i, cc.Name)
+		}
+		names[cc.Name] = true
+		if len(cc.BEHTTP) == 0 {
+			return nil, fmt.Errorf("cluster %s: be_http 必须设置", cc.Name)
+		}
+		if _, err := normalizeBEAddrs(cc.BEHTTP); err != nil {
+			return nil, fmt.Errorf("cluster %s: be_http: %w", cc.Name, err)
+		}
+		if cc.Database != "" {
+			if err := dorisload.ValidateIdentifier(cc.Database); err != nil {
+				return nil, fmt.Errorf("cluster %s: database: %w", cc.Name, err)
+			}
+		}
+		if cc.PasswordEnv == "" {
+			return nil, fmt.Errorf("cluster %s: password_env 必须设置", cc.Name)
+		}
+		if t := cc.TLS; t != nil && (t.CertFile == "") != (t.KeyFile == "") {
+			return nil, fmt.Errorf("cluster %s: tls.cert_file 和 tls.key_file 必须同时设置", cc.Name)
+		}
+		if _, err := dorisload.ParseProxy(cc.Proxy); err != nil {
+			return nil, fmt.Errorf("cluster %s: proxy: %w", cc.Name, err)
+		}
+	}
+
+	// 每个主集群最多一个副本，副本不能再作为主集群或其他集群的副本
+	primaries := make(map[string]string)
+	for _, cc := range configs {
+		if cc.ReplicaOf == "" {
+			continue
+		}
+		if !names[cc.ReplicaOf] || cc.ReplicaOf == cc.Name {
+			return nil, fmt.Errorf("cluster %s: replica_of 必须是其他已定义的集群: %q", cc.Name, cc.ReplicaOf)
+		}
+		if other, ok := primaries[cc.ReplicaOf]; ok {
+			return nil, fmt.Errorf("cluster %s: 集群 %s 已有副本 %s", cc.Name, cc.ReplicaOf, other)
+		}
+		primaries[cc.ReplicaOf] = cc.Name
+	}
+	for _, cc := range configs {
+		if _, ok := primaries[cc.Name]; ok && cc.ReplicaOf != "" {
+			return nil, fmt.Errorf("cluster %s: 副本集群不能再有副本", cc.Name)
+		}
+	}
+	return names, nil
+}
+
+// isReplica 判断集群是否为其他集群的副本
+func isReplica(configs []*ClusterConfig, name string) bool {
+	for _, cc := range configs {
+		if cc.Name == name {
+			return cc.ReplicaOf != ""
+		}
+	}
+	return false
+}
+
+// dorisConfig 返回集群的 Doris 配置：连接参数取自集群定义，其余沿用 base
+func (cc *ClusterConfig) dorisConfig(base *dorisload.Config) (*dorisload.Config, error) {
+	cfg := *base
+	cfg.BEHTTP, _ = normalizeBEAddrs(cc.BEHTTP) // 已在 validateClusters 中校验
+	cfg.DB = defaultString(cc.Database, base.DB)
+	cfg.User = defaultString(cc.User, base.User)
+	cfg.Passwd = os.Getenv(cc.PasswordEnv)
+	if cfg.Passwd == "" {
+		return nil, fmt.Errorf("cluster %s: 环境变量 %s 未设置", cc.Name, cc.PasswordEnv)
+	}
+	tlsConfig, err := loadClusterTLS(cc.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", cc.Name, err)
+	}
+	cfg.TLSConfig = tlsConfig
+	if cc.Proxy != "" {
+		cfg.Proxy, _ = dorisload.ParseProxy(cc.Proxy) // 已在 validateClusters 中校验
+	}
+	return &cfg, nil
+}
+
+// loadClusterTLS 读取 CA 和客户端证书，t 为 nil 时返回 nil（使用系统默认）
+func loadClusterTLS(t *ClusterTLS) (*tls.Config, error) {
+	if t == nil {
+		return nil, nil
+	}
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return nil, fmt.Errorf("客户端证书和私钥必须同时设置")
+	}
+	cfg := &tls.Config{ServerName: t.ServerName, InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 文件失败: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 文件中没有有效的证书: %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端证书失败: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// envClusterTLS 从 DORIS_TLS_* 环境变量读取 default 集群的 TLS 配置，均未设置时返回 nil
+func envClusterTLS() *ClusterTLS {
+	t := &ClusterTLS{
+		CAFile:             getEnv("DORIS_TLS_CA_FILE", ""),
+		CertFile:           getEnv("DORIS_TLS_CERT_FILE", ""),
+		KeyFile:            getEnv("DORIS_TLS_KEY_FILE", ""),
+		ServerName:         getEnv("DORIS_TLS_SERVER_NAME", ""),
+		InsecureSkipVerify: getEnv("DORIS_TLS_INSECURE_SKIP_VERIFY", "false") == "true",
+	}
+	if *t == (ClusterTLS{}) {
+		return nil
+	}
+	return t
+}
+
+// Clusters 按名称索引的 Doris 集群客户端（含 default），目标表按注册表中所属的集群选择客户端
+type Clusters struct {
+	clients  map[string]*dorisload.Client
+	registry *Registry
+}
+
+// newClusters 为 default 集群和配置文件中的每个集群创建客户端，共用对冲策略以及 cfg 中的写入预算、审计和传输包装
+func newClusters(cfg *dorisload.Config, registry *Registry, hedge *dorisload.HedgePolicy) (*Clusters, error) {
+	cs := &Clusters{clients: make(map[string]*dorisload.Client), registry: registry}
+	dcfg := *cfg
+	var err error
+	if dcfg.TableCredentials, err = registry.TableCredentials(defaultClusterName); err != nil {
+		return nil, err
+	}
+	cs.clients[defaultClusterName] = dorisload.New(&dcfg, hedge)
+	for _, cc := range registry.Clusters {
+		ccfg, err := cc.dorisConfig(cfg)
+		if err == nil {
+			ccfg.TableCredentials, err = registry.TableCredentials(cc.Name)
+		}
+		if err != nil {
+			cs.Close()
+			return nil, err
+		}
+		cs.clients[cc.Name] = dorisload.New(ccfg, hedge)
+	}
+	return cs, nil
+}
+
+// Default 返回 default 集群的客户端
+func (cs *Clusters) Default() *dorisload.Client {
+	return cs.clients[defaultClusterName]
+}
+
+// For 返回写入目标表的集群客户端
+func (cs *Clusters) For(table *dorisload.Table) *dorisload.Client {
+	return cs.clients[cs.registry.TableCluster(table.Name)]
+}
+
+// Names 返回集群名称，按字母顺序排列
+func (cs *Clusters) Names() []string {
+	names := make([]string, 0, len(cs.clients))
+	for name := range cs.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Client 按名称返回集群客户端
+func (cs *Clusters) Client(name string) *dorisload.Client {
+	return cs.clients[name]
+}
+
+// StartHealthCheck 为所有集群启动 BE 健康检查
+func (cs *Clusters) StartHealthCheck(hc dorisload.HealthCheck, logger *slog.Logger) error {
+	for _, name := range cs.Names() {
+		if err := cs.clients[name].StartHealthCheck(hc, logger.With("cluster", name)); err != nil {
+			return fmt.Errorf("cluster %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Health 返回所有集群的 BE 健康状态，未启动健康检查时返回 nil
+func (cs *Clusters) Health() []dorisload.BEHealth {
+	var backends []dorisload.BEHealth
+	for _, name := range cs.Names() {
+		backends = append(backends, cs.clients[name].Health()...)
+	}
+	return backends
+}
+
+// HealthHistory 返回各集群 BE 最近的健康检查结果，按集群名索引，未启动健康检查时返回 nil
+func (cs *Clusters) HealthHistory() map[string][]dorisload.BEHealthHistory {
+	var history map[string][]dorisload.BEHealthHistory
+	for name, dc := range cs.clients {
+		if h := dc.HealthHistory(); h != nil {
+			if history == nil {
+				history = make(map[string][]dorisload.BEHealthHistory, len(cs.clients))
+			}
+			history[name] = h
+		}
+	}
+	return history
+}
+
+// Prewarm 为各集群写入目标表使用的连接池预先建立连接，失败只记录告警，首次写入时再建立连接
+func (cs *Clusters) Prewarm(ctx context.Context, logger *slog.Logger) {
+	for _, name := range cs.Names() {
+		if err := cs.clients[name].Prewarm(ctx, cs.registry.DorisTablesIn(name)); err != nil {
+			logger.Warn("预热 BE 连接失败", "cluster", name, "error", err)
+		}
+	}
+}
+
+// StartDNSRefresh 为所有集群启动 BE 主机名的定期解析，未设置 DORIS_DNS_REFRESH_INTERVAL 时不启动
+func (cs *Clusters) StartDNSRefresh(logger *slog.Logger) {
+	for _, name := range cs.Names() {
+		cs.clients[name].StartDNSRefresh(logger.With("cluster", name))
+	}
+}
+
+// Recycles 返回各集群启动以来回收 BE 连接的次数，按集群名索引
+func (cs *Clusters) Recycles() map[string]int64 {
+	recycles := make(map[string]int64, len(cs.clients))
+	for name, dc := range cs.clients {
+		recycles[name] = dc.Recycles()
+	}
+	return recycles
+}
+
+// PhaseTimings 返回所有集群的 Stream Load 各阶段耗时（每张表只属于一个集群，不会重复）
+func (cs *Clusters) PhaseTimings() []dorisload.PhaseHistogram {
+	var histograms []dorisload.PhaseHistogram
+	for _, name := range cs.Names() {
+		histograms = append(histograms, cs.clients[name].PhaseTimings()...)
+	}
+	return histograms
+}
+
+// TraceTimings 返回所有集群发往各 BE 的请求在客户端侧的各阶段耗时
+func (cs *Clusters) TraceTimings() []dorisload.TraceHistogram {
+	var histograms []dorisload.TraceHistogram
+	for _, name := range cs.Names() {
+		histograms = append(histograms, cs.clients[name].TraceTimings()...)
+	}
+	return histograms
+}
+
+// Close 关闭所有集群的客户端，中止进行中的写入
+func (cs *Clusters) Close() {
+	for _, dc := range cs.clients {
+		dc.Close()
+	}
+}
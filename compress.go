@@ -0,0 +1,98 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipMinBytes 小于该长度的响应不压缩
+const gzipMinBytes = 1024
+
+var gzipWriterPool = sync.Pool{
+	New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	},
+}
+
+// gzipResponseWriter 缓冲响应体，结束时按长度决定是否压缩
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	buf []byte
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	w.buf = append(w.buf, data...)
+	return len(data), nil
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	w.buf = append(w.buf, s...)
+	return len(s), nil
+}
+
+// WriteHeaderNow 延迟到 finish 时写出状态码，以便设置压缩相关的响应头
+func (w *gzipResponseWriter) WriteHeaderNow() {}
+
+// finish 写出缓冲的响应体，超过 gzipMinBytes 时压缩
+func (w *gzipResponseWriter) finish() {
+	header := w.ResponseWriter.Header()
+	header.Add("Vary", "Accept-Encoding")
+	if len(w.buf) < gzipMinBytes || header.Get("Content-Encoding") != "" {
+		header.Set("Content-Length", strconv.Itoa(len(w.buf)))
+		w.ResponseWriter.WriteHeaderNow()
+		w.ResponseWriter.Write(w.buf)
+		return
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.ResponseWriter.WriteHeaderNow()
+
+	gz := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(gz)
+	gz.Reset(w.ResponseWriter)
+	gz.Write(w.buf)
+	gz.Close()
+}
+
+// gzipResponse 响应压缩中间件，用于 /admin/*、/metrics 等返回较大内容的读接口
+// 仅在客户端声明 Accept-Encoding: gzip 且响应体不小于 gzipMinBytes 时压缩
+func gzipResponse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		w := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+			w.finish()
+		}()
+		c.Next()
+	}
+}
+
+// acceptsGzip 判断 Accept-Encoding 是否接受 gzip（忽略 q=0）
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// configError 一个配置问题，Field 为环境变量名或配置文件中的字段路径
+type configError struct {
+	Field string
+	Err   error
+}
+
+func (e *configError) Error() string {
+	if e.Field == "" {
+		return e.Err.Error()
+	}
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *configError) Unwrap() error { return e.Err }
+
+// configErrors 校验过程中收集的所有配置问题，一次性报告，运维人员不必逐个修改、逐个重启
+type configErrors []*configError
+
+// add 记录字段的问题，err 为 nil 时忽略；err 本身是 configErrors 或 *configError 时逐条记录，字段路径加上 field 前缀
+func (e *configErrors) add(field string, err error) {
+	if err == nil {
+		return
+	}
+	var nested configErrors
+	if errors.As(err, &nested) {
+		for _, ce := range nested {
+			*e = append(*e, &configError{Field: joinFieldPath(field, ce.Field), Err: ce.Err})
+		}
+		return
+	}
+	var single *configError
+	if errors.As(err, &single) {
+		*e = append(*e, &configError{Field: joinFieldPath(field, single.Field), Err: single.Err})
+		return
+	}
+	*e = append(*e, &configError{Field: field, Err: err})
+}
+
+// addf 按格式记录字段的问题
+func (e *configErrors) addf(field, format string, args ...any) {
+	e.add(field, fmt.Errorf(format, args...))
+}
+
+// err 没有问题时返回 nil
+func (e configErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e configErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	parts := make([]string, len(e))
+	for i, ce := range e {
+		parts[i] = ce.Error()
+	}
+	return fmt.Sprintf("%d 个配置问题: %s", len(e), strings.Join(parts, "; "))
+}
+
+func joinFieldPath(prefix, field string) string {
+	switch {
+	case prefix == "":
+		return field
+	case field == "":
+		return prefix
+	}
+	return prefix + "." + field
+}
+
+// logConfigErrors 逐条输出配置问题（每条带 field），err 不是 configErrors 时输出一条
+func logConfigErrors(log func(msg string, args ...any), msg string, err error) {
+	var errs configErrors
+	if !errors.As(err, &errs) {
+		log(msg, "error", err)
+		return
+	}
+	for _, ce := range errs {
+		log(msg, "field", ce.Field, "error", ce.Err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// originPattern 允许的跨域源，host 以 "*." 开头时匹配任意一级子域名
+// 例如 https://*.example.com 匹配 https://app.example.com，不匹配 https://example.com 和 https://a.b.example.com
+type originPattern struct {
+	scheme string
+	host   string // 通配时为去掉 "*" 的后缀，如 ".example.com"
+	port   string
+}
+
+// originMatcher 跨域源匹配器
+type originMatcher struct {
+	allowAll bool
+	exact    map[string]bool
+	patterns []originPattern
+}
+
+// newOriginMatcher 解析跨域源列表，支持精确源、"*" 和 scheme://*.domain[:port] 通配
+func newOriginMatcher(origins []string) (*originMatcher, error) {
+	m := &originMatcher{exact: make(map[string]bool)}
+	for _, origin := range origins {
+		origin = strings.TrimSuffix(strings.ToLower(origin), "/")
+		switch {
+		case origin == "*":
+			m.allowAll = true
+		case strings.Contains(origin, "*"):
+			scheme, rest, ok := strings.Cut(origin, "://")
+			if !ok || scheme == "" || !strings.HasPrefix(rest, "*.") || strings.Count(rest, "*") > 1 {
+				return nil, fmt.Errorf("跨域源通配格式无效: %q（应为 scheme://*.example.com）", origin)
+			}
+			host, port, _ := strings.Cut(rest[1:], ":")
+			m.patterns = append(m.patterns, originPattern{scheme: scheme, host: host, port: port})
+		default:
+			if _, err := url.Parse(origin); err != nil {
+				return nil, fmt.Errorf("跨域源无效: %q", origin)
+			}
+			m.exact[origin] = true
+		}
+	}
+	return m, nil
+}
+
+// Allowed 判断请求的 Origin 是否允许
+func (m *originMatcher) Allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	if m.allowAll || m.exact[origin] {
+		return true
+	}
+	if len(m.patterns) == 0 {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host, port := u.Hostname(), u.Port()
+	for _, p := range m.patterns {
+		if u.Scheme != p.scheme || port != p.port || !strings.HasSuffix(host, p.host) {
+			continue
+		}
+		// 通配符只匹配一级子域名
+		if label := strings.TrimSuffix(host, p.host); label != "" && !strings.Contains(label, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinExposeHeaders 服务输出的响应头，始终允许浏览器 SDK 读取：请求 ID、限流退避和写入 label
+var builtinExposeHeaders = append([]string{requestIDHeader, "Retry-After", "X-Quota-Remaining", loadLabelHeader}, rateLimitHeaderNames...)
+
+// corsPolicy 跨域策略
+type corsPolicy struct {
+	Origins       []string
+	Methods       []string
+	Headers       []string
+	ExposeHeaders []string // 除 builtinExposeHeaders 外允许浏览器读取的响应头
+	Credentials   bool
+	MaxAge        time.Duration
+}
+
+// corsPolicyFromEnv 从 CORS_* 环境变量读取跨域策略，所有问题一起返回（configErrors）
+func corsPolicyFromEnv() (*corsPolicy, error) {
+	var errs configErrors
+	maxAge, err := envDuration("CORS_MAX_AGE", "3600", time.Second, 0, 0)
+	errs.add("", err)
+	p := &corsPolicy{
+		Origins:       splitList(getEnv("CORS_ALLOWED_ORIGIN", "*")),
+		Methods:       splitList(getEnv("CORS_ALLOWED_METHODS", "GET,POST,OPTIONS")),
+		Headers:       splitList(getEnv("CORS_ALLOWED_HEADERS", "Content-Type,Authorization")),
+		ExposeHeaders: splitList(getEnv("CORS_EXPOSE_HEADERS", "")),
+		Credentials:   getEnv("CORS_ALLOW_CREDENTIALS", "false") == "true",
+		MaxAge:        maxAge,
+	}
+	matcher, err := newOriginMatcher(p.Origins)
+	errs.add("CORS_ALLOWED_ORIGIN", err)
+	if err == nil && p.Credentials && matcher.allowAll {
+		errs.addf("CORS_ALLOW_CREDENTIALS", "不能与 CORS_ALLOWED_ORIGIN=* 同时使用，浏览器会拒绝带凭据的通配源响应，需要列出允许的源")
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// forEndpoint 返回端点生效的跨域策略：以当前策略为默认值，叠加端点的 cors 配置
+// 端点禁用 CORS 时返回 nil
+func (p *corsPolicy) forEndpoint(c *CORSConfig) *corsPolicy {
+	if c == nil {
+		return p
+	}
+	if c.Disabled {
+		return nil
+	}
+	merged := *p
+	if len(c.Origins) > 0 {
+		merged.Origins = c.Origins
+	}
+	if len(c.Methods) > 0 {
+		merged.Methods = c.Methods
+	}
+	if len(c.Headers) > 0 {
+		merged.Headers = c.Headers
+	}
+	if len(c.ExposeHeaders) > 0 {
+		merged.ExposeHeaders = c.ExposeHeaders
+	}
+	if c.AllowCredentials != nil {
+		merged.Credentials = *c.AllowCredentials
+	}
+	if c.MaxAge != nil {
+		merged.MaxAge = time.Duration(*c.MaxAge) * time.Second
+	}
+	return &merged
+}
+
+// middleware 返回跨域中间件
+// 非全部放行时，所有响应都带 Vary: Origin，避免缓存把某个源的响应返回给其他源
+func (p *corsPolicy) middleware() (gin.HandlerFunc, error) {
+	matcher, err := newOriginMatcher(p.Origins)
+	if err != nil {
+		return nil, err
+	}
+	if p.Credentials && matcher.allowAll {
+		return nil, fmt.Errorf("allow_credentials 不能与允许所有源（*）同时使用")
+	}
+
+	cfg := cors.Config{
+		ExposeHeaders:    p.exposeHeaders(),
+		AllowMethods:     p.Methods,
+		AllowHeaders:     p.Headers,
+		AllowCredentials: p.Credentials,
+		MaxAge:           p.MaxAge,
+	}
+	if matcher.allowAll {
+		cfg.AllowAllOrigins = true
+	} else {
+		cfg.AllowOriginFunc = matcher.Allowed
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("CORS 配置无效: %w", err)
+	}
+	handler := cors.New(cfg)
+
+	if matcher.allowAll {
+		return handler, nil
+	}
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Origin")
+		handler(c)
+	}, nil
+}
+
+// exposeHeaders 返回 Access-Control-Expose-Headers：内置响应头加上配置的响应头，不区分大小写去重
+func (p *corsPolicy) exposeHeaders() []string {
+	seen := make(map[string]bool)
+	var headers []string
+	for _, h := range append(append([]string{}, builtinExposeHeaders...), p.ExposeHeaders...) {
+		if key := http.CanonicalHeaderKey(h); !seen[key] {
+			seen[key] = true
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
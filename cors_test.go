@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestOriginMatcherAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		want    bool
+	}{
+		{name: "exact", origins: []string{"https://app.example.com"}, origin: "https://app.example.com", want: true},
+		{name: "exact case insensitive", origins: []string{"https://App.Example.com/"}, origin: "https://APP.example.COM", want: true},
+		{name: "exact other host", origins: []string{"https://app.example.com"}, origin: "https://api.example.com"},
+		{name: "exact scheme mismatch", origins: []string{"https://app.example.com"}, origin: "http://app.example.com"},
+		{name: "exact port mismatch", origins: []string{"https://app.example.com"}, origin: "https://app.example.com:8443"},
+		{name: "allow all", origins: []string{"*"}, origin: "https://anything.test", want: true},
+		{name: "wildcard subdomain", origins: []string{"https://*.example.com"}, origin: "https://shop.example.com", want: true},
+		{name: "wildcard apex", origins: []string{"https://*.example.com"}, origin: "https://example.com"},
+		{name: "wildcard multi level", origins: []string{"https://*.example.com"}, origin: "https://a.b.example.com"},
+		{name: "wildcard suffix without dot", origins: []string{"https://*.example.com"}, origin: "https://evilexample.com"},
+		{name: "wildcard suffix attack", origins: []string{"https://*.example.com"}, origin: "https://shop.example.com.evil.test"},
+		{name: "wildcard scheme mismatch", origins: []string{"https://*.example.com"}, origin: "http://shop.example.com"},
+		{name: "wildcard unexpected port", origins: []string{"https://*.example.com"}, origin: "https://shop.example.com:8443"},
+		{name: "wildcard with port", origins: []string{"https://*.example.com:8443"}, origin: "https://shop.example.com:8443", want: true},
+		{name: "wildcard port mismatch", origins: []string{"https://*.example.com:8443"}, origin: "https://shop.example.com:9443"},
+		{name: "wildcard missing port", origins: []string{"https://*.example.com:8443"}, origin: "https://shop.example.com"},
+		{name: "null origin", origins: []string{"https://*.example.com"}, origin: "null"},
+		{name: "empty origin", origins: []string{"https://app.example.com"}, origin: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newOriginMatcher(tt.origins)
+			if err != nil {
+				t.Fatalf("newOriginMatcher(%q) error = %v", tt.origins, err)
+			}
+			if got := m.Allowed(tt.origin); got != tt.want {
+				t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOriginMatcherInvalid(t *testing.T) {
+	for _, origin := range []string{
+		"*.example.com",           // 缺少 scheme
+		"https://shop.*.com",      // 通配符不在开头
+		"https://*.*.example.com", // 多个通配符
+		"https://*example.com",    // 通配符后没有点
+	} {
+		if _, err := newOriginMatcher([]string{origin}); err == nil {
+			t.Errorf("newOriginMatcher(%q) error = nil, want error", origin)
+		}
+	}
+}
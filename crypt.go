@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// encryptedLinePrefix 加密行的前缀，完整格式为 enc:v1:{密钥 ID}:{base64(nonce + 密文)}
+// 明文 NDJSON 行以 { 开头，同一文件中可以混有启用加密之前写入的明文行
+const encryptedLinePrefix = "enc:v1:"
+
+// encryptionKeyID 密钥 ID 的格式
+var encryptionKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ErrNoEncryptionKey 加密行使用的密钥不在密钥环中
+var ErrNoEncryptionKey = errors.New("加密密钥不存在")
+
+// Keyring 落盘数据（WAL 段、dlq、副本积压、S3 归档）的 AES-GCM 加密密钥
+// 第一个密钥用于加密新写入的数据，其余密钥只用于解密轮换之前写入的数据；每行独立加密，密钥 ID 作为附加数据
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// newKeyring 按 ENCRYPTION_KEYS 或 ENCRYPTION_KEYS_FILE 创建密钥环，都未设置时返回 nil（不加密）
+// 密钥列表为逗号或换行分隔的 {密钥 ID}:{base64 编码的 16、24 或 32 字节密钥}；
+// ENCRYPTION_KEYS_FILE 用于由 KMS、Vault Agent 或 Secrets Store CSI 解密后挂载的密钥文件
+func newKeyring() (*Keyring, error) {
+	raw, path := getEnv("ENCRYPTION_KEYS", ""), getEnv("ENCRYPTION_KEYS_FILE", "")
+	switch {
+	case raw != "" && path != "":
+		return nil, fmt.Errorf("ENCRYPTION_KEYS 和 ENCRYPTION_KEYS_FILE 不能同时设置")
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取 ENCRYPTION_KEYS_FILE 失败: %w", err)
+		}
+		raw = string(data)
+	case raw == "":
+		return nil, nil
+	}
+	return parseKeyring(raw)
+}
+
+// parseKeyring 解析密钥列表，第一个密钥为当前密钥
+func parseKeyring(raw string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || !encryptionKeyID.MatchString(id) {
+			return nil, fmt.Errorf("加密密钥无效: 格式为 {密钥 ID}:{base64 密钥}，ID 只能包含字母、数字、- 和 _")
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("加密密钥 ID 重复: %s", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("加密密钥 %s 无效: 不是 base64", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("加密密钥 %s 无效: 长度必须为 16、24 或 32 字节", id)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+		if k.active == "" {
+			k.active = id
+		}
+	}
+	if k.active == "" {
+		return nil, fmt.Errorf("加密密钥列表为空")
+	}
+	return k, nil
+}
+
+// EncryptLines 以当前密钥逐行加密 NDJSON（每行以换行符结尾），k 为 nil 时原样返回
+func (k *Keyring) EncryptLines(data []byte) []byte {
+	if k == nil {
+		return data
+	}
+	aead := k.keys[k.active]
+	var out bytes.Buffer
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		sealed := make([]byte, aead.NonceSize(), aead.NonceSize()+len(line)+aead.Overhead())
+		rand.Read(sealed) // 随机 nonce
+		sealed = aead.Seal(sealed, sealed, line, []byte(k.active))
+		out.WriteString(encryptedLinePrefix)
+		out.WriteString(k.active)
+		out.WriteByte(':')
+		out.WriteString(base64.StdEncoding.EncodeToString(sealed))
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// DecryptLines 逐行解密，明文行原样保留；k 为 nil 时遇到加密行返回错误
+func (k *Keyring) DecryptLines(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(encryptedLinePrefix)) {
+		return data, nil
+	}
+	out := make([]byte, 0, len(data))
+	for n := 1; len(data) > 0; n++ {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i+1], data[i+1:]
+		} else {
+			data = nil
+		}
+		if !bytes.HasPrefix(line, []byte(encryptedLinePrefix)) {
+			out = append(out, line...)
+			continue
+		}
+		plain, err := k.decryptLine(bytes.TrimSuffix(line, []byte{'\n'}))
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %w", n, err)
+		}
+		out = append(append(out, plain...), '\n')
+	}
+	return out, nil
+}
+
+// decryptLine 解密一个加密行（不含换行符）
+func (k *Keyring) decryptLine(line []byte) ([]byte, error) {
+	id, encoded, ok := strings.Cut(string(line[len(encryptedLinePrefix):]), ":")
+	if !ok {
+		return nil, fmt.Errorf("加密行格式无效")
+	}
+	var aead cipher.AEAD
+	if k != nil {
+		aead = k.keys[id]
+	}
+	if aead == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoEncryptionKey, id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("加密行格式无效")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("解密失败（密钥 %s）: 数据已损坏或密钥不正确", id)
+	}
+	return plain, nil
+}
+
+// 加密文件的子命令
+const (
+	decryptCommand   = "decrypt"   // 解密文件输出到标准输出
+	reencryptCommand = "reencrypt" // 以当前密钥重新加密文件
+)
+
+// runCryptCommand 子命令 decrypt FILE... 将 WAL 段、dlq 中的段或下载的 S3 归档解密后输出到 stdout，用于人工处理；
+// reencrypt FILE... 以当前密钥原地重写文件（明文行也会加密），用于轮换后移除旧密钥之前处理 dlq 中的段。出错时返回 1
+func runCryptCommand(name string, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintf(stderr, "用法: %s %s FILE...\n", os.Args[0], name)
+		return 2
+	}
+	keys, err := newKeyring()
+	if err != nil {
+		fmt.Fprintf(stderr, "加密密钥配置错误: %v\n", err)
+		return 1
+	}
+	if name == reencryptCommand && keys == nil {
+		fmt.Fprintln(stderr, "reencrypt 需要设置 ENCRYPTION_KEYS 或 ENCRYPTION_KEYS_FILE")
+		return 1
+	}
+	for _, path := range args {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			data, err = keys.DecryptLines(data)
+		}
+		if err == nil {
+			if name == decryptCommand {
+				_, err = stdout.Write(data)
+			} else {
+				err = writeFileAtomic(path, keys.EncryptLines(data))
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testKey 返回 ID 为 id、内容为 32 个 b 的密钥条目
+func testKey(id string, b byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// mustKeyring 解析测试用密钥列表
+func mustKeyring(t *testing.T, keys ...string) *Keyring {
+	t.Helper()
+	k, err := parseKeyring(strings.Join(keys, ","))
+	if err != nil {
+		t.Fatalf("parseKeyring() error = %v", err)
+	}
+	return k
+}
+
+func TestKeyringDecryptLines(t *testing.T) {
+	oldKeys := mustKeyring(t, testKey("k1", 1))
+	newKeys := mustKeyring(t, testKey("k2", 2))
+	rotated := mustKeyring(t, testKey("k2", 2), testKey("k1", 1))
+
+	tamper := func(line []byte) []byte {
+		out := bytes.Clone(line)
+		out[len(out)-3] ^= 1 // 改动 base64 尾部，认证失败
+		return out
+	}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	tests := []struct {
+		name    string
+		keys    *Keyring // 用于解密
+		data    []byte
+		want    string
+		wantErr error // 为 nil 时只检查是否出错
+		fail    bool
+	}{
+		{name: "plaintext only", keys: nil, data: []byte("{\"a\":1}\n{\"b\":2}\n"), want: "{\"a\":1}\n{\"b\":2}\n"},
+		{name: "plaintext without trailing newline", keys: oldKeys, data: []byte("{\"a\":1}"), want: "{\"a\":1}"},
+		{name: "encrypted", keys: oldKeys, data: oldKeys.EncryptLines([]byte("{\"a\":1}\n{\"b\":2}\n")), want: "{\"a\":1}\n{\"b\":2}\n"},
+		{name: "encrypted without trailing newline", keys: oldKeys, data: oldKeys.EncryptLines([]byte("{\"a\":1}")), want: "{\"a\":1}\n"},
+		{
+			name: "mixed plaintext and encrypted",
+			keys: oldKeys,
+			data: join([]byte("{\"a\":1}\n"), oldKeys.EncryptLines([]byte("{\"b\":2}\n")), []byte("{\"c\":3}\n")),
+			want: "{\"a\":1}\n{\"b\":2}\n{\"c\":3}\n",
+		},
+		{
+			name: "mixed keys after rotation",
+			keys: rotated,
+			data: join(oldKeys.EncryptLines([]byte("{\"a\":1}\n")), []byte("{\"b\":2}\n"), newKeys.EncryptLines([]byte("{\"c\":3}\n"))),
+			want: "{\"a\":1}\n{\"b\":2}\n{\"c\":3}\n",
+		},
+		{name: "unknown key", keys: newKeys, data: oldKeys.EncryptLines([]byte("{\"a\":1}\n")), wantErr: ErrNoEncryptionKey},
+		{name: "nil keyring", keys: nil, data: join([]byte("{\"a\":1}\n"), oldKeys.EncryptLines([]byte("{\"b\":2}\n"))), wantErr: ErrNoEncryptionKey},
+		{name: "tampered", keys: oldKeys, data: tamper(oldKeys.EncryptLines([]byte("{\"a\":1}\n"))), fail: true},
+		{name: "missing key id", keys: oldKeys, data: []byte(encryptedLinePrefix + "AAAA\n"), fail: true},
+		{name: "truncated ciphertext", keys: oldKeys, data: []byte(encryptedLinePrefix + "k1:AAAA\n"), fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keys.DecryptLines(tt.data)
+			switch {
+			case tt.wantErr != nil || tt.fail:
+				if err == nil {
+					t.Fatalf("DecryptLines() = %q, want error", got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("DecryptLines() error = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("DecryptLines() error = %v", err)
+			case string(got) != tt.want:
+				t.Errorf("DecryptLines() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyringEncryptLines(t *testing.T) {
+	keys := mustKeyring(t, testKey("k2", 2), testKey("k1", 1))
+	data := []byte("{\"a\":1}\n{\"b\":2}\n")
+
+	enc := keys.EncryptLines(data)
+	lines := strings.Split(strings.TrimSuffix(string(enc), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("EncryptLines() produced %d lines, want 2", len(lines))
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, encryptedLinePrefix+"k2:") {
+			t.Errorf("line %q not encrypted with active key k2", line)
+		}
+	}
+	if lines[0] == lines[1] || bytes.Equal(enc, keys.EncryptLines(data)) {
+		t.Error("EncryptLines() reused a nonce")
+	}
+	var nilKeys *Keyring
+	if got := nilKeys.EncryptLines(data); !bytes.Equal(got, data) {
+		t.Errorf("nil Keyring EncryptLines() = %q, want input unchanged", got)
+	}
+}
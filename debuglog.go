@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"unicode/utf8"
+)
+
+// DebugConfig 端点的调试日志：以 Debug 级别记录转换后的行，按比例采样、只记录指定列并截断
+type DebugConfig struct {
+	Enabled       *bool    `yaml:"enabled,omitempty" json:"enabled,omitempty"`               // 默认随 DEBUG
+	SamplePercent float64  `yaml:"sample_percent,omitempty" json:"sample_percent,omitempty"` // 记录的请求比例 (0, 100]，默认 DEBUG_SAMPLE_PERCENT
+	MaxBytes      ByteSize `yaml:"max_bytes,omitempty" json:"max_bytes,omitempty"`           // 每个请求记录的最大字节数，默认 DEBUG_MAX_BYTES
+	Fields        []string `yaml:"fields,omitempty" json:"fields,omitempty"`                 // 记录的列，默认全部列
+}
+
+// debugPolicy 端点生效的调试日志配置
+type debugPolicy struct {
+	percent  float64
+	maxBytes int
+	fields   []string
+}
+
+// DebugLog 按端点记录请求数据的调试日志
+type DebugLog struct {
+	logger   *slog.Logger
+	policies map[string]debugPolicy // 按端点名索引，只包含启用的端点
+}
+
+// newDebugLog 按 DEBUG 和端点的 debug 配置创建调试日志，没有端点启用时返回 nil
+// maxBytes 为 DEBUG_MAX_BYTES，与 Doris 客户端的 Debug 日志共用
+func newDebugLog(reg *Registry, maxBytes int, logger *slog.Logger) (*DebugLog, error) {
+	enabled := getEnv("DEBUG", "false") == "true"
+	percent, err := strconv.ParseFloat(getEnv("DEBUG_SAMPLE_PERCENT", "100"), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return nil, fmt.Errorf("DEBUG_SAMPLE_PERCENT 无效: %q", getEnv("DEBUG_SAMPLE_PERCENT", ""))
+	}
+
+	d := &DebugLog{logger: logger, policies: make(map[string]debugPolicy)}
+	for _, ep := range reg.Endpoints {
+		p := debugPolicy{percent: percent, maxBytes: maxBytes}
+		on := enabled
+		if dc := ep.Debug; dc != nil {
+			if dc.Enabled != nil {
+				on = *dc.Enabled
+			}
+			if dc.SamplePercent > 0 {
+				p.percent = dc.SamplePercent
+			}
+			if dc.MaxBytes > 0 {
+				p.maxBytes = int(dc.MaxBytes)
+			}
+			p.fields = dc.Fields
+		}
+		if on {
+			d.policies[ep.Name] = p
+		}
+	}
+	if len(d.policies) == 0 {
+		return nil, nil
+	}
+	if !logger.Enabled(context.Background(), slog.LevelDebug) {
+		logger.Warn("已启用调试日志，但 LOG_LEVEL 不是 debug，调试日志不会输出")
+	}
+	return d, nil
+}
+
+// Log 以 Debug 级别记录请求转换后的行：未启用或未被采样的请求不记录
+// 只保留配置的列，序列化为 NDJSON 后截断到 max_bytes，同时记录总行数和截断前的字节数
+func (d *DebugLog) Log(ctx context.Context, ep *Endpoint, requestID string, events []eventRow) {
+	p, ok := d.policies[ep.Name]
+	if !ok || !d.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	if p.percent < 100 && rand.Float64()*100 >= p.percent {
+		return
+	}
+
+	var (
+		buf   bytes.Buffer
+		total int
+	)
+	for _, ev := range events {
+		row := ev.Row
+		if len(p.fields) > 0 {
+			row = make(map[string]any, len(p.fields))
+			for _, f := range p.fields {
+				if v, ok := ev.Row[f]; ok {
+					row[f] = v
+				}
+			}
+		}
+		line, err := json.Marshal(row)
+		if err != nil {
+			continue
+		}
+		// 超过上限后只统计字节数，不再保留内容
+		total += len(line) + 1
+		if buf.Len() <= p.maxBytes {
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+	}
+
+	d.logger.LogAttrs(ctx, slog.LevelDebug, "处理请求",
+		slog.String("endpoint", ep.Name),
+		slog.String("request_id", requestID),
+		slog.Int("rows", len(events)),
+		slog.Int("bytes", total),
+		slog.String("data", truncateString(buf.String(), p.maxBytes)),
+	)
+}
+
+// truncateString 将字符串截断到 n 字节，超出时以 ...(truncated) 结尾
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	// 不截断多字节字符
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "...(truncated)"
+}
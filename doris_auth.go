@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"doris-webhook/dorisload"
+)
+
+// dorisManagedHeaders 由 Doris 客户端设置、不能通过 DORIS_EXTRA_HEADERS 覆盖的请求头
+var dorisManagedHeaders = []string{"Authorization", "Expect", "Host", "Content-Length", "Content-Type"}
+
+// loadDorisAuth 读取 DORIS_EXTRA_HEADERS 和 DORIS_AUTH_* 环境变量，都未设置时返回 nil
+// 令牌来源（DORIS_AUTH_TOKEN、DORIS_AUTH_TOKEN_FILE、DORIS_AUTH_OAUTH2_TOKEN_URL）最多设置一个
+func loadDorisAuth() (*dorisload.AuthConfig, error) {
+	auth := &dorisload.AuthConfig{Headers: make(http.Header)}
+	for _, entry := range splitList(getEnv("DORIS_EXTRA_HEADERS", "")) {
+		name, value, ok := strings.Cut(entry, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("DORIS_EXTRA_HEADERS 无效: %q（格式为 {请求头}:{值}）", entry)
+		}
+		for _, managed := range dorisManagedHeaders {
+			if strings.EqualFold(name, managed) {
+				return nil, fmt.Errorf("DORIS_EXTRA_HEADERS 不能设置 %s（访问令牌使用 DORIS_AUTH_TOKEN_HEADER）", name)
+			}
+		}
+		auth.Headers.Add(name, value)
+	}
+
+	var sources []string
+	if token := getEnv("DORIS_AUTH_TOKEN", ""); token != "" {
+		sources = append(sources, "DORIS_AUTH_TOKEN")
+		auth.Token = dorisload.StaticToken(token)
+	}
+	if path := getEnv("DORIS_AUTH_TOKEN_FILE", ""); path != "" {
+		sources = append(sources, "DORIS_AUTH_TOKEN_FILE")
+		// 启动时读取一次，提前发现路径或权限错误；之后文件修改时重新读取
+		if _, err := os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("DORIS_AUTH_TOKEN_FILE 无法读取: %w", err)
+		}
+		auth.Token = dorisload.NewFileToken(path)
+	}
+	if tokenURL := getEnv("DORIS_AUTH_OAUTH2_TOKEN_URL", ""); tokenURL != "" {
+		sources = append(sources, "DORIS_AUTH_OAUTH2_TOKEN_URL")
+		if !strings.HasPrefix(tokenURL, "http://") && !strings.HasPrefix(tokenURL, "https://") {
+			return nil, fmt.Errorf("DORIS_AUTH_OAUTH2_TOKEN_URL 无效: %q（需带 http:// 或 https:// 前缀）", tokenURL)
+		}
+		cc := dorisload.ClientCredentialsConfig{
+			TokenURL:     tokenURL,
+			ClientID:     getEnv("DORIS_AUTH_OAUTH2_CLIENT_ID", ""),
+			ClientSecret: getEnv("DORIS_AUTH_OAUTH2_CLIENT_SECRET", ""),
+			Scopes:       strings.Fields(strings.ReplaceAll(getEnv("DORIS_AUTH_OAUTH2_SCOPES", ""), ",", " ")),
+			Audience:     getEnv("DORIS_AUTH_OAUTH2_AUDIENCE", ""),
+		}
+		if cc.ClientID == "" || cc.ClientSecret == "" {
+			return nil, fmt.Errorf("设置了 DORIS_AUTH_OAUTH2_TOKEN_URL，需要设置 DORIS_AUTH_OAUTH2_CLIENT_ID 和 DORIS_AUTH_OAUTH2_CLIENT_SECRET")
+		}
+		auth.Token = dorisload.NewClientCredentials(cc)
+	}
+	if len(sources) > 1 {
+		return nil, fmt.Errorf("%s 只能设置一个", strings.Join(sources, "、"))
+	}
+
+	auth.TokenHeader = http.CanonicalHeaderKey(getEnv("DORIS_AUTH_TOKEN_HEADER", dorisload.DefaultTokenHeader))
+	if scheme := getEnv("DORIS_AUTH_TOKEN_SCHEME", "Bearer"); scheme != "none" {
+		auth.TokenScheme = scheme
+	}
+	if len(auth.Headers) == 0 && auth.Token == nil {
+		return nil, nil
+	}
+	return auth, nil
+}
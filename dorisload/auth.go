@@ -0,0 +1,222 @@
+package dorisload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTokenHeader AuthConfig.TokenHeader 未设置时携带令牌的请求头，不影响 Doris 的 Basic 认证（Authorization）
+const DefaultTokenHeader = "Proxy-Authorization"
+
+const (
+	tokenRequestTimeout = 10 * time.Second
+	tokenRefreshMargin  = 30 * time.Second // 令牌在过期前多久刷新
+)
+
+// AuthConfig 发往 BE 的请求在 Basic 认证之外附加的请求头和访问令牌，用于 BE 前的认证代理
+type AuthConfig struct {
+	Headers     http.Header // 附加到每个请求的请求头，客户端已设置的请求头（Authorization、Stream Load 选项等）不覆盖
+	Token       TokenSource // 为 nil 时不附加令牌
+	TokenHeader string      // 携带令牌的请求头，默认 DefaultTokenHeader；设置为 Authorization 时替换 Basic 认证
+	TokenScheme string      // 令牌前的认证方案（如 Bearer），为空时只发送令牌
+}
+
+// TokenSource 为发往 BE 的请求提供访问令牌，可并发调用
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// tokenInvalidator 由可以刷新的令牌实现：认证代理返回 401 时丢弃缓存的令牌，下次请求重新获取
+type tokenInvalidator interface {
+	Invalidate(token string)
+}
+
+// StaticToken 返回固定令牌
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// FileToken 从文件读取令牌（如 Kubernetes 投射的服务账号令牌），文件修改后重新读取
+type FileToken struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	token   string
+}
+
+// NewFileToken 返回从 path 读取令牌的 TokenSource
+func NewFileToken(path string) *FileToken {
+	return &FileToken{path: path}
+}
+
+func (t *FileToken) Token(context.Context) (string, error) {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return "", fmt.Errorf("读取令牌文件失败: %w", err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && info.ModTime().Equal(t.modTime) && info.Size() == t.size {
+		return t.token, nil
+	}
+	raw, err := os.ReadFile(t.path)
+	if err != nil {
+		return "", fmt.Errorf("读取令牌文件失败: %w", err)
+	}
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return "", fmt.Errorf("令牌文件为空: %s", t.path)
+	}
+	t.token, t.modTime, t.size = token, info.ModTime(), info.Size()
+	return token, nil
+}
+
+// ClientCredentialsConfig OAuth2 client credentials 授权的配置
+type ClientCredentialsConfig struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	Audience     string // 部分授权服务器（如 Auth0）要求的 audience 参数，为空时不发送
+}
+
+// ClientCredentials 通过 OAuth2 client credentials 授权获取令牌，缓存到过期前 30 秒
+// 客户端凭证以 HTTP Basic 认证发送（RFC 6749 2.3.1）
+type ClientCredentials struct {
+	config ClientCredentialsConfig
+	client *http.Client
+
+	mu      sync.Mutex // 获取令牌期间持有，并发的请求等待同一次获取
+	token   string
+	expires time.Time // 零值为不过期（响应没有 expires_in），直到认证代理返回 401
+}
+
+// NewClientCredentials 返回 OAuth2 client credentials 授权的 TokenSource
+func NewClientCredentials(cfg ClientCredentialsConfig) *ClientCredentials {
+	return &ClientCredentials{config: cfg, client: &http.Client{Timeout: tokenRequestTimeout}}
+}
+
+func (c *ClientCredentials) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && (c.expires.IsZero() || time.Now().Before(c.expires)) {
+		return c.token, nil
+	}
+	token, expiresIn, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.expires = token, time.Time{}
+	if expiresIn > 0 {
+		c.expires = time.Now().Add(max(expiresIn-tokenRefreshMargin, expiresIn/2))
+	}
+	return token, nil
+}
+
+// Invalidate 丢弃缓存的令牌，已被其他请求刷新时不丢弃
+func (c *ClientCredentials) Invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// fetch 向授权服务器请求令牌
+func (c *ClientCredentials) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.config.Scopes, " "))
+	}
+	if c.config.Audience != "" {
+		form.Set("audience", c.config.Audience)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("创建令牌请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("请求令牌失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, fmt.Errorf("读取令牌响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("授权服务器返回错误 [%d]: %s", resp.StatusCode, truncateForLog(body, defaultDebugMaxBytes))
+	}
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", 0, fmt.Errorf("解析令牌响应失败: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", 0, errors.New("令牌响应中没有 access_token")
+	}
+	return result.AccessToken, time.Duration(result.ExpiresIn) * time.Second, nil
+}
+
+// signingTransport 为发往 BE 的请求附加 AuthConfig 中的请求头和令牌
+type signingTransport struct {
+	base http.RoundTripper
+	auth *AuthConfig
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrip 不能修改传入的请求
+	req = req.Clone(req.Context())
+	for name, values := range t.auth.Headers {
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+	if t.auth.Token == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	token, err := t.auth.Token.Token(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("获取 BE 访问令牌失败: %w", err)
+	}
+	value := token
+	if t.auth.TokenScheme != "" {
+		value = t.auth.TokenScheme + " " + token
+	}
+	header := t.auth.TokenHeader
+	if header == "" {
+		header = DefaultTokenHeader
+	}
+	req.Header.Set(header, value)
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		if inv, ok := t.auth.Token.(tokenInvalidator); ok {
+			inv.Invalidate(token)
+		}
+	}
+	return resp, err
+}
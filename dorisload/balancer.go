@@ -0,0 +1,164 @@
+package dorisload
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// Balance 在多个 BE 之间分配 Stream Load 请求的策略
+type Balance string
+
+const (
+	BalanceRoundRobin  Balance = "round_robin"  // 轮询（默认）
+	BalanceLeastLoaded Balance = "least_loaded" // 进行中请求最少的 BE，相同时轮询
+	// BalanceHash 按路由键（WithRoutingKey，未设置时为表名）选择固定的 BE，同一键的数据写入同一 BE，
+	// 减少部分表布局下 BE 之间的数据转发；BE 移出轮询时只有该 BE 上的键改写到其他 BE
+	BalanceHash Balance = "hash"
+)
+
+// ParseBalance 解析 BE 分配策略，空串为轮询
+func ParseBalance(s string) (Balance, error) {
+	switch b := Balance(s); b {
+	case "", BalanceRoundRobin:
+		return BalanceRoundRobin, nil
+	case BalanceLeastLoaded, BalanceHash:
+		return b, nil
+	}
+	return "", fmt.Errorf("BE 分配策略无效: %q（可选 round_robin、least_loaded、hash）", s)
+}
+
+// routingKeyContext 路由键的 context key
+type routingKeyContext struct{}
+
+// WithRoutingKey 设置 hash 策略使用的路由键（如 project），其他策略忽略
+func WithRoutingKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, routingKeyContext{}, key)
+}
+
+// routingKey 返回 ctx 中的路由键，未设置时返回 def
+func routingKey(ctx context.Context, def string) string {
+	if key, ok := ctx.Value(routingKeyContext{}).(string); ok && key != "" {
+		return key
+	}
+	return def
+}
+
+// beBalancer 按策略在多个 BE 之间分配 Stream Load 请求，跳过健康检查标记为不可用的 BE
+type beBalancer struct {
+	urls     []string
+	strategy Balance
+	down     []atomic.Bool  // 与 urls 一一对应，由健康检查设置
+	inflight []atomic.Int64 // 与 urls 一一对应，进行中的请求数
+	next     atomic.Uint64
+}
+
+func newBEBalancer(urls []string, strategy Balance) *beBalancer {
+	return &beBalancer{
+		urls:     urls,
+		strategy: strategy,
+		down:     make([]atomic.Bool, len(urls)),
+		inflight: make([]atomic.Int64, len(urls)),
+	}
+}
+
+// Len 返回 BE 数量
+func (b *beBalancer) Len() int {
+	return len(b.urls)
+}
+
+// Pick 按策略选择一个可用的 BE，key 为 hash 策略的路由键；所有 BE 都不可用时仍按轮询选择，由请求本身报告失败
+func (b *beBalancer) Pick(key string) string {
+	switch b.strategy {
+	case BalanceLeastLoaded:
+		if idx := b.leastLoaded(); idx >= 0 {
+			return b.urls[idx]
+		}
+	case BalanceHash:
+		if idx := b.hashed(key); idx >= 0 {
+			return b.urls[idx]
+		}
+	}
+	return b.roundRobin()
+}
+
+// roundRobin 轮询选择一个可用的 BE
+func (b *beBalancer) roundRobin() string {
+	start := b.next.Add(1) - 1
+	n := uint64(len(b.urls))
+	for i := uint64(0); i < n; i++ {
+		if idx := (start + i) % n; !b.down[idx].Load() {
+			return b.urls[idx]
+		}
+	}
+	return b.urls[start%n]
+}
+
+// leastLoaded 返回进行中请求最少的可用 BE 下标，从轮询位置开始比较以分散相同负载的 BE；没有可用 BE 时返回 -1
+func (b *beBalancer) leastLoaded() int {
+	start := b.next.Add(1) - 1
+	n := uint64(len(b.urls))
+	best, bestLoad := -1, int64(0)
+	for i := uint64(0); i < n; i++ {
+		idx := int((start + i) % n)
+		if b.down[idx].Load() {
+			continue
+		}
+		if load := b.inflight[idx].Load(); best < 0 || load < bestLoad {
+			best, bestLoad = idx, load
+		}
+	}
+	return best
+}
+
+// hashed 按最高随机权重（rendezvous hashing）返回路由键对应的可用 BE 下标，没有可用 BE 时返回 -1
+// BE 增减或移出轮询时只有落在该 BE 上的键改变目标
+func (b *beBalancer) hashed(key string) int {
+	best, bestWeight := -1, uint64(0)
+	for idx, u := range b.urls {
+		if b.down[idx].Load() {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(u))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if w := h.Sum64(); best < 0 || w > bestWeight {
+			best, bestWeight = idx, w
+		}
+	}
+	return best
+}
+
+// PickOther 选择 exclude 之后的下一个可用 BE（不影响轮询顺序），没有其他可用 BE 时返回 exclude
+func (b *beBalancer) PickOther(exclude string) string {
+	for i, u := range b.urls {
+		if u != exclude {
+			continue
+		}
+		for j := 1; j < len(b.urls); j++ {
+			if idx := (i + j) % len(b.urls); !b.down[idx].Load() {
+				return b.urls[idx]
+			}
+		}
+		return exclude
+	}
+	return b.roundRobin()
+}
+
+// track 记录发往 be 的请求开始，返回的函数在请求结束时调用
+func (b *beBalancer) track(be string) func() {
+	for i, u := range b.urls {
+		if u == be {
+			b.inflight[i].Add(1)
+			return func() { b.inflight[i].Add(-1) }
+		}
+	}
+	return func() {}
+}
+
+// setDown 标记第 i 个 BE 是否移出轮询
+func (b *beBalancer) setDown(i int, down bool) {
+	b.down[i].Store(down)
+}
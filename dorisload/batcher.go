@@ -0,0 +1,346 @@
+package dorisload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrOverloaded 写入容量不足（队列已满或等待超时）
+var ErrOverloaded = errors.New("service overloaded")
+
+// ErrBatcherClosed 批量写入器已关闭
+var ErrBatcherClosed = errors.New("batcher closed")
+
+// batchItem 等待写入的单条事件
+type batchItem struct {
+	ctx  context.Context // 提交时的上下文，写入批次时通过 SubmitContexts 传给 Config.OnAttempt 和 ExemplarTraceID
+	data []byte
+	key  string // 公平调度键（WithBatchKey），未启用公平调度时为空
+	rows int
+	done chan batchResult
+}
+
+type submitContextsKey struct{}
+
+// SubmitContexts 返回批量写入时批次中各事件提交时的上下文（按提交顺序），不是批量写入时返回 nil
+// 批次的 Stream Load 不使用任何一个请求的上下文，Config.OnAttempt、ExemplarTraceID 可通过它取得各请求携带的值
+func SubmitContexts(ctx context.Context) []context.Context {
+	ctxs, _ := ctx.Value(submitContextsKey{}).([]context.Context)
+	return ctxs
+}
+
+// batchResult 事件所在分片的写入结果
+type batchResult struct {
+	label string
+	err   error
+}
+
+// batchTuner 根据 Doris 返回的耗时自适应调整批大小和刷新间隔
+// Doris 变慢时增大批次以摊薄事务开销，延迟预算充裕时缩小批次以降低等待时间
+type batchTuner struct {
+	minRows, maxRows         int
+	minInterval, maxInterval time.Duration
+	target                   time.Duration
+
+	mu       sync.Mutex
+	rows     int
+	interval time.Duration
+	ewmaLoad float64 // LoadTimeMs 的指数移动平均
+	ewmaTxn  float64 // BeginTxnTimeMs + CommitAndPublishTimeMs 的指数移动平均
+}
+
+const (
+	batchEWMAAlpha    = 0.2
+	batchGrowFactor   = 1.5
+	batchShrinkFactor = 0.75
+)
+
+// Current 返回当前的批大小和刷新间隔
+func (t *batchTuner) Current() (int, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rows, t.interval
+}
+
+// Saturate 使用最大的批大小和刷新间隔，冷却结束后由 Observe 按耗时逐步缩小
+func (t *batchTuner) Saturate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows, t.interval = t.maxRows, t.maxInterval
+}
+
+// Observe 记录一次 Stream Load 的耗时并调整参数
+func (t *batchTuner) Observe(resp *StreamLoadResponse) {
+	if resp == nil || resp.LoadTimeMs <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	txn := float64(resp.BeginTxnTimeMs + resp.CommitAndPublishTimeMs)
+	if t.ewmaLoad == 0 {
+		t.ewmaLoad, t.ewmaTxn = float64(resp.LoadTimeMs), txn
+	} else {
+		t.ewmaLoad = batchEWMAAlpha*float64(resp.LoadTimeMs) + (1-batchEWMAAlpha)*t.ewmaLoad
+		t.ewmaTxn = batchEWMAAlpha*txn + (1-batchEWMAAlpha)*t.ewmaTxn
+	}
+
+	targetMs := float64(t.target.Milliseconds())
+	switch {
+	case t.ewmaLoad > targetMs || t.ewmaTxn > targetMs/2:
+		// Doris 慢或事务开销占比高：增大批次，减少事务数
+		t.rows = min(int(float64(t.rows)*batchGrowFactor)+1, t.maxRows)
+		t.interval = min(time.Duration(float64(t.interval)*batchGrowFactor), t.maxInterval)
+	case t.ewmaLoad < targetMs/2:
+		// 延迟预算充裕：缩小批次，降低请求等待时间
+		t.rows = max(int(float64(t.rows)*batchShrinkFactor), t.minRows)
+		t.interval = max(time.Duration(float64(t.interval)*batchShrinkFactor), t.minInterval)
+	}
+}
+
+// BatchOptions 批量写入参数，批大小和刷新间隔在上下限内自适应调整
+type BatchOptions struct {
+	MinRows, MaxRows         int
+	MinInterval, MaxInterval time.Duration
+	TargetLatency            time.Duration // LoadTimeMs 的目标耗时
+	QueueSize                int           // 等待写入的事件数上限，积压超过 80% 时视为背压
+
+	// Fair 为 true 时按上下文中的公平调度键（WithBatchKey）分队列，按 Weights 加权轮询组成批次
+	Fair         bool
+	Weights      map[string]int // 按键的权重，未出现的键为 1
+	KeyQueueSize int            // 单个键排队的事件数上限，达到后该键的提交等待，其他键不受影响；为 0 时不限制
+
+	// Acquire 在写入批次前申请并发槽位，为 nil 时不限制并发
+	Acquire func(ctx context.Context) (release func(), ok bool)
+}
+
+// Batcher 将并发请求的事件合并为一次 Stream Load
+// 请求方等待所在批次写入完成后返回，保持同步写入语义
+type Batcher struct {
+	dc      *Client
+	table   *Table
+	acquire func(ctx context.Context) (release func(), ok bool)
+	tuner   *batchTuner
+	logger  *slog.Logger
+
+	fair  bool
+	queue *fairQueue
+	done  chan struct{}
+	wg    sync.WaitGroup // 进行中的 flush
+	exit  chan struct{}  // run 退出
+}
+
+// NewBatcher 创建写入指定表的批量写入器，调用方负责在退出前 Close
+func NewBatcher(dc *Client, table *Table, opts BatchOptions, logger *slog.Logger) (*Batcher, error) {
+	if opts.MinRows <= 0 || opts.MinRows > opts.MaxRows {
+		return nil, fmt.Errorf("批大小无效: %d～%d", opts.MinRows, opts.MaxRows)
+	}
+	if opts.MinInterval <= 0 || opts.MinInterval > opts.MaxInterval {
+		return nil, fmt.Errorf("刷新间隔无效: %v～%v", opts.MinInterval, opts.MaxInterval)
+	}
+	if opts.TargetLatency <= 0 || opts.QueueSize <= 0 {
+		return nil, fmt.Errorf("目标耗时和队列长度必须为正数")
+	}
+	if opts.KeyQueueSize < 0 || opts.KeyQueueSize > opts.QueueSize {
+		return nil, fmt.Errorf("单个键的队列长度无效: %d（应在 0 到 %d 之间）", opts.KeyQueueSize, opts.QueueSize)
+	}
+
+	b := &Batcher{
+		dc:      dc,
+		table:   table,
+		acquire: opts.Acquire,
+		logger:  logger.With("table", table.Name),
+		tuner: &batchTuner{
+			minRows:     opts.MinRows,
+			maxRows:     opts.MaxRows,
+			minInterval: opts.MinInterval,
+			maxInterval: opts.MaxInterval,
+			target:      opts.TargetLatency,
+			rows:        opts.MinRows,
+			interval:    opts.MinInterval,
+		},
+		fair:  opts.Fair,
+		queue: newFairQueue(opts.QueueSize, opts.KeyQueueSize, opts.Weights),
+		done:  make(chan struct{}),
+		exit:  make(chan struct{}),
+	}
+	b.queue.onHot = func(key string, share float64) {
+		b.logger.Warn("批量写入队列出现热点键，按权重轮询限制其占用", "key", key, "share", share)
+	}
+	go b.run()
+	return b, nil
+}
+
+// Submit 提交一条 NDJSON 事件并等待所在批次写入完成，返回事件所在分片的 Stream Load label
+func (b *Batcher) Submit(ctx context.Context, data []byte) (string, error) {
+	item := &batchItem{ctx: ctx, data: data, rows: countRows(data), done: make(chan batchResult, 1)}
+	if b.fair {
+		item.key = batchKey(ctx)
+	}
+	select {
+	case <-b.done:
+		return "", ErrBatcherClosed
+	default:
+	}
+	if err := b.queue.push(ctx, item, b.done); err != nil {
+		return "", err
+	}
+
+	select {
+	case res := <-item.done:
+		return res.label, res.err
+	case <-ctx.Done():
+		// 客户端已断开，事件仍会随批次写入
+		return "", ctx.Err()
+	}
+}
+
+// Pressured 队列积压超过 80% 时视为背压
+func (b *Batcher) Pressured() bool {
+	return b.queue.len() >= b.queue.capacity*8/10
+}
+
+// Current 返回当前的批大小和刷新间隔，表在冷却中（见 CooldownConfig）时为上限
+func (b *Batcher) Current() (int, time.Duration) {
+	if b.dc.CoolingDown(b.table.Name) {
+		b.tuner.Saturate()
+	}
+	return b.tuner.Current()
+}
+
+// QueueLength 返回队列中等待写入的事件数
+func (b *Batcher) QueueLength() int {
+	return b.queue.len()
+}
+
+// QueueCapacity 返回队列长度上限（BatchOptions.QueueSize）
+func (b *Batcher) QueueCapacity() int {
+	return b.queue.capacity
+}
+
+// KeyStats 返回各公平调度键的排队情况，未启用公平调度时返回 nil
+func (b *Batcher) KeyStats() []BatchKeyStats {
+	if !b.fair {
+		return nil
+	}
+	return b.queue.stats()
+}
+
+// run 从队列中收集事件，达到批大小或刷新间隔时写入
+func (b *Batcher) run() {
+	defer close(b.exit)
+	for {
+		first := b.queue.pop()
+		if first == nil {
+			select {
+			case <-b.queue.ready:
+			case <-b.done:
+				b.drain()
+				return
+			}
+			continue
+		}
+
+		batch := []*batchItem{first}
+		rows, interval := b.Current()
+		timer := time.NewTimer(interval)
+	collect:
+		for len(batch) < rows {
+			if item := b.queue.pop(); item != nil {
+				batch = append(batch, item)
+				continue
+			}
+			select {
+			case <-b.queue.ready:
+			case <-timer.C:
+				break collect
+			case <-b.done:
+				break collect
+			}
+		}
+		timer.Stop()
+		b.dispatch(batch)
+	}
+}
+
+// drain 关闭时写入队列中剩余的事件
+func (b *Batcher) drain() {
+	rows, _ := b.tuner.Current()
+	var batch []*batchItem
+	for {
+		item := b.queue.pop()
+		if item == nil {
+			if len(batch) > 0 {
+				b.dispatch(batch)
+			}
+			return
+		}
+		batch = append(batch, item)
+		if len(batch) >= rows {
+			b.dispatch(batch)
+			batch = nil
+		}
+	}
+}
+
+// dispatch 申请写入槽位后异步写入一个批次
+func (b *Batcher) dispatch(batch []*batchItem) {
+	release := func() {}
+	if b.acquire != nil {
+		if r, ok := b.acquire(context.Background()); ok {
+			release = r
+		}
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer release()
+		b.flush(batch)
+	}()
+}
+
+// flush 将批次作为一次 Stream Load 写入，并通知所有等待者
+// 批次超过单次 Stream Load 上限时拆分为多个事务，每个等待者收到所在分片的结果
+func (b *Batcher) flush(batch []*batchItem) {
+	lines := make([][]byte, len(batch))
+	ctx := context.Background()
+	if b.dc.config.OnAttempt != nil || b.dc.config.ExemplarTraceID != nil {
+		ctxs := make([]context.Context, len(batch))
+		for i, item := range batch {
+			ctxs[i] = item.ctx
+		}
+		ctx = context.WithValue(ctx, submitContextsKey{}, ctxs)
+	}
+	for i, item := range batch {
+		lines[i] = item.data
+	}
+
+	chunks := b.dc.WriteLinesSplit(ctx, b.table, uuid.New().String(), lines, b.logger)
+	cooling := b.dc.CoolingDown(b.table.Name)
+	for _, ch := range chunks {
+		if !cooling {
+			// 冷却期间保持最大批次，不按耗时缩小
+			b.tuner.Observe(ch.Resp)
+		}
+		if ch.Err != nil {
+			b.logger.Error("批量写入 Doris 失败", "label", ch.Label, "rows", ch.End-ch.Start, "error", ch.Err)
+		}
+		for _, item := range batch[ch.Start:ch.End] {
+			item.done <- batchResult{label: ch.Label, err: ch.Err}
+		}
+	}
+}
+
+// Close 停止接收新事件，写入剩余事件并等待所有批次完成
+func (b *Batcher) Close() {
+	close(b.done)
+	<-b.exit
+	b.wg.Wait()
+}
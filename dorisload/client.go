@@ -0,0 +1,492 @@
+// Package dorisload Doris Stream Load 客户端
+//
+// 直接连接 BE HTTP 端口写入 NDJSON 数据，支持多 BE 轮询、总时间预算内的重试（相同 label，由 Doris 去重）、
+// 对冲写入、超过单次上限时按行拆分，以及将并发写入合并为一次 Stream Load 的批量写入器。
+// doris-webhook 的 HTTP 服务基于该包实现，其他 Go 服务也可以直接引用，无需运行 HTTP 服务：
+//
+//	client := dorisload.New(&dorisload.Config{
+//		BEHTTP: []string{"http://be1:8040"}, DB: "video", User: "devops", Passwd: passwd,
+//	}, nil)
+//	defer client.Close()
+//	err := client.Load(ctx, &dorisload.Table{Name: "video_metrics", Columns: []string{"project", "event"}}, rows, logger)
+package dorisload
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxRedirects    = 10
+	defaultTimeout  = 30 * time.Second
+	idleConnTimeout = 90 * time.Second
+
+	// MaxConnsPerHost 每个 BE 的最大连接数
+	MaxConnsPerHost = 100
+)
+
+// 未设置时使用的默认值
+const (
+	defaultMaxLoadBytes   = 100 << 20
+	defaultWriteBudget    = 20 * time.Second
+	defaultAttemptTimeout = 10 * time.Second
+	defaultDebugMaxBytes  = 1024
+)
+
+// Config Doris 配置
+type Config struct {
+	BEHTTP       []string // BE HTTP 地址列表（用于 Stream Load），需带 http:// 或 https:// 前缀
+	DB           string
+	User         string
+	Passwd       string
+	MaxLoadBytes int64 // 单次 Stream Load 的数据上限，超过时拆分为多个事务，默认 100MB
+
+	// TableCredentials 按表名的 Doris 用户，用于各表属于不同用户、按最小权限授权的部署；未出现的表使用 User/Passwd
+	TableCredentials map[string]Credentials
+
+	WriteBudget    time.Duration // 单次写入（含重试）的总时间预算，默认 20s
+	AttemptTimeout time.Duration // 单次尝试的超时时间，默认 10s
+	MaxAttempts    int           // 可重试错误的最大尝试次数，默认 1（不重试）
+
+	// Cooldown 收到 too many versions 后的冷却，零值为不冷却
+	Cooldown CooldownConfig
+
+	// FailureRules 按 Doris 错误信息归类失败的规则（见 ParseFailureRules），优先于内置规则，用于新版本 Doris 的错误信息
+	FailureRules []FailureRule
+	// RetryableClasses 可重试的失败类别，为 nil 时使用 DefaultRetryableClasses
+	RetryableClasses []FailureClass
+
+	// Balance 多个 BE 之间的分配策略，默认轮询；hash 按 WithRoutingKey 设置的路由键（未设置时为表名）选择固定的 BE
+	Balance Balance
+
+	// TLSConfig 连接 https:// BE 使用的 TLS 配置（CA、客户端证书），为 nil 时使用系统默认
+	TLSConfig *tls.Config
+
+	// Proxy 连接 BE 使用的代理（见 ParseProxy），为 nil 时使用 HTTPS_PROXY、HTTP_PROXY、NO_PROXY 环境变量
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// Auth 在 Basic 认证之外附加的请求头和访问令牌（BE 前有认证代理时使用），为 nil 时不附加
+	Auth *AuthConfig
+
+	// Pool 连接池大小、存活时间和预热，零值为所有表共用一个连接池
+	Pool PoolConfig
+
+	// Continue Expect: 100-continue 行为，零值为带该请求头、不等待 100 Continue
+	Continue ContinueConfig
+
+	Debug         bool // 以 Debug 级别记录请求数据和写入结果
+	DebugMaxBytes int  // Debug 日志中请求数据的最大字节数，超出部分截断，默认 1024
+
+	// WrapTransport 包装发往 BE 的请求的 RoundTripper，用于追踪、指标或故障注入；为 nil 时不包装
+	WrapTransport func(http.RoundTripper) http.RoundTripper
+
+	// OnTrace 每次发往 BE 的 Stream Load 请求收到响应（或失败）后同步调用，传入客户端侧各阶段耗时，用于生成追踪 span；为 nil 时不调用
+	OnTrace func(ctx context.Context, t *ConnTrace)
+
+	// OnAttempt 每次 Stream Load 尝试结束后同步调用（含失败的尝试），用于审计；ctx 为写入时传入的上下文，为 nil 时不调用
+	OnAttempt func(ctx context.Context, a *Attempt)
+
+	// ExemplarTraceID 返回写入所属的追踪 ID，PhaseTimings、TraceTimings 的直方图据此为每个桶记录 exemplar；
+	// 批量写入时 ctx 同 OnAttempt，可通过 SubmitContexts 取得各请求的上下文。为 nil 或返回空串时不记录
+	ExemplarTraceID func(ctx context.Context) string
+}
+
+// Credentials Doris 用户名和密码（Basic 认证）
+type Credentials struct {
+	User   string
+	Passwd string
+}
+
+// header 返回 Authorization 请求头
+func (c Credentials) header() string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.User+":"+c.Passwd))
+}
+
+// Table Stream Load 的目标表
+type Table struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"` // Stream Load 的 columns 头
+}
+
+// ColumnsHeader 返回 Stream Load 的 columns 请求头
+func (t *Table) ColumnsHeader() string {
+	return strings.Join(t.Columns, ",")
+}
+
+// Stream Load 响应的 Status
+const (
+	StatusSuccess            = "Success"
+	StatusPublishTimeout     = "Publish Timeout" // 事务已提交，数据稍后可见
+	StatusLabelAlreadyExists = "Label Already Exists"
+	StatusFail               = "Fail"
+)
+
+// Status 为 Label Already Exists 时原任务的状态（ExistingJobStatus）
+// Stream Load 返回 RUNNING 或 FINISHED，两阶段提交的事务还可能返回 PREPARE、COMMITTED、VISIBLE、ABORTED
+const (
+	JobRunning   = "RUNNING"
+	JobPrepare   = "PREPARE"
+	JobCommitted = "COMMITTED"
+	JobVisible   = "VISIBLE"
+	JobFinished  = "FINISHED"
+	JobAborted   = "ABORTED"
+)
+
+// LoadOutcome 由 Stream Load 响应的 Status 和 ExistingJobStatus 得出的写入结果
+type LoadOutcome int
+
+const (
+	OutcomeFailed    LoadOutcome = iota // 写入失败：Status 为 Fail、未知状态，或 label 已存在但原任务已中止
+	OutcomeVisible                      // Success：事务已提交且数据可见
+	OutcomeCommitted                    // Publish Timeout：事务已提交，数据稍后可见
+	OutcomeDuplicate                    // Label Already Exists 且原任务已提交（FINISHED、COMMITTED、VISIBLE）
+	OutcomePending                      // Label Already Exists 且原任务尚未结束（RUNNING、PREPARE），稍后重试可得到结果
+)
+
+var outcomeNames = [...]string{"failed", "visible", "committed", "duplicate", "pending"}
+
+func (o LoadOutcome) String() string {
+	if int(o) < len(outcomeNames) {
+		return outcomeNames[o]
+	}
+	return "unknown"
+}
+
+// Committed 判断数据是否已提交（此次写入或此前使用相同 label 的写入）
+func (o LoadOutcome) Committed() bool {
+	return o == OutcomeVisible || o == OutcomeCommitted || o == OutcomeDuplicate
+}
+
+// StreamLoadResponse Doris Stream Load 响应
+type StreamLoadResponse struct {
+	TxnID                  int64  `json:"TxnId"`
+	Label                  string `json:"Label"`
+	Comment                string `json:"Comment"`
+	TwoPhaseCommit         string `json:"TwoPhaseCommit"` // "true" 时事务处于预提交状态
+	GroupCommit            bool   `json:"GroupCommit"`
+	Status                 string `json:"Status"`
+	Message                string `json:"Message"`
+	NumberTotalRows        int64  `json:"NumberTotalRows"`
+	NumberLoadedRows       int64  `json:"NumberLoadedRows"`
+	NumberFilteredRows     int64  `json:"NumberFilteredRows"`
+	NumberUnselectedRows   int64  `json:"NumberUnselectedRows"`
+	LoadBytes              int64  `json:"LoadBytes"`
+	LoadTimeMs             int64  `json:"LoadTimeMs"`
+	BeginTxnTimeMs         int64  `json:"BeginTxnTimeMs"`
+	StreamLoadPutTimeMs    int64  `json:"StreamLoadPutTimeMs"`
+	ReadDataTimeMs         int64  `json:"ReadDataTimeMs"`
+	WriteDataTimeMs        int64  `json:"WriteDataTimeMs"`
+	ReceiveDataTimeMs      int64  `json:"ReceiveDataTimeMs"`
+	CommitAndPublishTimeMs int64  `json:"CommitAndPublishTimeMs"`
+	ErrorURL               string `json:"ErrorURL"`
+	ExistingJobStatus      string `json:"ExistingJobStatus"` // label 已存在时原任务的状态，见 JobRunning 等
+}
+
+// Outcome 按 Status 和 ExistingJobStatus 判断写入结果，未知的 Status 视为失败
+func (r *StreamLoadResponse) Outcome() LoadOutcome {
+	switch r.Status {
+	case StatusSuccess:
+		return OutcomeVisible
+	case StatusPublishTimeout:
+		return OutcomeCommitted
+	case StatusLabelAlreadyExists:
+		switch strings.ToUpper(r.ExistingJobStatus) {
+		case JobFinished, JobVisible, JobCommitted:
+			return OutcomeDuplicate
+		case JobRunning, JobPrepare:
+			return OutcomePending
+		}
+	}
+	return OutcomeFailed
+}
+
+// Succeeded 判断此次写入是否成功：Publish Timeout 表示事务已提交，只是尚未可见
+func (r *StreamLoadResponse) Succeeded() bool {
+	o := r.Outcome()
+	return o == OutcomeVisible || o == OutcomeCommitted
+}
+
+// ErrLabelAlreadyExists label 已被使用；响应的 Outcome 为 OutcomeDuplicate 时说明该批数据此前已提交（使用固定 label 重放时可视为成功）
+var ErrLabelAlreadyExists = errors.New("label already exists")
+
+// Client Doris Stream Load 客户端，可并发使用
+type Client struct {
+	config     *Config
+	client     *http.Client // 共用连接池，Pool.PerTable 时只用于健康检查、预检和事务提交
+	pool       *connPool
+	balancer   *beBalancer
+	authHeader string
+	tableAuth  map[string]string // 按表名的 Authorization 请求头（Config.TableCredentials）
+	hedge      *HedgePolicy      // 未启用对冲写入时为 nil
+	latency    latencyWindow     // 最近成功写入的耗时
+	timings    phaseTimings      // 成功写入的各阶段耗时
+	traces     traceTimings      // 发往各 BE 的请求在客户端侧的各阶段耗时
+	health     healthState       // BE 健康检查状态，未启动健康检查时为空
+
+	poolsMu    sync.Mutex
+	tablePools map[string]*tablePool // Pool.PerTable 时按表名索引的连接池
+	recycler   recycleState
+	noContinue []atomic.Bool // 与 BEHTTP 一一对应，回退后不再带 Expect 请求头
+	cooldowns  cooldownState
+
+	ctx    context.Context // 客户端生命周期，关闭时取消所有进行中的写入
+	cancel context.CancelFunc
+}
+
+// New 创建 Doris 客户端，hedge 为 nil 时不启用对冲写入
+// cfg 中未设置的上限和超时使用默认值
+func New(cfg *Config, hedge *HedgePolicy) *Client {
+	c := *cfg
+	if c.MaxLoadBytes <= 0 {
+		c.MaxLoadBytes = defaultMaxLoadBytes
+	}
+	if c.WriteBudget <= 0 {
+		c.WriteBudget = defaultWriteBudget
+	}
+	if c.AttemptTimeout <= 0 {
+		c.AttemptTimeout = defaultAttemptTimeout
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 1
+	}
+	if c.DebugMaxBytes <= 0 {
+		c.DebugMaxBytes = defaultDebugMaxBytes
+	}
+	if c.RetryableClasses == nil {
+		c.RetryableClasses = DefaultRetryableClasses
+	}
+
+	dc := &Client{
+		config:     &c,
+		hedge:      hedge,
+		balancer:   newBEBalancer(c.BEHTTP, c.Balance),
+		authHeader: Credentials{User: c.User, Passwd: c.Passwd}.header(),
+		tableAuth:  make(map[string]string, len(c.TableCredentials)),
+		tablePools: make(map[string]*tablePool),
+	}
+	for name, cred := range c.TableCredentials {
+		dc.tableAuth[name] = cred.header()
+	}
+	dc.client, dc.pool = dc.newHTTPClient()
+	dc.recycler.failures = make([]atomic.Int64, len(c.BEHTTP))
+	dc.noContinue = make([]atomic.Bool, len(c.BEHTTP))
+	dc.ctx, dc.cancel = context.WithCancel(context.Background())
+	return dc
+}
+
+// authFor 返回写入目标表使用的 Authorization 请求头，table 为 nil 时使用 User/Passwd
+func (dc *Client) authFor(table *Table) string {
+	if table != nil {
+		if h, ok := dc.tableAuth[table.Name]; ok {
+			return h
+		}
+	}
+	return dc.authHeader
+}
+
+// streamURL 返回指定 BE 上目标表的 Stream Load 地址，库名和表名应已通过 ValidateIdentifier 校验，这里再转义一次
+func (dc *Client) streamURL(be string, table *Table) string {
+	return fmt.Sprintf("%s/api/%s/%s/_stream_load", be, url.PathEscape(dc.config.DB), url.PathEscape(table.Name))
+}
+
+// Load 将行序列化为 NDJSON 写入目标表，超过单次 Stream Load 上限时拆分为多个事务
+// 任一分片失败时返回错误，此时其他分片可能已经提交
+func (dc *Client) Load(ctx context.Context, table *Table, rows []map[string]any, logger *slog.Logger) error {
+	lines := make([][]byte, len(rows))
+	for i, row := range rows {
+		line, err := json.Marshal(row)
+		if err != nil {
+			return fmt.Errorf("序列化第 %d 行失败: %w", i, err)
+		}
+		lines[i] = append(line, '\n')
+	}
+	var errs []error
+	for _, ch := range dc.WriteLinesSplit(ctx, table, uuid.New().String(), lines, logger) {
+		errs = append(errs, ch.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// Write 写入数据到 Doris BE
+// 直接连接 BE HTTP 端口进行 Stream Load，不经过 FE
+func (dc *Client) Write(ctx context.Context, table *Table, data []byte, logger *slog.Logger) error {
+	_, err := dc.WriteWithLabel(ctx, table, uuid.New().String(), data, logger)
+	return err
+}
+
+// WriteWithLabel 使用指定 label 写入 NDJSON 数据，相同 label 的重复写入会被 Doris 拒绝
+// 写入受 WriteBudget 总预算约束，可重试错误在预算内重试；客户端关闭时返回 ErrShuttingDown
+// Doris 返回了响应体时，即使写入失败也会返回解析后的 StreamLoadResponse
+func (dc *Client) WriteWithLabel(ctx context.Context, table *Table, label string, data []byte, logger *slog.Logger) (*StreamLoadResponse, error) {
+	return dc.writeWithRetry(ctx, table, label, data, &LoadOptions{}, logger)
+}
+
+// WriteWithOptions 按指定选项写入数据，重试和 label 语义与 WriteWithLabel 相同
+// 启用 group commit 时不设置 label，只尝试一次
+func (dc *Client) WriteWithOptions(ctx context.Context, table *Table, label string, data []byte, opts LoadOptions, logger *slog.Logger) (*StreamLoadResponse, error) {
+	return dc.writeWithRetry(ctx, table, label, data, &opts, logger)
+}
+
+// PreviewRequest 返回按 opts 写入目标表时发往第一个 BE 的 Stream Load 地址和请求头，不发送请求，用于调试配置
+// Authorization 的凭证已隐去；Expect 请求头取决于该 BE 当前是否已回退（见 ContinueConfig.Fallback）
+func (dc *Client) PreviewRequest(table *Table, label string, opts LoadOptions) (string, http.Header) {
+	be := dc.config.BEHTTP[0]
+	h := make(http.Header)
+	h.Set("Authorization", "Basic ******")
+	if dc.expectContinue(be) {
+		h.Set("Expect", "100-continue")
+	}
+	opts.setHeaders(h, table, label)
+	return dc.streamURL(be, table), h
+}
+
+// streamLoad 向指定 BE 发起一次 Stream Load
+// 带 Expect: 100-continue 的请求没有收到 100 Continue 时（见 ContinueConfig.Fallback），此后发往该 BE 的请求不再带该请求头，
+// 请求失败时立即不带该请求头重发一次（label 相同，已提交时由 Doris 去重）
+func (dc *Client) streamLoad(ctx context.Context, be string, table *Table, label string, data []byte, opts *LoadOptions, logger *slog.Logger) (*StreamLoadResponse, error) {
+	resp, err, missing := dc.streamLoadOnce(ctx, be, table, label, data, opts, dc.expectContinue(be), logger)
+	if !missing {
+		return resp, err
+	}
+	dc.disableContinue(be, logger)
+	if err == nil || resp != nil {
+		// 已收到 Doris 的写入结果，不需要重试
+		return resp, err
+	}
+	logger.Warn("未收到 100 Continue，不带 Expect 请求头重试", "be", be, "label", label, "error", err)
+	resp, err, _ = dc.streamLoadOnce(ctx, be, table, label, data, opts, false, logger)
+	if errors.Is(err, ErrLabelAlreadyExists) && resp != nil && resp.Outcome() == OutcomeDuplicate {
+		return resp, nil
+	}
+	return resp, err
+}
+
+// streamLoadOnce 发起一次 Stream Load 请求，expect 为是否带 Expect: 100-continue
+// continueMissing 表示带了 Expect 但没有收到 100 Continue，且请求成功、返回 417 或连接失败（疑似中间代理不支持）
+func (dc *Client) streamLoadOnce(ctx context.Context, be string, table *Table, label string, data []byte, opts *LoadOptions, expect bool, logger *slog.Logger) (_ *StreamLoadResponse, _ error, continueMissing bool) {
+	url := dc.streamURL(be, table)
+
+	if dc.config.Debug {
+		logger.Debug("向 Doris BE 发送请求", "url", url, "bytes", len(data), "data", truncateForLog(data, dc.config.DebugMaxBytes))
+	}
+
+	trace := &ConnTrace{BE: be}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace.clientTrace()), "PUT", url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %w", err), false
+	}
+
+	// 设置 ContentLength，这样 Go 会自动处理 100-continue
+	req.ContentLength = int64(len(data))
+
+	// 设置请求头（与 curl 脚本保持一致）
+	req.Header.Set("Authorization", dc.authFor(table))
+	if expect {
+		req.Header.Set("Expect", "100-continue")
+	}
+	opts.setHeaders(req.Header, table, label)
+	fallback := expect && dc.config.Continue.fallback()
+
+	defer dc.balancer.track(be)()
+	resp, err := dc.clientFor(table).Do(req)
+	dc.observeTrace(ctx, trace, logger)
+	if err != nil {
+		dc.observeAttempt(be, !errors.Is(ctx.Err(), context.Canceled), logger)
+		return nil, dc.requestError(ctx, err), fallback && !trace.continued() && ctx.Err() == nil
+	}
+	defer resp.Body.Close()
+	dc.observeAttempt(be, resp.StatusCode >= http.StatusInternalServerError, logger)
+	continueMissing = fallback && !trace.continued() && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusExpectationFailed)
+
+	body, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		return nil, fmt.Errorf("读取 Doris 响应体失败: %w", readErr), continueMissing
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("Doris 返回错误", "status_code", resp.StatusCode, "body", string(body))
+		err := fmt.Errorf("doris 返回错误 [%d]: %s", resp.StatusCode, string(body))
+		return nil, dc.statusError(resp.StatusCode, string(body), err), continueMissing
+	}
+
+	// 解析响应体
+	var loadResp StreamLoadResponse
+	if err := json.Unmarshal(body, &loadResp); err != nil {
+		logger.Error("解析响应体失败", "error", err, "body", string(body))
+		return nil, fmt.Errorf("无法解析 Doris 响应: %s", string(body)), continueMissing
+	}
+
+	// 检查实际执行状态
+	switch outcome := loadResp.Outcome(); {
+	case outcome == OutcomeDuplicate:
+		// 调用方据此区分重放（此前已提交）和首次写入，重试时视为成功
+		return &loadResp, fmt.Errorf("doris stream load 失败: Label=%s: %w", label, ErrLabelAlreadyExists), continueMissing
+	case outcome == OutcomePending:
+		// 使用相同 label 的写入仍在进行，稍后重试可得到其结果；尚未提交，不能按 ErrLabelAlreadyExists 视为重放成功
+		return &loadResp, dc.loadError(FailureInProgress, fmt.Errorf("doris stream load 未完成: Label=%s 的原任务状态为 %s",
+			label, loadResp.ExistingJobStatus)), continueMissing
+	case !outcome.Committed():
+		logger.Error("Doris stream load 失败",
+			"status", loadResp.Status,
+			"existing_job_status", loadResp.ExistingJobStatus,
+			"message", loadResp.Message,
+			"error_url", loadResp.ErrorURL)
+		if loadResp.Status == StatusLabelAlreadyExists {
+			return &loadResp, dc.loadError(FailureUnknown, fmt.Errorf("doris stream load 失败: Label=%s 的原任务状态为 %s",
+				label, loadResp.ExistingJobStatus)), continueMissing
+		}
+		class := dc.classify(loadResp.Message, FailureUnknown)
+		return &loadResp, dc.loadError(class, fmt.Errorf("doris stream load 失败: Status=%s, Class=%s, Message=%s, ErrorURL=%s",
+			loadResp.Status, class, loadResp.Message, loadResp.ErrorURL)), continueMissing
+	}
+
+	if dc.config.Debug {
+		logger.Debug("Doris 写入成功",
+			"label", loadResp.Label,
+			"loaded_rows", loadResp.NumberLoadedRows,
+			"total_rows", loadResp.NumberTotalRows,
+			"load_time_ms", loadResp.LoadTimeMs)
+	}
+	return &loadResp, nil, continueMissing
+}
+
+// observeTrace 记录一次请求的客户端侧耗时
+func (dc *Client) observeTrace(ctx context.Context, trace *ConnTrace, logger *slog.Logger) {
+	dc.traces.observe(trace, dc.exemplarTraceID(ctx))
+	if dc.config.OnTrace != nil {
+		dc.config.OnTrace(ctx, trace)
+	}
+	if dc.config.Debug {
+		logger.Debug("BE 请求耗时", "be", trace.BE, "reused", trace.Reused,
+			"dns", trace.DNS, "connect", trace.Connect, "tls", trace.TLS,
+			"continue_wait", trace.ContinueWait, "first_byte", trace.FirstByte)
+	}
+}
+
+// truncateForLog 将请求数据截断到 n 字节用于 Debug 日志，超出时以 ...(truncated) 结尾
+func truncateForLog(data []byte, n int) string {
+	if len(data) <= n {
+		return string(data)
+	}
+	for n > 0 && !utf8.RuneStart(data[n]) {
+		n--
+	}
+	return string(data[:n]) + "...(truncated)"
+}
@@ -0,0 +1,116 @@
+package dorisload
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newTestClient 创建写入 httptest BE 的客户端，BE 对每个 Stream Load 请求返回 status 和 body
+func newTestClient(t *testing.T, status int, body string) *Client {
+	t.Helper()
+	be := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/api/db/events/_stream_load" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("label") != "test-label" {
+			t.Errorf("label header = %q, want test-label", r.Header.Get("label"))
+		}
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(be.Close)
+	dc := New(&Config{BEHTTP: []string{be.URL}, DB: "db", User: "root"}, nil)
+	t.Cleanup(dc.Close)
+	return dc
+}
+
+func TestWriteWithLabelOutcome(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		outcome   LoadOutcome
+		duplicate bool         // 返回 ErrLabelAlreadyExists
+		class     FailureClass // 为空时不应返回 LoadError
+	}{
+		{name: "success", body: `{"Status":"Success","Label":"test-label","NumberLoadedRows":1}`, outcome: OutcomeVisible},
+		{name: "publish timeout", body: `{"Status":"Publish Timeout","Label":"test-label"}`, outcome: OutcomeCommitted},
+		{name: "label exists finished", body: `{"Status":"Label Already Exists","ExistingJobStatus":"FINISHED"}`, outcome: OutcomeDuplicate, duplicate: true},
+		{name: "label exists visible", body: `{"Status":"Label Already Exists","ExistingJobStatus":"VISIBLE"}`, outcome: OutcomeDuplicate, duplicate: true},
+		{name: "label exists committed", body: `{"Status":"Label Already Exists","ExistingJobStatus":"COMMITTED"}`, outcome: OutcomeDuplicate, duplicate: true},
+		{name: "label exists lowercase", body: `{"Status":"Label Already Exists","ExistingJobStatus":"finished"}`, outcome: OutcomeDuplicate, duplicate: true},
+		{name: "label exists running", body: `{"Status":"Label Already Exists","ExistingJobStatus":"RUNNING"}`, outcome: OutcomePending, class: FailureInProgress},
+		{name: "label exists prepare", body: `{"Status":"Label Already Exists","ExistingJobStatus":"PREPARE"}`, outcome: OutcomePending, class: FailureInProgress},
+		{name: "label exists aborted", body: `{"Status":"Label Already Exists","ExistingJobStatus":"ABORTED"}`, outcome: OutcomeFailed, class: FailureUnknown},
+		{name: "label exists without job status", body: `{"Status":"Label Already Exists"}`, outcome: OutcomeFailed, class: FailureUnknown},
+		{name: "fail", body: `{"Status":"Fail","Message":"too many filtered rows"}`, outcome: OutcomeFailed, class: FailureSchema},
+		{name: "unknown status", body: `{"Status":"Whatever"}`, outcome: OutcomeFailed, class: FailureUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := newTestClient(t, http.StatusOK, tt.body)
+			resp, err := dc.WriteWithLabel(context.Background(), &Table{Name: "events"}, "test-label", []byte("{}\n"), discardLogger)
+			if resp == nil {
+				t.Fatalf("response = nil, err = %v", err)
+			}
+			if got := resp.Outcome(); got != tt.outcome {
+				t.Errorf("Outcome() = %s, want %s", got, tt.outcome)
+			}
+			if got := errors.Is(err, ErrLabelAlreadyExists); got != tt.duplicate {
+				t.Errorf("errors.Is(err, ErrLabelAlreadyExists) = %v, want %v (err = %v)", got, tt.duplicate, err)
+			}
+			switch {
+			case tt.class != "":
+				if got := ClassOf(err); got != tt.class {
+					t.Errorf("ClassOf(err) = %q, want %q (err = %v)", got, tt.class, err)
+				}
+			case !tt.duplicate && err != nil:
+				t.Errorf("err = %v, want nil", err)
+			}
+			if got, want := resp.Succeeded(), tt.outcome == OutcomeVisible || tt.outcome == OutcomeCommitted; got != want {
+				t.Errorf("Succeeded() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteWithLabelHTTPError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		class     FailureClass
+		retryable bool
+	}{
+		{name: "unauthorized", status: http.StatusUnauthorized, class: FailureAuth},
+		{name: "forbidden", status: http.StatusForbidden, class: FailureAuth},
+		{name: "bad request", status: http.StatusBadRequest, class: FailureUnknown},
+		{name: "internal error", status: http.StatusInternalServerError, class: FailureServer, retryable: true},
+		{name: "service unavailable", status: http.StatusServiceUnavailable, class: FailureServer, retryable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 响应体是合法的 Success 响应，也不应按成功处理
+			dc := newTestClient(t, tt.status, `{"Status":"Success"}`)
+			resp, err := dc.WriteWithLabel(context.Background(), &Table{Name: "events"}, "test-label", []byte("{}\n"), discardLogger)
+			if err == nil {
+				t.Fatalf("err = nil, want error for HTTP %d", tt.status)
+			}
+			if resp != nil {
+				t.Errorf("response = %+v, want nil", resp)
+			}
+			if got := ClassOf(err); got != tt.class {
+				t.Errorf("ClassOf(err) = %q, want %q", got, tt.class)
+			}
+			if got := IsRetryable(err); got != tt.retryable {
+				t.Errorf("IsRetryable(err) = %v, want %v", got, tt.retryable)
+			}
+		})
+	}
+}
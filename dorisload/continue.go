@@ -0,0 +1,41 @@
+package dorisload
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// ContinueConfig Stream Load 请求的 Expect: 100-continue 行为
+// BE 在读取数据前校验 label、鉴权等，带该请求头时被拒绝的请求不必发送数据；部分中间代理不支持该请求头，会吞掉 100 Continue 或返回 417
+type ContinueConfig struct {
+	Disabled bool // 不带 Expect: 100-continue
+
+	// Timeout 发出请求头后等待 100 Continue 的时间，超时后照常发送数据；为 0 时不等待，请求头和数据一起发送（默认）
+	Timeout time.Duration
+
+	// NoFallback 关闭回退：默认在 Timeout 大于 0 时，带 Expect 的请求没有收到 100 Continue（成功、返回 417 或连接失败）后，
+	// 此后发往该 BE 的请求不再带该请求头，失败的请求立即不带该请求头重发一次
+	NoFallback bool
+}
+
+// fallback 判断是否启用回退：不等待 100 Continue 时无法判断是否缺失
+func (c ContinueConfig) fallback() bool {
+	return !c.Disabled && !c.NoFallback && c.Timeout > 0
+}
+
+// expectContinue 判断发往 be 的请求是否带 Expect: 100-continue
+func (dc *Client) expectContinue(be string) bool {
+	if dc.config.Continue.Disabled {
+		return false
+	}
+	i := slices.Index(dc.config.BEHTTP, be)
+	return i < 0 || !dc.noContinue[i].Load()
+}
+
+// disableContinue 此后发往 be 的请求不再带 Expect: 100-continue，直到进程重启
+func (dc *Client) disableContinue(be string, logger *slog.Logger) {
+	if i := slices.Index(dc.config.BEHTTP, be); i >= 0 && !dc.noContinue[i].Swap(true) {
+		logger.Warn("BE 未返回 100 Continue，此后发往该 BE 的请求不再带 Expect 请求头", "be", be)
+	}
+}
@@ -0,0 +1,88 @@
+package dorisload
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// CooldownConfig 收到 too many versions（-235）后的冷却：tablet 版本数过多时立即重试只会让 compaction 更难跟上，
+// 冷却期间重试等待冷却结束，批量写入器使用最大批大小和刷新间隔，减少事务数
+type CooldownConfig struct {
+	// Duration 冷却时长，冷却过半后再次收到 too many versions 时加倍（不超过 8 倍）；为 0 时不冷却，按 retryBackoff 立即重试
+	Duration time.Duration
+
+	// Global 为 true 时任一表触发冷却后所有表一起冷却（compaction 压力在整个 BE 上），默认只冷却触发的表
+	Global bool
+}
+
+// cooldownMaxFactor 连续触发时冷却时长的最大倍数
+const cooldownMaxFactor = 8
+
+// cooldownGlobalKey Global 时所有表共用的冷却状态
+const cooldownGlobalKey = ""
+
+// cooldownState 按表名（Global 时为 cooldownGlobalKey）索引的冷却状态
+type cooldownState struct {
+	mu     sync.Mutex
+	tables map[string]*cooldown
+}
+
+type cooldown struct {
+	start  time.Time // 本轮冷却开始（或上次加倍）的时间
+	until  time.Time
+	factor int // 当前冷却时长的倍数，冷却结束后下次触发从 1 倍开始
+}
+
+func (dc *Client) cooldownKey(table string) string {
+	if dc.config.Cooldown.Global {
+		return cooldownGlobalKey
+	}
+	return table
+}
+
+// triggerCooldown 表收到 too many versions 后开始或延长冷却，返回冷却结束时间
+func (dc *Client) triggerCooldown(table string, logger *slog.Logger) time.Time {
+	base := dc.config.Cooldown.Duration
+	now := time.Now()
+	key := dc.cooldownKey(table)
+
+	s := &dc.cooldowns
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tables == nil {
+		s.tables = make(map[string]*cooldown)
+	}
+	c := s.tables[key]
+	switch {
+	case c == nil || now.After(c.until):
+		c = &cooldown{start: now, factor: 1}
+		s.tables[key] = c
+	case c.factor < cooldownMaxFactor && now.Sub(c.start) >= base*time.Duration(c.factor)/2:
+		// 冷却过半后仍收到说明版本数还在增加；同一时刻并发写入的多次失败只算一次
+		c.start = now
+		c.factor *= 2
+	}
+	d := base * time.Duration(c.factor)
+	if until := now.Add(d); until.After(c.until) {
+		c.until = until
+		logger.Warn("Doris tablet 版本数过多，暂停重试并增大批次", "table", table, "global", key == cooldownGlobalKey, "cooldown", d)
+	}
+	return c.until
+}
+
+// CooldownUntil 返回表的冷却结束时间，不在冷却中时返回零值
+func (dc *Client) CooldownUntil(table string) time.Time {
+	s := &dc.cooldowns
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.tables[dc.cooldownKey(table)]; c != nil && time.Now().Before(c.until) {
+		return c.until
+	}
+	return time.Time{}
+}
+
+// CoolingDown 判断表是否在冷却中
+func (dc *Client) CoolingDown(table string) bool {
+	return !dc.CooldownUntil(table).IsZero()
+}
@@ -0,0 +1,41 @@
+package dorisload
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// Exemplar 直方图桶的示例：最近一次落入该桶的观测及其所属的追踪，用于从慢的桶跳转到对应写入的追踪
+type Exemplar struct {
+	TraceID string
+	Value   float64 // 秒
+	Time    time.Time
+}
+
+// bucketIndex 返回 v 落入的最小的桶，超过所有桶上界时为 len(PhaseBuckets)（+Inf 桶）
+func bucketIndex(v float64) int {
+	i, _ := slices.BinarySearch(PhaseBuckets, v)
+	return i
+}
+
+// recordExemplar 记录观测到 exemplars（与 PhaseBuckets 一一对应，最后一个为 +Inf 桶），traceID 为空时不记录
+// exemplars 为 nil 时分配，返回记录后的切片
+func recordExemplar(exemplars []Exemplar, traceID string, v float64, now time.Time) []Exemplar {
+	if traceID == "" {
+		return exemplars
+	}
+	if exemplars == nil {
+		exemplars = make([]Exemplar, len(PhaseBuckets)+1)
+	}
+	exemplars[bucketIndex(v)] = Exemplar{TraceID: traceID, Value: v, Time: now}
+	return exemplars
+}
+
+// exemplarTraceID 返回写入所属的追踪 ID，未设置 Config.ExemplarTraceID 时为空
+func (dc *Client) exemplarTraceID(ctx context.Context) string {
+	if dc.config.ExemplarTraceID == nil {
+		return ""
+	}
+	return dc.config.ExemplarTraceID(ctx)
+}
@@ -0,0 +1,176 @@
+package dorisload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// FailureClass Stream Load 失败的类别，决定是否重试（见 Config.RetryableClasses）
+type FailureClass string
+
+const (
+	FailureNetwork         FailureClass = "network"           // 连接 BE 失败
+	FailureTimeout         FailureClass = "timeout"           // 单次尝试超时，或 Doris 报告超时
+	FailureServer          FailureClass = "server"            // BE 返回 5xx，且没有匹配更具体的类别
+	FailureInProgress      FailureClass = "in_progress"       // 使用相同 label 的写入仍在进行
+	FailureTooManyVersions FailureClass = "too_many_versions" // tablet 版本数过多，compaction 跟上后可恢复
+	FailureMemoryLimit     FailureClass = "memory_limit"      // BE 内存超限
+	FailureAuth            FailureClass = "auth"              // 认证失败或没有权限
+	FailureSchema          FailureClass = "schema"            // 数据与表结构不符：列不存在、类型不匹配、过滤行过多
+	FailureUnknown         FailureClass = "unknown"           // 没有匹配任何规则
+)
+
+// FailureClasses 所有失败类别
+var FailureClasses = []FailureClass{
+	FailureNetwork, FailureTimeout, FailureServer, FailureInProgress, FailureTooManyVersions,
+	FailureMemoryLimit, FailureAuth, FailureSchema, FailureUnknown,
+}
+
+// DefaultRetryableClasses 未设置 Config.RetryableClasses 时可重试的类别，其余类别重试不会改变结果
+var DefaultRetryableClasses = []FailureClass{
+	FailureNetwork, FailureTimeout, FailureServer, FailureInProgress, FailureTooManyVersions, FailureMemoryLimit,
+}
+
+// FailureRule 按 Doris 返回的错误信息归类失败：Match 为不区分大小写的子串
+type FailureRule struct {
+	Match string
+	Class FailureClass
+}
+
+// defaultFailureRules 内置的归类规则，按顺序匹配，Config.FailureRules 优先于这些规则
+var defaultFailureRules = []FailureRule{
+	{"too many versions", FailureTooManyVersions},
+	{"err=-235", FailureTooManyVersions},
+	{"memory limit exceeded", FailureMemoryLimit},
+	{"mem_limit_exceeded", FailureMemoryLimit},
+	{"memory exceed", FailureMemoryLimit},
+	{"access denied", FailureAuth},
+	{"authentication failed", FailureAuth},
+	{"privilege", FailureAuth},
+	{"too many filtered rows", FailureSchema},
+	{"data quality error", FailureSchema},
+	{"unknown column", FailureSchema},
+	{"column not found", FailureSchema},
+	{"does not exist", FailureSchema},
+	{"parse json", FailureSchema},
+	{"timeout", FailureTimeout},
+	{"timed out", FailureTimeout},
+}
+
+// ParseFailureRules 解析逗号分隔的归类规则，每条为 错误信息子串=类别，如 "tablet writer write failed=server"
+func ParseFailureRules(raw string) ([]FailureRule, error) {
+	var rules []FailureRule
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		match, class, ok := strings.Cut(item, "=")
+		match = strings.TrimSpace(match)
+		if !ok || match == "" {
+			return nil, fmt.Errorf("归类规则无效: %q（格式为 错误信息子串=类别）", item)
+		}
+		c, err := ParseFailureClass(class)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, FailureRule{Match: match, Class: c})
+	}
+	return rules, nil
+}
+
+// ParseFailureClasses 解析逗号分隔的失败类别
+func ParseFailureClasses(raw string) ([]FailureClass, error) {
+	classes := []FailureClass{}
+	for _, item := range strings.Split(raw, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		c, err := ParseFailureClass(item)
+		if err != nil {
+			return nil, err
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// ParseFailureClass 解析失败类别
+func ParseFailureClass(raw string) (FailureClass, error) {
+	c := FailureClass(strings.ToLower(strings.TrimSpace(raw)))
+	if !slices.Contains(FailureClasses, c) {
+		names := make([]string, len(FailureClasses))
+		for i, fc := range FailureClasses {
+			names[i] = string(fc)
+		}
+		return "", fmt.Errorf("失败类别无效: %q（可选 %s）", raw, strings.Join(names, "、"))
+	}
+	return c, nil
+}
+
+// LoadError Stream Load 失败及其类别，Retryable 为 true 时在写入预算内使用相同 label 重试
+type LoadError struct {
+	Class     FailureClass
+	Retryable bool
+	Err       error
+}
+
+func (e *LoadError) Error() string { return e.Err.Error() }
+func (e *LoadError) Unwrap() error { return e.Err }
+
+// ClassOf 返回写入错误的类别，err 不是 Stream Load 失败时返回 FailureUnknown
+func ClassOf(err error) FailureClass {
+	var le *LoadError
+	if errors.As(err, &le) {
+		return le.Class
+	}
+	return FailureUnknown
+}
+
+// IsRetryable 判断写入错误是否属于可重试的类别
+func IsRetryable(err error) bool {
+	var le *LoadError
+	return errors.As(err, &le) && le.Retryable
+}
+
+// classify 按配置的规则和内置规则归类错误信息，都不匹配时返回 fallback
+func (dc *Client) classify(message string, fallback FailureClass) FailureClass {
+	lower := strings.ToLower(message)
+	for _, rules := range [][]FailureRule{dc.config.FailureRules, defaultFailureRules} {
+		for _, r := range rules {
+			if strings.Contains(lower, strings.ToLower(r.Match)) {
+				return r.Class
+			}
+		}
+	}
+	return fallback
+}
+
+// loadError 按类别包装写入错误
+func (dc *Client) loadError(class FailureClass, err error) *LoadError {
+	return &LoadError{Class: class, Retryable: slices.Contains(dc.config.RetryableClasses, class), Err: err}
+}
+
+// requestError 归类连接失败或超时
+func (dc *Client) requestError(ctx context.Context, err error) *LoadError {
+	class := FailureNetwork
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		class = FailureTimeout
+	}
+	return dc.loadError(class, fmt.Errorf("doris 连接失败: %w", err))
+}
+
+// statusError 归类 BE 返回的非 200 响应：401、403 为认证失败，其余按响应体归类，5xx 默认为 server
+func (dc *Client) statusError(code int, body string, err error) *LoadError {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return dc.loadError(FailureAuth, err)
+	case code >= http.StatusInternalServerError:
+		return dc.loadError(dc.classify(body, FailureServer), err)
+	}
+	return dc.loadError(dc.classify(body, FailureUnknown), err)
+}
@@ -0,0 +1,253 @@
+package dorisload
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	fairQuantum  = 100 // 每轮按权重累加的行数
+	hotKeyShare  = 0.5 // 一个键的排队行数占比达到该值时视为热点
+	hotKeyMinLen = 10  // 队列积压（占容量的百分比）不低于该值时才判断热点，队列空闲时不算
+)
+
+// OtherBatchKey 统计中未配置权重的键合并后的键名：键来自客户端（如项目），逐个统计会无限增长
+const OtherBatchKey = "other"
+
+type batchKeyKey struct{}
+
+// WithBatchKey 返回带公平调度键（如项目）的上下文：启用 BatchOptions.Fair 时批量写入器按键分队列，
+// 按权重轮流取出事件组成批次，一个键的大量事件不会让其他键的事件排在其后；未设置键的事件共用空键
+func WithBatchKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, batchKeyKey{}, key)
+}
+
+// batchKey 返回上下文中的公平调度键
+func batchKey(ctx context.Context) string {
+	key, _ := ctx.Value(batchKeyKey{}).(string)
+	return key
+}
+
+// BatchKeyStats 一个公平调度键的排队情况
+type BatchKeyStats struct {
+	Key        string `json:"key"`
+	Weight     int    `json:"weight"`
+	QueuedRows int    `json:"queued_rows"`
+	Queued     int    `json:"queued"`    // 排队的请求数
+	Throttled  int64  `json:"throttled"` // 因该键的队列已满而等待的请求数（启动以来）
+	Hot        bool   `json:"hot"`       // 排队行数占比超过一半的热点键
+}
+
+// fairQueue 按键分队列、以加权 deficit round robin 出队的等待队列
+// 只有一个键（未启用公平调度）时等同于先进先出队列
+type fairQueue struct {
+	capacity    int            // 所有键排队的请求数上限
+	keyCapacity int            // 单个键排队的请求数上限，为 0 时不限制
+	weights     map[string]int // 按键的权重，未出现的键为 1
+	onHot       func(key string, share float64)
+
+	mu        sync.Mutex
+	queues    map[string]*keyQueue
+	active    []string // 有排队事件的键，按轮询顺序
+	cursor    int
+	length    int // 排队的请求数
+	rows      int // 排队的行数
+	waiters   int
+	space     chan struct{}    // 出队时关闭并替换，唤醒等待空位的提交方
+	throttled map[string]int64 // 按统计键（见 statKey）统计因键队列已满而等待的次数
+	ready     chan struct{}    // 入队时通知 run
+}
+
+// keyQueue 一个键的队列
+type keyQueue struct {
+	items    []*batchItem
+	rows     int
+	deficit  int
+	credited bool // 本轮已累加过额度
+	hot      bool
+}
+
+func newFairQueue(capacity, keyCapacity int, weights map[string]int) *fairQueue {
+	return &fairQueue{
+		capacity:    capacity,
+		keyCapacity: keyCapacity,
+		weights:     weights,
+		queues:      make(map[string]*keyQueue),
+		space:       make(chan struct{}),
+		throttled:   make(map[string]int64),
+		ready:       make(chan struct{}, 1),
+	}
+}
+
+// weight 返回键的权重
+func (q *fairQueue) weight(key string) int {
+	if w := q.weights[key]; w > 0 {
+		return w
+	}
+	return 1
+}
+
+// statKey 返回键在统计中使用的名称：配置了权重的键原样统计，其余合并为 OtherBatchKey
+func (q *fairQueue) statKey(key string) string {
+	if _, ok := q.weights[key]; ok {
+		return key
+	}
+	return OtherBatchKey
+}
+
+// push 将事件加入所属键的队列，队列已满时等待空位，直到 done 关闭或 ctx 取消
+func (q *fairQueue) push(ctx context.Context, item *batchItem, done <-chan struct{}) error {
+	counted := false
+	for {
+		q.mu.Lock()
+		kq := q.queues[item.key]
+		keyFull := q.keyCapacity > 0 && kq != nil && len(kq.items) >= q.keyCapacity
+		if q.length < q.capacity && !keyFull {
+			if kq == nil {
+				kq = &keyQueue{}
+				q.queues[item.key] = kq
+				q.active = append(q.active, item.key)
+			}
+			kq.items = append(kq.items, item)
+			kq.rows += item.rows
+			q.length++
+			q.rows += item.rows
+			hot, share := q.checkHotLocked(kq), float64(kq.rows)/float64(q.rows)
+			q.mu.Unlock()
+			if hot && q.onHot != nil {
+				q.onHot(item.key, share)
+			}
+			select {
+			case q.ready <- struct{}{}:
+			default:
+			}
+			return nil
+		}
+		if keyFull && !counted {
+			q.throttled[q.statKey(item.key)]++
+			counted = true
+		}
+		q.waiters++
+		space := q.space
+		q.mu.Unlock()
+
+		var err error
+		select {
+		case <-space:
+		case <-done:
+			err = ErrBatcherClosed
+		case <-ctx.Done():
+			err = ErrOverloaded
+		}
+		q.mu.Lock()
+		q.waiters--
+		q.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkHotLocked 判断键是否新成为热点：有多个键排队、队列积压达到容量的 hotKeyMinLen% 且该键的排队行数占比不低于 hotKeyShare
+func (q *fairQueue) checkHotLocked(kq *keyQueue) bool {
+	if kq.hot || len(q.active) < 2 || q.length*100 < q.capacity*hotKeyMinLen {
+		return false
+	}
+	if float64(kq.rows) < hotKeyShare*float64(q.rows) {
+		return false
+	}
+	kq.hot = true
+	return true
+}
+
+// pop 按加权轮询取出一个事件：每个键轮到时额度增加 权重×fairQuantum 行，额度足够时取出队首事件，
+// 不够时轮到下一个键；队列为空时返回 nil
+func (q *fairQueue) pop() *batchItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.active) > 0 {
+		if q.cursor >= len(q.active) {
+			q.cursor = 0
+		}
+		key := q.active[q.cursor]
+		kq := q.queues[key]
+		if !kq.credited {
+			kq.deficit += q.weight(key) * fairQuantum
+			kq.credited = true
+		}
+		item := kq.items[0]
+		if item.rows > kq.deficit {
+			kq.credited = false
+			q.cursor++
+			continue
+		}
+		kq.deficit -= item.rows
+		kq.items[0] = nil
+		kq.items = kq.items[1:]
+		kq.rows -= item.rows
+		q.length--
+		q.rows -= item.rows
+		if len(kq.items) == 0 {
+			// 队列清空的键不保留额度，下次有事件时重新加入轮询
+			delete(q.queues, key)
+			q.active = slices.Delete(q.active, q.cursor, q.cursor+1)
+		}
+		if q.waiters > 0 {
+			close(q.space)
+			q.space = make(chan struct{})
+		}
+		return item
+	}
+	return nil
+}
+
+// len 返回排队的请求数
+func (q *fairQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.length
+}
+
+// stats 返回有事件排队或等待过的键，按排队行数从多到少排序
+// 未配置权重的键合并为一项 OtherBatchKey（权重为 1），统计的键数不超过配置的权重数加一
+func (q *fairQueue) stats() []BatchKeyStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	byKey := make(map[string]*BatchKeyStats)
+	entry := func(key string) *BatchKeyStats {
+		st := byKey[key]
+		if st == nil {
+			st = &BatchKeyStats{Key: key, Weight: q.weight(key), Throttled: q.throttled[key]}
+			byKey[key] = st
+		}
+		return st
+	}
+	for key, kq := range q.queues {
+		st := entry(q.statKey(key))
+		st.QueuedRows += kq.rows
+		st.Queued += len(kq.items)
+		st.Hot = st.Hot || kq.hot
+	}
+	for key := range q.throttled {
+		entry(key)
+	}
+	stats := make([]BatchKeyStats, 0, len(byKey))
+	for _, st := range byKey {
+		stats = append(stats, *st)
+	}
+	slices.SortFunc(stats, func(a, b BatchKeyStats) int {
+		if a.QueuedRows != b.QueuedRows {
+			return b.QueuedRows - a.QueuedRows
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return stats
+}
+
+// countRows 返回 NDJSON 数据的行数，至少为 1
+func countRows(data []byte) int {
+	return max(bytes.Count(data, []byte{'\n'}), 1)
+}
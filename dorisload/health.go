@@ -0,0 +1,211 @@
+package dorisload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthCheck BE 健康检查配置
+type HealthCheck struct {
+	Interval         time.Duration // 检查间隔
+	Timeout          time.Duration // 单次检查超时
+	FailThreshold    int           // 连续失败多少次后移出轮询
+	RecoverThreshold int           // 移出后连续成功多少次恢复
+	History          HistoryConfig // 检查历史和抖动抑制
+}
+
+// BEHealth 单个 BE 的健康状态
+type BEHealth struct {
+	URL                  string    `json:"url"`
+	Healthy              bool      `json:"healthy"`  // false 表示已移出轮询
+	Flapping             bool      `json:"flapping"` // 状态频繁变化，移出后暂不恢复
+	ConsecutiveFailures  int       `json:"consecutive_failures"`
+	ConsecutiveSuccesses int       `json:"consecutive_successes"`
+	Probes               int64     `json:"probes"`
+	Failures             int64     `json:"failures"`
+	LastLatencyMs        float64   `json:"last_latency_ms"`
+	LastProbe            time.Time `json:"last_probe"`
+	LastError            string    `json:"last_error,omitempty"`
+}
+
+// healthState 所有 BE 的健康状态，未启动健康检查时为空
+type healthState struct {
+	mu       sync.Mutex
+	backends []BEHealth
+	history  []*HealthHistory // 与 backends 一一对应
+}
+
+// BEHealthHistory 单个 BE 最近的健康检查结果
+type BEHealthHistory struct {
+	URL         string        `json:"url"`
+	Healthy     bool          `json:"healthy"`
+	Flapping    bool          `json:"flapping"`
+	Transitions int           `json:"transitions"` // 抖动检测窗口内的状态变化次数
+	Probes      []HealthProbe `json:"probes"`      // 按时间从旧到新排列
+}
+
+// StartHealthCheck 启动后台健康检查：定期请求每个 BE 的 /api/health，记录可用性和耗时，
+// 连续失败达到阈值的 BE 移出轮询，恢复后重新加入，使写入请求不必先遇到失败的 BE
+// 检查在 Close 后停止
+func (dc *Client) StartHealthCheck(hc HealthCheck, logger *slog.Logger) error {
+	if hc.Interval <= 0 || hc.Timeout <= 0 {
+		return fmt.Errorf("健康检查间隔和超时必须大于 0")
+	}
+	if hc.FailThreshold <= 0 || hc.RecoverThreshold <= 0 {
+		return fmt.Errorf("健康检查阈值必须大于 0")
+	}
+
+	dc.health.mu.Lock()
+	dc.health.backends = make([]BEHealth, len(dc.config.BEHTTP))
+	dc.health.history = make([]*HealthHistory, len(dc.config.BEHTTP))
+	for i, be := range dc.config.BEHTTP {
+		dc.health.backends[i] = BEHealth{URL: be, Healthy: true}
+		dc.health.history[i] = NewHealthHistory(hc.History)
+	}
+	dc.health.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(hc.Interval)
+		defer ticker.Stop()
+		for {
+			var wg sync.WaitGroup
+			for i := range dc.config.BEHTTP {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					dc.probe(i, hc, logger)
+				}()
+			}
+			wg.Wait()
+
+			select {
+			case <-ticker.C:
+			case <-dc.ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// probe 检查第 i 个 BE 并更新其健康状态
+// 抖动的 BE（窗口内状态变化达到阈值）移出后保持移出，直到窗口内的状态变化少于阈值，避免反复加入和移出轮询
+func (dc *Client) probe(i int, hc HealthCheck, logger *slog.Logger) {
+	be := dc.config.BEHTTP[i]
+	start := time.Now()
+	err := dc.checkHealth(be, hc.Timeout)
+	latency := time.Since(start)
+	if dc.ctx.Err() != nil {
+		return
+	}
+
+	dc.health.mu.Lock()
+	defer dc.health.mu.Unlock()
+	h := &dc.health.backends[i]
+	hist := dc.health.history[i]
+	h.Probes++
+	h.LastProbe = start
+	h.LastLatencyMs = float64(latency) / float64(time.Millisecond)
+	result := HealthProbe{Time: start, OK: err == nil, LatencyMs: h.LastLatencyMs}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	hist.Record(result)
+	dc.updateFlapping(h, hist.Flapping(start), hist, logger)
+
+	if err != nil {
+		h.Failures++
+		h.ConsecutiveFailures++
+		h.ConsecutiveSuccesses = 0
+		h.LastError = err.Error()
+		if h.Healthy && h.ConsecutiveFailures >= hc.FailThreshold {
+			h.Healthy = false
+			dc.balancer.setDown(i, true)
+			if !h.Flapping {
+				logger.Warn("BE 健康检查失败，移出轮询", "be", be, "failures", h.ConsecutiveFailures, "error", err)
+			}
+			dc.updateFlapping(h, hist.Transition(start), hist, logger)
+		}
+		return
+	}
+	h.ConsecutiveFailures = 0
+	h.ConsecutiveSuccesses++
+	h.LastError = ""
+	if !h.Healthy && !h.Flapping && h.ConsecutiveSuccesses >= hc.RecoverThreshold {
+		h.Healthy = true
+		dc.balancer.setDown(i, false)
+		logger.Info("BE 健康检查恢复，重新加入轮询", "be", be)
+		dc.updateFlapping(h, hist.Transition(start), hist, logger)
+	}
+}
+
+// updateFlapping 更新 BE 的抖动状态，只在进入和退出抖动时记录日志
+func (dc *Client) updateFlapping(h *BEHealth, flapping bool, hist *HealthHistory, logger *slog.Logger) {
+	if flapping == h.Flapping {
+		return
+	}
+	h.Flapping = flapping
+	if flapping {
+		logger.Warn("BE 状态频繁变化，移出轮询后暂不恢复", "be", h.URL, "transitions", hist.Transitions(h.LastProbe))
+	} else {
+		logger.Info("BE 状态已稳定，恢复正常健康判断", "be", h.URL)
+	}
+}
+
+// checkHealth 请求 BE 的 /api/health
+func (dc *Client) checkHealth(be string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(dc.ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, be+"/api/health", nil)
+	if err != nil {
+		return fmt.Errorf("创建健康检查请求失败: %w", err)
+	}
+	resp, err := dc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("健康检查返回 [%d]", resp.StatusCode)
+	}
+	return nil
+}
+
+// Health 返回各 BE 的健康状态，未启动健康检查时返回 nil
+func (dc *Client) Health() []BEHealth {
+	dc.health.mu.Lock()
+	defer dc.health.mu.Unlock()
+	if dc.health.backends == nil {
+		return nil
+	}
+	out := make([]BEHealth, len(dc.health.backends))
+	copy(out, dc.health.backends)
+	return out
+}
+
+// HealthHistory 返回各 BE 最近的健康检查结果，未启动健康检查时返回 nil
+func (dc *Client) HealthHistory() []BEHealthHistory {
+	dc.health.mu.Lock()
+	defer dc.health.mu.Unlock()
+	if dc.health.backends == nil {
+		return nil
+	}
+	out := make([]BEHealthHistory, len(dc.health.backends))
+	for i, h := range dc.health.backends {
+		hist := dc.health.history[i]
+		out[i] = BEHealthHistory{
+			URL:         h.URL,
+			Healthy:     h.Healthy,
+			Flapping:    h.Flapping,
+			Transitions: hist.Transitions(time.Now()),
+			Probes:      hist.Probes(),
+		}
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package dorisload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// hedgeMinSamples 样本不足时使用 minDelay
+const hedgeMinSamples = 20
+
+// HedgePolicy 对冲写入策略
+// 首个 Stream Load 超过近期耗时的指定分位数仍未返回时，向另一个 BE 发起相同 label 的第二个请求，
+// 由 Doris 按 label 去重，先成功者返回
+type HedgePolicy struct {
+	percentile float64
+	minDelay   time.Duration
+	window     latencyWindow
+}
+
+// NewHedgePolicy 创建对冲策略：percentile 为 (0, 100) 内的耗时分位数，minDelay 为对冲前的最短等待时间
+func NewHedgePolicy(percentile float64, minDelay time.Duration) (*HedgePolicy, error) {
+	if percentile <= 0 || percentile >= 100 {
+		return nil, fmt.Errorf("对冲分位数无效: %v（应在 0 到 100 之间）", percentile)
+	}
+	if minDelay <= 0 {
+		return nil, fmt.Errorf("对冲最短等待时间无效: %v", minDelay)
+	}
+	return &HedgePolicy{percentile: percentile, minDelay: minDelay}, nil
+}
+
+// Observe 记录一次成功写入的耗时
+func (h *HedgePolicy) Observe(d time.Duration) {
+	h.window.observe(d)
+}
+
+// Delay 返回发起对冲请求前的等待时间
+func (h *HedgePolicy) Delay() time.Duration {
+	d, n := h.window.percentile(h.percentile)
+	if n < hedgeMinSamples {
+		return h.minDelay
+	}
+	return max(d, h.minDelay)
+}
+
+// hedgedStreamLoad 对冲写入：主请求超时未返回时向另一个 BE 发起相同 label 的请求
+func (dc *Client) hedgedStreamLoad(ctx context.Context, table *Table, label string, data []byte, opts *LoadOptions, logger *slog.Logger) (*StreamLoadResponse, error) {
+	type result struct {
+		resp *StreamLoadResponse
+		err  error
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // 返回时取消仍在进行的请求
+
+	results := make(chan result, 2)
+	attempt := func(be string) {
+		start := time.Now()
+		resp, err := dc.streamLoad(ctx, be, table, label, data, opts, logger)
+		if err == nil {
+			dc.hedge.Observe(time.Since(start))
+		}
+		results <- result{resp, err}
+	}
+
+	primary := dc.balancer.Pick(routingKey(ctx, table.Name))
+	go attempt(primary)
+
+	timer := time.NewTimer(dc.hedge.Delay())
+	defer timer.Stop()
+
+	pending, hedged := 1, false
+	var last *result
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			secondary := dc.balancer.PickOther(primary)
+			logger.Debug("发起对冲 Stream Load", "label", label, "primary", primary, "secondary", secondary)
+			hedged = true
+			pending++
+			go attempt(secondary)
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.resp, nil
+			}
+			if !hedged {
+				// 主请求在对冲前已失败，不再发起对冲
+				return r.resp, r.err
+			}
+			// label 冲突通常说明另一个请求正在提交，优先保留真实的失败原因
+			if last == nil || errors.Is(last.err, ErrLabelAlreadyExists) || (last.resp != nil && last.resp.Outcome() == OutcomePending) {
+				last = &r
+			}
+		}
+	}
+	return last.resp, last.err
+}
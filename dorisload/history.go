@@ -0,0 +1,104 @@
+package dorisload
+
+import (
+	"sync"
+	"time"
+)
+
+// HistoryConfig 健康历史和抖动抑制配置
+type HistoryConfig struct {
+	Size int // 保留最近多少次检查结果，0 表示不保留
+
+	// FlapWindow 内状态变化（可用 ↔ 不可用）达到 FlapThreshold 次视为抖动，FlapThreshold 为 0 时不检测
+	// 抖动期间 BE 保持移出轮询、不再逐次记录状态变化日志，窗口内状态变化少于阈值后恢复正常判断
+	FlapWindow    time.Duration
+	FlapThreshold int
+}
+
+// HealthProbe 一次健康检查（或写入）的结果
+type HealthProbe struct {
+	Time      time.Time `json:"time"`
+	OK        bool      `json:"ok"`
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// HealthHistory 最近的检查结果和状态变化时间，可并发使用
+type HealthHistory struct {
+	config HistoryConfig
+
+	mu          sync.Mutex
+	probes      []HealthProbe // 环形缓冲，next 为下一个写入位置
+	next        int
+	full        bool
+	transitions []time.Time // FlapWindow 内的状态变化时间
+}
+
+// NewHealthHistory 创建健康历史
+func NewHealthHistory(config HistoryConfig) *HealthHistory {
+	return &HealthHistory{config: config, probes: make([]HealthProbe, config.Size)}
+}
+
+// Record 记录一次检查结果
+func (h *HealthHistory) Record(p HealthProbe) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.probes) == 0 {
+		return
+	}
+	h.probes[h.next] = p
+	h.next = (h.next + 1) % len(h.probes)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Transition 记录一次状态变化，返回记录后是否处于抖动状态
+func (h *HealthHistory) Transition(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.config.FlapThreshold <= 0 {
+		return false
+	}
+	h.transitions = append(h.transitions, now)
+	return h.flappingLocked(now)
+}
+
+// Flapping 判断窗口内的状态变化次数是否达到抖动阈值
+func (h *HealthHistory) Flapping(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.flappingLocked(now)
+}
+
+func (h *HealthHistory) flappingLocked(now time.Time) bool {
+	if h.config.FlapThreshold <= 0 {
+		return false
+	}
+	i := 0
+	for i < len(h.transitions) && now.Sub(h.transitions[i]) > h.config.FlapWindow {
+		i++
+	}
+	h.transitions = h.transitions[i:]
+	return len(h.transitions) >= h.config.FlapThreshold
+}
+
+// Transitions 返回窗口内的状态变化次数
+func (h *HealthHistory) Transitions(now time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.flappingLocked(now)
+	return len(h.transitions)
+}
+
+// Probes 返回保留的检查结果，按时间从旧到新排列
+func (h *HealthHistory) Probes() []HealthProbe {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]HealthProbe(nil), h.probes[:h.next]...)
+	}
+	out := make([]HealthProbe, 0, len(h.probes))
+	out = append(out, h.probes[h.next:]...)
+	return append(out, h.probes[:h.next]...)
+}
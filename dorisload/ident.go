@@ -0,0 +1,17 @@
+package dorisload
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// identPattern Doris 库名和表名的格式（FE 默认的 enable_unicode_name_support=false）：字母开头，由字母、数字、_ 和 - 组成，最长 64 个字符
+var identPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,63}$`)
+
+// ValidateIdentifier 校验库名或表名，拼接到 Stream Load 地址中的名称不能包含 /、?、# 等改变路径或查询参数的字符
+func ValidateIdentifier(name string) error {
+	if !identPattern.MatchString(name) {
+		return fmt.Errorf("%q 不是有效的库名或表名（需以字母开头，由字母、数字、_ 和 - 组成，最长 64 个字符）", name)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package dorisload
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencySampleSize 用于计算延迟分位数的最近样本数
+const latencySampleSize = 256
+
+// latencyWindow 最近若干次成功写入的耗时，可并发使用
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	pos     int
+}
+
+// observe 记录一次成功写入的耗时，样本满后覆盖最早的样本
+func (w *latencyWindow) observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < latencySampleSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.pos] = d
+	w.pos = (w.pos + 1) % latencySampleSize
+}
+
+// percentile 返回耗时的 p 分位数（0 < p <= 100）和样本数，没有样本时返回 0
+func (w *latencyWindow) percentile(p float64) (time.Duration, int) {
+	w.mu.Lock()
+	sorted := slices.Clone(w.samples)
+	w.mu.Unlock()
+	if len(sorted) == 0 {
+		return 0, 0
+	}
+
+	slices.Sort(sorted)
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(idx, 0)], len(sorted)
+}
+
+// Latency 返回最近成功的 Stream Load 尝试（含对冲请求）耗时的 p 分位数（0 < p <= 100）
+// 以及参与计算的样本数，尚无成功写入时返回 0
+func (dc *Client) Latency(p float64) (time.Duration, int) {
+	return dc.latency.percentile(p)
+}
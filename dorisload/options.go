@@ -0,0 +1,84 @@
+package dorisload
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Format Stream Load 数据格式
+type Format string
+
+const (
+	FormatJSON Format = "json" // NDJSON，每行一个 JSON 对象（默认）
+	FormatCSV  Format = "csv"
+)
+
+// GroupCommit Doris 2.1+ 的 group commit 模式，由 BE 将多次小写入合并为一个事务
+type GroupCommit string
+
+const (
+	GroupCommitOff   GroupCommit = ""           // 不使用 group commit（默认）
+	GroupCommitSync  GroupCommit = "sync_mode"  // 合并后的事务提交后返回
+	GroupCommitAsync GroupCommit = "async_mode" // 数据写入 WAL 后立即返回
+)
+
+// LoadOptions 单次 Stream Load 的选项，零值为 NDJSON 格式、非严格模式、单阶段提交
+type LoadOptions struct {
+	Format          Format
+	Columns         []string // 覆盖 Table.Columns 作为 columns 头，为空时使用表的列
+	ColumnSeparator string   // CSV 列分隔符，默认 \t
+
+	StrictMode     bool     // 严格模式：类型转换失败的行被过滤而不是写入 NULL
+	MaxFilterRatio *float64 // 允许过滤的行比例上限，超过时整批失败；nil 使用 Doris 默认值（0）
+
+	// TwoPC 两阶段提交：写入成功后事务处于预提交状态，需要调用 CommitTxn 或 AbortTxn
+	TwoPC bool
+	// GroupCommit 启用时 Doris 不接受 label，写入不会重试也不会对冲，重复写入无法去重
+	GroupCommit GroupCommit
+}
+
+// usesLabel 是否可以使用 label（group commit 不支持 label）
+func (o *LoadOptions) usesLabel() bool {
+	return o.GroupCommit == GroupCommitOff
+}
+
+// setHeaders 设置 Stream Load 请求头
+func (o *LoadOptions) setHeaders(h http.Header, table *Table, label string) {
+	if o.usesLabel() {
+		h.Set("label", label)
+	} else {
+		h.Set("group_commit", string(o.GroupCommit))
+	}
+
+	columns := table.ColumnsHeader()
+	if len(o.Columns) > 0 {
+		columns = strings.Join(o.Columns, ",")
+	}
+	if columns != "" {
+		h.Set("columns", columns)
+	}
+
+	switch o.Format {
+	case FormatCSV:
+		h.Set("Content-Type", "text/plain")
+		h.Set("format", string(FormatCSV))
+		if o.ColumnSeparator != "" {
+			h.Set("column_separator", o.ColumnSeparator)
+		}
+	default:
+		h.Set("Content-Type", "application/json")
+		h.Set("format", string(FormatJSON))
+		h.Set("read_json_by_line", "true")
+	}
+
+	if o.StrictMode {
+		h.Set("strict_mode", "true")
+	}
+	if o.MaxFilterRatio != nil {
+		h.Set("max_filter_ratio", strconv.FormatFloat(*o.MaxFilterRatio, 'f', -1, 64))
+	}
+	if o.TwoPC {
+		h.Set("two_phase_commit", "true")
+	}
+}
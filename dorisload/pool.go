@@ -0,0 +1,232 @@
+package dorisload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PoolConfig Stream Load 连接池配置，零值为所有表共用一个连接池、连接不限存活时间
+type PoolConfig struct {
+	// PerTable 每张表使用独立的连接池，一张繁忙的表不会占满其他表的连接或挤掉它们的空闲连接
+	// 健康检查、预检和事务提交等请求使用共用的连接池
+	PerTable bool
+
+	// Concurrency 写入的并发上限，用于推导连接池大小：每个池对每个 BE 的最大连接数默认为该值，
+	// 空闲连接数默认为该值在各 BE 之间的平均分配；为 0 时使用 MaxConnsPerHost
+	Concurrency   int
+	MaxConnsPerBE int // 每个池对每个 BE 的最大连接数，覆盖 Concurrency 的推导
+	MaxIdlePerBE  int // 每个池对每个 BE 保留的空闲连接数，覆盖 Concurrency 的推导
+
+	IdleTimeout time.Duration // 空闲连接的保留时间，默认 90s
+	MaxConnAge  time.Duration // 连接的最长存活时间，到期后新请求使用新连接，旧连接在请求结束后关闭；为 0 时不限制
+	Prewarm     int           // Prewarm 为每个池对每个 BE 预先建立的连接数
+
+	// DNSRefresh 定期重新解析 BE 主机名，解析结果变化时回收所有连接，使写入跟随 BE 地址变化（如 Kubernetes Pod 重建）；为 0 时不解析
+	DNSRefresh time.Duration
+	// RecycleAfterFailures 同一 BE 连续连接失败或返回 5xx 达到该次数时回收所有连接；为 0 时不回收
+	RecycleAfterFailures int
+}
+
+// connPool 一组 BE 连接，MaxConnAge 到期后整体替换为新的 Transport，旧 Transport 的连接在进行中的请求结束后关闭
+type connPool struct {
+	newTransport func() *http.Transport
+	maxAge       time.Duration
+	drain        time.Duration // 替换后等待旧连接上的请求结束的时间
+
+	mu      sync.Mutex
+	current *http.Transport
+	born    time.Time
+}
+
+func newConnPool(newTransport func() *http.Transport, maxAge, drain time.Duration) *connPool {
+	return &connPool{newTransport: newTransport, maxAge: maxAge, drain: drain, current: newTransport(), born: time.Now()}
+}
+
+// RoundTrip 使用当前 Transport 发送请求，Transport 超过 maxAge 时先替换
+func (p *connPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	return p.transport().RoundTrip(req)
+}
+
+func (p *connPool) transport() *http.Transport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.maxAge > 0 && time.Since(p.born) >= p.maxAge {
+		p.recycleLocked()
+	}
+	return p.current
+}
+
+// Recycle 立即替换为新的 Transport，之后的请求使用新连接
+func (p *connPool) Recycle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.recycleLocked()
+}
+
+func (p *connPool) recycleLocked() {
+	old := p.current
+	p.current, p.born = p.newTransport(), time.Now()
+	// 空闲连接立即关闭，进行中的请求结束后连接回到旧 Transport 的空闲池，等待结束后再次关闭
+	old.CloseIdleConnections()
+	time.AfterFunc(p.drain, old.CloseIdleConnections)
+}
+
+// CloseIdleConnections 关闭当前 Transport 的空闲连接
+func (p *connPool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current.CloseIdleConnections()
+}
+
+// newHTTPClient 按连接池配置创建一个 http.Client，Auth 的请求头和令牌在连接池之外附加，WrapTransport 包装在最外层
+func (dc *Client) newHTTPClient() (*http.Client, *connPool) {
+	cfg := dc.config.Pool
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = MaxConnsPerHost
+	}
+	maxConns := cfg.MaxConnsPerBE
+	if maxConns <= 0 {
+		maxConns = concurrency
+	}
+	maxIdle := cfg.MaxIdlePerBE
+	if maxIdle <= 0 {
+		maxIdle = max((concurrency+len(dc.config.BEHTTP)-1)/max(len(dc.config.BEHTTP), 1), 1)
+	}
+	maxIdle = min(max(maxIdle, cfg.Prewarm), maxConns)
+	idleTimeout := cfg.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = idleConnTimeout
+	}
+	proxy := dc.config.Proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+
+	pool := newConnPool(func() *http.Transport {
+		return &http.Transport{
+			Proxy:               proxy,
+			MaxIdleConns:        maxIdle * max(len(dc.config.BEHTTP), 1),
+			MaxIdleConnsPerHost: maxIdle,
+			MaxConnsPerHost:     maxConns,
+			IdleConnTimeout:     idleTimeout,
+			DisableKeepAlives:   false,
+			DisableCompression:  true,
+			TLSClientConfig:     dc.config.TLSConfig,
+			// 带 Expect: 100-continue 时等待 100 Continue 再发送数据的时间
+			ExpectContinueTimeout: dc.config.Continue.Timeout,
+		}
+	}, cfg.MaxConnAge, defaultTimeout)
+
+	var transport http.RoundTripper = pool
+	if dc.config.Auth != nil {
+		transport = &signingTransport{base: transport, auth: dc.config.Auth}
+	}
+	if dc.config.WrapTransport != nil {
+		transport = dc.config.WrapTransport(transport)
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   defaultTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("重定向次数过多")
+			}
+			return nil
+		},
+	}, pool
+}
+
+// clientFor 返回写入目标表使用的 http.Client：PerTable 时为表的独立连接池（首次使用时创建），否则为共用连接池
+func (dc *Client) clientFor(table *Table) *http.Client {
+	if !dc.config.Pool.PerTable || table == nil {
+		return dc.client
+	}
+	dc.poolsMu.Lock()
+	defer dc.poolsMu.Unlock()
+	tp := dc.tablePools[table.Name]
+	if tp == nil {
+		client, pool := dc.newHTTPClient()
+		tp = &tablePool{client: client, pool: pool}
+		dc.tablePools[table.Name] = tp
+	}
+	return tp.client
+}
+
+// tablePool 一张表的独立连接池
+type tablePool struct {
+	client *http.Client
+	pool   *connPool
+}
+
+// Prewarm 为写入 tables 使用的连接池预先建立 PoolConfig.Prewarm 个到每个 BE 的连接，Prewarm 为 0 时直接返回
+// 连接通过并发的 /api/health 请求建立，请求结束后保留在空闲池中；不可达的 BE 返回错误，但不影响其他连接
+func (dc *Client) Prewarm(ctx context.Context, tables []*Table) error {
+	n := dc.config.Pool.Prewarm
+	if n <= 0 {
+		return nil
+	}
+	clients := []*http.Client{dc.client}
+	if dc.config.Pool.PerTable {
+		clients = clients[:0]
+		for _, table := range tables {
+			clients = append(clients, dc.clientFor(table))
+		}
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(map[string]error) // 按 BE 只保留一个错误
+	)
+	for _, client := range clients {
+		for _, be := range dc.config.BEHTTP {
+			for range n {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := warm(ctx, client, be); err != nil {
+						mu.Lock()
+						errs[be] = fmt.Errorf("BE %s 预热连接失败: %w", be, err)
+						mu.Unlock()
+					}
+				}()
+			}
+		}
+	}
+	wg.Wait()
+	var joined []error
+	for _, be := range dc.config.BEHTTP {
+		joined = append(joined, errs[be])
+	}
+	return errors.Join(joined...)
+}
+
+// warm 通过一次 /api/health 请求建立一个连接，请求结束后连接留在空闲池中
+func warm(ctx context.Context, client *http.Client, be string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, be+"/api/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// closeIdle 关闭所有连接池的空闲连接
+func (dc *Client) closeIdle() {
+	dc.pool.CloseIdleConnections()
+	dc.poolsMu.Lock()
+	defer dc.poolsMu.Unlock()
+	for _, tp := range dc.tablePools {
+		tp.pool.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,51 @@
+package dorisload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Preflight 检查所有 BE 可达且 Doris 凭证有效
+// 凭证通过对每张目标表的一次空数据 Stream Load 校验：BE 在开启事务时向 FE 鉴权，空数据不会写入任何行
+func (dc *Client) Preflight(ctx context.Context, tables []*Table, logger *slog.Logger) error {
+	for _, be := range dc.config.BEHTTP {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, be+"/api/health", nil)
+		if err != nil {
+			return fmt.Errorf("创建健康检查请求失败: %w", err)
+		}
+		resp, err := dc.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("BE %s 不可达: %w", be, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("BE %s 健康检查失败 [%d]", be, resp.StatusCode)
+		}
+
+		for _, table := range tables {
+			_, err = dc.streamLoad(ctx, be, table, "preflight-"+uuid.New().String(), nil, &LoadOptions{}, logger)
+			if err != nil && isAuthError(err) {
+				return fmt.Errorf("BE %s 鉴权失败（表 %s），请检查用户名和密码: %w", be, table.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// isAuthError 判断 Stream Load 错误是否由鉴权失败引起
+func isAuthError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"access denied", "authorization", "[401]", "[403]"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
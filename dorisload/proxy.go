@@ -0,0 +1,35 @@
+package dorisload
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ProxyDirect ParseProxy 的特殊值：直接连接 BE，忽略 HTTPS_PROXY、HTTP_PROXY 环境变量
+const ProxyDirect = "direct"
+
+// ParseProxy 解析连接 BE 使用的代理，返回 Config.Proxy
+// 空串返回 nil（使用 HTTPS_PROXY、HTTP_PROXY、NO_PROXY 环境变量），ProxyDirect 为直接连接，
+// 其他为 http://、https://、socks5:// 或 socks5h://（由代理解析 BE 主机名）代理地址，可带 user:password@
+func ParseProxy(raw string) (func(*http.Request) (*url.URL, error), error) {
+	switch raw {
+	case "":
+		return nil, nil
+	case ProxyDirect:
+		return func(*http.Request) (*url.URL, error) { return nil, nil }, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("代理地址无效: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("代理地址协议无效: %q（可选 http、https、socks5、socks5h）", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("代理地址缺少主机: %q", u.Redacted())
+	}
+	return http.ProxyURL(u), nil
+}
@@ -0,0 +1,134 @@
+package dorisload
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minRecycleInterval 两次回收连接的最短间隔，避免 BE 持续失败时反复重建连接
+const minRecycleInterval = 5 * time.Second
+
+// recycleState 连接回收状态
+type recycleState struct {
+	failures []atomic.Int64 // 与 BEHTTP 一一对应，连续失败次数
+	last     atomic.Int64   // 上次回收的时间（UnixNano）
+	count    atomic.Int64   // 启动以来的回收次数
+
+	mu       sync.Mutex
+	resolved map[string][]string // 按主机名索引的上次解析结果
+}
+
+// recycle 回收所有连接池的连接：空闲连接立即关闭，进行中的请求不受影响，之后的请求重新解析地址并建立连接
+// 距上次回收不足 minRecycleInterval 时跳过，返回是否回收
+func (dc *Client) recycle(reason string, logger *slog.Logger, attrs ...any) bool {
+	now := time.Now().UnixNano()
+	last := dc.recycler.last.Load()
+	if now-last < int64(minRecycleInterval) || !dc.recycler.last.CompareAndSwap(last, now) {
+		return false
+	}
+	dc.recycler.count.Add(1)
+	dc.pool.Recycle()
+	dc.poolsMu.Lock()
+	for _, tp := range dc.tablePools {
+		tp.pool.Recycle()
+	}
+	dc.poolsMu.Unlock()
+	logger.Warn("回收 BE 连接", append([]any{"reason", reason}, attrs...)...)
+	return true
+}
+
+// Recycles 返回启动以来回收连接的次数
+func (dc *Client) Recycles() int64 {
+	return dc.recycler.count.Load()
+}
+
+// observeAttempt 记录一次 Stream Load 尝试的结果：同一 BE 连续连接失败或返回 5xx 达到 RecycleAfterFailures 时回收连接
+func (dc *Client) observeAttempt(be string, failed bool, logger *slog.Logger) {
+	threshold := dc.config.Pool.RecycleAfterFailures
+	if threshold <= 0 {
+		return
+	}
+	i := slices.Index(dc.config.BEHTTP, be)
+	if i < 0 {
+		return
+	}
+	if !failed {
+		dc.recycler.failures[i].Store(0)
+		return
+	}
+	if n := dc.recycler.failures[i].Add(1); n >= int64(threshold) {
+		dc.recycler.failures[i].Store(0)
+		dc.recycle("consecutive_failures", logger, "be", be, "failures", n)
+	}
+}
+
+// StartDNSRefresh 按 Pool.DNSRefresh 定期重新解析 BE 主机名，解析结果变化时回收连接；DNSRefresh 为 0 时不启动
+// IP 地址形式的 BE 不解析，解析在 Close 后停止
+func (dc *Client) StartDNSRefresh(logger *slog.Logger) {
+	interval := dc.config.Pool.DNSRefresh
+	if interval <= 0 {
+		return
+	}
+	var hosts []string
+	for _, be := range dc.config.BEHTTP {
+		u, err := url.Parse(be)
+		if err != nil || net.ParseIP(u.Hostname()) != nil || slices.Contains(hosts, u.Hostname()) {
+			continue
+		}
+		hosts = append(hosts, u.Hostname())
+	}
+	if len(hosts) == 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			dc.refreshDNS(hosts, interval, logger)
+			select {
+			case <-dc.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// refreshDNS 解析每个主机名并与上次结果比较，任一主机名的地址变化时回收连接；解析失败时保留上次结果
+func (dc *Client) refreshDNS(hosts []string, timeout time.Duration, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(dc.ctx, timeout)
+	defer cancel()
+
+	dc.recycler.mu.Lock()
+	defer dc.recycler.mu.Unlock()
+	if dc.recycler.resolved == nil {
+		dc.recycler.resolved = make(map[string][]string, len(hosts))
+	}
+	var changed []string
+	for _, host := range hosts {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			logger.Warn("解析 BE 主机名失败", "host", host, "error", err)
+			continue
+		}
+		slices.Sort(addrs)
+		prev, ok := dc.recycler.resolved[host]
+		dc.recycler.resolved[host] = addrs
+		if ok && !slices.Equal(prev, addrs) {
+			logger.Info("BE 主机名解析结果变化", "host", host, "previous", prev, "current", addrs)
+			changed = append(changed, host)
+		}
+	}
+	if len(changed) > 0 {
+		// 地址变化只在本次解析时发现，不受最短间隔限制
+		dc.recycler.last.Store(0)
+		dc.recycle("dns_changed", logger, "hosts", changed)
+	}
+}
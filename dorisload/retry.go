@@ -0,0 +1,122 @@
+package dorisload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrShuttingDown 客户端已关闭，进行中的 Doris 写入已中止
+var ErrShuttingDown = errors.New("doris client shutting down")
+
+// retryBackoff 重试前的等待时间（按尝试次数线性增加）
+const retryBackoff = 100 * time.Millisecond
+
+// Attempt 一次 Stream Load 尝试的结果，传给 Config.OnAttempt
+type Attempt struct {
+	Table    string
+	Label    string
+	Number   int                 // 同一 label 的第几次尝试，从 1 开始
+	Rows     int                 // 写入的 NDJSON 行数
+	Resp     *StreamLoadResponse // 未收到 BE 响应时为 nil
+	Err      error
+	Duration time.Duration
+}
+
+// budgetContext 为一次写入设置总时间预算，并在客户端关闭时取消
+func (dc *Client) budgetContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, dc.config.WriteBudget)
+	stop := context.AfterFunc(dc.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// writeWithRetry 在总时间预算内写入数据，每次尝试的超时不超过 AttemptTimeout 和剩余预算
+// 可重试类别（见 Config.RetryableClasses）的错误最多尝试 MaxAttempts 次，重试使用相同 label，已提交的数据不会重复写入；重试时 label 已存在且原任务已提交，说明此前的尝试已提交，视为成功
+// label 已存在但原任务仍在进行（RUNNING）时可重试，等待原任务结束；too many versions 触发冷却（见 CooldownConfig），重试等待冷却结束
+// group commit 不支持 label，重试可能重复写入，因此只尝试一次
+func (dc *Client) writeWithRetry(ctx context.Context, table *Table, label string, data []byte, opts *LoadOptions, logger *slog.Logger) (*StreamLoadResponse, error) {
+	ctx, cancel := dc.budgetContext(ctx)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		resp, err := dc.attempt(ctx, table, label, data, opts, logger)
+		if dc.config.OnAttempt != nil {
+			dc.config.OnAttempt(ctx, &Attempt{
+				Table:    table.Name,
+				Label:    label,
+				Number:   attempt,
+				Rows:     bytes.Count(data, []byte{'\n'}),
+				Resp:     resp,
+				Err:      err,
+				Duration: time.Since(start),
+			})
+		}
+		if attempt > 1 && errors.Is(err, ErrLabelAlreadyExists) && resp != nil && resp.Outcome() == OutcomeDuplicate {
+			return resp, nil
+		}
+		if err == nil {
+			return resp, nil
+		}
+		var cooldownUntil time.Time
+		if ClassOf(err) == FailureTooManyVersions && dc.config.Cooldown.Duration > 0 {
+			cooldownUntil = dc.triggerCooldown(table.Name, logger)
+		}
+		if dc.ctx.Err() != nil {
+			return resp, errors.Join(ErrShuttingDown, err)
+		}
+
+		if !IsRetryable(err) || attempt >= dc.config.MaxAttempts || !opts.usesLabel() || ctx.Err() != nil {
+			return resp, err
+		}
+		logger.Warn("Stream Load 失败，重试", "label", label, "attempt", attempt, "class", ClassOf(err), "error", err)
+
+		wait := time.Duration(attempt) * retryBackoff
+		if !cooldownUntil.IsZero() {
+			// 等待 compaction 跟上，冷却超出剩余预算时放弃重试
+			wait = time.Until(cooldownUntil)
+			if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+				return resp, err
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return resp, err
+		}
+	}
+}
+
+// attempt 发起一次 Stream Load 尝试（启用对冲时包含对冲请求）
+func (dc *Client) attempt(ctx context.Context, table *Table, label string, data []byte, opts *LoadOptions, logger *slog.Logger) (*StreamLoadResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, dc.config.AttemptTimeout)
+	defer cancel()
+	start := time.Now()
+	var (
+		resp *StreamLoadResponse
+		err  error
+	)
+	if dc.hedge != nil && dc.balancer.Len() > 1 && opts.usesLabel() {
+		resp, err = dc.hedgedStreamLoad(ctx, table, label, data, opts, logger)
+	} else {
+		resp, err = dc.streamLoad(ctx, dc.balancer.Pick(routingKey(ctx, table.Name)), table, label, data, opts, logger)
+	}
+	if err == nil {
+		dc.latency.observe(time.Since(start))
+		if resp != nil {
+			dc.timings.observe(table.Name, resp, dc.exemplarTraceID(ctx))
+		}
+	}
+	return resp, err
+}
+
+// Close 中止所有进行中的写入，用于超过关闭等待时间后的强制退出
+func (dc *Client) Close() {
+	dc.cancel()
+	dc.closeIdle()
+}
@@ -0,0 +1,101 @@
+package dorisload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Chunk 一个分片（lines[Start:End]）的 Stream Load 结果
+type Chunk struct {
+	Start, End int
+	Label      string
+	Resp       *StreamLoadResponse
+	Err        error
+}
+
+// WriteLinesSplit 将多行 NDJSON 写入 Doris，超过单次 Stream Load 上限时按行拆分为多个事务
+// 只有一个分片时使用 label 本身，否则各分片使用独立 label（{label}-{序号}），相同输入的拆分结果固定，
+// 重试时已提交的分片会被 Doris 按 label 去重。Doris 仍以数据过大拒绝时，分片再对半拆分重试
+func (dc *Client) WriteLinesSplit(ctx context.Context, table *Table, label string, lines [][]byte, logger *slog.Logger) []Chunk {
+	ranges := splitBySize(lines, dc.config.MaxLoadBytes)
+	var chunks []Chunk
+	for i, r := range ranges {
+		chunkLabel := label
+		if len(ranges) > 1 {
+			chunkLabel = fmt.Sprintf("%s-%d", label, i)
+		}
+		chunks = append(chunks, dc.loadRange(ctx, table, chunkLabel, lines, r[0], r[1], logger)...)
+	}
+	if len(chunks) > 1 {
+		logger.Info("超出单次 Stream Load 上限，已拆分写入", "table", table.Name, "label", label, "rows", len(lines), "chunks", len(chunks))
+	}
+	return chunks
+}
+
+// loadRange 写入 lines[start:end]，遇到数据过大错误时对半拆分
+func (dc *Client) loadRange(ctx context.Context, table *Table, label string, lines [][]byte, start, end int, logger *slog.Logger) []Chunk {
+	resp, err := dc.WriteWithLabel(ctx, table, label, JoinLines(lines[start:end]), logger)
+	if err != nil && isBodyTooLarge(err) && end-start > 1 {
+		mid := start + (end-start)/2
+		logger.Warn("Stream Load 数据过大，对半拆分重试", "label", label, "rows", end-start)
+		return append(
+			dc.loadRange(ctx, table, label+"-a", lines, start, mid, logger),
+			dc.loadRange(ctx, table, label+"-b", lines, mid, end, logger)...)
+	}
+	return []Chunk{{Start: start, End: end, Label: label, Resp: resp, Err: err}}
+}
+
+// splitBySize 按累计字节数将行划分为若干区间 [start, end)，单行超过上限时独占一个区间
+func splitBySize(lines [][]byte, maxBytes int64) [][2]int {
+	var ranges [][2]int
+	start, size := 0, int64(0)
+	for i, line := range lines {
+		n := int64(len(line))
+		if i > start && size+n > maxBytes {
+			ranges = append(ranges, [2]int{start, i})
+			start, size = i, 0
+		}
+		size += n
+	}
+	if start < len(lines) {
+		ranges = append(ranges, [2]int{start, len(lines)})
+	}
+	return ranges
+}
+
+// SplitNDJSON 将 NDJSON 数据按行切分，每行保留结尾换行符
+func SplitNDJSON(data []byte) [][]byte {
+	var lines [][]byte
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, data)
+			break
+		}
+		lines = append(lines, data[:i+1])
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// JoinLines 拼接多行数据
+func JoinLines(lines [][]byte) []byte {
+	n := 0
+	for _, line := range lines {
+		n += len(line)
+	}
+	buf := make([]byte, 0, n)
+	for _, line := range lines {
+		buf = append(buf, line...)
+	}
+	return buf
+}
+
+// isBodyTooLarge 判断 Stream Load 错误是否由数据超过 BE 的 streaming_load_max_mb 引起
+func isBodyTooLarge(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "exceed max size") || strings.Contains(msg, "exceed the max size") || strings.Contains(msg, "[413]")
+}
@@ -0,0 +1,54 @@
+package dorisload
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitBySize(t *testing.T) {
+	// lines 按长度生成测试行
+	lines := func(sizes ...int) [][]byte {
+		out := make([][]byte, len(sizes))
+		for i, n := range sizes {
+			out[i] = []byte(strings.Repeat("x", n))
+		}
+		return out
+	}
+	tests := []struct {
+		name     string
+		lines    [][]byte
+		maxBytes int64
+		want     [][2]int
+	}{
+		{name: "empty", lines: nil, maxBytes: 10, want: nil},
+		{name: "fits", lines: lines(3, 3, 3), maxBytes: 10, want: [][2]int{{0, 3}}},
+		{name: "exactly at limit", lines: lines(5, 5), maxBytes: 10, want: [][2]int{{0, 2}}},
+		{name: "one over limit", lines: lines(5, 5, 1), maxBytes: 10, want: [][2]int{{0, 2}, {2, 3}}},
+		{name: "even split", lines: lines(4, 4, 4, 4), maxBytes: 8, want: [][2]int{{0, 2}, {2, 4}}},
+		{name: "oversized first line", lines: lines(20, 1, 1), maxBytes: 10, want: [][2]int{{0, 1}, {1, 3}}},
+		{name: "oversized middle line", lines: lines(2, 20, 2), maxBytes: 10, want: [][2]int{{0, 1}, {1, 2}, {2, 3}}},
+		{name: "oversized last line", lines: lines(2, 2, 20), maxBytes: 10, want: [][2]int{{0, 2}, {2, 3}}},
+		{name: "every line oversized", lines: lines(11, 12), maxBytes: 10, want: [][2]int{{0, 1}, {1, 2}}},
+		{name: "empty lines", lines: lines(0, 0, 0), maxBytes: 1, want: [][2]int{{0, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitBySize(tt.lines, tt.maxBytes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitBySize() = %v, want %v", got, tt.want)
+			}
+			// 区间首尾相接覆盖全部行
+			next := 0
+			for _, r := range got {
+				if r[0] != next || r[1] <= r[0] {
+					t.Fatalf("ranges %v not contiguous", got)
+				}
+				next = r[1]
+			}
+			if next != len(tt.lines) {
+				t.Errorf("ranges cover %d lines, want %d", next, len(tt.lines))
+			}
+		})
+	}
+}
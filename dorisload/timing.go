@@ -0,0 +1,97 @@
+package dorisload
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// PhaseBuckets 阶段耗时直方图的桶上界（秒）
+var PhaseBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Stream Load 的各阶段，与 BE 返回的耗时字段对应
+const (
+	PhaseBeginTxn         = "begin_txn"          // BeginTxnTimeMs：向 FE 开启事务
+	PhaseStreamLoadPut    = "stream_load_put"    // StreamLoadPutTimeMs：向 FE 获取导入计划
+	PhaseReadData         = "read_data"          // ReadDataTimeMs：从客户端读取数据（网络）
+	PhaseWriteData        = "write_data"         // WriteDataTimeMs：BE 写入数据
+	PhaseCommitAndPublish = "commit_and_publish" // CommitAndPublishTimeMs：提交并发布事务
+)
+
+// phases 阶段名称，顺序与 phaseMillis 返回值一致
+var phases = []string{PhaseBeginTxn, PhaseStreamLoadPut, PhaseReadData, PhaseWriteData, PhaseCommitAndPublish}
+
+// phaseMillis 返回响应中各阶段的耗时（毫秒）
+func phaseMillis(r *StreamLoadResponse) []int64 {
+	return []int64{r.BeginTxnTimeMs, r.StreamLoadPutTimeMs, r.ReadDataTimeMs, r.WriteDataTimeMs, r.CommitAndPublishTimeMs}
+}
+
+// PhaseHistogram 单张表单个阶段的耗时直方图
+type PhaseHistogram struct {
+	Table   string
+	Phase   string
+	Buckets []uint64 // 与 PhaseBuckets 一一对应的累计计数
+	Count   uint64
+	Sum     float64 // 秒
+
+	// Exemplars 各桶最近一次观测的追踪，与 PhaseBuckets 一一对应，最后一个为 +Inf 桶；未记录过追踪时为 nil
+	Exemplars []Exemplar
+}
+
+// phaseTimings 按表统计成功的 Stream Load 各阶段耗时，可并发使用
+type phaseTimings struct {
+	mu     sync.Mutex
+	tables map[string][]PhaseHistogram // 按表名索引，与 phases 一一对应
+}
+
+// observe 记录一次成功写入的各阶段耗时，traceID 不为空时记为所在桶的 exemplar
+func (t *phaseTimings) observe(table string, r *StreamLoadResponse, traceID string) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hs, ok := t.tables[table]
+	if !ok {
+		if t.tables == nil {
+			t.tables = make(map[string][]PhaseHistogram)
+		}
+		hs = make([]PhaseHistogram, len(phases))
+		for i, phase := range phases {
+			hs[i] = PhaseHistogram{Table: table, Phase: phase, Buckets: make([]uint64, len(PhaseBuckets))}
+		}
+		t.tables[table] = hs
+	}
+	for i, ms := range phaseMillis(r) {
+		v := float64(ms) / 1000
+		h := &hs[i]
+		h.Count++
+		h.Sum += v
+		for j, le := range PhaseBuckets {
+			if v <= le {
+				h.Buckets[j]++
+			}
+		}
+		h.Exemplars = recordExemplar(h.Exemplars, traceID, v, now)
+	}
+}
+
+// PhaseTimings 返回成功的 Stream Load 尝试按表统计的各阶段耗时直方图，按表名和阶段排序
+// 用于区分写入慢在网络（read_data）、BE 写入（write_data）还是事务提交（commit_and_publish）
+func (dc *Client) PhaseTimings() []PhaseHistogram {
+	dc.timings.mu.Lock()
+	defer dc.timings.mu.Unlock()
+	names := make([]string, 0, len(dc.timings.tables))
+	for name := range dc.timings.tables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var out []PhaseHistogram
+	for _, name := range names {
+		for _, h := range dc.timings.tables[name] {
+			h.Buckets = slices.Clone(h.Buckets)
+			h.Exemplars = slices.Clone(h.Exemplars)
+			out = append(out, h)
+		}
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package dorisload
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 发往 BE 的请求在客户端侧的各阶段，由 net/http/httptrace 采集
+const (
+	TracePhaseDNS          = "dns"           // 解析 BE 主机名
+	TracePhaseConnect      = "connect"       // 建立 TCP 连接
+	TracePhaseTLS          = "tls"           // TLS 握手
+	TracePhaseContinueWait = "continue_wait" // 发出请求头到收到 100 Continue
+	TracePhaseFirstByte    = "first_byte"    // 请求发送完毕到收到响应的第一个字节，主要是 BE 处理时间
+)
+
+// tracePhases 阶段名称，顺序与 ConnTrace.durations 返回值一致
+var tracePhases = []string{TracePhaseDNS, TracePhaseConnect, TracePhaseTLS, TracePhaseContinueWait, TracePhaseFirstByte}
+
+// ConnTrace 一次发往 BE 的请求在客户端侧的耗时，没有经历的阶段（如复用连接时的 dns、connect、tls）为 0
+type ConnTrace struct {
+	BE           string
+	Reused       bool // 是否复用了空闲连接
+	DNS          time.Duration
+	Connect      time.Duration
+	TLS          time.Duration
+	ContinueWait time.Duration
+	FirstByte    time.Duration
+
+	dnsStart, connectStart, tlsStart time.Time
+
+	// 写请求的回调（WroteHeaders、WroteRequest）在 Transport 的写 goroutine 调用，读响应的回调在读 goroutine 调用，
+	// BE 在请求体发送完之前就返回响应时两者并发
+	mu           sync.Mutex
+	wroteHeaders time.Time
+	wroteRequest time.Time
+
+	gotContinue bool // 是否收到 100 Continue
+}
+
+// clientTrace 返回记录到 t 的 httptrace.ClientTrace，回调可能来自不同的 goroutine
+// 建立连接阶段的回调依次调用，不会并发；写请求和读响应的回调可能并发，由 mu 保护
+func (t *ConnTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn:  func(info httptrace.GotConnInfo) { t.Reused = info.Reused },
+		DNSStart: func(httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.DNS = time.Since(t.dnsStart) },
+		ConnectStart: func(string, string) {
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone:       func(string, string, error) { t.Connect = time.Since(t.connectStart) },
+		TLSHandshakeStart: func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.TLS = time.Since(t.tlsStart) },
+		WroteHeaders: func() {
+			t.mu.Lock()
+			t.wroteHeaders = time.Now()
+			t.mu.Unlock()
+		},
+		Got100Continue: func() {
+			t.mu.Lock()
+			t.ContinueWait, t.gotContinue = time.Since(t.wroteHeaders), true
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			t.wroteRequest = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			if !t.wroteRequest.IsZero() {
+				t.FirstByte = time.Since(t.wroteRequest)
+			}
+			t.mu.Unlock()
+		},
+	}
+}
+
+// continued 返回是否收到 100 Continue；请求失败时读响应的回调可能仍在进行
+func (t *ConnTrace) continued() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.gotContinue
+}
+
+// durations 返回各阶段的耗时，顺序与 tracePhases 一致
+func (t *ConnTrace) durations() []time.Duration {
+	return []time.Duration{t.DNS, t.Connect, t.TLS, t.ContinueWait, t.FirstByte}
+}
+
+// TraceHistogram 单个 BE 单个客户端阶段的耗时直方图，桶上界与 PhaseBuckets 相同
+type TraceHistogram struct {
+	BE      string
+	Phase   string
+	Buckets []uint64 // 与 PhaseBuckets 一一对应的累计计数
+	Count   uint64
+	Sum     float64 // 秒
+
+	// Exemplars 各桶最近一次观测的追踪，与 PhaseBuckets 一一对应，最后一个为 +Inf 桶；未记录过追踪时为 nil
+	Exemplars []Exemplar
+}
+
+// traceTimings 按 BE 统计客户端各阶段耗时，可并发使用
+type traceTimings struct {
+	mu       sync.Mutex
+	backends map[string][]TraceHistogram // 按 BE 索引，与 tracePhases 一一对应
+}
+
+// observe 记录一次请求经历的阶段，没有经历的阶段不计数；traceID 不为空时记为所在桶的 exemplar
+func (t *traceTimings) observe(ct *ConnTrace, traceID string) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hs, ok := t.backends[ct.BE]
+	if !ok {
+		if t.backends == nil {
+			t.backends = make(map[string][]TraceHistogram)
+		}
+		hs = make([]TraceHistogram, len(tracePhases))
+		for i, phase := range tracePhases {
+			hs[i] = TraceHistogram{BE: ct.BE, Phase: phase, Buckets: make([]uint64, len(PhaseBuckets))}
+		}
+		t.backends[ct.BE] = hs
+	}
+	for i, d := range ct.durations() {
+		if d <= 0 {
+			continue
+		}
+		v := d.Seconds()
+		h := &hs[i]
+		h.Count++
+		h.Sum += v
+		for j, le := range PhaseBuckets {
+			if v <= le {
+				h.Buckets[j]++
+			}
+		}
+		h.Exemplars = recordExemplar(h.Exemplars, traceID, v, now)
+	}
+}
+
+// TraceTimings 返回发往各 BE 的请求在客户端侧的各阶段耗时直方图，按 BE 和阶段排序
+// 与 PhaseTimings（BE 报告的耗时）对照，用于区分写入慢在网络（dns、connect、tls、continue_wait）还是 BE（first_byte）
+func (dc *Client) TraceTimings() []TraceHistogram {
+	dc.traces.mu.Lock()
+	defer dc.traces.mu.Unlock()
+	bes := make([]string, 0, len(dc.traces.backends))
+	for be := range dc.traces.backends {
+		bes = append(bes, be)
+	}
+	slices.Sort(bes)
+
+	var out []TraceHistogram
+	for _, be := range bes {
+		for _, h := range dc.traces.backends[be] {
+			h.Buckets = slices.Clone(h.Buckets)
+			h.Exemplars = slices.Clone(h.Exemplars)
+			out = append(out, h)
+		}
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+package dorisload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// 两阶段提交的事务操作
+const (
+	txnCommit = "commit"
+	txnAbort  = "abort"
+)
+
+// CommitTxn 提交两阶段 Stream Load（LoadOptions.TwoPC）预提交的事务，使用 Config.User/Passwd
+func (dc *Client) CommitTxn(ctx context.Context, txnID int64) error {
+	return dc.txnOperation(ctx, nil, txnID, txnCommit)
+}
+
+// AbortTxn 放弃两阶段 Stream Load 预提交的事务，使用 Config.User/Passwd
+func (dc *Client) AbortTxn(ctx context.Context, txnID int64) error {
+	return dc.txnOperation(ctx, nil, txnID, txnAbort)
+}
+
+// CommitTableTxn 同 CommitTxn，使用写入目标表的用户（Config.TableCredentials）
+func (dc *Client) CommitTableTxn(ctx context.Context, table *Table, txnID int64) error {
+	return dc.txnOperation(ctx, table, txnID, txnCommit)
+}
+
+// AbortTableTxn 同 AbortTxn，使用写入目标表的用户（Config.TableCredentials）
+func (dc *Client) AbortTableTxn(ctx context.Context, table *Table, txnID int64) error {
+	return dc.txnOperation(ctx, table, txnID, txnAbort)
+}
+
+// txnOperation 通过任一 BE 提交或放弃事务，BE 转发给 FE 执行；table 为 nil 时使用 Config.User/Passwd
+func (dc *Client) txnOperation(ctx context.Context, table *Table, txnID int64, op string) error {
+	target := fmt.Sprintf("%s/api/%s/_stream_load_2pc", dc.balancer.Pick(""), url.PathEscape(dc.config.DB))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Authorization", dc.authFor(table))
+	req.Header.Set("txn_id", strconv.FormatInt(txnID, 10))
+	req.Header.Set("txn_operation", op)
+
+	resp, err := dc.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("doris 连接失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取 Doris 响应体失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("doris 返回错误 [%d]: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Status string `json:"status"`
+		Msg    string `json:"msg"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("无法解析 Doris 响应: %s", string(body))
+	}
+	if result.Status != StatusSuccess {
+		return fmt.Errorf("事务 %d %s 失败: %s", txnID, op, result.Msg)
+	}
+	return nil
+}
@@ -0,0 +1,492 @@
+//go:build e2e
+
+// e2e 端到端测试：启动模拟 BE 和 doris-webhook 服务，通过各个端点写入事件，
+// 检查写入 BE 的行以及错误响应，分别在直接写入和批量写入（BATCH_ENABLED）两种模式下运行。
+//
+//	go run -tags e2e ./e2e
+//	go run -tags e2e ./e2e -bin ./doris-webhook -v
+//
+// 服务固定监听 :8080，运行前需确保端口空闲。
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	serverAddr = "http://127.0.0.1:8080"
+	database   = "e2e"
+)
+
+// configYAML 测试使用的端点配置：单条写入、批量写入和必填字段校验
+const configYAML = `endpoints:
+  - name: video
+    path: /video
+    table: video_metrics
+    priority: high
+    columns:
+      - column: project
+        required: true
+      - column: event
+        required: true
+      - column: event_time
+        source: ingest_time
+  - name: click
+    path: /click
+    bulk_path: /click/bulk
+    table: click_events
+    columns:
+      - column: project
+        required: true
+      - column: page
+        required: true
+      - column: x
+        type: int
+      - column: sdk_version
+        source: sdk_version
+        default: unknown
+      - column: event_time
+        source: ingest_time
+`
+
+// 模拟 BE 的故障模式
+const (
+	beOK       int32 = iota
+	beFail           // 返回 Status=Fail
+	beInternal       // 返回 HTTP 500
+)
+
+// mockBE 模拟 Doris BE 的 Stream Load 接口，记录写入的行
+type mockBE struct {
+	mu   sync.Mutex
+	rows map[string][]map[string]any // 表名 → 已写入的行
+	mode atomic.Int32
+}
+
+func newMockBE() *mockBE {
+	return &mockBE{rows: make(map[string][]map[string]any)}
+}
+
+func (m *mockBE) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Path == "/api/health" {
+		w.Write([]byte(`{"status":"OK"}`))
+		return
+	}
+	// /api/{db}/{table}/_stream_load
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[0] != "api" || parts[3] != "_stream_load" {
+		http.NotFound(w, r)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	label := r.Header.Get("label")
+
+	switch m.mode.Load() {
+	case beInternal:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	case beFail:
+		writeLoadResponse(w, label, "Fail", "[ANALYSIS_ERROR]injected failure", 0)
+		return
+	}
+
+	var rows []map[string]any
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var row map[string]any
+		if err := json.Unmarshal(line, &row); err != nil {
+			writeLoadResponse(w, label, "Fail", "invalid json line: "+err.Error(), 0)
+			return
+		}
+		rows = append(rows, row)
+	}
+	m.mu.Lock()
+	m.rows[parts[2]] = append(m.rows[parts[2]], rows...)
+	m.mu.Unlock()
+	writeLoadResponse(w, label, "Success", "OK", len(rows))
+}
+
+func writeLoadResponse(w http.ResponseWriter, label, status, message string, rows int) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"TxnId":            1,
+		"Label":            label,
+		"Status":           status,
+		"Message":          message,
+		"NumberTotalRows":  rows,
+		"NumberLoadedRows": rows,
+	})
+}
+
+// projectRows 返回目标表中指定项目的行
+func (m *mockBE) projectRows(table, project string) []map[string]any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []map[string]any
+	for _, row := range m.rows[table] {
+		if row["project"] == project {
+			out = append(out, row)
+		}
+	}
+	return out
+}
+
+// waitRows 等待目标表中出现指定项目的 n 行（批量写入模式下写入是异步合并的）
+func (m *mockBE) waitRows(table, project string, n int) []map[string]any {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rows := m.projectRows(table, project)
+		if len(rows) >= n || time.Now().After(deadline) {
+			return rows
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// errorResponse 服务的统一错误响应
+type errorResponse struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details"`
+	RequestID string         `json:"request_id"`
+}
+
+// suite 一轮测试的上下文
+type suite struct {
+	mode    string
+	be      *mockBE
+	verbose bool
+	failed  int
+}
+
+func (s *suite) check(name string, err error) {
+	if err != nil {
+		s.failed++
+		fmt.Printf("FAIL [%s] %s: %v\n", s.mode, name, err)
+		return
+	}
+	if s.verbose {
+		fmt.Printf("ok   [%s] %s\n", s.mode, name)
+	}
+}
+
+// post 发送请求，返回状态码和（失败时的）错误响应
+func post(path, body string, header http.Header) (int, *errorResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, serverAddr+path, strings.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 400 {
+		return resp.StatusCode, nil, nil
+	}
+	var er errorResponse
+	if err := json.Unmarshal(raw, &er); err != nil {
+		return resp.StatusCode, nil, fmt.Errorf("错误响应不是 JSON: %s", raw)
+	}
+	return resp.StatusCode, &er, nil
+}
+
+// expectError 检查请求返回指定状态码和错误码
+func expectError(path, body string, wantStatus int, wantCode string) error {
+	status, er, err := post(path, body, nil)
+	if err != nil {
+		return err
+	}
+	if status != wantStatus || er == nil || er.Code != wantCode {
+		return fmt.Errorf("期望 %d %s，实际 %d %+v", wantStatus, wantCode, status, er)
+	}
+	if er.RequestID == "" {
+		return fmt.Errorf("错误响应缺少 request_id")
+	}
+	return nil
+}
+
+// expectRows 检查请求成功且目标表中出现指定项目的 n 行
+func (s *suite) expectRows(path, body, table, project string, n int) ([]map[string]any, error) {
+	status, er, err := post(path, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("期望 200，实际 %d %+v", status, er)
+	}
+	rows := s.be.waitRows(table, project, n)
+	if len(rows) != n {
+		return nil, fmt.Errorf("表 %s 中项目 %s 期望 %d 行，实际 %d 行", table, project, n, len(rows))
+	}
+	return rows, nil
+}
+
+func (s *suite) run() {
+	p := func(name string) string { return "e2e-" + s.mode + "-" + name }
+
+	s.check("health", func() error {
+		resp, err := http.Get(serverAddr + "/health")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("期望 200，实际 %d", resp.StatusCode)
+		}
+		return nil
+	}())
+
+	s.check("video 单条写入", func() error {
+		rows, err := s.expectRows("/video", fmt.Sprintf(`{"project":%q,"event":"play"}`, p("video")), "video_metrics", p("video"), 1)
+		if err != nil {
+			return err
+		}
+		if rows[0]["event"] != "play" || rows[0]["event_time"] == nil {
+			return fmt.Errorf("行内容不正确: %v", rows[0])
+		}
+		return nil
+	}())
+
+	s.check("click 单条写入", func() error {
+		rows, err := s.expectRows("/click", fmt.Sprintf(`{"project":%q,"page":"/home","x":"12"}`, p("click")), "click_events", p("click"), 1)
+		if err != nil {
+			return err
+		}
+		if rows[0]["x"] != float64(12) || rows[0]["sdk_version"] != "unknown" {
+			return fmt.Errorf("类型转换或默认值不正确: %v", rows[0])
+		}
+		return nil
+	}())
+
+	s.check("click 批量写入（数组）", func() error {
+		project := p("bulk-array")
+		_, err := s.expectRows("/click/bulk", fmt.Sprintf(`[{"project":%q,"page":"/a"},{"project":%q,"page":"/b"}]`, project, project), "click_events", project, 2)
+		return err
+	}())
+
+	s.check("click 批量写入（信封）", func() error {
+		project := p("bulk-envelope")
+		body := fmt.Sprintf(`{"sent_at":%q,"sdk_version":"2.1.0","events":[{"project":%q,"page":"/a"},{"project":%q,"page":"/b"},{"project":%q,"page":"/c"}]}`,
+			time.Now().UTC().Format(time.RFC3339), project, project, project)
+		rows, err := s.expectRows("/click/bulk", body, "click_events", project, 3)
+		if err != nil {
+			return err
+		}
+		if rows[0]["sdk_version"] != "2.1.0" {
+			return fmt.Errorf("sdk_version 不正确: %v", rows[0])
+		}
+		return nil
+	}())
+
+	s.check("非法 JSON", expectError("/video", `{"project":`, http.StatusBadRequest, "SCHEMA_INVALID"))
+	s.check("缺少必填字段", expectError("/video", `{"project":"x"}`, http.StatusBadRequest, "SCHEMA_INVALID"))
+	s.check("字段类型错误", expectError("/click", `{"project":"x","page":"/","x":"abc"}`, http.StatusUnprocessableEntity, "SCHEMA_INVALID"))
+	s.check("批量写入中的无效事件", func() error {
+		project := p("bulk-invalid")
+		_, er, err := post("/click/bulk", fmt.Sprintf(`[{"project":%q,"page":"/a"},{"project":%q}]`, project, project), nil)
+		if err != nil {
+			return err
+		}
+		if er == nil || er.Code != "SCHEMA_INVALID" || er.Details["index"] != float64(1) {
+			return fmt.Errorf("期望 SCHEMA_INVALID index=1，实际 %+v", er)
+		}
+		// 批量请求整体拒绝，有效事件也不应写入
+		time.Sleep(200 * time.Millisecond)
+		if rows := s.be.projectRows("click_events", project); len(rows) != 0 {
+			return fmt.Errorf("被拒绝的批量请求写入了 %d 行", len(rows))
+		}
+		return nil
+	}())
+	s.check("批量写入超过上限", expectError("/click/bulk", `[{},{},{},{},{},{}]`, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE"))
+	s.check("未知路径", expectError("/nope", `{}`, http.StatusNotFound, "NOT_FOUND"))
+
+	s.check("透传 X-Request-Id", func() error {
+		_, er, err := post("/video", `{}`, http.Header{"X-Request-Id": {"e2e-trace-1"}})
+		if err != nil {
+			return err
+		}
+		if er == nil || er.RequestID != "e2e-trace-1" {
+			return fmt.Errorf("期望 request_id=e2e-trace-1，实际 %+v", er)
+		}
+		return nil
+	}())
+
+	s.check("BE 返回 Status=Fail", func() error {
+		s.be.mode.Store(beFail)
+		defer s.be.mode.Store(beOK)
+		return expectError("/video", fmt.Sprintf(`{"project":%q,"event":"play"}`, p("be-fail")), http.StatusBadGateway, "DORIS_UNAVAILABLE")
+	}())
+
+	s.check("BE 返回 500", func() error {
+		s.be.mode.Store(beInternal)
+		defer s.be.mode.Store(beOK)
+		return expectError("/video", fmt.Sprintf(`{"project":%q,"event":"play"}`, p("be-500")), http.StatusBadGateway, "DORIS_UNAVAILABLE")
+	}())
+
+	s.check("BE 恢复后写入", func() error {
+		_, err := s.expectRows("/video", fmt.Sprintf(`{"project":%q,"event":"play"}`, p("recovered")), "video_metrics", p("recovered"), 1)
+		return err
+	}())
+}
+
+// server 运行中的服务进程
+type server struct {
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+// startServer 启动服务并等待健康检查通过
+func startServer(bin string, env []string, logFile *os.File) (*server, error) {
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	srv := &server{cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(srv.exited)
+	}()
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-srv.exited:
+			return nil, fmt.Errorf("服务启动失败，日志见 %s", logFile.Name())
+		default:
+		}
+		if resp, err := http.Get(serverAddr + "/health"); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return srv, nil
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	srv.stop()
+	return nil, fmt.Errorf("等待服务启动超时，日志见 %s", logFile.Name())
+}
+
+// stop 发送 SIGTERM 并等待服务优雅退出，超时后强制结束
+func (srv *server) stop() {
+	srv.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-srv.exited:
+	case <-time.After(30 * time.Second):
+		srv.cmd.Process.Kill()
+		<-srv.exited
+	}
+}
+
+func main() {
+	bin := flag.String("bin", "", "doris-webhook 可执行文件，为空时在临时目录中构建")
+	verbose := flag.Bool("v", false, "输出每个用例的结果")
+	flag.Parse()
+
+	if err := run(*bin, *verbose); err != nil {
+		fmt.Fprintln(os.Stderr, "e2e:", err)
+		os.Exit(1)
+	}
+}
+
+func run(bin string, verbose bool) error {
+	if conn, err := net.DialTimeout("tcp", "127.0.0.1:8080", time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf(":8080 已被占用")
+	}
+
+	dir, err := os.MkdirTemp("", "doris-webhook-e2e-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if bin == "" {
+		bin = filepath.Join(dir, "doris-webhook")
+		build := exec.Command("go", "build", "-o", bin, ".")
+		build.Stdout, build.Stderr = os.Stdout, os.Stderr
+		if err := build.Run(); err != nil {
+			return fmt.Errorf("构建失败: %w", err)
+		}
+	}
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configFile, []byte(configYAML), 0o644); err != nil {
+		return err
+	}
+
+	be := newMockBE()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	beServer := &http.Server{Handler: be}
+	go beServer.Serve(ln)
+	defer beServer.Shutdown(context.Background())
+
+	baseEnv := []string{
+		"DORIS_BE_HTTP=" + ln.Addr().String(),
+		"DORIS_DATABASE=" + database,
+		"DORIS_USER=e2e",
+		"DORIS_PASSWORD=e2e",
+		"CONFIG_FILE=" + configFile,
+		"BULK_MAX_EVENTS=5",
+		"LOG_FORMAT=json",
+	}
+	modes := []struct {
+		name string
+		env  []string
+	}{
+		{"direct", []string{"BATCH_ENABLED=false"}},
+		{"batch", []string{"BATCH_ENABLED=true"}},
+	}
+
+	failed := 0
+	for _, mode := range modes {
+		logFile, err := os.Create(filepath.Join(os.TempDir(), "doris-webhook-e2e-"+mode.name+".log"))
+		if err != nil {
+			return err
+		}
+		srv, err := startServer(bin, append(append([]string{}, baseEnv...), mode.env...), logFile)
+		if err != nil {
+			logFile.Close()
+			return fmt.Errorf("[%s] %w", mode.name, err)
+		}
+		s := &suite{mode: mode.name, be: be, verbose: verbose}
+		s.run()
+		srv.stop()
+		logFile.Close()
+		if s.failed > 0 {
+			fmt.Printf("[%s] %d 个用例失败，服务日志见 %s\n", mode.name, s.failed, logFile.Name())
+		}
+		failed += s.failed
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d 个用例失败", failed)
+	}
+	fmt.Println("e2e: 全部通过")
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// 错误码：错误响应的 code 字段，客户端按错误码处理，message 仅供排查
+const (
+	errCodeInvalidRequest        = "INVALID_REQUEST"        // 请求无法读取或管理接口参数错误
+	errCodeSchemaInvalid         = "SCHEMA_INVALID"         // 请求体不符合端点的列映射：JSON 无效、缺少必填字段、类型无法转换
+	errCodeChecksumMismatch      = "CHECKSUM_MISMATCH"      // 请求体与 Content-MD5 或 X-Checksum-SHA256 不一致，传输中损坏
+	errCodeTimestampOutOfRange   = "TIMESTAMP_OUT_OF_RANGE" // datetime 字段超出 max_age/max_future
+	errCodePayloadTooLarge       = "PAYLOAD_TOO_LARGE"      // 请求体超过上限，批量请求的事件数超过上限，或事件超出端点的 limits
+	errCodeUnauthorized          = "UNAUTHORIZED"           // 鉴权失败
+	errCodeForbidden             = "FORBIDDEN"              // 客户端 IP 已被封禁
+	errCodeNotFound              = "NOT_FOUND"              // 路径或资源不存在
+	errCodeRateLimited           = "RATE_LIMITED"           // 项目超出配额
+	errCodeRequestInProgress     = "REQUEST_IN_PROGRESS"    // 同一个 Idempotency-Key 的请求正在写入，稍后重试
+	errCodeOverloaded            = "OVERLOADED"             // 背压或并发上限，稍后重试
+	errCodeShuttingDown          = "SHUTTING_DOWN"          // 服务正在关闭，稍后重试
+	errCodeIngestionPaused       = "INGESTION_PAUSED"       // 目标表或端点的写入已通过管理接口暂停，PAUSE_POLICY 为 reject
+	errCodeTimeout               = "TIMEOUT"                // 请求超时
+	errCodeDorisUnavailable      = "DORIS_UNAVAILABLE"      // Doris 写入失败或预检未通过
+	errCodeSinkFailed            = "SINK_FAILED"            // must_succeed 输出目标写入失败
+	errCodeDependencyUnavailable = "DEPENDENCY_UNAVAILABLE" // 配额、nonce 等存储不可用
+	errCodeInternal              = "INTERNAL"               // 服务内部错误
+)
+
+const (
+	requestIDHeader = "X-Request-Id"
+	loadLabelHeader = "X-Load-Label" // 成功响应中写入 Doris 使用的 Stream Load label
+	requestIDKey    = "request_id"
+	maxRequestIDLen = 128
+)
+
+// errorResponse 统一的错误响应
+type errorResponse struct {
+	Code      string     `json:"code"`
+	Message   string     `json:"message"`
+	Details   any        `json:"details,omitempty"`
+	RequestID string     `json:"request_id,omitempty"`
+	Retry     *retryHint `json:"retry,omitempty"` // 限流、过载等可重试错误的重试建议
+}
+
+// 重试建议的退避策略
+const (
+	retryStrategyFixed       = "fixed"       // 等待 after_seconds 后重试，如配额重置、服务重启
+	retryStrategyExponential = "exponential" // 以 after_seconds 为初始间隔指数退避，并加随机抖动
+)
+
+// maxRetryBackoff 指数退避的建议间隔上限
+const maxRetryBackoff = 5 * time.Minute
+
+// retryHint 可重试错误的重试建议，after_seconds 与 Retry-After 头一致
+type retryHint struct {
+	AfterSeconds int    `json:"after_seconds"`
+	Strategy     string `json:"strategy"`
+	MaxSeconds   int    `json:"max_seconds,omitempty"` // 指数退避的间隔上限
+}
+
+// abortWithError 写出错误响应并中止后续处理函数
+func abortWithError(c *gin.Context, status int, code, message string, details any) {
+	c.AbortWithStatusJSON(status, errorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: c.GetString(requestIDKey),
+	})
+}
+
+// abortWithRetry 写出带 Retry-After 头和重试建议的错误响应，after 向上取整到秒，至少 1 秒
+func abortWithRetry(c *gin.Context, status int, code, message string, after time.Duration, strategy string, details any) {
+	hint := &retryHint{AfterSeconds: max(int(math.Ceil(after.Seconds())), 1), Strategy: strategy}
+	if strategy == retryStrategyExponential {
+		hint.MaxSeconds = int(maxRetryBackoff / time.Second)
+	}
+	c.Header("Retry-After", strconv.Itoa(hint.AfterSeconds))
+	c.AbortWithStatusJSON(status, errorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: c.GetString(requestIDKey),
+		Retry:     hint,
+	})
+}
+
+// optionalDetails 空的 details 返回 nil，错误响应中省略 details 字段
+func optionalDetails(details gin.H) any {
+	if len(details) == 0 {
+		return nil
+	}
+	return details
+}
+
+// requestID 请求 ID 中间件：沿用客户端的 X-Request-Id，缺失或无效时生成，并写入响应头
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// validRequestID 请求 ID 只允许可打印 ASCII 字符，避免写入日志和响应头时注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// notFound 未注册路径的处理函数
+func notFound(c *gin.Context) {
+	abortWithError(c, http.StatusNotFound, errCodeNotFound, "Not found", nil)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"doris-webhook/dorisload"
+)
+
+// traceparentHeader W3C Trace Context 请求头：{version}-{trace-id}-{parent-id}-{flags}
+const traceparentHeader = "traceparent"
+
+// openMetricsContentType 带 exemplar 的 /metrics 响应类型，Prometheus 抓取时在 Accept 中声明
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+type traceIDKey struct{}
+
+// parseTraceparent 返回 traceparent 中的 trace-id（32 位小写十六进制），格式无效或全为 0 时返回空串
+func parseTraceparent(value string) string {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ""
+	}
+	traceID := parts[1]
+	if strings.Trim(traceID, "0") == "" || strings.Trim(traceID, "0123456789abcdef") != "" {
+		return ""
+	}
+	return traceID
+}
+
+// traceContext 在请求上下文中记录调用方 traceparent 的 trace-id，写入 Doris 时作为耗时直方图的 exemplar
+func traceContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if traceID := parseTraceparent(c.GetHeader(traceparentHeader)); traceID != "" {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), traceIDKey{}, traceID))
+		}
+		c.Next()
+	}
+}
+
+// exemplarTraceID 作为 dorisload.Config.ExemplarTraceID：返回写入所属请求的 trace-id，
+// 批量写入时为批次中第一个带 traceparent 的请求；WAL 回放、作业等没有请求的写入返回空串
+func exemplarTraceID(ctx context.Context) string {
+	ctxs := dorisload.SubmitContexts(ctx)
+	if ctxs == nil {
+		ctxs = []context.Context{ctx}
+	}
+	for _, c := range ctxs {
+		if traceID, ok := c.Value(traceIDKey{}).(string); ok {
+			return traceID
+		}
+	}
+	return ""
+}
+
+// newExemplars 按 METRICS_EXEMPLARS 返回 dorisload.Config.ExemplarTraceID，未启用时返回 nil
+func newExemplars() (func(context.Context) string, error) {
+	switch v := getEnv("METRICS_EXEMPLARS", "false"); v {
+	case "false":
+		return nil, nil
+	case "true":
+		return exemplarTraceID, nil
+	default:
+		return nil, fmt.Errorf("METRICS_EXEMPLARS 无效: %q（可选 true、false）", v)
+	}
+}
+
+// acceptsOpenMetrics 判断抓取方是否接受 OpenMetrics 格式，只有该格式能携带 exemplar
+func acceptsOpenMetrics(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "application/openmetrics-text")
+}
+
+// formatExemplar 返回直方图第 i 个桶的 exemplar 后缀，没有记录时返回空串
+func formatExemplar(exemplars []dorisload.Exemplar, i int) string {
+	if i >= len(exemplars) || exemplars[i].TraceID == "" {
+		return ""
+	}
+	e := exemplars[i]
+	return fmt.Sprintf(" # {trace_id=%q} %g %.3f", e.TraceID, e.Value, float64(e.Time.UnixMilli())/1000)
+}
+
+// toOpenMetrics 将 Prometheus 文本格式转为 OpenMetrics：counter 的指标族名去掉 _total 后缀，结尾加 # EOF
+func toOpenMetrics(text string) string {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	counters := make(map[string]bool)
+	for _, line := range lines {
+		if name, ok := strings.CutPrefix(line, "# TYPE "); ok && strings.HasSuffix(name, "_total counter") {
+			counters[strings.TrimSuffix(name, " counter")] = true
+		}
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		for _, prefix := range []string{"# HELP ", "# TYPE "} {
+			rest, ok := strings.CutPrefix(line, prefix)
+			if !ok {
+				continue
+			}
+			if name, desc, _ := strings.Cut(rest, " "); counters[name] {
+				line = prefix + strings.TrimSuffix(name, "_total") + " " + desc
+			}
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteString("# EOF\n")
+	return b.String()
+}
@@ -0,0 +1,258 @@
+//go:build chaos
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// faultType 注入的故障类型
+type faultType string
+
+const (
+	faultTimeout  faultType = "timeout"   // 不转发请求，阻塞到请求上下文取消（单次尝试超时）
+	faultHTTP500  faultType = "http_500"  // 不转发请求，直接返回 HTTP 500（可重试错误）
+	faultFail     faultType = "fail"      // 不转发请求，返回 Status=Fail 的 Stream Load 响应
+	faultPartial  faultType = "partial"   // 转发请求，响应体截断一半：数据已提交但客户端无法解析响应
+	faultSlowRead faultType = "slow_read" // 转发请求，响应体每次读取前等待 delay_ms
+)
+
+const defaultSlowReadDelay = time.Second
+
+// Fault 一条故障注入规则
+type Fault struct {
+	ID      string    `json:"id"`
+	Type    faultType `json:"type"`
+	Table   string    `json:"table,omitempty"`    // 只对该表的 Stream Load 生效，为空时对所有发往 BE 的请求生效
+	Percent float64   `json:"percent,omitempty"`  // 命中概率（0-100），为 0 时总是命中
+	DelayMs int       `json:"delay_ms,omitempty"` // slow_read 每次读取前的等待时间，默认 1000
+	Count   int64     `json:"count,omitempty"`    // 最多注入的次数，达到后规则自动删除；为 0 时不限次数
+
+	Injected int64 `json:"injected"` // 已注入的次数
+}
+
+// FaultInjector 在发往 BE 的请求上按规则注入故障，用于验证重试、WAL 和降级行为
+// 只在 chaos 构建中编译，规则通过 /admin/faults 管理，服务重启后清空
+type FaultInjector struct {
+	mu     sync.Mutex
+	faults []*Fault
+	logger *slog.Logger
+}
+
+// newFaultInjector 创建故障注入器
+func newFaultInjector(logger *slog.Logger) *FaultInjector {
+	logger.Warn("故障注入已启用（chaos 构建），不要在生产环境使用")
+	return &FaultInjector{logger: logger}
+}
+
+// wrap 包装 Doris 客户端的 RoundTripper
+func (f *FaultInjector) wrap(rt http.RoundTripper) http.RoundTripper {
+	return &faultTransport{next: rt, faults: f}
+}
+
+// pick 返回命中请求的规则（按添加顺序第一条），并计入注入次数
+func (f *FaultInjector) pick(table string) *Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, fault := range f.faults {
+		if fault.Table != "" && fault.Table != table {
+			continue
+		}
+		if fault.Percent > 0 && rand.Float64()*100 >= fault.Percent {
+			continue
+		}
+		fault.Injected++
+		hit := *fault
+		if fault.Count > 0 && fault.Injected >= fault.Count {
+			f.faults = append(f.faults[:i], f.faults[i+1:]...)
+		}
+		return &hit
+	}
+	return nil
+}
+
+// faultTransport 按注入规则处理请求的 RoundTripper
+type faultTransport struct {
+	next   http.RoundTripper
+	faults *FaultInjector
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	table := streamLoadTable(req.URL.Path)
+	fault := t.faults.pick(table)
+	if fault == nil {
+		return t.next.RoundTrip(req)
+	}
+	t.faults.logger.Warn("注入故障", "fault_id", fault.ID, "type", fault.Type, "table", table, "url", req.URL.String())
+
+	switch fault.Type {
+	case faultTimeout:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		<-req.Context().Done()
+		return nil, fmt.Errorf("injected timeout: %w", req.Context().Err())
+	case faultHTTP500:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return fakeResponse(req, http.StatusInternalServerError, "injected internal error"), nil
+	case faultFail:
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		body := fmt.Sprintf(`{"TxnId":0,"Label":%q,"Status":"Fail","Message":"[INTERNAL_ERROR]injected failure"}`, req.Header.Get("label"))
+		return fakeResponse(req, http.StatusOK, body), nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch fault.Type {
+	case faultPartial:
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = body[:len(body)/2]
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Del("Content-Length")
+	case faultSlowRead:
+		delay := defaultSlowReadDelay
+		if fault.DelayMs > 0 {
+			delay = time.Duration(fault.DelayMs) * time.Millisecond
+		}
+		resp.Body = &slowReader{ReadCloser: resp.Body, ctx: req.Context(), delay: delay}
+	}
+	return resp, nil
+}
+
+// streamLoadTable 从 /api/{db}/{table}/_stream_load 中取出表名，其他请求返回空字符串
+func streamLoadTable(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) == 4 && parts[0] == "api" && parts[3] == "_stream_load" {
+		return parts[2]
+	}
+	return ""
+}
+
+// fakeResponse 构造不经过 BE 的响应
+func fakeResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// slowReader 每次读取前等待，每次最多读取 16 字节
+type slowReader struct {
+	io.ReadCloser
+	ctx   context.Context
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	case <-time.After(r.delay):
+	}
+	if len(p) > 16 {
+		p = p[:16]
+	}
+	return r.ReadCloser.Read(p)
+}
+
+// registerRoutes 注册故障注入管理接口
+func (f *FaultInjector) registerRoutes(admin *gin.RouterGroup) {
+	admin.GET("/faults", f.listHandler)
+	admin.POST("/faults", f.addHandler)
+	admin.DELETE("/faults", f.clearHandler)
+	admin.DELETE("/faults/:id", f.deleteHandler)
+}
+
+// listHandler 返回当前的注入规则，按匹配顺序排列
+func (f *FaultInjector) listHandler(c *gin.Context) {
+	f.mu.Lock()
+	faults := make([]Fault, len(f.faults))
+	for i, fault := range f.faults {
+		faults[i] = *fault
+	}
+	f.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"faults": faults})
+}
+
+// addHandler 添加注入规则
+func (f *FaultInjector) addHandler(c *gin.Context) {
+	var fault Fault
+	if err := c.ShouldBindJSON(&fault); err != nil {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body: "+err.Error(), nil)
+		return
+	}
+	switch fault.Type {
+	case faultTimeout, faultHTTP500, faultFail, faultPartial, faultSlowRead:
+	default:
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Invalid fault type %q", fault.Type), gin.H{
+			"types": []faultType{faultTimeout, faultHTTP500, faultFail, faultPartial, faultSlowRead},
+		})
+		return
+	}
+	if fault.Percent < 0 || fault.Percent > 100 || fault.DelayMs < 0 || fault.Count < 0 {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body: percent must be 0-100, delay_ms and count must be non-negative", nil)
+		return
+	}
+	fault.ID = uuid.New().String()
+	fault.Injected = 0
+	added := fault
+
+	f.mu.Lock()
+	f.faults = append(f.faults, &fault)
+	f.mu.Unlock()
+	f.logger.Warn("添加故障注入规则", "fault_id", added.ID, "type", added.Type, "table", added.Table, "percent", added.Percent, "count", added.Count)
+	c.JSON(http.StatusOK, added)
+}
+
+// deleteHandler 删除注入规则
+func (f *FaultInjector) deleteHandler(c *gin.Context) {
+	id := c.Param("id")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, fault := range f.faults {
+		if fault.ID == id {
+			f.faults = append(f.faults[:i], f.faults[i+1:]...)
+			c.JSON(http.StatusOK, gin.H{"message": "Fault removed."})
+			return
+		}
+	}
+	abortWithError(c, http.StatusNotFound, errCodeNotFound, "Fault not found", nil)
+}
+
+// clearHandler 删除所有注入规则
+func (f *FaultInjector) clearHandler(c *gin.Context) {
+	f.mu.Lock()
+	f.faults = nil
+	f.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"message": "All faults removed."})
+}
@@ -0,0 +1,20 @@
+//go:build !chaos
+
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FaultInjector 故障注入只在 chaos 构建中可用（go build -tags chaos），正式构建中不包含任何注入代码
+type FaultInjector struct{}
+
+// newFaultInjector 非 chaos 构建中始终返回 nil
+func newFaultInjector(*slog.Logger) *FaultInjector { return nil }
+
+func (f *FaultInjector) wrap(rt http.RoundTripper) http.RoundTripper { return rt }
+
+func (f *FaultInjector) registerRoutes(*gin.RouterGroup) {}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"doris-webhook/dorisload"
+)
+
+// 文件格式，/upload 和定时补录任务共用
+const (
+	formatNDJSON = "ndjson" // 每行一个 JSON 对象（默认）
+	formatCSV    = "csv"    // 首行为表头，按表头名映射到列的 field
+)
+
+// loadRetryInterval 背压时等待 Stream Load 槽位的间隔
+const loadRetryInterval = 200 * time.Millisecond
+
+// labelPartPattern 拼入 Stream Load label 的标识（upload_id、任务名称），只允许 label 可用的字符
+var labelPartPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// errLoadDegraded 降级模式下不写入文件数据，避免补录数据占满 WAL
+var errLoadDegraded = errors.New("doris unavailable")
+
+// fileFormat 返回文件格式，未指定时按扩展名判断：.csv 为 CSV，其他为 NDJSON
+func fileFormat(name, format string) string {
+	if format != "" {
+		return format
+	}
+	if strings.EqualFold(path.Ext(name), ".csv") {
+		return formatCSV
+	}
+	return formatNDJSON
+}
+
+// fileRows 逐行读取文件，返回行号和事件，读完时返回 io.EOF
+type fileRows func() (int, map[string]any, error)
+
+// newFileRows 按格式创建逐行读取器
+func newFileRows(format string, r io.Reader) (fileRows, error) {
+	switch format {
+	case formatNDJSON:
+		return ndjsonRows(r), nil
+	case formatCSV:
+		return csvRows(r), nil
+	}
+	return nil, fmt.Errorf("invalid format %q (ndjson or csv)", format)
+}
+
+// fileLineError 文件中某一行无效
+type fileLineError struct {
+	Line int
+	Err  error
+}
+
+func (e *fileLineError) Error() string { return fmt.Sprintf("line %d: %v", e.Line, e.Err) }
+
+func (e *fileLineError) Unwrap() error { return e.Err }
+
+// ndjsonRows 逐行解析 NDJSON，跳过空行
+func ndjsonRows(r io.Reader) fileRows {
+	br := bufio.NewReader(r)
+	line := 0
+	return func() (int, map[string]any, error) {
+		for {
+			data, err := br.ReadBytes('\n')
+			if err != nil && (len(data) == 0 || !errors.Is(err, io.EOF)) {
+				return line, nil, err
+			}
+			line++
+			if len(bytes.TrimSpace(data)) == 0 {
+				continue
+			}
+			body, decodeErr := decodeJSONObject(data)
+			if decodeErr != nil {
+				return line, nil, &fileLineError{Line: line, Err: decodeErr}
+			}
+			return line, body, nil
+		}
+	}
+}
+
+// csvRows 按表头解析 CSV，值均为字符串，由列的 type 转换；空单元格视为缺少该字段
+func csvRows(r io.Reader) fileRows {
+	cr := csv.NewReader(r)
+	var header []string
+	return func() (int, map[string]any, error) {
+		if header == nil {
+			record, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				return 0, nil, err
+			}
+			if err != nil {
+				return 1, nil, &fileLineError{Line: 1, Err: err}
+			}
+			header = record
+			header[0] = strings.TrimPrefix(header[0], "\ufeff")
+		}
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return 0, nil, err
+		}
+		if err != nil {
+			var pe *csv.ParseError
+			if !errors.As(err, &pe) {
+				return 0, nil, err
+			}
+			return pe.Line, nil, &fileLineError{Line: pe.Line, Err: pe.Err}
+		}
+		line, _ := cr.FieldPos(0)
+		body := make(map[string]any, len(header))
+		for i, v := range record {
+			if v != "" {
+				body[header[i]] = v
+			}
+		}
+		return line, body, nil
+	}
+}
+
+// countingReader 统计已读取的字节数，用于进度报告
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// chunkLoadError 分块写入 Doris 失败
+type chunkLoadError struct {
+	Chunk int
+	Err   error
+}
+
+func (e *chunkLoadError) Error() string { return fmt.Sprintf("chunk %d: %v", e.Chunk, e.Err) }
+
+func (e *chunkLoadError) Unwrap() error { return e.Err }
+
+// chunkLoader 将文件逐行转换后分块写入端点对应的表（含双写表），供 /upload 和定时补录任务共用
+// 分块的 label 为 {label}-{序号}，以相同 label 重新写入同一文件时，已提交的分块被 Doris 去重
+type chunkLoader struct {
+	app       *App
+	ep        *Endpoint
+	label     string
+	chunkRows int
+	build     func(body map[string]any) (eventRow, error)
+	onChunk   func(chunk, rows int, skipped bool) // 每个分块写入后调用，rows 为累计写入的行数，可以为 nil
+}
+
+// run 读取并写入整个文件，返回写入的行数
+// 遇到无效行（*fileLineError）或写入失败（*chunkLoadError）时停止，此前的分块已提交
+func (l *chunkLoader) run(ctx context.Context, next fileRows) (int, error) {
+	var (
+		rows, chunk int
+		lines       [][]byte
+		dualLines   = make([][][]byte, len(l.ep.DualWrite))
+	)
+	flush := func() error {
+		chunk++
+		skipped, err := l.loadChunk(ctx, fmt.Sprintf("%s-%d", l.label, chunk), lines, dualLines)
+		if err != nil {
+			return &chunkLoadError{Chunk: chunk, Err: err}
+		}
+		rows += len(lines)
+		lines = lines[:0]
+		for j := range dualLines {
+			dualLines[j] = dualLines[j][:0]
+		}
+		if l.onChunk != nil {
+			l.onChunk(chunk, rows, skipped)
+		}
+		return nil
+	}
+
+	for {
+		line, body, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return rows, err
+		}
+		ev, err := l.build(body)
+		if err != nil {
+			return rows, &fileLineError{Line: line, Err: err}
+		}
+		data, err := marshalLine(ev.Row)
+		if err != nil {
+			return rows, &fileLineError{Line: line, Err: err}
+		}
+		lines = append(lines, data)
+		for j, dualRow := range ev.Dual {
+			if data, err = marshalLine(dualRow); err != nil {
+				return rows, &fileLineError{Line: line, Err: err}
+			}
+			dualLines[j] = append(dualLines[j], data)
+		}
+		if len(lines) >= l.chunkRows {
+			if err := flush(); err != nil {
+				return rows, err
+			}
+		}
+	}
+	if len(lines) > 0 {
+		if err := flush(); err != nil {
+			return rows, err
+		}
+	}
+	return rows, nil
+}
+
+// loadChunk 写入一个分块（主表及双写表），按低优先级申请槽位，背压时等待而不是丢弃
+// 所有表的 label 均已存在时返回 skipped=true，说明该分块此前已提交
+func (l *chunkLoader) loadChunk(ctx context.Context, label string, lines [][]byte, dualLines [][][]byte) (skipped bool, err error) {
+	app := l.app
+	if app.degraded.Load() {
+		return false, errLoadDegraded
+	}
+	var release func()
+	for {
+		var ok bool
+		if release, ok = app.limiter.Acquire(ctx, PriorityLow); ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(loadRetryInterval):
+		}
+	}
+	defer release()
+
+	type target struct {
+		table *dorisload.Table
+		label string
+		lines [][]byte
+	}
+	targets := []target{{l.ep.Table(), label, lines}}
+	for j, dw := range l.ep.DualWrite {
+		targets = append(targets, target{dw.Table(), label + "-" + dw.Table().Name, dualLines[j]})
+	}
+	skipped = true
+	for _, t := range targets {
+		for _, ch := range app.clusters.For(t.table).WriteLinesSplit(ctx, t.table, t.label, t.lines, app.logger) {
+			switch {
+			case errors.Is(ch.Err, dorisload.ErrLabelAlreadyExists):
+			case ch.Err != nil:
+				return false, fmt.Errorf("table %s: %w", t.table.Name, ch.Err)
+			default:
+				skipped = false
+				app.committed(t.table, dorisload.JoinLines(t.lines[ch.Start:ch.End]))
+			}
+		}
+	}
+	return skipped, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoIP 基于 MaxMind 数据库（GeoLite2-Country / GeoIP2-Country 等）查询客户端 IP 所在国家/地区
+type GeoIP struct {
+	reader *geoip2.Reader
+}
+
+// newGeoIP 从 GEOIP_DB 打开 GeoIP 数据库，未设置时返回 nil
+// 有端点包含 geoip_country 列而未设置 GEOIP_DB 时返回错误
+func newGeoIP(registry *Registry) (*GeoIP, error) {
+	path := getEnv("GEOIP_DB", "")
+	if path == "" {
+		if registry.UsesGeoIP() {
+			return nil, fmt.Errorf("端点包含 geoip_country 列，需要设置 GEOIP_DB")
+		}
+		return nil, nil
+	}
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开 GEOIP_DB 失败: %w", err)
+	}
+	return &GeoIP{reader: reader}, nil
+}
+
+// Country 返回 IP 所在国家/地区的 ISO 3166-1 代码，无法识别时返回空串
+func (g *GeoIP) Country(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	record, err := g.reader.Country(addr)
+	if err != nil {
+		return ""
+	}
+	return record.Country.IsoCode
+}
+
+// Close 关闭数据库
+func (g *GeoIP) Close() error {
+	return g.reader.Close()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"doris-webhook/dorisload"
+)
+
+// newHedgePolicy 根据环境变量创建对冲策略，未启用 HEDGE_ENABLED 时返回 nil
+func newHedgePolicy() (*dorisload.HedgePolicy, error) {
+	if getEnv("HEDGE_ENABLED", "false") != "true" {
+		return nil, nil
+	}
+	percentile, err := strconv.ParseFloat(getEnv("HEDGE_PERCENTILE", "95"), 64)
+	if err != nil || percentile <= 0 || percentile >= 100 {
+		return nil, fmt.Errorf("HEDGE_PERCENTILE 无效: %q（应在 0 到 100 之间）", getEnv("HEDGE_PERCENTILE", ""))
+	}
+	minDelay, err := envDuration("HEDGE_MIN_DELAY_MS", "50", time.Millisecond, time.Millisecond, 0)
+	if err != nil {
+		return nil, err
+	}
+	return dorisload.NewHedgePolicy(percentile, minDelay)
+}
+
+// newHealthCheck 根据环境变量创建 BE 健康检查配置，未启用 BE_HEALTH_CHECK_ENABLED 时返回 nil
+func newHealthCheck() (*dorisload.HealthCheck, error) {
+	if getEnv("BE_HEALTH_CHECK_ENABLED", "false") != "true" {
+		return nil, nil
+	}
+	interval, err := parsePositiveSeconds("BE_HEALTH_CHECK_INTERVAL", "5")
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parsePositiveSeconds("BE_HEALTH_CHECK_TIMEOUT", "2")
+	if err != nil {
+		return nil, err
+	}
+	thresholds := map[string]int{
+		"BE_HEALTH_CHECK_FAIL_THRESHOLD":    2,
+		"BE_HEALTH_CHECK_RECOVER_THRESHOLD": 2,
+	}
+	for key, def := range thresholds {
+		v, err := strconv.Atoi(getEnv(key, strconv.Itoa(def)))
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("%s 无效: %q", key, getEnv(key, ""))
+		}
+		thresholds[key] = v
+	}
+	history, err := loadHistoryConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &dorisload.HealthCheck{
+		Interval:         interval,
+		Timeout:          timeout,
+		FailThreshold:    thresholds["BE_HEALTH_CHECK_FAIL_THRESHOLD"],
+		RecoverThreshold: thresholds["BE_HEALTH_CHECK_RECOVER_THRESHOLD"],
+		History:          history,
+	}, nil
+}
+
+// loadHistoryConfig 读取 BE 和输出目标共用的健康历史和抖动抑制配置
+func loadHistoryConfig() (dorisload.HistoryConfig, error) {
+	ints := map[string]int{
+		"HEALTH_HISTORY_SIZE":        20,
+		"HEALTH_FLAP_WINDOW_SECONDS": 300,
+		"HEALTH_FLAP_THRESHOLD":      4,
+	}
+	for key, def := range ints {
+		v, err := strconv.Atoi(getEnv(key, strconv.Itoa(def)))
+		if err != nil || v < 0 {
+			return dorisload.HistoryConfig{}, fmt.Errorf("%s 无效: %q", key, getEnv(key, ""))
+		}
+		ints[key] = v
+	}
+	if ints["HEALTH_FLAP_THRESHOLD"] > 0 && ints["HEALTH_FLAP_WINDOW_SECONDS"] == 0 {
+		return dorisload.HistoryConfig{}, fmt.Errorf("HEALTH_FLAP_WINDOW_SECONDS 必须大于 0")
+	}
+	return dorisload.HistoryConfig{
+		Size:          ints["HEALTH_HISTORY_SIZE"],
+		FlapWindow:    time.Duration(ints["HEALTH_FLAP_WINDOW_SECONDS"]) * time.Second,
+		FlapThreshold: ints["HEALTH_FLAP_THRESHOLD"],
+	}, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 端点单条写入接受的输入
+const (
+	inputJSON  = "json"  // JSON 对象请求体（默认）
+	inputForm  = "form"  // application/x-www-form-urlencoded 请求体
+	inputQuery = "query" // 查询参数，同时接受 GET 请求
+)
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// accepts 判断端点是否接受指定输入
+func (ep *Endpoint) accepts(input string) bool {
+	return slices.Contains(ep.Inputs, input)
+}
+
+// decodeEvent 按端点接受的输入解析单条事件
+// 表单和查询参数的值均为字符串，由列的 type 转换；同一键出现多次时取第一个值，请求体中的字段优先于查询参数。
+// 返回的 forward 为可原样转发给 http 输出目标的 JSON 请求体，事件来自表单或查询参数时为 nil
+func decodeEvent(c *gin.Context, ep *Endpoint, raw []byte) (body map[string]any, forward []byte, err error) {
+	switch {
+	case ep.accepts(inputForm) && c.ContentType() == formContentType:
+		values, err := url.ParseQuery(string(raw))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid form body: %w", err)
+		}
+		body = make(map[string]any, len(values))
+		addValues(body, values)
+	case ep.accepts(inputQuery) && len(bytes.TrimSpace(raw)) == 0:
+		body = make(map[string]any)
+	case ep.accepts(inputJSON):
+		if body, err = decodeJSONObject(raw); err != nil {
+			return nil, nil, err
+		}
+		forward = raw
+	default:
+		return nil, nil, fmt.Errorf("unsupported content type %q", c.ContentType())
+	}
+
+	if ep.accepts(inputQuery) {
+		if query := c.Request.URL.Query(); addValues(body, query) {
+			forward = nil
+		}
+	}
+	return body, forward, nil
+}
+
+// addValues 将表单或查询参数中 body 尚未包含的键加入 body，返回是否加入了新的键
+func addValues(body map[string]any, values url.Values) bool {
+	added := false
+	for k, v := range values {
+		if _, ok := body[k]; ok || len(v) == 0 {
+			continue
+		}
+		body[k] = v[0]
+		added = true
+	}
+	return added
+}
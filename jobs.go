@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJobMaxAge    = 48 * time.Hour
+	defaultJobChunkRows = 10000
+	// jobSettleTime 最近修改的文件可能仍在写入，留到下次运行再处理，避免同一文件的不完整版本和完整版本都被写入
+	jobSettleTime = time.Minute
+)
+
+// JobConfig 定时补录任务：按 cron 表达式定期从本地目录或 S3 前缀拉取文件，按端点的列映射写入 Doris
+// 每个文件的分块 label 由文件名和版本（ETag 或修改时间、大小）派生，重复拉取同一文件时已提交的分块被 Doris 去重
+type JobConfig struct {
+	Name      string `yaml:"name" json:"name"`
+	Schedule  string `yaml:"schedule" json:"schedule"` // cron 表达式（分 时 日 月 周）或 @hourly/@daily/@weekly/@monthly
+	Endpoint  string `yaml:"endpoint" json:"endpoint"` // 按该端点的列映射校验和转换，写入其目标表（含双写表）
+	Dir       string `yaml:"dir,omitempty" json:"dir,omitempty"`
+	S3        *JobS3 `yaml:"s3,omitempty" json:"s3,omitempty"`
+	Pattern   string `yaml:"pattern,omitempty" json:"pattern,omitempty"`       // 文件名通配符，如 *.csv，默认处理所有文件
+	Format    string `yaml:"format,omitempty" json:"format,omitempty"`         // ndjson 或 csv，默认按扩展名判断
+	MaxAge    string `yaml:"max_age,omitempty" json:"max_age,omitempty"`       // 只处理该时间内修改的文件，默认 48h
+	ChunkRows int    `yaml:"chunk_rows,omitempty" json:"chunk_rows,omitempty"` // 每次 Stream Load 的行数，默认 10000
+}
+
+// JobS3 任务的 S3 数据源，凭证从环境变量读取
+type JobS3 struct {
+	Endpoint     string `yaml:"endpoint" json:"endpoint"`
+	Region       string `yaml:"region,omitempty" json:"region,omitempty"`
+	Bucket       string `yaml:"bucket" json:"bucket"`
+	Prefix       string `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	AccessKeyEnv string `yaml:"access_key_env" json:"access_key_env"`
+	SecretKeyEnv string `yaml:"secret_key_env" json:"secret_key_env"`
+}
+
+// sourceFile 数据源中的一个文件
+type sourceFile struct {
+	Name    string // 本地文件的路径或 S3 对象的 key
+	ModTime time.Time
+	Size    int64
+	Version string // S3 对象的 ETag，本地文件为空
+}
+
+// fileSource 任务的数据源
+type fileSource interface {
+	List(ctx context.Context) ([]sourceFile, error)
+	Open(ctx context.Context, f sourceFile) (io.ReadCloser, error)
+}
+
+// JobScheduler 定时补录任务调度器
+// 多副本部署时只应在一个副本上启用（JOBS_ENABLED），其他副本重复拉取的文件在 Doris label 保留期内会被去重
+type JobScheduler struct {
+	jobs   []*job
+	logger *slog.Logger
+}
+
+// job 一个定时任务及其运行状态
+type job struct {
+	config    *JobConfig
+	schedule  *cronSchedule
+	endpoint  *Endpoint
+	source    fileSource
+	maxAge    time.Duration
+	chunkRows int
+
+	mu     sync.Mutex
+	status JobStatus
+}
+
+// JobStatus 任务的运行状态，通过 /admin/stats 查看
+type JobStatus struct {
+	Schedule     string `json:"schedule"`
+	NextRun      string `json:"next_run,omitempty"` // RFC 3339
+	LastRun      string `json:"last_run,omitempty"`
+	LastDuration int64  `json:"last_duration_ms"`
+	LastFiles    int    `json:"last_files"`  // 上次运行处理的文件数（含此前已提交而被跳过的文件）
+	LastRows     int    `json:"last_rows"`   // 上次运行写入的行数
+	LastFailed   int    `json:"last_failed"` // 上次运行失败的文件数，下次运行时重试
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// newJobScheduler 校验任务配置并创建调度器，未配置任务或 JOBS_ENABLED=false 时返回 nil
+func newJobScheduler(registry *Registry, logger *slog.Logger) (*JobScheduler, error) {
+	if len(registry.Jobs) == 0 {
+		return nil, nil
+	}
+	if getEnv("JOBS_ENABLED", "true") != "true" {
+		logger.Info("定时补录任务已禁用（JOBS_ENABLED=false）", "jobs", len(registry.Jobs))
+		return nil, nil
+	}
+
+	s := &JobScheduler{logger: logger}
+	names := make(map[string]bool)
+	for i, jc := range registry.Jobs {
+		if !labelPartPattern.MatchString(jc.Name) {
+			return nil, fmt.Errorf("jobs[%d]: name 无效: %q（只允许字母、数字、- 和 _，最长 64 个字符）", i, jc.Name)
+		}
+		if names[jc.Name] {
+			return nil, fmt.Errorf("jobs[%d]: 任务名称重复: %s", i, jc.Name)
+		}
+		names[jc.Name] = true
+		j, err := newJob(registry, jc)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", jc.Name, err)
+		}
+		s.jobs = append(s.jobs, j)
+	}
+	return s, nil
+}
+
+// newJob 校验单个任务的配置
+func newJob(registry *Registry, jc *JobConfig) (*job, error) {
+	schedule, err := parseCron(jc.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	j := &job{config: jc, schedule: schedule, maxAge: defaultJobMaxAge, chunkRows: defaultJobChunkRows}
+	j.status.Schedule = jc.Schedule
+
+	for _, ep := range registry.Endpoints {
+		if ep.Name == jc.Endpoint {
+			j.endpoint = ep
+		}
+	}
+	if j.endpoint == nil {
+		return nil, fmt.Errorf("endpoint 不存在: %q", jc.Endpoint)
+	}
+	if !j.endpoint.WritesDoris() {
+		return nil, fmt.Errorf("endpoint %s 不写入 Doris", jc.Endpoint)
+	}
+	if j.endpoint.Rollup != nil {
+		return nil, fmt.Errorf("endpoint %s 配置了 rollup，不能补录", jc.Endpoint)
+	}
+
+	switch {
+	case jc.Dir != "" && jc.S3 != nil:
+		return nil, fmt.Errorf("dir 和 s3 只能设置其一")
+	case jc.Dir != "":
+		j.source = &dirSource{dir: jc.Dir}
+	case jc.S3 != nil:
+		if j.source, err = newS3Source(jc.S3); err != nil {
+			return nil, fmt.Errorf("s3: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("需要设置 dir 或 s3")
+	}
+
+	if _, err := path.Match(jc.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("pattern 无效: %q", jc.Pattern)
+	}
+	switch jc.Format {
+	case "", formatNDJSON, formatCSV:
+	default:
+		return nil, fmt.Errorf("format 无效: %q（可选 ndjson、csv）", jc.Format)
+	}
+	if jc.MaxAge != "" {
+		if j.maxAge, err = time.ParseDuration(jc.MaxAge); err != nil || j.maxAge <= 0 {
+			return nil, fmt.Errorf("max_age 无效: %q", jc.MaxAge)
+		}
+	}
+	if jc.ChunkRows < 0 {
+		return nil, fmt.Errorf("chunk_rows 不能为负数")
+	}
+	if jc.ChunkRows > 0 {
+		j.chunkRows = jc.ChunkRows
+	}
+	return j, nil
+}
+
+// Run 按调度运行所有任务，直到 ctx 取消；进行中的文件在取消后停止写入，下次启动后重新拉取
+func (s *JobScheduler) Run(ctx context.Context, app *App) {
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.loop(ctx, app, s.logger.With("job", j.config.Name))
+		}()
+	}
+	wg.Wait()
+}
+
+// Stats 返回各任务的运行状态
+func (s *JobScheduler) Stats() map[string]JobStatus {
+	stats := make(map[string]JobStatus, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		stats[j.config.Name] = j.status
+		j.mu.Unlock()
+	}
+	return stats
+}
+
+// loop 等待下一个调度时间并运行任务；运行时间超过调度间隔时，错过的调度被跳过
+func (j *job) loop(ctx context.Context, app *App, logger *slog.Logger) {
+	for {
+		next := j.schedule.Next(time.Now())
+		if next.IsZero() {
+			logger.Warn("cron 表达式没有可运行的时间，任务停止", "schedule", j.config.Schedule)
+			return
+		}
+		j.mu.Lock()
+		j.status.NextRun = next.Format(time.RFC3339)
+		j.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		j.run(ctx, app, logger)
+	}
+}
+
+// run 运行一次任务：写入数据源中 max_age 内修改的文件，单个文件失败不影响其他文件
+func (j *job) run(ctx context.Context, app *App, logger *slog.Logger) {
+	start := time.Now()
+	status := JobStatus{Schedule: j.config.Schedule, LastRun: start.Format(time.RFC3339)}
+	defer func() {
+		status.LastDuration = time.Since(start).Milliseconds()
+		j.mu.Lock()
+		status.NextRun = j.status.NextRun
+		j.status = status
+		j.mu.Unlock()
+		logger.Info("定时补录任务完成", "files", status.LastFiles, "rows", status.LastRows, "failed", status.LastFailed, "duration_ms", status.LastDuration)
+	}()
+
+	files, err := j.source.List(ctx)
+	if err != nil {
+		logger.Error("列出数据源文件失败", "error", err)
+		status.LastError = err.Error()
+		return
+	}
+	cutoff, settled := start.Add(-j.maxAge), start.Add(-jobSettleTime)
+	files = slices.DeleteFunc(files, func(f sourceFile) bool {
+		if f.ModTime.Before(cutoff) || f.ModTime.After(settled) {
+			return true
+		}
+		matched, _ := path.Match(j.config.Pattern, path.Base(filepath.ToSlash(f.Name)))
+		return j.config.Pattern != "" && !matched
+	})
+	slices.SortFunc(files, func(a, b sourceFile) int { return strings.Compare(a.Name, b.Name) })
+
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		rows, err := j.loadFile(ctx, app, f)
+		status.LastFiles++
+		status.LastRows += rows
+		if err != nil {
+			logger.Error("补录文件失败，下次运行时重试", "file", f.Name, "rows", rows, "error", err)
+			status.LastFailed++
+			status.LastError = fmt.Sprintf("%s: %v", f.Name, err)
+			continue
+		}
+		logger.Info("补录文件完成", "file", f.Name, "rows", rows)
+	}
+}
+
+// loadFile 写入一个文件，返回写入的行数
+// label 由任务名称、文件名和版本派生，文件内容变化（修改时间、大小或 ETag 变化）后视为新文件重新写入
+func (j *job) loadFile(ctx context.Context, app *App, f sourceFile) (int, error) {
+	version := f.Version
+	if version == "" {
+		version = fmt.Sprintf("%d-%d", f.ModTime.UnixNano(), f.Size)
+	}
+	label := fmt.Sprintf("job-%s-%s", j.config.Name, sha256Hex([]byte(f.Name + "\x00" + version))[:16])
+
+	rc, err := j.source.Open(ctx, f)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	next, err := newFileRows(fileFormat(f.Name, j.config.Format), rc)
+	if err != nil {
+		return 0, err
+	}
+	loader := &chunkLoader{
+		app:       app,
+		ep:        j.endpoint,
+		label:     label,
+		chunkRows: j.chunkRows,
+		build: func(body map[string]any) (eventRow, error) {
+			return convertEvent(j.endpoint, body, RowContext{Now: time.Now()})
+		},
+	}
+	return loader.run(withLoadSource(ctx, loadSource{Principal: "job:" + j.config.Name, Endpoint: j.endpoint.Name}), next)
+}
+
+// dirSource 本地目录数据源，只处理目录下的普通文件（不递归）
+type dirSource struct {
+	dir string
+}
+
+func (d *dirSource) List(ctx context.Context) ([]sourceFile, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []sourceFile
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		files = append(files, sourceFile{Name: filepath.Join(d.dir, e.Name()), ModTime: info.ModTime(), Size: info.Size()})
+	}
+	return files, nil
+}
+
+func (d *dirSource) Open(ctx context.Context, f sourceFile) (io.ReadCloser, error) {
+	return os.Open(f.Name)
+}
+
+// s3Source S3 兼容对象存储数据源（path-style），列出前缀下的所有对象
+type s3Source struct {
+	endpoint *url.URL
+	signer   s3Signer
+	bucket   string
+	prefix   string
+	client   *http.Client
+}
+
+// newS3Source 创建 S3 数据源，凭证从 access_key_env/secret_key_env 指定的环境变量读取
+func newS3Source(c *JobS3) (*s3Source, error) {
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("endpoint 无效: %q", c.Endpoint)
+	}
+	if c.Bucket == "" || c.AccessKeyEnv == "" || c.SecretKeyEnv == "" {
+		return nil, fmt.Errorf("需要设置 endpoint、bucket、access_key_env 和 secret_key_env")
+	}
+	accessKey, secretKey := os.Getenv(c.AccessKeyEnv), os.Getenv(c.SecretKeyEnv)
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("环境变量 %s/%s 未设置", c.AccessKeyEnv, c.SecretKeyEnv)
+	}
+	return &s3Source{
+		endpoint: endpoint,
+		signer:   s3Signer{region: defaultString(c.Region, "us-east-1"), accessKey: accessKey, secretKey: secretKey},
+		bucket:   c.Bucket,
+		prefix:   strings.TrimPrefix(c.Prefix, "/"),
+		// 对象按流式读取写入 Doris，不设置整体超时，由调用方的 ctx 控制
+		client: &http.Client{},
+	}, nil
+}
+
+// listBucketResult ListObjectsV2 的响应
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3Source) List(ctx context.Context) ([]sourceFile, error) {
+	var files []sourceFile
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u := *s.endpoint
+		u.Path = "/" + s.bucket
+		u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+		resp, err := s.do(ctx, &u)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("解析 ListObjectsV2 响应失败: %w", err)
+		}
+		for _, obj := range result.Contents {
+			if strings.HasSuffix(obj.Key, "/") {
+				continue
+			}
+			files = append(files, sourceFile{Name: obj.Key, ModTime: obj.LastModified, Size: obj.Size, Version: strings.Trim(obj.ETag, `"`)})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Source) Open(ctx context.Context, f sourceFile) (io.ReadCloser, error) {
+	u := *s.endpoint
+	u.Path = "/" + s.bucket + "/" + f.Name
+	u.RawPath = "/" + s.bucket + "/" + s3EscapePath(f.Name)
+	resp, err := s.do(ctx, &u)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// do 发送签名的 GET 请求，非 200 响应返回错误
+func (s *s3Source) do(ctx context.Context, u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.signer.sign(req, nil, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 返回错误 [%d]: %s", resp.StatusCode, string(body))
+	}
+	return resp, nil
+}
+
+// s3EscapePath 按 SigV4 的规则转义对象 key：除未保留字符和 / 外全部百分号编码
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
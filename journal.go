@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"doris-webhook/dorisload"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed" // 重复请求的响应带有该响应头
+	idempotencyKeyCtx        = "idempotency_key"     // gin 上下文中请求的幂等键（{端点名}/{键}），由 journalAppend 写入标记行
+	journalKeysFile          = "idempotency.log"
+	journalMaxKeyLen         = 256
+	journalCompactMinRecords = 1024 // 文件中的记录数超过有效键数两倍且不少于该值时重写文件
+	journalPruneInterval     = time.Minute
+)
+
+// journalMarkerPrefix 幂等键标记行的前缀，完整格式为 {"__idempotency_key":"{端点名}/{键}","at":{接收时间（纳秒）}}
+// 列名以字母开头，事件行不会以该前缀开头
+const journalMarkerPrefix = `{"__idempotency_key":`
+
+// Acquire 的结果
+type journalKeyState int
+
+const (
+	journalKeyAcquired journalKeyState = iota // 键未接收过，已标记为处理中
+	journalKeySeen                            // 键已在 JOURNAL_KEY_TTL 内接收过
+	journalKeyPending                         // 另一个请求正在以该键写入
+)
+
+// Journal 请求日志：设置了 journal 的端点在返回 202 之前将事件同步写入 WAL（fsync），由 WAL 回放写入 Doris；
+// 回放分片的 label 由段名和偏移派生，进程崩溃后重放由 Doris 去重。
+// 请求带 Idempotency-Key 时，键在写入之前标记为处理中（Acquire），同一个键的并发请求只有一个写入；
+// 键的标记行与事件在同一次追加中写入 WAL（回放时跳过），写入完成后键持久化到 WAL 目录的 idempotency.log。
+// 进程在写入 WAL 之后、记录键之前崩溃时，重启后从 WAL 段中的标记行恢复键。JOURNAL_KEY_TTL 内以相同键重试的请求直接返回 202，不会再次写入
+type Journal struct {
+	path   string
+	ttl    time.Duration
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	file    *os.File
+	keys    map[string]time.Time // 按 {端点名}/{键} 索引的接收时间
+	pending map[string]bool      // 正在写入的请求的键
+	records int                  // 文件中的记录数（含过期和重复的记录）
+	pruned  time.Time
+
+	// 平滑升级期间新旧进程共用 idempotency.log：不重写文件，并读取另一进程追加的记录
+	shared bool
+	parent int   // 新进程中为旧进程的 PID，旧进程退出后结束共用
+	offset int64 // 已读取的文件长度
+}
+
+// newJournal 没有端点设置 journal 时返回 nil，设置了 journal 的端点需要 WAL
+func newJournal(registry *Registry, wal *WAL, logger *slog.Logger) (*Journal, error) {
+	ttl, err := loadJournalConfig(registry, wal)
+	if ttl == 0 || err != nil {
+		return nil, err
+	}
+	j := &Journal{path: filepath.Join(wal.dir, journalKeysFile), ttl: ttl, logger: logger}
+	if err := j.open(wal, time.Now()); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// loadJournalConfig 校验请求日志配置并返回幂等键的保留时间，不访问 WAL 目录；没有端点设置 journal 时返回 0
+func loadJournalConfig(registry *Registry, wal *WAL) (time.Duration, error) {
+	var names []string
+	for _, ep := range registry.Endpoints {
+		if ep.Journal {
+			names = append(names, ep.Name)
+		}
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	if wal == nil {
+		return 0, fmt.Errorf("端点 %s 设置了 journal，需要设置 WAL_DIR", strings.Join(names, ", "))
+	}
+	if wal.dir == "" {
+		// 对象存储中的段封存之前不可见，无法在返回 202 之前持久化
+		return 0, fmt.Errorf("端点 %s 设置了 journal，需要本地 WAL（WAL_DIR），不支持 WAL_S3_BUCKET", strings.Join(names, ", "))
+	}
+	return envDuration("JOURNAL_KEY_TTL", "24h", time.Second, time.Second, 0)
+}
+
+// open 读取上次运行记录的幂等键和 WAL 段中未记录的键，丢弃过期的键后重写文件
+// 平滑升级时旧进程仍在追加该文件，只读取记录、不重写，旧进程退出后再重写
+func (j *Journal) open(w *WAL, now time.Time) error {
+	j.keys = make(map[string]time.Time)
+	j.pending = make(map[string]bool)
+	data, err := os.ReadFile(j.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("读取幂等键失败: %w", err)
+	}
+	n := j.readRecords(data, now)
+	j.pruned = now
+	if pid := upgradeParentPID(); pid != 0 {
+		// 旧进程正在写入的段由其自行封存和回放，键由旧进程记录
+		j.shared, j.parent, j.offset = true, pid, int64(n)
+		return j.openAppend()
+	}
+	if n := j.recoverKeys(w, now); n > 0 {
+		j.logger.Warn("从 WAL 段恢复了未记录的幂等键（上次运行在写入事件后、记录键之前退出）", "keys", n)
+	}
+	return j.compactLocked()
+}
+
+// readRecords 读取幂等键记录，返回已读取的完整行的字节数
+// 每行为 {接收时间（纳秒）} {端点名}/{键}；最后一行不完整（崩溃时写了一半，或另一进程正在追加）时不读取，无法解析的行直接丢弃
+func (j *Journal) readRecords(data []byte, now time.Time) int {
+	n := 0
+	for {
+		i := bytes.IndexByte(data[n:], '\n')
+		if i < 0 {
+			return n
+		}
+		line := string(data[n : n+i])
+		n += i + 1
+		ts, key, ok := strings.Cut(line, " ")
+		nanos, err := strconv.ParseInt(ts, 10, 64)
+		if !ok || err != nil || key == "" {
+			continue
+		}
+		j.observe(key, time.Unix(0, nanos), now)
+	}
+}
+
+// observe 记录键的接收时间，忽略已过期的键
+func (j *Journal) observe(key string, at, now time.Time) {
+	if now.Sub(at) < j.ttl && at.After(j.keys[key]) {
+		j.keys[key] = at
+	}
+}
+
+// recoverKeys 从 WAL 段的标记行恢复未记录的键，返回恢复的键数；加密的段按行解密，缺少密钥的行跳过
+func (j *Journal) recoverKeys(w *WAL, now time.Time) int {
+	segments, err := w.sealedSegments()
+	if err != nil {
+		j.logger.Warn("读取 WAL 段失败，无法恢复未记录的幂等键", "error", err)
+		return 0
+	}
+	recovered := 0
+	for _, seg := range segments {
+		data, err := w.store.read(seg.name + walSealedSuffix)
+		if err != nil {
+			j.logger.Warn("读取 WAL 段失败，无法恢复未记录的幂等键", "segment", seg.name, "error", err)
+			continue
+		}
+		if !bytes.Contains(data, []byte(journalMarkerPrefix)) && !bytes.Contains(data, []byte(encryptedLinePrefix)) {
+			continue
+		}
+		for _, line := range dorisload.SplitNDJSON(data) {
+			line = bytes.TrimSuffix(line, []byte{'\n'})
+			if bytes.HasPrefix(line, []byte(encryptedLinePrefix)) {
+				if line, err = w.keys.decryptLine(line); err != nil {
+					continue
+				}
+			}
+			key, at, ok := parseJournalMarker(line)
+			if !ok {
+				continue
+			}
+			if _, seen := j.keys[key]; !seen && now.Sub(at) < j.ttl {
+				recovered++
+			}
+			j.observe(key, at, now)
+		}
+	}
+	return recovered
+}
+
+// compactLocked 只保留有效的键重写文件，并重新打开用于追加，调用方需持有锁（或尚未并发使用）
+func (j *Journal) compactLocked() error {
+	var buf bytes.Buffer
+	for key, at := range j.keys {
+		buf.Write(journalRecord(key, at))
+	}
+	if err := writeFileAtomic(j.path, buf.Bytes()); err != nil {
+		return fmt.Errorf("重写幂等键文件失败: %w", err)
+	}
+	if err := j.openAppend(); err != nil {
+		return err
+	}
+	j.records, j.offset = len(j.keys), int64(buf.Len())
+	return nil
+}
+
+// openAppend 打开幂等键文件用于追加，替换当前打开的文件
+func (j *Journal) openAppend() error {
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("打开幂等键文件失败: %w", err)
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = f
+	return nil
+}
+
+// refreshLocked 共用文件时读取另一进程追加的记录（含本进程追加的，重复读取没有影响），调用方需持有锁
+func (j *Journal) refreshLocked(now time.Time) {
+	f, err := os.Open(j.path)
+	if err != nil {
+		j.logger.Warn("读取幂等键文件失败", "error", err)
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.NewSectionReader(f, j.offset, 1<<62))
+	if err != nil {
+		j.logger.Warn("读取幂等键文件失败", "error", err)
+		return
+	}
+	j.offset += int64(j.readRecords(data, now))
+}
+
+// Share 平滑升级开始前由旧进程调用：新进程就绪前后两个进程同时接收请求，此后不再重写文件，并读取新进程追加的记录
+func (j *Journal) Share() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if info, err := j.file.Stat(); err == nil {
+		j.offset = info.Size()
+	}
+	j.shared = true
+}
+
+// Unshare 平滑升级失败（新进程已退出）时由旧进程调用，恢复定期重写文件
+func (j *Journal) Unshare() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.shared = false
+}
+
+func journalRecord(key string, at time.Time) []byte {
+	return fmt.Appendf(nil, "%d %s\n", at.UnixNano(), key)
+}
+
+// journalMarker 返回写入 WAL 的幂等键标记行
+func journalMarker(key string, at time.Time) []byte {
+	quoted, _ := json.Marshal(key)
+	return fmt.Appendf(nil, "%s%s,\"at\":%d}\n", journalMarkerPrefix, quoted, at.UnixNano())
+}
+
+// isJournalMarker 判断 WAL 中的一行（已解密）是否为幂等键标记行
+func isJournalMarker(line []byte) bool {
+	return bytes.HasPrefix(line, []byte(journalMarkerPrefix))
+}
+
+// parseJournalMarker 解析幂等键标记行，不是标记行时返回 false
+func parseJournalMarker(line []byte) (string, time.Time, bool) {
+	if !isJournalMarker(line) {
+		return "", time.Time{}, false
+	}
+	var m struct {
+		Key string `json:"__idempotency_key"`
+		At  int64  `json:"at"`
+	}
+	if err := json.Unmarshal(line, &m); err != nil || m.Key == "" {
+		return "", time.Time{}, false
+	}
+	return m.Key, time.Unix(0, m.At), true
+}
+
+// Acquire 以端点的幂等键开始处理请求：检查和标记在同一把锁内完成，同一个键的并发请求只有一个返回 journalKeyAcquired
+// 返回 journalKeyAcquired 后，调用方写入完成时调用 Record，未写入时调用 Release
+func (j *Journal) Acquire(ep *Endpoint, key string, now time.Time) journalKeyState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.shared {
+		j.refreshLocked(now)
+	}
+	full := ep.Name + "/" + key
+	if at, ok := j.keys[full]; ok && now.Sub(at) < j.ttl {
+		return journalKeySeen
+	}
+	if j.pending[full] {
+		return journalKeyPending
+	}
+	j.pending[full] = true
+	return journalKeyAcquired
+}
+
+// Release 请求未写入时释放 Acquire 标记的键，以相同键重试的请求重新写入
+func (j *Journal) Release(ep *Endpoint, key string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.pending, ep.Name+"/"+key)
+}
+
+// Record 持久化端点的幂等键（fsync 后返回）并结束处理中的标记，同时定期清理过期的键
+// 写入文件失败时键仍视为已接收：标记行已随事件写入 WAL，重启后可以恢复
+func (j *Journal) Record(ep *Endpoint, key string, now time.Time) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	full := ep.Name + "/" + key
+	delete(j.pending, full)
+	j.keys[full] = now
+	defer j.pruneLocked(now)
+
+	if _, err := j.file.Write(journalRecord(full, now)); err != nil {
+		return fmt.Errorf("写入幂等键失败: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("同步幂等键失败: %w", err)
+	}
+	j.records++
+	return nil
+}
+
+// pruneLocked 定期清理过期的键，记录数过多时重写文件；共用文件期间不重写，调用方需持有锁
+func (j *Journal) pruneLocked(now time.Time) {
+	if now.Sub(j.pruned) < journalPruneInterval {
+		return
+	}
+	j.pruned = now
+	for k, at := range j.keys {
+		if now.Sub(at) >= j.ttl {
+			delete(j.keys, k)
+		}
+	}
+	if j.shared && j.parent != 0 && syscall.Kill(j.parent, 0) != nil {
+		// 旧进程已退出，读取其最后追加的记录后结束共用
+		j.refreshLocked(now)
+		j.shared, j.parent = false, 0
+		j.logger.Info("平滑升级的旧进程已退出，幂等键文件恢复定期重写")
+	}
+	if !j.shared && j.records >= journalCompactMinRecords && j.records > 2*len(j.keys) {
+		if err := j.compactLocked(); err != nil {
+			// 键已写入旧文件，下次清理时再重写
+			j.logger.Warn("重写幂等键文件失败", "error", err)
+		}
+	}
+}
+
+// Keys 返回有效的幂等键数量
+func (j *Journal) Keys() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.keys)
+}
+
+// Close 关闭幂等键文件
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// journalAppend 将请求日志端点的事件同步写入 WAL：请求带幂等键时，键的标记行随事件在同一次追加中写入（位于事件之后），
+// 事件持久化时键同样持久化，进程在记录键之前崩溃时重启后可以从 WAL 恢复
+func (app *App) journalAppend(c *gin.Context, table *dorisload.Table, data []byte) error {
+	if key := c.GetString(idempotencyKeyCtx); key != "" {
+		data = append(slices.Clip(data), journalMarker(key, time.Now())...)
+	}
+	return app.wal.AppendSync(table.Name, data)
+}
+
+// idempotencyKey 返回请求的幂等键，超过长度上限时写出 400 响应并返回 false
+func idempotencyKey(c *gin.Context) (string, bool) {
+	key := strings.TrimSpace(c.GetHeader(idempotencyKeyHeader))
+	if len(key) > journalMaxKeyLen {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("%s too long (max %d)", idempotencyKeyHeader, journalMaxKeyLen), nil)
+		return "", false
+	}
+	return key, true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournalAcquire(t *testing.T) {
+	dir := t.TempDir()
+	j := &Journal{path: filepath.Join(dir, journalKeysFile), ttl: time.Hour, logger: discardLogger}
+	w := &WAL{store: walDirStore{dir: dir}, dir: dir, logger: discardLogger, retries: make(map[string]*walRetry)}
+	if err := j.open(w, time.Now()); err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	defer j.Close()
+	ep := &Endpoint{Name: "events"}
+	now := time.Now()
+
+	if got := j.Acquire(ep, "k1", now); got != journalKeyAcquired {
+		t.Fatalf("first Acquire() = %v, want acquired", got)
+	}
+	if got := j.Acquire(ep, "k1", now); got != journalKeyPending {
+		t.Errorf("concurrent Acquire() = %v, want pending", got)
+	}
+	if got := j.Acquire(&Endpoint{Name: "other"}, "k1", now); got != journalKeyAcquired {
+		t.Errorf("Acquire() on another endpoint = %v, want acquired", got)
+	}
+	j.Release(ep, "k1")
+	if got := j.Acquire(ep, "k1", now); got != journalKeyAcquired {
+		t.Fatalf("Acquire() after Release = %v, want acquired", got)
+	}
+	if err := j.Record(ep, "k1", now); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if got := j.Acquire(ep, "k1", now); got != journalKeySeen {
+		t.Errorf("Acquire() after Record = %v, want seen", got)
+	}
+	if got := j.Acquire(ep, "k1", now.Add(2*time.Hour)); got != journalKeyAcquired {
+		t.Errorf("Acquire() after ttl = %v, want acquired", got)
+	}
+}
+
+func TestJournalRecoverKeys(t *testing.T) {
+	now := time.Now()
+	keys := mustKeyring(t, testKey("k1", 1))
+	tests := []struct {
+		name string
+		keys *Keyring
+		data string
+		want []string // 恢复的键
+	}{
+		{name: "no markers", data: "{\"a\":1}\n", want: nil},
+		{
+			name: "marker after events",
+			data: "{\"a\":1}\n" + string(journalMarker("events/k1", now)) + "{\"b\":2}\n" + string(journalMarker("events/k2", now)),
+			want: []string{"events/k1", "events/k2"},
+		},
+		{name: "expired marker", data: string(journalMarker("events/k1", now.Add(-2*time.Hour))), want: nil},
+		{
+			name: "encrypted",
+			keys: keys,
+			data: string(keys.EncryptLines(append([]byte("{\"a\":1}\n"), journalMarker("events/k1", now)...))),
+			want: []string{"events/k1"},
+		},
+		{name: "missing key", data: string(keys.EncryptLines(journalMarker("events/k1", now))), want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := &WAL{store: walDirStore{dir: dir}, dir: dir, keys: tt.keys, logger: discardLogger, retries: make(map[string]*walRetry)}
+			writeTestFile(t, filepath.Join(dir, "events-1700000000000000000"+walSealedSuffix), tt.data)
+			j := &Journal{path: filepath.Join(dir, journalKeysFile), ttl: time.Hour, logger: discardLogger}
+			if err := j.open(w, now); err != nil {
+				t.Fatalf("open() error = %v", err)
+			}
+			defer j.Close()
+			if got := j.Keys(); got != len(tt.want) {
+				t.Errorf("Keys() = %d, want %d", got, len(tt.want))
+			}
+			for _, key := range tt.want {
+				if _, ok := j.keys[key]; !ok {
+					t.Errorf("key %q not recovered", key)
+				}
+			}
+		})
+	}
+}
+
+func TestJournalMarker(t *testing.T) {
+	at := time.Unix(0, 1700000000123456789)
+	line := journalMarker(`events/a"b`, at)
+	key, got, ok := parseJournalMarker(line)
+	if !ok || key != `events/a"b` || !got.Equal(at) {
+		t.Errorf("parseJournalMarker(%q) = %q, %v, %v", line, key, got, ok)
+	}
+	for _, line := range []string{"{\"a\":1}\n", "{\"__idempotency_key\":1}\n", ""} {
+		if _, _, ok := parseJournalMarker([]byte(line)); ok {
+			t.Errorf("parseJournalMarker(%q) ok = true, want false", line)
+		}
+	}
+}
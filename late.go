@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"doris-webhook/dorisload"
+)
+
+// LateConfig 端点的迟到事件路由：事件时间早于接收时间减去 watermark 的事件写入单独的修正表，
+// 热表只接收近期分区的数据，分区合并的开销可预期
+type LateConfig struct {
+	Column    string `yaml:"column" json:"column"`       // 事件时间列，必须是请求体或 original_timestamp 的 datetime 列
+	Watermark string `yaml:"watermark" json:"watermark"` // 如 1h，事件时间早于接收时间减去该值时为迟到事件
+	TableName string `yaml:"table" json:"table"`         // 修正表，列与端点的目标表相同
+
+	watermark time.Duration
+	table     *dorisload.Table
+	routed    atomic.Int64 // 写入修正表的事件数
+}
+
+// validate 校验迟到事件配置
+func (lc *LateConfig) validate(ep *Endpoint) error {
+	var col *ColumnMapping
+	for i := range ep.Columns {
+		if ep.Columns[i].Column == lc.Column {
+			col = &ep.Columns[i]
+		}
+	}
+	if col == nil || col.Type != typeDatetime || (col.Source != sourceBody && col.Source != sourceOriginalTimestamp) {
+		return fmt.Errorf("late.column 必须是请求体的 datetime 列: %q", lc.Column)
+	}
+	d, err := time.ParseDuration(lc.Watermark)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("late.watermark 无效: %q", lc.Watermark)
+	}
+	lc.watermark = d
+	if lc.TableName == "" || lc.TableName == ep.TableName {
+		return fmt.Errorf("late.table 必须设置且不能与 table 相同")
+	}
+	return nil
+}
+
+// Table 返回修正表
+func (lc *LateConfig) Table() *dorisload.Table {
+	return lc.table
+}
+
+// Routed 返回启动以来写入修正表的事件数
+func (lc *LateConfig) Routed() int64 {
+	return lc.routed.Load()
+}
+
+// isLate 判断行的事件时间是否早于 now 减去 watermark；事件时间缺失时不是迟到事件
+func (lc *LateConfig) isLate(row map[string]any, now time.Time) bool {
+	s, ok := row[lc.Column].(string)
+	if !ok {
+		return false
+	}
+	t, err := time.ParseInLocation(dorisDatetimeFormat, s, time.Local)
+	return err == nil && t.Before(now.Add(-lc.watermark))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+)
+
+// 字段超出 limits 时的处理方式
+const (
+	limitReject   = "reject"   // 返回 413（默认）
+	limitTruncate = "truncate" // 字符串截断到上限，对象和数组只保留前 max_fields 项
+)
+
+// LimitsConfig 端点的事件大小和字段基数限制，防止异常请求体占用 Doris 存储
+type LimitsConfig struct {
+	MaxEventBytes ByteSize `yaml:"max_event_bytes,omitempty" json:"max_event_bytes,omitempty"` // 转换后一行 JSON 的最大字节数，超过时始终拒绝
+	MaxFieldBytes ByteSize `yaml:"max_field_bytes,omitempty" json:"max_field_bytes,omitempty"` // 字符串字段的默认最大字节数，列的 max_bytes 优先
+	MaxFields     int      `yaml:"max_fields,omitempty" json:"max_fields,omitempty"`           // 对象字段的最大键数、数组字段的最大元素数（JSON/VARIANT 列）
+	OnExceed      string   `yaml:"on_exceed,omitempty" json:"on_exceed,omitempty"`             // reject（默认）或 truncate
+}
+
+// validate 校验限制配置并设置默认值
+func (l *LimitsConfig) validate() error {
+	if l.MaxEventBytes < 0 || l.MaxFieldBytes < 0 || l.MaxFields < 0 {
+		return fmt.Errorf("limits 的 max_event_bytes/max_field_bytes/max_fields 不能为负数")
+	}
+	l.OnExceed = defaultString(l.OnExceed, limitReject)
+	if l.OnExceed != limitReject && l.OnExceed != limitTruncate {
+		return fmt.Errorf("limits.on_exceed 无效: %q（可选 reject、truncate）", l.OnExceed)
+	}
+	return nil
+}
+
+// limitError 事件或字段超出 limits，返回 413
+type limitError struct {
+	Field string // 超出事件大小时为空
+	Limit string // max_event_bytes、max_bytes 或 max_fields
+	Max   int
+}
+
+func (e *limitError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("event exceeds %s (%d)", e.Limit, e.Max)
+	}
+	return fmt.Sprintf("field %q exceeds %s (%d)", e.Field, e.Limit, e.Max)
+}
+
+// bindLimits 按列的 max_bytes 和端点的 limits 设置列的限制
+func (m *ColumnMapping) bindLimits(l *LimitsConfig) {
+	m.maxBytes = int(m.MaxBytes)
+	if l == nil {
+		return
+	}
+	if m.maxBytes == 0 {
+		m.maxBytes = int(l.MaxFieldBytes)
+	}
+	m.maxFields = l.MaxFields
+	m.truncate = l.OnExceed == limitTruncate
+}
+
+// limit 检查字符串和未设置 type 的列的字节数和键数，超出时按 on_exceed 拒绝或截断
+// 对象按键名排序后保留前 max_fields 个键，使截断结果稳定
+func (m *ColumnMapping) limit(v any) (any, error) {
+	// int、float、datetime、bool 列转换后长度固定
+	if m.Type != "" && m.Type != typeString {
+		return v, nil
+	}
+	switch v := v.(type) {
+	case string:
+		if m.maxBytes == 0 || len(v) <= m.maxBytes {
+			return v, nil
+		}
+		if !m.truncate {
+			return nil, &limitError{Field: m.inputName(), Limit: "max_bytes", Max: m.maxBytes}
+		}
+		n := m.maxBytes
+		for n > 0 && !utf8.RuneStart(v[n]) {
+			n--
+		}
+		return v[:n], nil
+	case map[string]any:
+		if m.maxFields == 0 || len(v) <= m.maxFields {
+			return v, nil
+		}
+		if !m.truncate {
+			return nil, &limitError{Field: m.inputName(), Limit: "max_fields", Max: m.maxFields}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		out := make(map[string]any, m.maxFields)
+		for _, k := range keys[:m.maxFields] {
+			out[k] = v[k]
+		}
+		return out, nil
+	case []any:
+		if m.maxFields == 0 || len(v) <= m.maxFields {
+			return v, nil
+		}
+		if !m.truncate {
+			return nil, &limitError{Field: m.inputName(), Limit: "max_fields", Max: m.maxFields}
+		}
+		return v[:m.maxFields], nil
+	}
+	return v, nil
+}
+
+// checkEventSize 检查转换后的行是否超过 limits.max_event_bytes
+func (ep *Endpoint) checkEventSize(row map[string]any) error {
+	if ep.Limits == nil || ep.Limits.MaxEventBytes == 0 {
+		return nil
+	}
+	line, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if len(line) > int(ep.Limits.MaxEventBytes) {
+		return &limitError{Limit: "max_event_bytes", Max: int(ep.Limits.MaxEventBytes)}
+	}
+	return nil
+}
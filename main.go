@@ -1,151 +1,244 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"doris-webhook/dorisload"
 )
 
 const (
-	videoTable        = "video_metrics"
-	listenPort        = ":8080"
-	maxRedirects      = 10
-	defaultTimeout    = 30 * time.Second
-	shutdownTimeout   = 5 * time.Second
-	readTimeout       = 10 * time.Second
-	writeTimeout      = 30 * time.Second
-	idleTimeout       = 120 * time.Second
-	maxHeaderBytes    = 1 << 20 // 1MB
-	maxIdleConns      = 100
-	maxIdleConnsPerHost = 50
-	maxConnsPerHost   = 100
-	idleConnTimeout   = 90 * time.Second
-)
+	videoTable     = "video_metrics"
+	listenPort     = ":8080"
+	defaultTimeout = 30 * time.Second
 
-// Config Doris 配置
-type Config struct {
-	BEHTTP string // BE HTTP 地址（用于 Stream Load）
-	DB     string
-	User   string
-	Passwd string
-}
+	statusClientClosedRequest = 499 // 客户端在响应前断开（沿用 nginx 的约定）
+)
 
-// VideoRequest HTTP 请求数据
-type VideoRequest struct {
-	Project   string `json:"project" binding:"required"`
-	Event     string `json:"event" binding:"required"`
-	UserAgent string `json:"userAgent"`
+// App 应用主结构
+type App struct {
+	config     *dorisload.Config
+	logger     *slog.Logger
+	clusters   *Clusters // Doris 集群客户端，按目标表所属的集群选择
+	registry   *Registry
+	quota      *QuotaManager // 未配置配额时为 nil
+	limiter    *LoadLimiter
+	priorities *PriorityRules
+	wal        *WAL                          // 未设置 WAL_DIR 时为 nil
+	batchers   map[string]*dorisload.Batcher // 按表名索引，未启用批量写入时为 nil
+	sinks      map[string]Sink               // 按名称索引的输出目标，含内置 doris
+	sinkWG     sync.WaitGroup                // 进行中的 best_effort 写入
+	geoip      *GeoIP                        // 未设置 GEOIP_DB 时为 nil
+	nonces     NonceStore                    // 请求签名的防重放记录
+	abuse      *AbuseGuard                   // 未配置滥用检测时为 nil
+	degraded   atomic.Bool                   // 降级模式：Doris 不可用，事件全部写入 WAL
+	recheck    time.Duration                 // 降级模式下重试预检的间隔，作为 503 的重试建议
+	faults     *FaultInjector                // 非 chaos 构建中为 nil
+	uploads    *Uploader                     // 未启用文件上传时为 nil
+	audit      *AuditLog                     // 未设置 AUDIT_SINK 时为 nil
+	debug      *DebugLog                     // 没有端点启用调试日志时为 nil
+	jobs       *JobScheduler                 // 未配置定时补录任务时为 nil
+	sdk        *SDKConfigServer              // 配置文件中没有 sdk 时为 nil
+	toggles    *Toggles                      // 通过 /admin/toggles 修改的运行时开关
+	rollups    map[string]*Rollup            // 按端点名索引，没有端点配置 rollup 时为 nil
+	replicas   *Replicator                   // 没有集群配置 replica_of 时为 nil
+	watermarks *Watermarks                   // 未启用 WATERMARKS_ENABLED 时为 nil
+	hashKey    string                        // DORIS_BE_HASH_KEY：按该列的值选择 BE，为空时按表名
+	schema     *SchemaSampler                // SCHEMA_SAMPLE_PERCENT 为 0 时为 nil
+	journal    *Journal                      // 没有端点设置 journal 时为 nil
+	usage      *UsageMeter                   // 未设置 USAGE_FILE 时为 nil
 }
 
-// VideoData Doris 数据格式
-type VideoData struct {
-	Project   string `json:"project"`
-	Event     string `json:"event"`
-	UserAgent string `json:"user_agent"`
-	EventTime string `json:"event_time,omitempty"` // 事件时间，格式：YYYY-MM-DD HH:MM:SS
-}
+// loadConfig 加载配置，校验所有环境变量后一次性返回全部问题（configErrors）
+func loadConfig() (*dorisload.Config, error) {
+	var errs configErrors
 
-// DorisClient Doris 客户端封装
-type DorisClient struct {
-	config     *Config
-	client     *http.Client
-	streamURL  string
-	authHeader string
-	once       sync.Once
-}
-
-// App 应用主结构
-type App struct {
-	config      *Config
-	logger      *slog.Logger
-	dorisClient *DorisClient
-}
-
-// NewDorisClient 创建 Doris 客户端
-func NewDorisClient(cfg *Config) *DorisClient {
-	dc := &DorisClient{
-		config: cfg,
-		client: &http.Client{
-			Transport: &http.Transport{
-				MaxIdleConns:        maxIdleConns,
-				MaxIdleConnsPerHost: maxIdleConnsPerHost,
-				MaxConnsPerHost:     maxConnsPerHost,
-				IdleConnTimeout:     idleConnTimeout,
-				DisableKeepAlives:   false,
-				DisableCompression:  true,
-			},
-			Timeout: defaultTimeout,
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				if len(via) >= maxRedirects {
-					return fmt.Errorf("重定向次数过多")
-				}
-				return nil
-			},
-		},
+	// 模拟 Doris 时不连接 BE，不需要 BE 地址和密码
+	mock := getEnv("DORIS_MOCK", "false") == "true"
+	defaultBE := ""
+	if mock {
+		defaultBE = mockBEHTTP
+	}
+	beHTTPAddrs, err := normalizeBEAddrs(splitList(getEnv("DORIS_BE_HTTP", defaultBE)))
+	errs.add("DORIS_BE_HTTP", err)
+	if err == nil && len(beHTTPAddrs) == 0 {
+		errs.addf("DORIS_BE_HTTP", "必须设置")
 	}
-	// 延迟初始化 URL 和 auth header
-	dc.once.Do(dc.init)
-	return dc
-}
 
-// init 初始化 URL 和认证头（延迟初始化，只执行一次）
-func (dc *DorisClient) init() {
-	dc.streamURL = fmt.Sprintf("%s/api/%s/%s/_stream_load", dc.config.BEHTTP, dc.config.DB, videoTable)
-	auth := base64.StdEncoding.EncodeToString([]byte(dc.config.User + ":" + dc.config.Passwd))
-	dc.authHeader = "Basic " + auth
-}
+	// 与 BE 的 streaming_load_max_mb 保持一致，不带单位时按 MB 计
+	maxLoadBytes, err := envByteSize("DORIS_STREAMING_LOAD_MAX_MB", "100", 1<<20, 1<<20, 10<<30)
+	errs.add("", err)
+
+	// 写入的总时间预算和单次尝试的超时，不带单位时按毫秒计
+	writeBudget, err := envDuration("DORIS_WRITE_BUDGET_MS", "20000", time.Millisecond, time.Millisecond, 0)
+	errs.add("", err)
+	attemptTimeout, err := envDuration("DORIS_ATTEMPT_TIMEOUT_MS", "10000", time.Millisecond, time.Millisecond, 0)
+	errs.add("", err)
+	maxAttempts, err := strconv.Atoi(getEnv("DORIS_MAX_ATTEMPTS", "1"))
+	if err != nil || maxAttempts <= 0 {
+		errs.addf("DORIS_MAX_ATTEMPTS", "无效: %q", getEnv("DORIS_MAX_ATTEMPTS", ""))
+	}
 
-// loadConfig 加载配置
-func loadConfig() (*Config, error) {
-	beHTTPAddr := getEnv("DORIS_BE_HTTP", "")
-	if beHTTPAddr == "" {
-		return nil, fmt.Errorf("DORIS_BE_HTTP 必须设置")
+	// Debug 日志中请求数据的最大字节数，端点调试日志未配置 max_bytes 时也使用此值
+	debugMaxBytes, err := envByteSize("DEBUG_MAX_BYTES", "1024", 1, 1, 1<<20)
+	errs.add("", err)
+
+	cfg := &dorisload.Config{
+		BEHTTP:         beHTTPAddrs,
+		DB:             getEnv("DORIS_DATABASE", "video"),
+		User:           getEnv("DORIS_USER", "devops"),
+		Passwd:         getEnv("DORIS_PASSWORD", ""),
+		MaxLoadBytes:   maxLoadBytes,
+		WriteBudget:    writeBudget,
+		AttemptTimeout: attemptTimeout,
+		MaxAttempts:    maxAttempts,
+		Debug:          getEnv("DEBUG", "false") == "true",
+		DebugMaxBytes:  int(debugMaxBytes),
 	}
 
-	// 确保有协议前缀
-	if !strings.HasPrefix(beHTTPAddr, "http://") && !strings.HasPrefix(beHTTPAddr, "https://") {
-		beHTTPAddr = "http://" + beHTTPAddr
+	if cfg.Passwd == "" && !mock {
+		errs.addf("DORIS_PASSWORD", "未设置")
+	}
+	if mock {
+		cfg.WrapTransport = (&mockDoris{}).wrap
+	}
+	// 库名拼接在 Stream Load 地址中
+	errs.add("DORIS_DATABASE", dorisload.ValidateIdentifier(cfg.DB))
+	// 多个 BE 之间的分配策略；hash 按 DORIS_BE_HASH_KEY 列的值（未设置时按表名）固定写入同一 BE
+	cfg.Balance, err = dorisload.ParseBalance(getEnv("DORIS_BE_BALANCE", ""))
+	errs.add("DORIS_BE_BALANCE", err)
+	if err == nil && getEnv("DORIS_BE_HASH_KEY", "") != "" && cfg.Balance != dorisload.BalanceHash {
+		errs.addf("DORIS_BE_HASH_KEY", "需要 DORIS_BE_BALANCE=hash")
+	}
+	// Stream Load 连接池，大小默认按 DORIS_MAX_INFLIGHT 推导（见 main）
+	pool := map[string]int{
+		"DORIS_POOL_MAX_CONNS_PER_BE":  0,
+		"DORIS_POOL_MAX_IDLE_PER_BE":   0,
+		"DORIS_POOL_PREWARM":           0,
+		"DORIS_RECYCLE_AFTER_FAILURES": 0,
+	}
+	for _, key := range slices.Sorted(maps.Keys(pool)) {
+		v, err := strconv.Atoi(getEnv(key, strconv.Itoa(pool[key])))
+		if err != nil || v < 0 {
+			errs.addf(key, "无效: %q", getEnv(key, ""))
+			continue
+		}
+		pool[key] = v
+	}
+	// 不带单位时按秒计
+	poolDurations := map[string]string{
+		"DORIS_POOL_IDLE_TIMEOUT":    "90",
+		"DORIS_POOL_MAX_CONN_AGE":    "0",
+		"DORIS_DNS_REFRESH_INTERVAL": "0",
+	}
+	durations := make(map[string]time.Duration, len(poolDurations))
+	for _, key := range slices.Sorted(maps.Keys(poolDurations)) {
+		d, err := envDuration(key, poolDurations[key], time.Second, 0, 0)
+		errs.add("", err)
+		durations[key] = d
+	}
+	cfg.Pool = dorisload.PoolConfig{
+		PerTable:      getEnv("DORIS_POOL_PER_TABLE", "false") == "true",
+		MaxConnsPerBE: pool["DORIS_POOL_MAX_CONNS_PER_BE"],
+		MaxIdlePerBE:  pool["DORIS_POOL_MAX_IDLE_PER_BE"],
+		IdleTimeout:   durations["DORIS_POOL_IDLE_TIMEOUT"],
+		MaxConnAge:    durations["DORIS_POOL_MAX_CONN_AGE"],
+		Prewarm:       pool["DORIS_POOL_PREWARM"],
+
+		DNSRefresh:           durations["DORIS_DNS_REFRESH_INTERVAL"],
+		RecycleAfterFailures: pool["DORIS_RECYCLE_AFTER_FAILURES"],
 	}
 
-	cfg := &Config{
-		BEHTTP: beHTTPAddr,
-		DB:     getEnv("DORIS_DATABASE", "video"),
-		User:   getEnv("DORIS_USER", "devops"),
-		Passwd: getEnv("DORIS_PASSWORD", ""),
+	// Expect: 100-continue：enabled（默认）或 disabled，等待 100 Continue 的时间，未收到时的回退
+	switch mode := getEnv("DORIS_EXPECT_CONTINUE", "enabled"); mode {
+	case "enabled":
+	case "disabled":
+		cfg.Continue.Disabled = true
+	default:
+		errs.addf("DORIS_EXPECT_CONTINUE", "无效: %q（可选 enabled、disabled）", mode)
+	}
+	cfg.Continue.Timeout, err = envDuration("DORIS_EXPECT_CONTINUE_TIMEOUT_MS", "0", time.Millisecond, 0, 0)
+	errs.add("", err)
+	cfg.Continue.NoFallback = getEnv("DORIS_EXPECT_CONTINUE_FALLBACK", "true") != "true"
+
+	// 失败归类：追加的归类规则和可重试的类别，决定是否重试以及 WAL 段是否直接移入 dlq
+	cfg.FailureRules, err = dorisload.ParseFailureRules(getEnv("DORIS_FAILURE_RULES", ""))
+	errs.add("DORIS_FAILURE_RULES", err)
+	if raw := getEnv("DORIS_RETRYABLE_CLASSES", ""); raw != "" {
+		cfg.RetryableClasses, err = dorisload.ParseFailureClasses(raw)
+		errs.add("DORIS_RETRYABLE_CLASSES", err)
 	}
 
-	if cfg.Passwd == "" {
-		return nil, fmt.Errorf("DORIS_PASSWORD 未设置")
+	// too many versions 后的冷却，为 0 时不冷却
+	cfg.Cooldown.Duration, err = envDuration("DORIS_VERSION_COOLDOWN", "10s", time.Second, 0, time.Hour)
+	errs.add("", err)
+	switch scope := getEnv("DORIS_VERSION_COOLDOWN_SCOPE", "table"); scope {
+	case "table":
+	case "global":
+		cfg.Cooldown.Global = true
+	default:
+		errs.addf("DORIS_VERSION_COOLDOWN_SCOPE", "无效: %q（可选 table、global）", scope)
 	}
 
+	// 连接 BE 使用的代理，未设置时使用 HTTPS_PROXY、HTTP_PROXY、NO_PROXY 环境变量
+	cfg.Proxy, err = dorisload.ParseProxy(getEnv("DORIS_PROXY", ""))
+	errs.add("DORIS_PROXY", err)
+
+	// BE 前有认证代理时附加的请求头和访问令牌
+	cfg.Auth, err = loadDorisAuth()
+	errs.add("", err)
+
+	// 连接 https:// BE 的 CA 和客户端证书
+	cfg.TLSConfig, err = loadClusterTLS(envClusterTLS())
+	errs.add("DORIS_TLS_*", err)
+
+	// 跨域策略在创建路由时使用，这里提前校验，与 Doris 配置的问题一起报告
+	_, err = corsPolicyFromEnv()
+	errs.add("", err)
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
-// getEnv 获取环境变量
+// getEnv 获取环境变量，未设置时依次使用运行环境（APP_ENV）的默认值和 defaultValue
 func getEnv(key, defaultValue string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
 	}
+	if v := profileEnv[key]; v != "" {
+		return v
+	}
 	return defaultValue
 }
 
 var (
-	logger *slog.Logger // 全局 logger（向后兼容）
+	logger   *slog.Logger  // 全局 logger（向后兼容）
+	logLevel slog.LevelVar // 日志级别，运行时开关 debug 可临时调整
 )
 
 // initLogger 初始化日志记录器
@@ -166,9 +259,11 @@ func initLogger() *slog.Logger {
 		level = slog.LevelInfo
 	}
 
-	// 创建日志选项
+	// 创建日志选项，所有日志行输出前经过脱敏
+	logLevel.Set(level)
 	opts := &slog.HandlerOptions{
-		Level: level,
+		Level:       &logLevel,
+		ReplaceAttr: newRedactor().ReplaceAttr,
 	}
 
 	// 根据环境变量选择日志格式
@@ -191,129 +286,148 @@ func maskPassword(pwd string) string {
 	return pwd[:2] + "****" + pwd[len(pwd)-2:]
 }
 
-// StreamLoadResponse Doris Stream Load 响应
-type StreamLoadResponse struct {
-	TxnID                  int64  `json:"TxnId"`
-	Label                  string `json:"Label"`
-	Status                 string `json:"Status"`
-	Message                string `json:"Message"`
-	NumberTotalRows        int64  `json:"NumberTotalRows"`
-	NumberLoadedRows       int64  `json:"NumberLoadedRows"`
-	NumberFilteredRows     int64  `json:"NumberFilteredRows"`
-	NumberUnselectedRows   int64  `json:"NumberUnselectedRows"`
-	LoadBytes              int64  `json:"LoadBytes"`
-	LoadTimeMs             int64  `json:"LoadTimeMs"`
-	BeginTxnTimeMs         int64  `json:"BeginTxnTimeMs"`
-	StreamLoadPutTimeMs    int64  `json:"StreamLoadPutTimeMs"`
-	ReadDataTimeMs         int64  `json:"ReadDataTimeMs"`
-	WriteDataTimeMs        int64  `json:"WriteDataTimeMs"`
-	CommitAndPublishTimeMs int64  `json:"CommitAndPublishTimeMs"`
-	ErrorURL               string `json:"ErrorURL"`
+// adminAuth 管理接口鉴权中间件
+// 设置 ADMIN_TOKEN 时要求请求携带 Authorization: Bearer <token>；未设置时只允许只读请求（GET、HEAD），
+// 暂停写入、清理 WAL、解除封禁等修改状态的请求返回 401
+func (app *App) adminAuth() gin.HandlerFunc {
+	token := getEnv("ADMIN_TOKEN", "")
+	return func(c *gin.Context) {
+		if token == "" {
+			if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+				abortWithError(c, http.StatusUnauthorized, errCodeUnauthorized, "Admin token not configured", nil)
+				return
+			}
+			c.Next()
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			abortWithError(c, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized", nil)
+			return
+		}
+		c.Next()
+	}
 }
 
-// WriteToDoris 写入数据到 Doris BE
-// 直接连接 BE HTTP 端口进行 Stream Load，不经过 FE
-func (dc *DorisClient) WriteToDoris(ctx context.Context, data []byte, logger *slog.Logger) error {
-	isDebug := getEnv("DEBUG", "false") == "true"
-
-	if isDebug {
-		logger.Debug("向 Doris BE 发送请求", "url", dc.streamURL, "data", string(data))
+// statsHandler 返回运行统计信息
+func (app *App) statsHandler(c *gin.Context) {
+	stats := gin.H{
+		"doris_inflight": app.limiter.Inflight(),
+		"sinks":          app.sinkStats(),
 	}
-
-	req, err := http.NewRequestWithContext(ctx, "PUT", dc.streamURL, bytes.NewReader(data))
-	if err != nil {
-		return fmt.Errorf("创建请求失败: %w", err)
+	if app.batchers != nil {
+		batch := gin.H{}
+		for name, b := range app.batchers {
+			rows, interval := b.Current()
+			entry := gin.H{
+				"queue_length":      b.QueueLength(),
+				"batch_rows":        rows,
+				"flush_interval_ms": interval.Milliseconds(),
+			}
+			if keys := b.KeyStats(); keys != nil {
+				entry["projects"] = keys
+			}
+			batch[name] = entry
+		}
+		stats["batch"] = batch
 	}
-
-	// 设置 ContentLength，这样 Go 会自动处理 100-continue
-	req.ContentLength = int64(len(data))
-
-	// 设置请求头（与 curl 脚本保持一致）
-	req.Header.Set("Authorization", dc.authHeader)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Expect", "100-continue")
-	req.Header.Set("label", uuid.New().String())
-	req.Header.Set("format", "json")
-	req.Header.Set("read_json_by_line", "true")
-	req.Header.Set("columns", "project,event,user_agent,event_time")
-
-	resp, err := dc.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("doris 连接失败: %w", err)
+	if app.abuse != nil {
+		stats["abuse"] = app.abuse.Stats()
 	}
-	defer resp.Body.Close()
-
-	body, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		return fmt.Errorf("读取 Doris 响应体失败: %w", readErr)
+	if backends := app.clusters.Health(); backends != nil {
+		stats["backends"] = backends
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		logger.Error("Doris 返回错误", "status_code", resp.StatusCode, "body", string(body))
-		return fmt.Errorf("doris 返回错误 [%d]: %s", resp.StatusCode, string(body))
+	stats["connection_recycles"] = app.clusters.Recycles()
+	if app.wal != nil {
+		segments, bytes := app.wal.Pending()
+		wal := gin.H{
+			"pending_segments": segments,
+			"pending_bytes":    bytes,
+			"replay":           app.wal.ReplayStatus(),
+			"recovery":         app.wal.RecoveryStats(),
+		}
+		if retention := app.wal.RetentionStats(); retention != nil {
+			wal["retention"] = retention
+		}
+		stats["wal"] = wal
 	}
-
-	// 解析响应体
-	var loadResp StreamLoadResponse
-	if err := json.Unmarshal(body, &loadResp); err != nil {
-		logger.Error("解析响应体失败", "error", err, "body", string(body))
-		return fmt.Errorf("无法解析 Doris 响应: %s", string(body))
+	if app.journal != nil {
+		stats["journal"] = gin.H{"idempotency_keys": app.journal.Keys()}
 	}
-
-	// 检查实际执行状态
-	if loadResp.Status != "Success" {
-		logger.Error("Doris stream load 失败",
-			"status", loadResp.Status,
-			"message", loadResp.Message,
-			"error_url", loadResp.ErrorURL)
-		return fmt.Errorf("doris stream load 失败: Status=%s, Message=%s, ErrorURL=%s",
-			loadResp.Status, loadResp.Message, loadResp.ErrorURL)
+	if app.jobs != nil {
+		stats["jobs"] = app.jobs.Stats()
 	}
-
-	if isDebug {
-		logger.Debug("Doris 写入成功",
-			"label", loadResp.Label,
-			"loaded_rows", loadResp.NumberLoadedRows,
-			"total_rows", loadResp.NumberTotalRows,
-			"load_time_ms", loadResp.LoadTimeMs)
+	if app.rollups != nil {
+		rollups := gin.H{}
+		for name, r := range app.rollups {
+			rollups[name] = gin.H{"groups": r.Groups()}
+		}
+		stats["rollups"] = rollups
 	}
-	return nil
+	if app.replicas != nil {
+		stats["replicas"] = app.replicas.Stats()
+	}
+	splits := gin.H{}
+	for _, ep := range app.registry.Endpoints {
+		if ep.Split != nil {
+			splits[ep.Name] = ep.Split.Stats()
+		}
+	}
+	if len(splits) > 0 {
+		stats["splits"] = splits
+	}
+	if app.quota != nil {
+		quota, err := app.quota.Snapshot(c.Request.Context())
+		if err != nil {
+			app.logger.Error("查询配额统计失败", "error", err)
+			abortWithError(c, http.StatusInternalServerError, errCodeDependencyUnavailable, "Failed to load quota stats", nil)
+			return
+		}
+		stats["quota"] = quota
+	}
+	c.JSON(http.StatusOK, stats)
 }
 
-// setupRouter 设置路由
-func (app *App) setupRouter() *gin.Engine {
-	// 根据环境变量设置 Gin 模式
-	ginMode := getEnv("GIN_MODE", "release")
-	gin.SetMode(ginMode)
-
-	r := gin.New()
-
-	// 使用自定义日志中间件（使用 slog）
-	r.Use(app.ginLogger())
-	r.Use(gin.Recovery())
-
-	// 配置 CORS
-	corsConfig := cors.Config{
-		AllowOrigins:     strings.Split(getEnv("CORS_ALLOWED_ORIGIN", "*"), ","),
-		AllowMethods:     strings.Split(getEnv("CORS_ALLOWED_METHODS", "GET,POST,OPTIONS"), ","),
-		AllowHeaders:     strings.Split(getEnv("CORS_ALLOWED_HEADERS", "Content-Type,Authorization"), ","),
-		AllowCredentials: getEnv("CORS_ALLOW_CREDENTIALS", "false") == "true",
-		MaxAge:           3600 * time.Second,
+// healthHistoryHandler 返回各集群 BE 的健康检查历史和各输出目标的写入历史，含抖动状态
+func (app *App) healthHistoryHandler(c *gin.Context) {
+	sinks := make(map[string]SinkHealthHistory, len(app.sinks))
+	for name, s := range app.sinks {
+		if cs, ok := s.(*countingSink); ok {
+			sinks[name] = cs.healthHistory()
+		}
 	}
-	r.Use(cors.New(corsConfig))
+	resp := gin.H{"sinks": sinks}
+	if backends := app.clusters.HealthHistory(); backends != nil {
+		resp["backends"] = backends
+	}
+	c.JSON(http.StatusOK, resp)
+}
 
-	// 健康检查端点
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":  "ok",
-			"service": "doris-webhook",
-		})
+// endpointsHandler 返回已注册的端点及其字段映射
+func (app *App) endpointsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"endpoints": app.registry.Endpoints,
+		"tables":    app.registry.Tables,
 	})
+}
 
-	// 视频数据写入端点
-	r.POST("/video", app.videoHandler)
+// configureHTTP2 为服务器启用 HTTP/2
+// TLS 模式下通过 ALPN 协商 h2；明文模式下（H2C_ENABLED=true）支持 h2c，
+// 便于 SDK 和内部服务在少量连接上复用大量小请求
+func configureHTTP2(srv *http.Server, tlsEnabled bool) error {
+	maxStreams, err := strconv.ParseUint(getEnv("HTTP2_MAX_CONCURRENT_STREAMS", "1000"), 10, 32)
+	if err != nil {
+		return fmt.Errorf("HTTP2_MAX_CONCURRENT_STREAMS 无效: %w", err)
+	}
 
-	return r
+	h2s := &http2.Server{
+		MaxConcurrentStreams: uint32(maxStreams),
+		IdleTimeout:          srv.IdleTimeout,
+	}
+
+	if !tlsEnabled && getEnv("H2C_ENABLED", "true") == "true" {
+		srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+	}
+	return http2.ConfigureServer(srv, h2s)
 }
 
 // ginLogger 自定义日志中间件（使用 slog）
@@ -336,6 +450,7 @@ func (app *App) ginLogger() gin.HandlerFunc {
 			"path", path,
 			"latency", latency,
 			"ip", c.ClientIP(),
+			"request_id", c.GetString(requestIDKey),
 		}
 
 		if raw != "" {
@@ -355,54 +470,745 @@ func (app *App) ginLogger() gin.HandlerFunc {
 	}
 }
 
-// videoHandler 处理视频数据写入
-func (app *App) videoHandler(c *gin.Context) {
-	var req VideoRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		app.logger.Warn("请求验证失败", "error", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid request body: " + err.Error(),
-		})
-		return
+// limitBody 请求体上限中间件：声明的 Content-Length 超过上限时直接返回 413，
+// 未声明长度（chunked）的请求体读取到上限后失败，由 readBody 返回 413
+func limitBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			abortWithError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, "Request body too large", gin.H{"max_bytes": maxBytes})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
 	}
+}
 
-	// 转换为 Doris 数据格式并序列化
-	// 使用当前时间作为事件时间，包含毫秒精度
-	eventTime := time.Now().Format("2006-01-02 15:04:05.000")
-	jsonData, err := json.Marshal(VideoData{
-		Project:   req.Project,
-		Event:     req.Event,
-		UserAgent: req.UserAgent,
-		EventTime: eventTime,
-	})
+// readBody 读取请求体，超过 limitBody 的上限时返回 413，其他读取错误返回 400；返回 false 时已写入错误响应
+func readBody(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err == nil {
+		return body, true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		abortWithError(c, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, "Request body too large", gin.H{"max_bytes": tooLarge.Limit})
+		return nil, false
+	}
+	abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Failed to read request body", nil)
+	return nil, false
+}
+
+// requestTimeout 请求超时中间件，为请求上下文设置截止时间
+// 客户端断开或超时后上下文被取消，下游的 Doris 请求随之中止，不再占用 BE 连接
+func requestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// ingestHandler 返回端点的事件写入处理函数
+// 请求体（及端点接受的表单、查询参数）按端点的列映射校验并转换为 Doris 行，写入端点对应的表
+func (app *App) ingestHandler(ep *Endpoint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw, ok := readBody(c)
+		if !ok {
+			return
+		}
+		raw, err := ep.sanitizeBody(raw)
+		if err != nil {
+			app.rejectInvalid(c, ep, err)
+			return
+		}
+		body, forward, err := decodeEvent(c, ep, raw)
+		var ev eventRow
+		if err == nil {
+			ev, err = app.buildEvent(c, ep, body, RowContext{Now: time.Now()})
+		}
+		if err != nil {
+			app.rejectInvalid(c, ep, err)
+			return
+		}
+		app.ingestRows(c, ep, []eventRow{ev}, forward, nil)
+	}
+}
+
+// eventRow 一个事件转换得到的行：主表一行，双写表各一行
+type eventRow struct {
+	Row  map[string]any
+	Dual []map[string]any // 与 ep.DualWrite 一一对应
+}
+
+// buildEvent 按端点的列映射（含双写表）转换一个请求中的事件，请求头和 GeoIP 取自请求
+func (app *App) buildEvent(c *gin.Context, ep *Endpoint, body map[string]any, rc RowContext) (eventRow, error) {
+	rc.Header = c.Request.Header
+	if app.schema != nil {
+		app.schema.Observe(ep, body)
+	}
+	if err := ep.checkStrict(body); err != nil {
+		return eventRow{}, err
+	}
+	if ep.UsesGeoIP() {
+		rc.Country = app.geoip.Country(c.ClientIP())
+	}
+	return convertEvent(ep, body, rc)
+}
+
+// convertEvent 按端点的列映射（含双写表）转换一个事件
+func convertEvent(ep *Endpoint, body map[string]any, rc RowContext) (eventRow, error) {
+	row, err := ep.BuildRow(body, rc)
 	if err != nil {
-		app.logger.Error("序列化数据失败", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to marshal data",
-		})
+		return eventRow{}, err
+	}
+	if err := ep.checkEventSize(row); err != nil {
+		return eventRow{}, err
+	}
+	ev := eventRow{Row: row}
+	for _, dw := range ep.DualWrite {
+		dualRow, err := dw.BuildRow(body, rc)
+		if err != nil {
+			return eventRow{}, err
+		}
+		ev.Dual = append(ev.Dual, dualRow)
+	}
+	return ev, nil
+}
+
+// rejectInvalid 返回请求校验失败的响应：类型或时间窗口错误返回 422，超出 limits 返回 413，其他返回 400
+// details 中带有出错的字段，批量请求还带有事件下标
+func (app *App) rejectInvalid(c *gin.Context, ep *Endpoint, err error) {
+	app.logger.Warn("请求验证失败", "endpoint", ep.Name, "error", err)
+	status, code, message, details := classifyInvalid(err)
+	abortWithError(c, status, code, message, optionalDetails(details))
+}
+
+// classifyInvalid 将校验错误映射为响应状态码、错误码、消息和 details
+func classifyInvalid(err error) (int, string, string, gin.H) {
+	var (
+		ce *coercionError
+		ue *unmappedFieldsError
+		we *windowError
+		le *limitError
+		be *bulkEventError
+	)
+	details := gin.H{}
+	if errors.As(err, &be) {
+		details["index"] = be.Index
+	}
+	switch {
+	case errors.As(err, &ce):
+		details["field"], details["type"] = ce.Field, ce.Type
+		return http.StatusUnprocessableEntity, errCodeSchemaInvalid, "Invalid field type: " + err.Error(), details
+	case errors.As(err, &ue):
+		details["fields"] = ue.Fields
+		return http.StatusUnprocessableEntity, errCodeSchemaInvalid, "Strict mode: " + err.Error(), details
+	case errors.As(err, &we):
+		details["field"], details["limit"] = we.Field, we.Limit
+		return http.StatusUnprocessableEntity, errCodeTimestampOutOfRange, "Timestamp out of range: " + err.Error(), details
+	case errors.As(err, &le):
+		details["limit"], details["max"] = le.Limit, le.Max
+		if le.Field != "" {
+			details["field"] = le.Field
+		}
+		return http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, "Event too large: " + err.Error(), details
+	default:
+		return http.StatusBadRequest, errCodeSchemaInvalid, "Invalid request body: " + err.Error(), details
+	}
+}
+
+// ingestRows 配额检查后按优先级写入一个或多个事件
+// 事件作为一个批次写入，任一项目超出配额时整个请求返回 429；请求日志端点以 Idempotency-Key 去重
+// raw 为转发给 http 输出目标的原始请求体，为 nil 时转发 NDJSON 行；bulk 为批量请求的写入模式，单条写入时为 nil
+func (app *App) ingestRows(c *gin.Context, ep *Endpoint, events []eventRow, raw []byte, bulk *bulkResult) {
+	// 已接收过的幂等键直接返回与首次请求相同的响应，不写入也不计入配额；同一个键的请求正在写入时返回 409
+	var idemKey string
+	committed := false
+	if ep.Journal {
+		var ok bool
+		if idemKey, ok = idempotencyKey(c); !ok {
+			return
+		}
+		if idemKey != "" {
+			switch app.journal.Acquire(ep, idemKey, time.Now()) {
+			case journalKeySeen:
+				c.Header(idempotentReplayedHeader, "true")
+				if bulk != nil {
+					respondBulk(c, ep, bulk, true, "", len(events), nil)
+					return
+				}
+				respondAccepted(c, ep, true, "")
+				return
+			case journalKeyPending:
+				abortWithRetry(c, http.StatusConflict, errCodeRequestInProgress, "A request with the same Idempotency-Key is in progress, please retry later", time.Second, retryStrategyFixed, nil)
+				return
+			}
+			// 请求未写入时释放键，以相同键重试的请求重新写入
+			defer func() {
+				if !committed {
+					app.journal.Release(ep, idemKey)
+				}
+			}()
+			c.Set(idempotencyKeyCtx, ep.Name+"/"+idemKey)
+		}
+	}
+
+	// 按项目统计事件数，用于配额检查和计数
+	var projects []string
+	counts := make(map[string]int64)
+	for _, ev := range events {
+		project := stringValue(ev.Row, "project")
+		if project == "" {
+			continue
+		}
+		if counts[project] == 0 {
+			projects = append(projects, project)
+		}
+		counts[project]++
+	}
+
+	// 配额检查：按本次请求的行数预留配额，加上本次请求会超限时拒绝；配额存储不可用时放行，避免影响数据写入
+	// 请求未写入（失败或 dry-run）时归还预留的配额
+	var reserved []string
+	defer func() {
+		if committed {
+			return
+		}
+		ctx := context.WithoutCancel(c.Request.Context())
+		for _, project := range reserved {
+			if err := app.quota.Release(ctx, project, counts[project]); err != nil {
+				app.logger.Warn("归还配额失败", "project", project, "error", err)
+			}
+		}
+	}()
+	var quotaStatus *QuotaStatus // 最后一个项目的配额，用于成功响应的限流响应头
+	if app.quota != nil {
+		for _, project := range projects {
+			status, period, err := app.quota.Reserve(c.Request.Context(), project, counts[project])
+			if err != nil {
+				app.logger.Warn("更新配额计数失败", "project", project, "error", err)
+				continue
+			}
+			if period != "" {
+				limit, used, resetAt := status.DailyLimit, status.DailyUsed, status.DailyReset
+				if period == quotaMonthly {
+					limit, used, resetAt = status.MonthlyLimit, status.MonthlyUsed, status.MonthlyReset
+				}
+				c.Header("X-Quota-Remaining", strconv.FormatInt(max(status.Remaining(), 0), 10))
+				app.quota.SetHeaders(c.Writer.Header(), status, time.Now())
+				// 配额在重置时间之前不会恢复，退避没有意义
+				abortWithRetry(c, http.StatusTooManyRequests, errCodeRateLimited, fmt.Sprintf("Quota exceeded: project %q would exceed its %s limit", project, period), time.Until(resetAt), retryStrategyFixed, gin.H{
+					"project":   project,
+					"period":    period,
+					"limit":     limit,
+					"used":      used,
+					"requested": counts[project],
+					"reset_at":  resetAt.Format(time.RFC3339),
+				})
+				return
+			}
+			reserved = append(reserved, project)
+			quotaStatus = status
+		}
+	}
+
+	// 序列化为 NDJSON，read_json_by_line=true 需要每行一个 JSON；批次优先级取事件中的最高优先级
+	// 端点配置了 late 时，迟到事件的行另外收集，写入修正表而不是目标表
+	lines := make([][]byte, len(events))
+	dualLines := make([][][]byte, len(ep.DualWrite))
+	var onTimeLines, lateLines [][]byte
+	priority := PriorityLow
+	now := time.Now()
+	for i, ev := range events {
+		var err error
+		if lines[i], err = marshalLine(ev.Row); err != nil {
+			app.logger.Error("序列化数据失败", "error", err)
+			abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to marshal data", nil)
+			return
+		}
+		if ep.Late != nil && ep.Late.isLate(ev.Row, now) {
+			lateLines = append(lateLines, lines[i])
+		} else {
+			onTimeLines = append(onTimeLines, lines[i])
+		}
+		for j, dualRow := range ev.Dual {
+			line, err := marshalLine(dualRow)
+			if err != nil {
+				app.logger.Error("序列化数据失败", "error", err)
+				abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to marshal data", nil)
+				return
+			}
+			dualLines[j] = append(dualLines[j], line)
+		}
+		if app.priorities.For(ep, stringValue(ev.Row, "event")) == PriorityHigh {
+			priority = PriorityHigh
+		}
+	}
+
+	if app.debug != nil {
+		app.debug.Log(c.Request.Context(), ep, c.GetString(requestIDKey), events)
+	}
+
+	// dry-run：事件已通过校验和转换，不写入任何输出目标，也不计入配额
+	if app.toggles.DryRun() {
+		if bulk != nil && bulk.mode == bulkModePartial {
+			respondBulk(c, ep, bulk, false, "", len(events), nil)
+			return
+		}
+		respondAccepted(c, ep, false, "")
 		return
 	}
-	// 添加换行符，因为 read_json_by_line=true 需要每行一个 JSON
-	jsonData = append(jsonData, '\n')
 
-	if getEnv("DEBUG", "false") == "true" {
-		app.logger.Debug("处理请求", "project", req.Project, "event", req.Event)
+	// hash 策略下同一请求的各次写入（含双写和迟到事件）按首个事件的路由键写入同一 BE
+	// 合并批量写入（BATCH_ENABLED）和 WAL 回放不区分请求，按表名选择 BE
+	if app.hashKey != "" {
+		if key := stringValue(events[0].Row, app.hashKey); key != "" {
+			c.Request = c.Request.WithContext(dorisload.WithRoutingKey(c.Request.Context(), key))
+		}
+	}
+	// BATCH_FAIRNESS 按项目公平调度时，请求按首个项目排队
+	if app.batchers != nil && len(projects) > 0 {
+		c.Request = c.Request.WithContext(dorisload.WithBatchKey(c.Request.Context(), projects[0]))
 	}
 
-	if err := app.dorisClient.WriteToDoris(c.Request.Context(), jsonData, app.logger); err != nil {
-		app.logger.Error("写入 Doris 失败", "error", err)
-		c.JSON(http.StatusBadGateway, gin.H{
-			"error": fmt.Sprintf("Doris connection failed: %v", err),
-		})
+	batch := &SinkBatch{
+		Table:    ep.Table(),
+		Priority: priority,
+		Lines:    lines,
+		Body:     raw,
+	}
+
+	// 影子流量在后台写入，不影响主写入路径和响应
+	app.shadow(c.Request.Context(), ep, batch)
+
+	// must_succeed 输出目标在 Doris 之前写入：失败时 Doris 尚未写入，返回 502 由客户端重试不会重复写入 Doris
+	if err := app.writeRequiredSinks(c.Request.Context(), ep, batch); err != nil {
+		app.logger.Error("写入输出目标失败", "endpoint", ep.Name, "error", err)
+		abortWithError(c, http.StatusBadGateway, errCodeSinkFailed, fmt.Sprintf("Sink write failed: %v", err), nil)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Data processed successfully.",
-	})
+	status := http.StatusOK
+	// 目标表已接收后未能写入其他表和 WAL 的事件数，按表名（分流时为输出目标名）索引；
+	// lost 为其中未写入任何表的事件数（迟到事件、分流的事件和目标表拆分写入时失败的分片）
+	unwritten := make(map[string]int)
+	lost := 0
+	// written 记录一次 Doris 写入的结果：拆分写入后失败且未写入 WAL 的分片计入未写入的事件数，primary 为 true 时这些事件未写入任何表
+	written := func(b *SinkBatch, writeStatus int, primary bool) {
+		if writeStatus == http.StatusAccepted {
+			status = http.StatusAccepted
+		}
+		if n := b.unwrittenRows(); n > 0 {
+			unwritten[b.Table.Name] += n
+			if primary {
+				lost += n
+			}
+		}
+	}
+	// secondary 在目标表已接收后写入其他表（见 loadSecondary），未写入该表和 WAL 时返回 false
+	secondary := func(b *SinkBatch, primary bool) bool {
+		writeStatus, ok := app.loadSecondary(c, ep, b)
+		if !ok {
+			unwritten[b.Table.Name] += len(b.Lines)
+			if primary {
+				lost += len(b.Lines)
+			}
+			return false
+		}
+		written(b, writeStatus, primary)
+		return true
+	}
+	if ep.WritesDoris() {
+		// 迁移表结构期间写入临时表，dual_write 时随后同样写入目标表；响应中的 label 为临时表的写入
+		primary, original := app.migrationTargets(ep)
+		dorisBatch := batch
+		if primary != ep.Table() {
+			dorisBatch = &SinkBatch{Table: primary, Priority: priority, Lines: lines, Body: raw}
+		}
+		if r := app.rollups[ep.Name]; r != nil {
+			// 预聚合端点的主表只写入聚合结果，事件计入内存中的窗口即可
+			r.Add(now, events)
+		} else if bulk != nil && bulk.mode == bulkModeAtomic {
+			if !app.loadAtomic(c, ep, dorisBatch) {
+				return
+			}
+			batch.Label = dorisBatch.Label
+		} else if ep.Split != nil {
+			splitStatus, splitFailed, ok := app.loadSplit(c, ep, dorisBatch, events)
+			if !ok {
+				return
+			}
+			written(dorisBatch, splitStatus, true)
+			if splitFailed > 0 {
+				unwritten[ep.Split.Sink] += splitFailed
+				lost += splitFailed
+			}
+			batch.Label, batch.Chunks = dorisBatch.Label, dorisBatch.Chunks
+		} else if len(lateLines) == 0 {
+			loadStatus, ok := app.loadDoris(c, ep, dorisBatch)
+			if !ok {
+				return
+			}
+			written(dorisBatch, loadStatus, true)
+			batch.Label, batch.Chunks = dorisBatch.Label, dorisBatch.Chunks
+		} else {
+			// 迟到事件写入修正表，其余事件写入目标表；响应中的 label 为目标表的写入
+			late := &SinkBatch{Table: ep.Late.Table(), Priority: priority, Lines: lateLines}
+			if len(onTimeLines) > 0 {
+				onTime := &SinkBatch{Table: primary, Priority: priority, Lines: onTimeLines}
+				loadStatus, ok := app.loadDoris(c, ep, onTime)
+				if !ok {
+					return
+				}
+				written(onTime, loadStatus, true)
+				batch.Label, batch.Chunks = onTime.Label, onTime.Chunks
+				// 目标表已接收，迟到事件写入失败时请求不能再返回错误，否则客户端重试会重复写入目标表
+				if secondary(late, true) {
+					ep.Late.routed.Add(int64(len(lateLines)))
+				}
+			} else {
+				loadStatus, ok := app.loadDoris(c, ep, late)
+				if !ok {
+					return
+				}
+				written(late, loadStatus, true)
+				ep.Late.routed.Add(int64(len(lateLines)))
+			}
+		}
+
+		// 临时表已接收，目标表写入失败时同双写表写入 WAL，不返回错误
+		if original != nil {
+			originalLines := lines
+			if len(lateLines) > 0 {
+				originalLines = onTimeLines
+			}
+			if len(originalLines) > 0 {
+				secondary(&SinkBatch{Table: original, Priority: priority, Lines: originalLines}, false)
+			}
+		}
+
+		// 双写表在主表接收后依次写入，失败时写入 WAL；WAL 也不可用时请求返回部分写入的响应，不能返回错误让客户端重试
+		for j, dw := range ep.DualWrite {
+			secondary(&SinkBatch{Table: dw.Table(), Priority: priority, Lines: dualLines[j]}, false)
+		}
+	}
+
+	// best_effort 输出目标在 Doris 接收事件后在后台写入
+	app.writeBestEffortSinks(c.Request.Context(), ep, batch)
+
+	// 事件和幂等键的标记行已同步写入 WAL，幂等键写入文件失败时重启后从 WAL 恢复
+	if idemKey != "" {
+		if err := app.journal.Record(ep, idemKey, time.Now()); err != nil {
+			app.logger.Error("记录幂等键失败", "endpoint", ep.Name, "error", err)
+		}
+	}
+	committed = true
+	if quotaStatus != nil {
+		if remaining := quotaStatus.Remaining(); remaining >= 0 {
+			c.Header("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+			app.quota.SetHeaders(c.Writer.Header(), quotaStatus, time.Now())
+		}
+	}
+	c.Set(acceptedRowsKey, len(events)-lost)
+	if len(unwritten) > 0 {
+		respondPartial(c, bulk, status == http.StatusAccepted, batch.Label, len(events)-lost, unwritten, batch.Chunks)
+		return
+	}
+	if bulk != nil {
+		respondBulk(c, ep, bulk, status == http.StatusAccepted, batch.Label, len(events)-lost, batch.Chunks)
+		return
+	}
+	respondAccepted(c, ep, status == http.StatusAccepted, batch.Label)
+}
+
+// respondAccepted 按端点的 response 配置写出成功响应，buffered 表示事件已写入 WAL、尚未写入 Doris
+// 有 label 时无论响应体如何都通过 X-Load-Label 响应头返回
+func respondAccepted(c *gin.Context, ep *Endpoint, buffered bool, label string) {
+	if label != "" {
+		c.Header(loadLabelHeader, label)
+	}
+	status, body := http.StatusOK, responseBodyMessage
+	if buffered {
+		status = http.StatusAccepted
+	}
+	if rc := ep.Response; rc != nil {
+		if rc.Status != 0 {
+			status = rc.Status
+		}
+		body = rc.Body
+	}
+
+	switch body {
+	case responseBodyEmpty:
+		c.Status(status)
+	case responseBodyLabel:
+		// 写入 WAL 或端点不写入 Doris 时没有 label
+		resp := gin.H{"buffered": buffered}
+		if label != "" {
+			resp["label"] = label
+		}
+		c.JSON(status, resp)
+	default:
+		message := "Data processed successfully."
+		if buffered {
+			message = "Data accepted and buffered."
+		}
+		c.JSON(status, gin.H{"message": message})
+	}
+}
+
+// respondPartial 目标表已接收、部分事件未能写入其他表和 WAL 时的响应，unwritten 为各表未写入的事件数，chunks 为目标表拆分写入的各分片
+// 仍返回 2xx，避免客户端重试重复写入目标表；响应体不受端点 response 配置影响
+func respondPartial(c *gin.Context, bulk *bulkResult, buffered bool, label string, accepted int, unwritten map[string]int, chunks []loadChunk) {
+	status := http.StatusOK
+	if buffered {
+		status = http.StatusAccepted
+	}
+	resp := gin.H{
+		"message":   "Data partially processed: some events were not written.",
+		"partial":   true,
+		"buffered":  buffered,
+		"unwritten": unwritten,
+	}
+	if label != "" {
+		c.Header(loadLabelHeader, label)
+		resp["label"] = label
+	}
+	if chunks != nil {
+		resp["chunks"] = chunks
+	}
+	if bulk != nil && bulk.mode == bulkModePartial {
+		resp["accepted"] = accepted
+		resp["rejected"] = bulk.rejected
+		if bulk.rejected == nil {
+			resp["rejected"] = []bulkRejection{}
+		}
+	}
+	c.JSON(status, resp)
+}
+
+// marshalLine 将行序列化为以换行符结尾的 JSON
+func marshalLine(row map[string]any) ([]byte, error) {
+	line, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// loadDoris 将事件写入 Doris，返回接收状态（200 已写入，202 已写入 WAL）
+// 降级模式下事件全部写入 WAL；表或端点暂停、背压时低优先级事件按策略写入 WAL 或被拒绝。
+// 写入失败时已写出错误响应，返回 false
+func (app *App) loadDoris(c *gin.Context, ep *Endpoint, batch *SinkBatch) (int, bool) {
+	table := batch.Table
+
+	// 请求日志端点的事件同步写入 WAL，由回放写入 Doris
+	if ep.Journal {
+		if err := app.journalAppend(c, table, dorisload.JoinLines(batch.Lines)); err != nil {
+			app.logger.Error("写入请求日志失败", "table", table.Name, "error", err)
+			abortWithRetry(c, http.StatusServiceUnavailable, errCodeDependencyUnavailable, "Journal unavailable, please retry later", time.Second, retryStrategyExponential, nil)
+			return 0, false
+		}
+		return http.StatusAccepted, true
+	}
+
+	// 降级模式下事件全部写入 WAL，待 Doris 恢复后回放
+	if app.degraded.Load() {
+		if app.spill(table, dorisload.JoinLines(batch.Lines)) {
+			return http.StatusAccepted, true
+		}
+		abortWithRetry(c, http.StatusServiceUnavailable, errCodeDorisUnavailable, "Service unavailable, please retry later", app.recheck, retryStrategyExponential, nil)
+		return 0, false
+	}
+
+	// 暂停写入的表或端点：按 PAUSE_POLICY 写入 WAL（恢复后回放）或拒绝
+	if app.toggles.Paused(ep, table.Name) {
+		if app.toggles.pausePolicy == pausePolicySpill && app.spill(table, dorisload.JoinLines(batch.Lines)) {
+			return http.StatusAccepted, true
+		}
+		abortWithRetry(c, http.StatusServiceUnavailable, errCodeIngestionPaused, "Ingestion paused, please retry later", time.Minute, retryStrategyFixed, gin.H{
+			"endpoint": ep.Name,
+			"table":    table.Name,
+		})
+		return 0, false
+	}
+
+	// 背压或开启 shed_low_priority 时低优先级事件落盘或被丢弃，高优先级事件继续写入
+	if batch.Priority == PriorityLow && (app.toggles.ShedLowPriority() || app.underPressure(table)) {
+		return app.shedOrSpill(c, batch)
+	}
+
+	err := app.sinks[dorisSinkName].Write(c.Request.Context(), batch)
+	if err == nil {
+		if batch.chunksBuffered() {
+			return http.StatusAccepted, true
+		}
+		return http.StatusOK, true
+	}
+
+	switch ctxErr := c.Request.Context().Err(); {
+	case errors.Is(ctxErr, context.DeadlineExceeded):
+		app.logger.Warn("写入 Doris 超时", "table", table.Name, "error", err)
+		abortWithError(c, http.StatusGatewayTimeout, errCodeTimeout, "Request timed out", nil)
+		return 0, false
+	case errors.Is(ctxErr, context.Canceled):
+		// 客户端已断开，无需返回响应体
+		app.logger.Info("客户端已断开，取消写入", "table", table.Name)
+		c.AbortWithStatus(statusClientClosedRequest)
+		return 0, false
+	}
+	if errors.Is(err, dorisload.ErrShuttingDown) {
+		// 重试会被负载均衡转发到其他实例或升级后的新进程
+		abortWithRetry(c, http.StatusServiceUnavailable, errCodeShuttingDown, "Service shutting down, please retry later", time.Second, retryStrategyFixed, nil)
+		return 0, false
+	}
+	if errors.Is(err, dorisload.ErrOverloaded) {
+		if batch.Priority == PriorityLow {
+			return app.shedOrSpill(c, batch)
+		}
+		abortWithRetry(c, http.StatusServiceUnavailable, errCodeOverloaded, "Service overloaded, please retry later", time.Second, retryStrategyExponential, nil)
+		return 0, false
+	}
+	app.logger.Error("写入 Doris 失败", "table", table.Name, "error", err)
+	abortWithError(c, http.StatusBadGateway, errCodeDorisUnavailable, fmt.Sprintf("Doris connection failed: %v", err), nil)
+	return 0, false
+}
+
+// loadSecondary 在目标表已接收同一请求的事件后写入其他表（迟到事件的修正表、迁移期间的原目标表、双写表），不写出错误响应：
+// 此时请求不能再返回错误，否则客户端重试会重复写入目标表。降级、暂停或写入 Doris 失败时事件写入 WAL 等待回放（返回 202）；
+// WAL 也不可用时返回 false，事件未写入该表
+func (app *App) loadSecondary(c *gin.Context, ep *Endpoint, batch *SinkBatch) (int, bool) {
+	table := batch.Table
+	data := dorisload.JoinLines(batch.Lines)
+	if ep.Journal {
+		if err := app.journalAppend(c, table, data); err != nil {
+			app.logger.Error("目标表已接收，事件写入请求日志失败，未写入该表", "endpoint", ep.Name, "table", table.Name, "rows", len(batch.Lines), "error", err)
+			return 0, false
+		}
+		return http.StatusAccepted, true
+	}
+	if !app.degraded.Load() && !app.toggles.Paused(ep, table.Name) {
+		err := app.sinks[dorisSinkName].Write(c.Request.Context(), batch)
+		if err == nil {
+			if batch.chunksBuffered() {
+				return http.StatusAccepted, true
+			}
+			return http.StatusOK, true
+		}
+		app.logger.Warn("目标表已接收，写入其他表失败，写入 WAL", "endpoint", ep.Name, "table", table.Name, "error", err)
+	}
+	if app.wal != nil && app.spill(table, data) {
+		return http.StatusAccepted, true
+	}
+	app.logger.Error("目标表已接收，事件未写入该表和 WAL", "endpoint", ep.Name, "table", table.Name, "rows", len(batch.Lines))
+	return 0, false
+}
+
+// underPressure 判断目标表的 Doris 写入是否处于背压状态
+func (app *App) underPressure(table *dorisload.Table) bool {
+	if b := app.batchers[table.Name]; b != nil {
+		return b.Pressured()
+	}
+	return app.limiter.Inflight() >= app.limiter.lowLimit
+}
+
+// load 写入事件：启用批量写入时合并到批次，否则直接 Stream Load，超过单次 Stream Load 上限时拆分为多个事务；写入使用的 label 写入 batch
+// 直接写入拆分后部分分片失败时，已提交的分片不能撤回，失败的分片写入 WAL 等待回放，不返回错误（否则重试会重复写入已提交的分片），
+// 各分片的结果写入 batch.Chunks。写入成功后排队复制到表所属集群的副本集群，并更新表的水位
+func (app *App) load(ctx context.Context, batch *SinkBatch) error {
+	table := batch.Table
+	if b := app.batchers[table.Name]; b != nil {
+		data := dorisload.JoinLines(batch.Lines)
+		label, err := b.Submit(ctx, data)
+		batch.Label = label
+		if err == nil {
+			app.committed(table, data)
+		}
+		return err
+	}
+
+	release, ok := app.limiter.Acquire(ctx, batch.Priority)
+	if !ok {
+		return dorisload.ErrOverloaded
+	}
+	defer release()
+	chunks := app.clusters.For(table).WriteLinesSplit(ctx, table, uuid.New().String(), batch.Lines, app.logger)
+	var firstErr error
+	committedChunks := 0
+	results := make([]loadChunk, len(chunks))
+	for i, ch := range chunks {
+		results[i] = loadChunk{Label: ch.Label, Rows: ch.End - ch.Start}
+		if ch.Err != nil {
+			results[i].Error = ch.Err.Error()
+			if firstErr == nil {
+				firstErr = ch.Err
+			}
+			continue
+		}
+		committedChunks++
+		app.committed(table, dorisload.JoinLines(batch.Lines[ch.Start:ch.End]))
+	}
+	if len(chunks) > 0 {
+		batch.Label = chunks[0].Label
+	}
+	if len(chunks) > 1 {
+		batch.Chunks = results
+	}
+	// 全部成功，或没有分片提交（客户端可以安全重试）时按整体成功或失败处理
+	if firstErr == nil || committedChunks == 0 {
+		return firstErr
+	}
+
+	for i, ch := range chunks {
+		if ch.Err == nil {
+			continue
+		}
+		if app.wal != nil && app.spill(table, dorisload.JoinLines(batch.Lines[ch.Start:ch.End])) {
+			results[i].Buffered = true
+			continue
+		}
+		app.logger.Error("拆分写入的分片失败，且未写入 WAL", "table", table.Name, "label", ch.Label, "rows", ch.End-ch.Start, "error", ch.Err)
+	}
+	return nil
+}
+
+// loadChunk 直接写入拆分为多个 Stream Load 事务时一个分片的结果，随批量请求的响应返回
+type loadChunk struct {
+	Label    string `json:"label"`
+	Rows     int    `json:"rows"`
+	Error    string `json:"error,omitempty"`
+	Buffered bool   `json:"buffered,omitempty"` // 写入失败后已写入 WAL 等待回放
+}
+
+// committed 写入 Doris 成功后调用：排队复制到副本集群并更新表的水位
+func (app *App) committed(table *dorisload.Table, data []byte) {
+	app.replicas.Replicate(table, data)
+	app.watermarks.Observe(table, data)
+}
+
+// shedOrSpill 按低优先级策略处理背压下的事件：写入 WAL 返回 202，或写出 503 响应并返回 false
+func (app *App) shedOrSpill(c *gin.Context, batch *SinkBatch) (int, bool) {
+	if app.priorities.lowPolicy == lowPriorityPolicySpill && app.spill(batch.Table, dorisload.JoinLines(batch.Lines)) {
+		return http.StatusAccepted, true
+	}
+	abortWithRetry(c, http.StatusServiceUnavailable, errCodeOverloaded, "Service overloaded, please retry later", time.Second, retryStrategyExponential, nil)
+	return 0, false
+}
+
+// spill 将事件写入 WAL，失败时返回 false
+func (app *App) spill(table *dorisload.Table, data []byte) bool {
+	if err := app.wal.Append(table.Name, data); err != nil {
+		app.logger.Error("写入 WAL 失败", "error", err)
+		return false
+	}
+	return true
 }
 
 func main() {
+	checkOnly := flag.Bool("check", false, "校验配置、端点定义并解析 BE 域名后退出，不启动服务")
+	flag.Parse()
+
 	// 设置时区为香港时间（东八区）
 	loc, err := time.LoadLocation("Asia/Hong_Kong")
 	if err != nil {
@@ -411,70 +1217,541 @@ func main() {
 	}
 	time.Local = loc
 
+	// 运行环境（APP_ENV）调整日志级别等默认值，需要在初始化日志之前加载
+	profileErr := loadProfile()
+
+	// 子命令 test-transforms：用夹具校验端点的列映射后退出
+	if flag.Arg(0) == testTransformsCommand {
+		if profileErr != nil {
+			fmt.Fprintf(os.Stderr, "运行环境配置错误: %v\n", profileErr)
+			os.Exit(1)
+		}
+		os.Exit(runTestTransforms(flag.Args()[1:], os.Stdout))
+	}
+	// 子命令 decrypt、reencrypt：处理加密的 WAL 段和归档文件后退出
+	if cmd := flag.Arg(0); cmd == decryptCommand || cmd == reencryptCommand {
+		if profileErr != nil {
+			fmt.Fprintf(os.Stderr, "运行环境配置错误: %v\n", profileErr)
+			os.Exit(1)
+		}
+		os.Exit(runCryptCommand(cmd, flag.Args()[1:], os.Stdout, os.Stderr))
+	}
+
 	// 初始化日志记录器
 	logger := initLogger()
+	if profileErr != nil {
+		logger.Error("运行环境配置错误", "error", profileErr)
+		os.Exit(1)
+	}
+	if appEnv != "" {
+		logger.Info("运行环境", "app_env", appEnv, "defaults", slices.Sorted(maps.Keys(profileEnv)))
+	}
+	info := buildInfo()
+	logger.Info("版本信息", "version", info.Version, "commit", info.Commit, "build_date", info.BuildDate, "go_version", info.GoVersion)
 	logger.Info("时区设置", "timezone", time.Local.String())
 
+	if *checkOnly {
+		os.Exit(runCheck(logger))
+	}
+
+	// 平滑升级需要在可执行文件被替换之前解析其路径
+	upg, err := newUpgrader(logger)
+	if err != nil {
+		logger.Error("平滑升级配置错误", "error", err)
+		os.Exit(1)
+	}
+
 	// 加载配置
 	cfg, err := loadConfig()
 	if err != nil {
-		logger.Error("配置错误", "error", err)
+		logConfigErrors(logger.Error, "配置错误", err)
+		os.Exit(1)
+	}
+
+	// 加载端点注册表
+	registry, err := loadRegistry()
+	if err != nil {
+		logger.Error("端点配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	geoip, err := newGeoIP(registry)
+	if err != nil {
+		logger.Error("GeoIP 配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	abuse, err := newAbuseGuard(logger)
+	if err != nil {
+		logger.Error("滥用检测配置错误", "error", err)
+		os.Exit(1)
+	}
+	nonces, err := newNonceStore()
+	if err != nil {
+		logger.Error("请求签名配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	uploads, err := newUploader(registry)
+	if err != nil {
+		logger.Error("文件上传配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	jobs, err := newJobScheduler(registry, logger)
+	if err != nil {
+		logger.Error("定时任务配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	sdk, err := newSDKConfigServer(registry)
+	if err != nil {
+		logger.Error("SDK 远程配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	debug, err := newDebugLog(registry, cfg.DebugMaxBytes, logger)
+	if err != nil {
+		logger.Error("调试日志配置错误", "error", err)
+		os.Exit(1)
+	}
+	schema, err := newSchemaSampler()
+	if err != nil {
+		logger.Error("字段采样配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	// 初始化配额
+	quota, err := newQuotaManager()
+	if err != nil {
+		logger.Error("配额配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	// 初始化 WAL、并发限制和优先级规则
+	wal, err := newWAL(logger)
+	if err != nil {
+		logger.Error("WAL 配置错误", "error", err)
+		os.Exit(1)
+	}
+	journal, err := newJournal(registry, wal, logger)
+	if err != nil {
+		logger.Error("请求日志配置错误", "error", err)
+		os.Exit(1)
+	}
+	limiter, err := newLoadLimiter()
+	if err != nil {
+		logger.Error("并发限制配置错误", "error", err)
+		os.Exit(1)
+	}
+	priorities, err := newPriorityRules(wal != nil)
+	if err != nil {
+		logger.Error("优先级配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	toggles, err := newToggles(registry, wal != nil, logger)
+	if err != nil {
+		logger.Error("运行时开关配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	hedge, err := newHedgePolicy()
+	if err != nil {
+		logger.Error("对冲写入配置错误", "error", err)
+		os.Exit(1)
+	}
+	healthCheck, err := newHealthCheck()
+	if err != nil {
+		logger.Error("BE 健康检查配置错误", "error", err)
+		os.Exit(1)
+	}
+	// 故障注入只在 chaos 构建中启用，包装发往 BE 的请求
+	faults := newFaultInjector(logger)
+	if faults != nil {
+		// DORIS_MOCK 时故障注入包装在模拟 BE 之外
+		inner := cfg.WrapTransport
+		cfg.WrapTransport = func(next http.RoundTripper) http.RoundTripper {
+			if inner != nil {
+				next = inner(next)
+			}
+			return faults.wrap(next)
+		}
+	}
+	if getEnv("DORIS_MOCK", "false") == "true" {
+		logger.Warn("DORIS_MOCK 已启用，事件不会写入 Doris")
+	}
+	// 审计记录每次 Stream Load 尝试
+	audit, err := newAuditLog(logger)
+	if err != nil {
+		logger.Error("审计配置错误", "error", err)
+		os.Exit(1)
+	}
+	if audit != nil {
+		cfg.OnAttempt = audit.Record
+	}
+	// 按调用方统计每天的用量
+	usage, err := newUsageMeter(logger)
+	if err != nil {
+		logger.Error("用量统计配置错误", "error", err)
+		os.Exit(1)
+	}
+	// METRICS_BACKEND 为 statsd、dogstatsd 时推送指标，Stream Load 的耗时作为 timer
+	statsd, err := newStatsD(logger)
+	if err != nil {
+		logger.Error("StatsD 配置错误", "error", err)
+		os.Exit(1)
+	}
+	if statsd != nil {
+		onAttempt := cfg.OnAttempt
+		cfg.OnAttempt = func(ctx context.Context, at *dorisload.Attempt) {
+			if onAttempt != nil {
+				onAttempt(ctx, at)
+			}
+			statsd.Attempt(ctx, at)
+		}
+		cfg.OnTrace = statsd.Trace
+	}
+	// 耗时直方图的 exemplar 关联调用方的追踪
+	cfg.ExemplarTraceID, err = newExemplars()
+	if err != nil {
+		logger.Error("指标 exemplar 配置错误", "error", err)
+		os.Exit(1)
+	}
+	// 连接池大小按写入并发上限推导
+	cfg.Pool.Concurrency = cap(limiter.slots)
+	// 每个 Doris 集群一个客户端，目标表写入所属集群；审计表位于 default 集群
+	clusters, err := newClusters(cfg, registry, hedge)
+	if err != nil {
+		logger.Error("Doris 集群配置错误", "error", err)
+		os.Exit(1)
+	}
+	if audit != nil {
+		audit.Start(clusters.Default())
+	}
+	if usage != nil {
+		usage.Start(clusters.Default())
+	}
+	clusters.StartDNSRefresh(logger)
+	if healthCheck != nil {
+		if err := clusters.StartHealthCheck(*healthCheck, logger); err != nil {
+			logger.Error("BE 健康检查配置错误", "error", err)
+			os.Exit(1)
+		}
+	}
+	batchers, err := newBatchers(clusters.For, limiter, registry.DorisTables(), logger)
+	if err != nil {
+		logger.Error("批量写入配置错误", "error", err)
 		os.Exit(1)
 	}
 
 	// 创建应用实例
 	app := &App{
-		config:      cfg,
-		logger:      logger,
-		dorisClient: NewDorisClient(cfg),
+		config:     cfg,
+		logger:     logger,
+		clusters:   clusters,
+		registry:   registry,
+		quota:      quota,
+		limiter:    limiter,
+		priorities: priorities,
+		wal:        wal,
+		batchers:   batchers,
+		geoip:      geoip,
+		nonces:     nonces,
+		abuse:      abuse,
+		faults:     faults,
+		uploads:    uploads,
+		jobs:       jobs,
+		sdk:        sdk,
+		audit:      audit,
+		debug:      debug,
+		toggles:    toggles,
+		hashKey:    getEnv("DORIS_BE_HASH_KEY", ""),
+		schema:     schema,
+		journal:    journal,
+		usage:      usage,
+	}
+
+	// 初始化输出目标，写入结果计入健康历史
+	history, err := loadHistoryConfig()
+	if err != nil {
+		logger.Error("健康历史配置错误", "error", err)
+		os.Exit(1)
+	}
+	sinks, err := newSinks(registry.Sinks, cfg, history, logger)
+	if err != nil {
+		logger.Error("输出目标配置错误", "error", err)
+		os.Exit(1)
 	}
+	sinks[dorisSinkName] = newCountingSink(dorisSinkName, &dorisSink{app: app}, history, logger)
+
+	// 双集群复制：写入主集群的数据（含 WAL 回放）尽力写入副本集群，失败的写入在副本积压中独立回放
+	replicas, err := newReplicator(registry, clusters, wal, logger)
+	if err != nil {
+		logger.Error("集群复制配置错误", "error", err)
+		os.Exit(1)
+	}
+	app.replicas = replicas
+	app.watermarks = newWatermarks(registry)
+	app.sinks = sinks
+	app.rollups = newRollups(registry, app.writeRollup, logger)
+
+	// 后台回放 WAL，回放按低优先级申请槽位，背压时自动暂停；暂停写入的表不回放
+	if wal != nil {
+		wal.paused = toggles.TablePaused
+		wal.committed = app.committed
+	}
+	walCtx, stopWAL := context.WithCancel(context.Background())
+
+	// 启动预检：检查 BE 可达且凭证有效
+	if err := app.runPreflight(walCtx); err != nil {
+		logger.Error("启动预检失败", "error", err)
+		os.Exit(1)
+	}
+	prewarmCtx, cancelPrewarm := context.WithTimeout(walCtx, 10*time.Second)
+	clusters.Prewarm(prewarmCtx, logger)
+	cancelPrewarm()
+
+	walDone := make(chan struct{})
+	go func() {
+		defer close(walDone)
+		if wal != nil {
+			wal.Run(walCtx, clusters.For, registry, func(ctx context.Context) (func(), bool) {
+				return limiter.Acquire(ctx, PriorityLow)
+			})
+		}
+	}()
+
+	// 定时补录任务
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		if jobs != nil {
+			jobs.Run(jobsCtx, app)
+		}
+	}()
+
+	// 预聚合：窗口结束后写入聚合结果
+	rollupCtx, stopRollups := context.WithCancel(context.Background())
+	var rollupWG sync.WaitGroup
+	for _, r := range app.rollups {
+		rollupWG.Add(1)
+		go func() {
+			defer rollupWG.Done()
+			r.Run(rollupCtx)
+		}()
+	}
+
+	// 定期推送 gauge 和 counter 到 StatsD
+	statsdCtx, stopStatsD := context.WithCancel(context.Background())
+	statsdDone := make(chan struct{})
+	go func() {
+		defer close(statsdDone)
+		if statsd != nil {
+			statsd.Run(statsdCtx, func() string { return app.renderMetrics(false) })
+		}
+	}()
 
 	// 打印配置信息
 	logger.Info("Doris 配置",
 		"be_http", cfg.BEHTTP,
 		"database", cfg.DB,
 		"user", cfg.User,
-		"password", maskPassword(cfg.Passwd),
-		"table", videoTable)
+		"password", maskPassword(cfg.Passwd))
+	for _, cc := range registry.Clusters {
+		logger.Info("Doris 集群", "name", cc.Name, "be_http", cc.BEHTTP, "database", defaultString(cc.Database, cfg.DB), "user", defaultString(cc.User, cfg.User), "tls", cc.TLS != nil)
+	}
+	if wal != nil {
+		logger.Info("WAL 配置", "store", wal.storeKind(), "fsync", wal.fsync, "durability", walFsyncTradeoffs[wal.fsync])
+	}
+	for _, ep := range registry.Endpoints {
+		logger.Info("事件端点", "name", ep.Name, "path", ep.Path, "bulk_path", ep.BulkPath, "table", ep.TableName, "cluster", ep.Cluster, "priority", ep.priority)
+	}
 
 	// 设置路由
-	router := app.setupRouter()
+	router, err := app.setupRouter()
+	if err != nil {
+		logger.Error("路由配置错误", "error", err)
+		os.Exit(1)
+	}
+
+	// 监听地址（主机为空时双栈监听）和超时
+	server, err := loadServerConfig()
+	if err != nil {
+		logConfigErrors(logger.Error, "HTTP 服务配置错误", err)
+		os.Exit(1)
+	}
 
 	// 创建 HTTP 服务器
 	srv := &http.Server{
-		Addr:           listenPort,
+		Addr:           server.addr,
 		Handler:        router,
-		ReadTimeout:    readTimeout,
-		WriteTimeout:   writeTimeout,
-		IdleTimeout:    idleTimeout,
-		MaxHeaderBytes: maxHeaderBytes,
+		ReadTimeout:    server.readTimeout,
+		WriteTimeout:   server.writeTimeout,
+		IdleTimeout:    server.idleTimeout,
+		MaxHeaderBytes: server.maxHeaderBytes,
+	}
+
+	// 启用 HTTP/2（TLS）和 h2c（明文）
+	tlsCert, tlsKey := getEnv("TLS_CERT_FILE", ""), getEnv("TLS_KEY_FILE", "")
+	tlsEnabled := tlsCert != "" && tlsKey != ""
+	if err := configureHTTP2(srv, tlsEnabled); err != nil {
+		logger.Error("HTTP/2 配置失败", "error", err)
+		os.Exit(1)
+	}
+
+	// 通过 systemd socket activation 启动时使用传入的监听 socket
+	listener, err := systemdListener()
+	if err != nil {
+		logger.Error("systemd socket 初始化失败", "error", err)
+		os.Exit(1)
+	}
+
+	if listener == nil {
+		// 平滑升级启动时使用旧进程传入的监听 socket
+		if listener, err = upgradeListener(); err != nil {
+			logger.Error("继承监听 socket 失败", "error", err)
+			os.Exit(1)
+		}
+		if listener != nil {
+			logger.Info("使用旧进程传入的监听 socket", "addr", listener.Addr().String(), "parent_pid", upgradeParentPID())
+		}
+	} else {
+		logger.Info("使用 systemd socket activation 传入的监听 socket", "addr", listener.Addr().String())
+	}
+	if listener == nil {
+		// 先绑定端口，保证发送 READY=1 时已可接收连接
+		listener, err = net.Listen(server.network, srv.Addr)
+		if err != nil {
+			logger.Error("服务器启动失败", "error", err)
+			os.Exit(1)
+		}
 	}
 
 	// 在 goroutine 中启动服务器
 	go func() {
-		logger.Info("服务器启动", "port", listenPort, "health_check", fmt.Sprintf("http://localhost%s/health", listenPort))
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("服务器启动", "addr", listener.Addr().String(), "health_check", localURL(listener.Addr(), "/health"))
+		var err error
+		if tlsEnabled {
+			err = srv.ServeTLS(listener, tlsCert, tlsKey)
+		} else {
+			err = srv.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("服务器启动失败", "error", err)
 			os.Exit(1)
 		}
 	}()
 
-	// 等待中断信号以优雅关闭服务器
+	// 通知 systemd（平滑升级时同时通知旧进程）服务已就绪，并启动 watchdog
+	if upgradeParentPID() != 0 {
+		if err := notifyUpgradeReady(); err != nil {
+			logger.Error("平滑升级就绪通知失败", "error", err)
+			os.Exit(1)
+		}
+	} else if _, err := sdNotify("READY=1"); err != nil {
+		logger.Warn("systemd 就绪通知失败", "error", err)
+	}
+	stopWatchdog := startSystemdWatchdog(logger)
+
+	// 等待中断信号以优雅关闭服务器；SIGHUP 触发平滑升级，新进程就绪后当前进程优雅关闭
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	upgraded := false
+	for sig := range quit {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		logger.Info("收到 SIGHUP，开始平滑升级")
+		// 新进程就绪前后两个进程同时接收请求，共用幂等键文件
+		if app.journal != nil {
+			app.journal.Share()
+		}
+		if err := upg.Upgrade(listener); err != nil {
+			logger.Error("平滑升级失败，继续由当前进程提供服务", "error", err)
+			if app.journal != nil {
+				app.journal.Unshare()
+			}
+			continue
+		}
+		upgraded = true
+		break
+	}
 
-	logger.Info("正在关闭服务器...")
+	logger.Info("正在关闭服务器...", "upgraded", upgraded)
+	// 升级后 systemd 的主进程已切换为新进程，不能再发送 STOPPING=1
+	if !upgraded {
+		if _, err := sdNotify("STOPPING=1"); err != nil {
+			logger.Warn("systemd 停止通知失败", "error", err)
+		}
+	}
+	stopWatchdog()
 
 	// 创建超时上下文，用于优雅关闭
-	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), server.shutdownTimeout)
 	defer cancel()
 
 	// 优雅关闭服务器
 	if err := srv.Shutdown(ctx); err != nil {
-		logger.Error("服务器强制关闭", "error", err)
-		os.Exit(1)
+		// 超过关闭等待时间，中止进行中的 Doris 写入，未完成的请求返回 503
+		logger.Error("服务器强制关闭，中止进行中的 Doris 写入", "error", err)
+		clusters.Close()
+	}
+
+	// 停止定时补录任务，进行中的文件在下次运行时重新拉取，已提交的分块被去重
+	stopJobs()
+	<-jobsDone
+
+	// 写入内存中的聚合结果，写入经过批量写入器，需要在其关闭之前完成
+	stopRollups()
+	rollupWG.Wait()
+
+	// 写入批次中剩余的事件
+	for _, b := range batchers {
+		b.Close()
+	}
+
+	// 等待后台输出目标写入完成并关闭
+	app.closeSinks()
+
+	// 停止 WAL 回放并封存当前段，未回放的数据在下次启动后继续回放
+	stopWAL()
+	<-walDone
+	if wal != nil {
+		if err := wal.Close(); err != nil {
+			logger.Error("封存 WAL 段失败", "error", err)
+		}
+	}
+	if journal != nil {
+		if err := journal.Close(); err != nil {
+			logger.Error("关闭幂等键文件失败", "error", err)
+		}
+	}
+
+	// 主集群的写入全部结束后写完副本队列，剩余积压在下次启动后回放
+	if err := replicas.Close(); err != nil {
+		logger.Error("封存副本积压失败", "error", err)
 	}
 
+	// 所有写入结束后写入剩余的审计记录
+	if audit != nil {
+		if err := audit.Close(); err != nil {
+			logger.Error("关闭审计日志失败", "error", err)
+		}
+	}
+
+	if usage != nil {
+		usage.Close()
+	}
+
+	// 推送最后一次指标
+	stopStatsD()
+	<-statsdDone
+
+	if geoip != nil {
+		geoip.Close()
+	}
+	clusters.Close()
 	logger.Info("服务器已优雅关闭")
 }
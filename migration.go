@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"doris-webhook/dorisload"
+)
+
+// errNoMigration 端点没有配置 migration
+var errNoMigration = errors.New("endpoint has no migration table")
+
+// MigrationConfig 端点在线修改表结构期间使用的临时表，列与端点的目标表相同
+// 通过 /admin/migration/start 将端点的 Doris 写入改写到临时表，/admin/migration/finish 切回目标表
+type MigrationConfig struct {
+	TableName string `yaml:"table" json:"table"`
+
+	table *dorisload.Table
+}
+
+// Table 返回临时表
+func (mc *MigrationConfig) Table() *dorisload.Table {
+	return mc.table
+}
+
+// MigrationToggle 正在迁移的端点，保存在运行时开关中
+type MigrationToggle struct {
+	Endpoint  string `json:"endpoint"`
+	DualWrite bool   `json:"dual_write"` // 同时写入目标表，默认只写入临时表
+}
+
+// migrationTargets 返回端点当前的 Doris 写入目标：迁移期间为临时表，dual_write 时 original 为目标表，否则为 nil
+func (app *App) migrationTargets(ep *Endpoint) (primary, original *dorisload.Table) {
+	m, ok := app.toggles.Migration(ep.Name)
+	if !ok || ep.Migration == nil {
+		return ep.Table(), nil
+	}
+	if m.DualWrite {
+		return ep.Migration.Table(), ep.Table()
+	}
+	return ep.Migration.Table(), nil
+}
+
+// migrationStartHandler 开始迁移：端点的 Doris 写入改写到临时表
+// 请求体为 {"endpoint": "...", "dual_write": false}
+func (app *App) migrationStartHandler(c *gin.Context) {
+	var req MigrationToggle
+	if err := c.ShouldBindJSON(&req); err != nil || req.Endpoint == "" {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body: endpoint is required", nil)
+		return
+	}
+	rt, err := app.toggles.SetMigration(req.Endpoint, &req, "")
+	app.respondToggles(c, rt, err)
+}
+
+// migrationFinishHandler 结束迁移：端点的 Doris 写入切回目标表
+// pause 为 true 时在同一次修改中暂停目标表（写入 WAL 的事件也不回放），临时表的数据合并回目标表后再通过 /admin/resume 恢复
+// 请求体为 {"endpoint": "...", "pause": false}
+func (app *App) migrationFinishHandler(c *gin.Context) {
+	var req struct {
+		Endpoint string `json:"endpoint"`
+		Pause    bool   `json:"pause"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Endpoint == "" {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body: endpoint is required", nil)
+		return
+	}
+	pauseTable := ""
+	if req.Pause {
+		for _, ep := range app.registry.Endpoints {
+			if ep.Name == req.Endpoint {
+				pauseTable = ep.TableName
+			}
+		}
+	}
+	rt, err := app.toggles.SetMigration(req.Endpoint, nil, pauseTable)
+	app.respondToggles(c, rt, err)
+}
+
+// validate 校验端点的临时表配置
+func (mc *MigrationConfig) validate(ep *Endpoint) error {
+	if !ep.WritesDoris() || ep.Rollup != nil {
+		return fmt.Errorf("migration 需要端点写入 doris 且不能与 rollup 同时使用")
+	}
+	if mc.TableName == "" || mc.TableName == ep.TableName {
+		return fmt.Errorf("migration.table 必须设置且不能与 table 相同")
+	}
+	return nil
+}
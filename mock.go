@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"doris-webhook/dorisload"
+)
+
+// mockBEHTTP DORIS_MOCK=true 且未设置 DORIS_BE_HTTP 时使用的 BE 地址，请求不会真正发出
+const mockBEHTTP = "127.0.0.1:8040"
+
+// mockDoris 模拟 Doris BE 的 RoundTripper（DORIS_MOCK=true），不连接任何 BE：
+// 健康检查返回 OK，Stream Load 读取请求体后返回成功，两阶段提交的事务操作返回成功。
+// 用于本地开发和演示，写入路径（批量写入、拆分、WAL、审计、指标）与连接真实 Doris 时相同
+type mockDoris struct {
+	txnID atomic.Int64
+}
+
+// wrap 作为 dorisload.Config.WrapTransport 使用，请求不会转发给 next
+func (m *mockDoris) wrap(http.RoundTripper) http.RoundTripper { return m }
+
+func (m *mockDoris) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// 客户端按 100 Continue 和首字节判断 BE 的行为，模拟真实 BE 的响应时序
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil {
+		if req.Header.Get("Expect") == "100-continue" && trace.Got100Continue != nil {
+			trace.Got100Continue()
+		}
+		if trace.GotFirstResponseByte != nil {
+			trace.GotFirstResponseByte()
+		}
+	}
+
+	path := req.URL.Path
+	switch {
+	case path == "/api/health":
+		return mockResponse(req, http.StatusOK, map[string]any{"status": "OK", "msg": "To Be Added"}), nil
+	case strings.HasSuffix(path, "/_stream_load_2pc"):
+		return mockResponse(req, http.StatusOK, map[string]any{"status": dorisload.StatusSuccess, "msg": "transaction [" + req.Header.Get("txn_id") + "] " + req.Header.Get("txn_operation") + " successfully."}), nil
+	case strings.HasSuffix(path, "/_stream_load"):
+		rows := int64(bytes.Count(body, []byte("\n")))
+		if len(body) > 0 && body[len(body)-1] != '\n' {
+			rows++
+		}
+		resp := dorisload.StreamLoadResponse{
+			TxnID:            m.txnID.Add(1),
+			Label:            req.Header.Get("label"),
+			Comment:          "mock",
+			Status:           dorisload.StatusSuccess,
+			Message:          "OK",
+			NumberTotalRows:  rows,
+			NumberLoadedRows: rows,
+			LoadBytes:        int64(len(body)),
+			LoadTimeMs:       1,
+		}
+		if req.Header.Get("two_phase_commit") == "true" {
+			resp.TwoPhaseCommit = "true"
+		}
+		if req.Header.Get("group_commit") != "" {
+			resp.GroupCommit = true
+		}
+		return mockResponse(req, http.StatusOK, resp), nil
+	}
+	return mockResponse(req, http.StatusNotFound, map[string]any{"status": "FAILED", "msg": "not found"}), nil
+}
+
+func mockResponse(req *http.Request, status int, v any) *http.Response {
+	body, _ := json.Marshal(v)
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:      "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+		Header: http.Header{
+			"Content-Type": {"application/json; charset=utf-8"},
+			"Date":         {time.Now().UTC().Format(http.TimeFormat)},
+		},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
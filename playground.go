@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"doris-webhook/dorisload"
+)
+
+// playgroundRequest /admin/playground 的请求体
+type playgroundRequest struct {
+	Endpoint string            `json:"endpoint"`
+	Payload  json.RawMessage   `json:"payload"`           // 示例请求体
+	Columns  []ColumnMapping   `json:"columns,omitempty"` // 替代端点的列映射试验修改，格式同配置文件，为空时使用端点的列映射
+	Headers  map[string]string `json:"headers,omitempty"` // source 为 header 的列读取的请求头
+	Now      string            `json:"now,omitempty"`     // 接收时间（RFC3339），默认为当前时间
+}
+
+// playgroundTarget 转换得到的一行及其 Stream Load 请求
+type playgroundTarget struct {
+	Table      string         `json:"table"`
+	Row        map[string]any `json:"row"`
+	Line       string         `json:"line"` // 写入 Doris 的 NDJSON 行
+	StreamLoad *playgroundReq `json:"stream_load,omitempty"`
+}
+
+// playgroundReq 写入使用的 Stream Load 请求，label 为示例
+type playgroundReq struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// playgroundHandler POST /admin/playground：按端点的列映射（或请求中试验的列映射）转换示例请求体，
+// 返回转换后的行和写入 Doris 的 Stream Load 请求头，不写入任何输出目标，用于交互式调试端点配置
+// 请求体被拒绝时返回 200，rejected 为写入接口会返回的状态码和错误
+func (app *App) playgroundHandler(c *gin.Context) {
+	var req playgroundRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Endpoint == "" || len(req.Payload) == 0 {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body: endpoint and payload are required", nil)
+		return
+	}
+	i := slices.IndexFunc(app.registry.Endpoints, func(ep *Endpoint) bool { return ep.Name == req.Endpoint })
+	if i < 0 {
+		abortWithError(c, http.StatusNotFound, errCodeNotFound, "Endpoint not found", gin.H{"endpoint": req.Endpoint})
+		return
+	}
+	ep := app.registry.Endpoints[i]
+	now := time.Now()
+	if req.Now != "" {
+		t, err := time.Parse(time.RFC3339, req.Now)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid now: must be RFC3339", nil)
+			return
+		}
+		now = t
+	}
+
+	// 试验的列映射在独立的注册表中校验，不影响正在使用的端点
+	table := ep.Table()
+	if len(req.Columns) > 0 {
+		probe := *ep
+		probe.Columns = req.Columns
+		scratch := &Registry{tables: make(map[string]*dorisload.Table), tableClusters: make(map[string]string)}
+		var err error
+		if table, err = scratch.bindColumns(&probe, ep.TableName, probe.Columns); err != nil {
+			abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid columns: "+err.Error(), nil)
+			return
+		}
+		probe.bindStrict()
+		ep = &probe
+	}
+
+	rc := RowContext{Now: now, Header: make(http.Header, len(req.Headers))}
+	for k, v := range req.Headers {
+		rc.Header.Set(k, v)
+	}
+	ev, err := playgroundConvert(ep, req.Payload, rc)
+	if err != nil {
+		status, code, message, details := classifyInvalid(err)
+		rejected := gin.H{"status": status, "code": code, "message": message}
+		if len(details) > 0 {
+			rejected["details"] = details
+		}
+		c.JSON(http.StatusOK, gin.H{"endpoint": ep.Name, "rejected": rejected})
+		return
+	}
+
+	// 迁移期间写入临时表，迟到事件写入修正表，分流的事件写入分流目标
+	resp := gin.H{"endpoint": ep.Name}
+	if ep.Split != nil && ep.Split.routed(stringValue(ev.Row, ep.Split.Key)) {
+		resp["split_sink"] = ep.Split.Sink
+	} else if ep.WritesDoris() {
+		if primary, _ := app.migrationTargets(ep); primary != ep.Table() {
+			table = primary
+		}
+		if ep.Late != nil && ep.Late.isLate(ev.Row, now) {
+			table = ep.Late.Table()
+		}
+	}
+	target, err := app.playgroundTarget(ep, table, ev.Row, resp["split_sink"] == nil)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to marshal data", nil)
+		return
+	}
+	resp["target"] = target
+	var dual []playgroundTarget
+	for j, dw := range ep.DualWrite {
+		t, err := app.playgroundTarget(ep, dw.Table(), ev.Dual[j], true)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to marshal data", nil)
+			return
+		}
+		dual = append(dual, t)
+	}
+	if dual != nil {
+		resp["dual_write"] = dual
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// playgroundConvert 按写入接口的顺序转换请求体：sanitize、解析、strict、列映射和 limits
+func playgroundConvert(ep *Endpoint, payload []byte, rc RowContext) (eventRow, error) {
+	raw, err := ep.sanitizeBody(payload)
+	if err != nil {
+		return eventRow{}, err
+	}
+	body, err := decodeJSONObject(raw)
+	if err != nil {
+		return eventRow{}, err
+	}
+	if err := ep.checkStrict(body); err != nil {
+		return eventRow{}, err
+	}
+	return convertEvent(ep, body, rc)
+}
+
+// playgroundTarget 序列化一行，load 为 true 且端点写入 Doris 时附带单条写入使用的 Stream Load 请求
+func (app *App) playgroundTarget(ep *Endpoint, table *dorisload.Table, row map[string]any, load bool) (playgroundTarget, error) {
+	line, err := marshalLine(row)
+	if err != nil {
+		return playgroundTarget{}, err
+	}
+	t := playgroundTarget{Table: table.Name, Row: row, Line: strings.TrimSuffix(string(line), "\n")}
+	if !load || !ep.WritesDoris() {
+		return t, nil
+	}
+	url, header := app.clusters.Client(ep.Cluster).PreviewRequest(table, uuid.New().String(), dorisload.LoadOptions{})
+	t.StreamLoad = &playgroundReq{URL: url, Headers: make(map[string]string, len(header))}
+	for k := range header {
+		t.StreamLoad.Headers[k] = header.Get(k)
+	}
+	return t, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// runPreflight 执行启动预检
+// 预检失败时：未启用 DEGRADED_START 返回错误（快速失败）；
+// 启用时进入降级模式，事件全部写入 WAL，后台定期重试预检，通过后恢复正常写入
+func (app *App) runPreflight(ctx context.Context) error {
+	if getEnv("PREFLIGHT_ENABLED", "true") != "true" {
+		return nil
+	}
+
+	timeout, err := parsePositiveSeconds("PREFLIGHT_TIMEOUT", "10")
+	if err != nil {
+		return err
+	}
+	retryInterval, err := parsePositiveSeconds("PREFLIGHT_RETRY_INTERVAL", "10")
+	if err != nil {
+		return err
+	}
+	degradedStart := getEnv("DEGRADED_START", "false") == "true"
+	if degradedStart && app.wal == nil {
+		return fmt.Errorf("DEGRADED_START=true 需要设置 WAL_DIR")
+	}
+
+	// 每个集群分别检查，凭证使用该集群的目标表校验
+	check := func() error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		for _, name := range app.clusters.Names() {
+			if err := app.clusters.Client(name).Preflight(ctx, app.registry.DorisTablesIn(name), app.logger); err != nil {
+				return fmt.Errorf("cluster %s: %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	err = check()
+	if err == nil {
+		app.logger.Info("Doris 连接预检通过")
+		return nil
+	}
+	if !degradedStart {
+		return fmt.Errorf("doris 连接预检失败: %w", err)
+	}
+
+	app.logger.Warn("Doris 连接预检失败，以降级模式启动，事件将写入 WAL", "error", err)
+	app.recheck = retryInterval
+	app.degraded.Store(true)
+	go func() {
+		ticker := time.NewTicker(retryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := check(); err != nil {
+				app.logger.Warn("Doris 仍不可用", "error", err)
+				continue
+			}
+			app.degraded.Store(false)
+			app.logger.Info("Doris 已恢复，退出降级模式")
+			return
+		}
+	}()
+	return nil
+}
+
+// parsePositiveSeconds 解析正的时长配置，不带单位时按秒计（见 envDuration）
+func parsePositiveSeconds(key, def string) (time.Duration, error) {
+	return envDuration(key, def, time.Second, time.Millisecond, 0)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"doris-webhook/dorisload"
+)
+
+// Priority 事件优先级
+type Priority int
+
+const (
+	PriorityHigh Priority = iota // 高优先级：背压时继续写入 Doris（如 purchase、error）
+	PriorityLow                  // 低优先级：背压时优先丢弃或落盘到 WAL（如 heartbeat）
+)
+
+const (
+	lowPriorityPolicyShed  = "shed"  // 直接拒绝，返回 503
+	lowPriorityPolicySpill = "spill" // 写入 WAL，稍后回放
+)
+
+// String 返回优先级名称
+func (p Priority) String() string {
+	if p == PriorityLow {
+		return "low"
+	}
+	return "high"
+}
+
+// parsePriority 解析优先级名称
+func parsePriority(s string) (Priority, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "high":
+		return PriorityHigh, nil
+	case "low":
+		return PriorityLow, nil
+	default:
+		return PriorityHigh, fmt.Errorf("无效的优先级: %q（可选 high、low）", s)
+	}
+}
+
+// PriorityRules 事件优先级规则，按事件名覆盖端点的默认优先级
+type PriorityRules struct {
+	events    map[string]Priority
+	lowPolicy string
+}
+
+// newPriorityRules 根据环境变量创建优先级规则
+func newPriorityRules(walEnabled bool) (*PriorityRules, error) {
+	rules := &PriorityRules{
+		events:    make(map[string]Priority),
+		lowPolicy: lowPriorityPolicyShed,
+	}
+	for _, e := range splitList(getEnv("PRIORITY_HIGH_EVENTS", "")) {
+		rules.events[e] = PriorityHigh
+	}
+	for _, e := range splitList(getEnv("PRIORITY_LOW_EVENTS", "")) {
+		rules.events[e] = PriorityLow
+	}
+
+	defaultPolicy := lowPriorityPolicyShed
+	if walEnabled {
+		defaultPolicy = lowPriorityPolicySpill
+	}
+	switch policy := getEnv("LOW_PRIORITY_POLICY", defaultPolicy); policy {
+	case lowPriorityPolicyShed:
+	case lowPriorityPolicySpill:
+		if !walEnabled {
+			return nil, fmt.Errorf("LOW_PRIORITY_POLICY=spill 需要设置 WAL_DIR")
+		}
+		rules.lowPolicy = policy
+	default:
+		return nil, fmt.Errorf("LOW_PRIORITY_POLICY 无效: %q（可选 shed、spill）", policy)
+	}
+	return rules, nil
+}
+
+// For 返回端点上事件的优先级
+func (r *PriorityRules) For(ep *Endpoint, event string) Priority {
+	if p, ok := r.events[event]; ok {
+		return p
+	}
+	return ep.priority
+}
+
+// splitList 解析逗号分隔的列表，忽略空项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// LoadLimiter 限制并发 Stream Load 数量
+// 低优先级事件只能使用前 lowLimit 个并发槽位，剩余容量保留给高优先级事件
+type LoadLimiter struct {
+	slots    chan struct{}
+	lowLimit int64
+	inflight atomic.Int64
+}
+
+// newLoadLimiter 根据环境变量创建并发限制器
+func newLoadLimiter() (*LoadLimiter, error) {
+	maxInflight, err := strconv.Atoi(getEnv("DORIS_MAX_INFLIGHT", strconv.Itoa(dorisload.MaxConnsPerHost)))
+	if err != nil || maxInflight <= 0 {
+		return nil, fmt.Errorf("DORIS_MAX_INFLIGHT 无效: %q", getEnv("DORIS_MAX_INFLIGHT", ""))
+	}
+	lowLimit, err := strconv.Atoi(getEnv("LOW_PRIORITY_MAX_INFLIGHT", strconv.Itoa(maxInflight*8/10)))
+	if err != nil || lowLimit < 0 || lowLimit > maxInflight {
+		return nil, fmt.Errorf("LOW_PRIORITY_MAX_INFLIGHT 无效: %q（应在 0 到 DORIS_MAX_INFLIGHT 之间）", getEnv("LOW_PRIORITY_MAX_INFLIGHT", ""))
+	}
+	return &LoadLimiter{
+		slots:    make(chan struct{}, maxInflight),
+		lowLimit: int64(lowLimit),
+	}, nil
+}
+
+// Acquire 申请一个 Stream Load 槽位
+// 高优先级事件等待空闲槽位直到 ctx 取消；低优先级事件在背压时立即返回 false
+func (l *LoadLimiter) Acquire(ctx context.Context, p Priority) (release func(), ok bool) {
+	if p == PriorityLow {
+		// 检查和计数用 CAS 一次完成，否则并发的低优先级请求可能同时通过检查，超过 lowLimit
+		for {
+			n := l.inflight.Load()
+			if n >= l.lowLimit {
+				return nil, false
+			}
+			if l.inflight.CompareAndSwap(n, n+1) {
+				break
+			}
+		}
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.inflight.Add(-1)
+			return nil, false
+		}
+	} else {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, false
+		}
+		l.inflight.Add(1)
+	}
+
+	var once atomic.Bool
+	return func() {
+		if once.CompareAndSwap(false, true) {
+			l.inflight.Add(-1)
+			<-l.slots
+		}
+	}, true
+}
+
+// Inflight 返回当前进行中的 Stream Load 数量
+func (l *LoadLimiter) Inflight() int64 {
+	return l.inflight.Load()
+}
+
+// Capacity 返回并发 Stream Load 上限（DORIS_MAX_INFLIGHT）
+func (l *LoadLimiter) Capacity() int {
+	return cap(l.slots)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 内置的运行环境，通过 APP_ENV 选择
+const (
+	profileDev     = "dev"
+	profileStaging = "staging"
+	profileProd    = "prod"
+)
+
+// builtinProfiles 各运行环境调整的默认值，只在对应环境变量未设置时生效
+var builtinProfiles = map[string]map[string]string{
+	// 本地开发：debug 日志并记录请求数据，模拟 Doris（不需要 BE 和密码），放行所有跨域源
+	profileDev: {
+		"LOG_LEVEL":              "debug",
+		"LOG_FORMAT":             "text",
+		"DEBUG":                  "true",
+		"DORIS_MOCK":             "true",
+		"CORS_ALLOWED_ORIGIN":    "*",
+		"CORS_ALLOW_CREDENTIALS": "false",
+	},
+	// 预发：JSON 日志，连接真实 Doris
+	profileStaging: {
+		"LOG_LEVEL":  "debug",
+		"LOG_FORMAT": "json",
+	},
+	// 生产：JSON 日志，不记录请求数据，不允许 DORIS_MOCK
+	profileProd: {
+		"LOG_LEVEL":  "info",
+		"LOG_FORMAT": "json",
+		"DEBUG":      "false",
+	},
+}
+
+// ProfileConfig 配置文件中一个运行环境的覆盖项
+type ProfileConfig struct {
+	// Env 环境变量的默认值，覆盖内置环境的默认值，进程的环境变量仍然优先
+	Env map[string]string `yaml:"env" json:"env"`
+}
+
+// 当前运行环境，loadProfile 在初始化日志之前设置，之后只读
+var (
+	appEnv     string            // APP_ENV，未设置时为空
+	profileEnv map[string]string // 当前运行环境提供的默认值，由 getEnv 读取
+)
+
+// loadProfile 按 APP_ENV 加载运行环境：内置默认值叠加配置文件 profiles 中同名环境的 env
+// 未设置 APP_ENV 时不调整任何默认值；APP_ENV 和 CONFIG_FILE 只能通过进程的环境变量设置
+func loadProfile() error {
+	name := os.Getenv("APP_ENV")
+	if name == "" {
+		return nil
+	}
+	builtin, ok := builtinProfiles[name]
+
+	var profiles map[string]*ProfileConfig
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		var err error
+		if profiles, err = readProfiles(path); err != nil {
+			return err
+		}
+	}
+	if !ok && profiles[name] == nil {
+		known := slices.Sorted(maps.Keys(builtinProfiles))
+		for p := range profiles {
+			if builtinProfiles[p] == nil {
+				known = append(known, p)
+			}
+		}
+		return fmt.Errorf("APP_ENV 无效: %q（可选 %s）", name, strings.Join(known, "、"))
+	}
+
+	env := maps.Clone(builtin)
+	if env == nil {
+		env = make(map[string]string)
+	}
+	if p := profiles[name]; p != nil {
+		for key, value := range p.Env {
+			if key == "APP_ENV" || key == "CONFIG_FILE" {
+				return fmt.Errorf("profiles.%s.env: %s 只能通过环境变量设置", name, key)
+			}
+			env[key] = value
+		}
+	}
+	mock := os.Getenv("DORIS_MOCK")
+	if mock == "" {
+		mock = env["DORIS_MOCK"]
+	}
+	if name == profileProd && mock == "true" {
+		return fmt.Errorf("APP_ENV=prod 时不能启用 DORIS_MOCK")
+	}
+	appEnv, profileEnv = name, env
+	return nil
+}
+
+// readProfiles 只读取配置文件中的 profiles，其余部分由 loadRegistry 校验
+func readProfiles(path string) (map[string]*ProfileConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	var fc struct {
+		Profiles map[string]*ProfileConfig `yaml:"profiles"`
+	}
+	if err := yaml.Unmarshal(raw, &fc); err != nil {
+		return nil, fmt.Errorf("解析配置文件的 profiles 失败: %w", err)
+	}
+	return fc.Profiles, nil
+}
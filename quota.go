@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	quotaKeyPrefix = "doris-webhook:quota:"
+	quotaDaily     = "daily"
+	quotaMonthly   = "monthly"
+	// quotaSweepEvery 清理统计接口中计数周期已结束的项目的间隔
+	quotaSweepEvery = time.Minute
+)
+
+// QuotaStore 配额计数存储
+type QuotaStore interface {
+	// Get 获取计数，key 不存在时返回 0
+	Get(ctx context.Context, key string) (int64, error)
+	// GetMany 一次获取多个计数，结果与 keys 一一对应，不存在的 key 为 0
+	GetMany(ctx context.Context, keys []string) ([]int64, error)
+	// IncrBy 增加计数并设置过期时间，返回增加后的值
+	IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+}
+
+// memoryQuotaStore 进程内配额计数（单实例部署或未配置 Redis 时使用）
+type memoryQuotaStore struct {
+	mu       sync.Mutex
+	counters map[string]memoryQuotaCounter
+}
+
+type memoryQuotaCounter struct {
+	value    int64
+	expireAt time.Time
+}
+
+func newMemoryQuotaStore() *memoryQuotaStore {
+	return &memoryQuotaStore{counters: make(map[string]memoryQuotaCounter)}
+}
+
+func (s *memoryQuotaStore) Get(_ context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[key]
+	if !ok || time.Now().After(c.expireAt) {
+		return 0, nil
+	}
+	return c.value, nil
+}
+
+func (s *memoryQuotaStore) GetMany(_ context.Context, keys []string) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	values := make([]int64, len(keys))
+	for i, key := range keys {
+		if c, ok := s.counters[key]; ok && !now.After(c.expireAt) {
+			values[i] = c.value
+		}
+	}
+	return values, nil
+}
+
+func (s *memoryQuotaStore) IncrBy(_ context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	c, ok := s.counters[key]
+	if !ok || now.After(c.expireAt) {
+		c = memoryQuotaCounter{expireAt: now.Add(ttl)}
+		// 新周期开始时顺带清理过期计数
+		for k, v := range s.counters {
+			if now.After(v.expireAt) {
+				delete(s.counters, k)
+			}
+		}
+	}
+	c.value += n
+	s.counters[key] = c
+	return c.value, nil
+}
+
+// redisQuotaStore 基于 Redis 的配额计数，多副本共享
+type redisQuotaStore struct {
+	client *redis.Client
+}
+
+func (s *redisQuotaStore) Get(ctx context.Context, key string) (int64, error) {
+	v, err := s.client.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (s *redisQuotaStore) GetMany(ctx context.Context, keys []string) ([]int64, error) {
+	values := make([]int64, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	raw, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range raw {
+		str, ok := v.(string)
+		if !ok {
+			continue // key 不存在
+		}
+		if values[i], err = strconv.ParseInt(str, 10, 64); err != nil {
+			return nil, fmt.Errorf("配额计数 %s 无效: %w", keys[i], err)
+		}
+	}
+	return values, nil
+}
+
+func (s *redisQuotaStore) IncrBy(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	pipe := s.client.TxPipeline()
+	incr := pipe.IncrBy(ctx, key, n)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// quotaLimit 配额上限，0 表示不限制
+type quotaLimit struct {
+	Daily   int64
+	Monthly int64
+}
+
+// QuotaStatus 项目配额使用情况
+type QuotaStatus struct {
+	Project      string    `json:"project"`
+	DailyLimit   int64     `json:"daily_limit"`
+	DailyUsed    int64     `json:"daily_used"`
+	DailyReset   time.Time `json:"daily_reset_at"`
+	MonthlyLimit int64     `json:"monthly_limit"`
+	MonthlyUsed  int64     `json:"monthly_used"`
+	MonthlyReset time.Time `json:"monthly_reset_at"`
+}
+
+// Remaining 返回各周期中最小的剩余配额，未设置限制时返回 -1
+func (s *QuotaStatus) Remaining() int64 {
+	_, remaining, _ := s.binding()
+	return remaining
+}
+
+// binding 返回剩余配额最少的周期的上限、剩余和重置时间，剩余相同时取日配额；未设置限制时剩余为 -1
+func (s *QuotaStatus) binding() (limit, remaining int64, reset time.Time) {
+	remaining = -1
+	periods := []struct {
+		limit, used int64
+		reset       time.Time
+	}{
+		{s.DailyLimit, s.DailyUsed, s.DailyReset},
+		{s.MonthlyLimit, s.MonthlyUsed, s.MonthlyReset},
+	}
+	for _, p := range periods {
+		if p.limit <= 0 {
+			continue
+		}
+		if r := max(p.limit-p.used, 0); remaining < 0 || r < remaining {
+			limit, remaining, reset = p.limit, r, p.reset
+		}
+	}
+	return limit, remaining, reset
+}
+
+// 限流响应头的格式，由 RATELIMIT_HEADERS 选择
+const (
+	rateLimitHeadersX     = "x"     // X-RateLimit-Limit/Remaining/Reset，Reset 为 Unix 时间戳（秒）
+	rateLimitHeadersDraft = "draft" // IETF 草案的 RateLimit-Limit/Remaining/Reset，Reset 为距离重置的秒数
+	rateLimitHeadersBoth  = "both"
+	rateLimitHeadersNone  = "none"
+)
+
+// rateLimitHeaderNames 限流响应头，跨域响应中允许浏览器 SDK 读取
+var rateLimitHeaderNames = []string{
+	"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset",
+	"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+}
+
+// SetHeaders 按 RATELIMIT_HEADERS 输出剩余配额最少的周期的限流响应头，SDK 可据此在触发 429 之前自行降速
+func (qm *QuotaManager) SetHeaders(h http.Header, s *QuotaStatus, now time.Time) {
+	limit, remaining, reset := s.binding()
+	if remaining < 0 {
+		return
+	}
+	limitValue, remainingValue := strconv.FormatInt(limit, 10), strconv.FormatInt(remaining, 10)
+	if qm.headers == rateLimitHeadersX || qm.headers == rateLimitHeadersBoth {
+		h.Set("X-RateLimit-Limit", limitValue)
+		h.Set("X-RateLimit-Remaining", remainingValue)
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	}
+	if qm.headers == rateLimitHeadersDraft || qm.headers == rateLimitHeadersBoth {
+		h.Set("RateLimit-Limit", limitValue)
+		h.Set("RateLimit-Remaining", remainingValue)
+		h.Set("RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(max(reset.Sub(now), 0).Seconds())), 10))
+	}
+}
+
+// QuotaManager 按 project 统计已接收行数并执行日/月配额
+type QuotaManager struct {
+	store     QuotaStore
+	defaults  quotaLimit
+	overrides map[string]quotaLimit
+	headers   string // 限流响应头的格式：x、draft、both、none
+
+	mu        sync.Mutex
+	projects  map[string]time.Time // 本实例在当前计数周期内写入过的项目 → 周期结束时间，用于统计接口
+	lastSweep time.Time
+}
+
+// newQuotaManager 根据环境变量创建配额管理器，未配置任何配额时返回 nil
+func newQuotaManager() (*QuotaManager, error) {
+	daily, err := strconv.ParseInt(getEnv("QUOTA_DAILY_LIMIT", "0"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("QUOTA_DAILY_LIMIT 无效: %w", err)
+	}
+	monthly, err := strconv.ParseInt(getEnv("QUOTA_MONTHLY_LIMIT", "0"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("QUOTA_MONTHLY_LIMIT 无效: %w", err)
+	}
+	overrides, err := parseQuotaOverrides(getEnv("QUOTA_PROJECT_LIMITS", ""))
+	if err != nil {
+		return nil, err
+	}
+	if daily <= 0 && monthly <= 0 && len(overrides) == 0 {
+		return nil, nil
+	}
+	headers := getEnv("RATELIMIT_HEADERS", rateLimitHeadersX)
+	switch headers {
+	case rateLimitHeadersX, rateLimitHeadersDraft, rateLimitHeadersBoth, rateLimitHeadersNone:
+	default:
+		return nil, fmt.Errorf("RATELIMIT_HEADERS 无效: %q（可选 x、draft、both、none）", headers)
+	}
+
+	qm := &QuotaManager{
+		store:     newMemoryQuotaStore(),
+		defaults:  quotaLimit{Daily: daily, Monthly: monthly},
+		overrides: overrides,
+		headers:   headers,
+		projects:  make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+
+	if addr := getEnv("QUOTA_REDIS_ADDR", ""); addr != "" {
+		db, err := strconv.Atoi(getEnv("QUOTA_REDIS_DB", "0"))
+		if err != nil {
+			return nil, fmt.Errorf("QUOTA_REDIS_DB 无效: %w", err)
+		}
+		qm.store = &redisQuotaStore{client: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: getEnv("QUOTA_REDIS_PASSWORD", ""),
+			DB:       db,
+		})}
+	}
+	return qm, nil
+}
+
+// parseQuotaOverrides 解析项目级配额，格式：project:daily:monthly,...
+// 例如 "game-a:100000:2000000,game-b:5000:0"，0 表示该周期不限制
+func parseQuotaOverrides(raw string) (map[string]quotaLimit, error) {
+	overrides := make(map[string]quotaLimit)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("QUOTA_PROJECT_LIMITS 格式错误: %q（应为 project:daily:monthly）", item)
+		}
+		daily, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("QUOTA_PROJECT_LIMITS 日配额无效: %q", item)
+		}
+		monthly, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("QUOTA_PROJECT_LIMITS 月配额无效: %q", item)
+		}
+		overrides[parts[0]] = quotaLimit{Daily: daily, Monthly: monthly}
+	}
+	return overrides, nil
+}
+
+// limitFor 获取项目配额上限
+func (qm *QuotaManager) limitFor(project string) quotaLimit {
+	if l, ok := qm.overrides[project]; ok {
+		return l
+	}
+	return qm.defaults
+}
+
+// quotaPeriods 返回当前日/月的计数 key 后缀和重置时间
+func quotaPeriods(now time.Time) (dayKey, monthKey string, dayReset, monthReset time.Time) {
+	y, m, d := now.Date()
+	dayReset = time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+	monthReset = time.Date(y, m+1, 1, 0, 0, 0, 0, now.Location())
+	return now.Format("20060102"), now.Format("200601"), dayReset, monthReset
+}
+
+func quotaKey(project, period, suffix string) string {
+	return quotaKeyPrefix + project + ":" + period + ":" + suffix
+}
+
+// quotaCounter 项目一个周期的计数
+type quotaCounter struct {
+	period string
+	key    string
+	limit  int64
+	ttl    time.Duration // 周期结束后多保留一小时，避免时钟偏差导致提前过期
+	used   *int64        // 写回 QuotaStatus 的用量字段
+}
+
+// counters 返回项目设置了上限的周期的计数
+func (qm *QuotaManager) counters(status *QuotaStatus, now time.Time) []quotaCounter {
+	dayKey, monthKey, _, _ := quotaPeriods(now)
+	var counters []quotaCounter
+	if status.DailyLimit > 0 {
+		counters = append(counters, quotaCounter{quotaDaily, quotaKey(status.Project, quotaDaily, dayKey), status.DailyLimit, status.DailyReset.Sub(now) + time.Hour, &status.DailyUsed})
+	}
+	if status.MonthlyLimit > 0 {
+		counters = append(counters, quotaCounter{quotaMonthly, quotaKey(status.Project, quotaMonthly, monthKey), status.MonthlyLimit, status.MonthlyReset.Sub(now) + time.Hour, &status.MonthlyUsed})
+	}
+	return counters
+}
+
+// newStatus 返回项目当前周期的配额上限和重置时间，用量为 0
+func (qm *QuotaManager) newStatus(project string, now time.Time) *QuotaStatus {
+	limit := qm.limitFor(project)
+	_, _, dayReset, monthReset := quotaPeriods(now)
+	return &QuotaStatus{
+		Project:      project,
+		DailyLimit:   limit.Daily,
+		DailyReset:   dayReset,
+		MonthlyLimit: limit.Monthly,
+		MonthlyReset: monthReset,
+	}
+}
+
+// Reserve 为项目预留 rows 行配额：先增加各周期的计数，增加后超过任一周期的上限时撤销增加，返回超限的周期
+// 检查基于存储的原子自增，多个副本共享 Redis 计数时不会同时通过检查；写入失败时调用 Release 归还
+// 超限时返回的用量为本次请求之前的值
+func (qm *QuotaManager) Reserve(ctx context.Context, project string, rows int64) (*QuotaStatus, string, error) {
+	now := time.Now()
+	status := qm.newStatus(project, now)
+	counters := qm.counters(status, now)
+	for i, ct := range counters {
+		used, err := qm.store.IncrBy(ctx, ct.key, rows, ct.ttl)
+		if err != nil {
+			qm.undo(ctx, counters[:i], rows)
+			return nil, "", err
+		}
+		*ct.used = used
+		if used <= ct.limit {
+			continue
+		}
+		qm.undo(ctx, counters[:i+1], rows)
+		for _, done := range counters[:i+1] {
+			*done.used -= rows
+		}
+		// 未检查到的周期读取当前用量，用于限流响应头
+		for _, rest := range counters[i+1:] {
+			if *rest.used, err = qm.store.Get(ctx, rest.key); err != nil {
+				return nil, "", err
+			}
+		}
+		return status, ct.period, nil
+	}
+	qm.track(project, quotaLimit{Daily: status.DailyLimit, Monthly: status.MonthlyLimit}, now, status.DailyReset, status.MonthlyReset)
+	return status, "", nil
+}
+
+// Release 归还 Reserve 预留的配额，用于写入失败或 dry-run 的请求
+func (qm *QuotaManager) Release(ctx context.Context, project string, rows int64) error {
+	now := time.Now()
+	return qm.undo(ctx, qm.counters(qm.newStatus(project, now), now), rows)
+}
+
+// undo 撤销各计数增加的 rows
+func (qm *QuotaManager) undo(ctx context.Context, counters []quotaCounter, rows int64) error {
+	var errs []error
+	for _, ct := range counters {
+		if _, err := qm.store.IncrBy(ctx, ct.key, -rows, ct.ttl); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// track 记录项目在当前计数周期内有用量，供统计接口列出
+// 只记录到有配额的最长周期结束为止，过期的项目定期清理；周期内（设置了月配额时为整月）仍会随客户端传入的 project 增长
+func (qm *QuotaManager) track(project string, limit quotaLimit, now, dayReset, monthReset time.Time) {
+	if _, ok := qm.overrides[project]; ok {
+		return // 已配置的项目始终列出
+	}
+	var until time.Time
+	switch {
+	case limit.Monthly > 0:
+		until = monthReset
+	case limit.Daily > 0:
+		until = dayReset
+	default:
+		return // 不计数的项目没有用量可列出
+	}
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if now.Sub(qm.lastSweep) >= quotaSweepEvery {
+		for p, exp := range qm.projects {
+			if !now.Before(exp) {
+				delete(qm.projects, p)
+			}
+		}
+		qm.lastSweep = now
+	}
+	qm.projects[project] = until
+}
+
+// Snapshot 返回已配置的项目和本实例在当前计数周期内写入过的项目的配额使用情况
+// 所有计数通过一次 GetMany 读取
+func (qm *QuotaManager) Snapshot(ctx context.Context) ([]*QuotaStatus, error) {
+	now := time.Now()
+	qm.mu.Lock()
+	names := make(map[string]struct{}, len(qm.projects)+len(qm.overrides))
+	for p, exp := range qm.projects {
+		if now.Before(exp) {
+			names[p] = struct{}{}
+		}
+	}
+	qm.mu.Unlock()
+	for p := range qm.overrides {
+		names[p] = struct{}{}
+	}
+
+	projects := make([]string, 0, len(names))
+	for p := range names {
+		projects = append(projects, p)
+	}
+	sort.Strings(projects)
+
+	dayKey, monthKey, dayReset, monthReset := quotaPeriods(now)
+	statuses := make([]*QuotaStatus, 0, len(projects))
+	keys := make([]string, 0, 2*len(projects))
+	for _, p := range projects {
+		limit := qm.limitFor(p)
+		statuses = append(statuses, &QuotaStatus{
+			Project:      p,
+			DailyLimit:   limit.Daily,
+			DailyReset:   dayReset,
+			MonthlyLimit: limit.Monthly,
+			MonthlyReset: monthReset,
+		})
+		keys = append(keys, quotaKey(p, quotaDaily, dayKey), quotaKey(p, quotaMonthly, monthKey))
+	}
+	values, err := qm.store.GetMany(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range statuses {
+		if s.DailyLimit > 0 {
+			s.DailyUsed = values[2*i]
+		}
+		if s.MonthlyLimit > 0 {
+			s.MonthlyUsed = values[2*i+1]
+		}
+	}
+	return statuses, nil
+}
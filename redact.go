@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// redactedValue 替换敏感值的占位符
+const redactedValue = "[REDACTED]"
+
+// defaultRedactKeys 默认脱敏的字段名（忽略大小写、- 和 _），用于日志属性名、JSON 字段、查询参数和请求头
+var defaultRedactKeys = []string{
+	"authorization", "proxy-authorization", "cookie", "set-cookie",
+	"password", "passwd", "pwd", "secret", "token", "access_token", "refresh_token",
+	"api_key", "apikey", "x-api-key", "access_key", "secret_key", "signature", "x-signature",
+}
+
+var (
+	// redactJSONPattern JSON 中的 "字段": 值（字符串值或到下一个分隔符为止的标量）
+	redactJSONPattern = regexp.MustCompile(`"([^"\\]{1,64})"\s*:\s*("(?:[^"\\]|\\.)*"|[^,{}\[\]\s"]+)`)
+	// redactQueryPattern 查询字符串或表单中的 字段=值
+	redactQueryPattern = regexp.MustCompile(`(^|[?&\s])([A-Za-z0-9_.-]{1,64})=([^&\s"]*)`)
+	// redactBearerPattern Authorization 头的凭证
+	redactBearerPattern = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]{8,}`)
+	// redactHeaderPattern 请求转储中的 请求头: 值 行
+	redactHeaderPattern = regexp.MustCompile(`(?m)^([A-Za-z0-9-]{1,64}):[ \t]*([^\r\n]*)`)
+)
+
+// redactor 日志脱敏：在 slog 输出前替换所有日志行中的敏感字段
+// 属性名为敏感字段时整个值被替换；字符串和错误中的 JSON 字段、查询参数和 Bearer/Basic 凭证被替换，
+// 覆盖访问日志的查询字符串、Debug 级别输出的 Stream Load 数据和 Doris 返回的错误响应体
+type redactor struct {
+	keys map[string]bool // 归一化后的字段名
+}
+
+// newRedactor 创建日志脱敏器，LOG_REDACT_FIELDS 追加需要脱敏的请求体字段（逗号分隔）
+func newRedactor() *redactor {
+	r := &redactor{keys: make(map[string]bool)}
+	for _, k := range append(defaultRedactKeys, splitList(getEnv("LOG_REDACT_FIELDS", ""))...) {
+		r.keys[normalizeRedactKey(k)] = true
+	}
+	return r
+}
+
+// normalizeRedactKey 字段名忽略大小写、- 和 _
+func normalizeRedactKey(key string) string {
+	return strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(key))
+}
+
+// sensitive 判断字段名是否需要脱敏
+func (r *redactor) sensitive(key string) bool {
+	return r.keys[normalizeRedactKey(key)]
+}
+
+// ReplaceAttr 作为 slog.HandlerOptions.ReplaceAttr，对每个属性（含 With 添加的属性）脱敏
+func (r *redactor) ReplaceAttr(_ []string, a slog.Attr) slog.Attr {
+	if r.sensitive(a.Key) {
+		return slog.String(a.Key, redactedValue)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if s := a.Value.String(); s != "" {
+			a.Value = slog.StringValue(r.redactString(s))
+		}
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case http.Header:
+			a.Value = slog.AnyValue(r.redactHeader(v))
+		case error:
+			a.Value = slog.StringValue(r.redactString(v.Error()))
+		case []byte:
+			a.Value = slog.StringValue(r.redactString(string(v)))
+		case fmt.Stringer:
+			a.Value = slog.StringValue(r.redactString(v.String()))
+		}
+	}
+	return a
+}
+
+// redactHeader 返回敏感请求头被替换后的副本
+func (r *redactor) redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for name := range out {
+		if r.sensitive(name) {
+			out[name] = []string{redactedValue}
+		}
+	}
+	return out
+}
+
+// redactString 替换字符串中的敏感 JSON 字段、查询参数、请求头和凭证
+func (r *redactor) redactString(s string) string {
+	s = redactBearerPattern.ReplaceAllString(s, "$1 "+redactedValue)
+	if strings.Contains(s, ":") {
+		s = r.replaceMatches(s, redactHeaderPattern, 1, func(m []string) string {
+			return m[1] + ": " + redactedValue
+		})
+	}
+	if strings.Contains(s, `"`) {
+		s = r.replaceMatches(s, redactJSONPattern, 1, func(m []string) string {
+			return `"` + m[1] + `":"` + redactedValue + `"`
+		})
+	}
+	if strings.Contains(s, "=") {
+		s = r.replaceMatches(s, redactQueryPattern, 2, func(m []string) string {
+			return m[1] + m[2] + "=" + redactedValue
+		})
+	}
+	return s
+}
+
+// writer 返回对写入内容脱敏的 io.Writer，用于不经过 slog 的输出（如 panic 恢复时转储的请求）
+func (r *redactor) writer(w io.Writer) io.Writer {
+	return redactWriter{w: w, r: r}
+}
+
+type redactWriter struct {
+	w io.Writer
+	r *redactor
+}
+
+func (rw redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, rw.r.redactString(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// replaceMatches 替换 pattern 的匹配中字段名（第 keyGroup 个分组）为敏感字段的部分
+func (r *redactor) replaceMatches(s string, pattern *regexp.Regexp, keyGroup int, replace func(m []string) string) string {
+	var (
+		b    strings.Builder
+		last int
+	)
+	for _, idx := range pattern.FindAllStringSubmatchIndex(s, -1) {
+		m := make([]string, len(idx)/2)
+		for i := range m {
+			if idx[2*i] >= 0 {
+				m[i] = s[idx[2*i]:idx[2*i+1]]
+			}
+		}
+		if !r.sensitive(m[keyGroup]) {
+			continue
+		}
+		b.WriteString(s[last:idx[0]])
+		b.WriteString(replace(m))
+		last = idx[1]
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
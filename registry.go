@@ -0,0 +1,982 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"doris-webhook/dorisload"
+)
+
+// 列取值来源
+const (
+	sourceBody              = "body"               // 请求体字段（默认）
+	sourceIngestTime        = "ingest_time"        // 服务端接收时间
+	sourceIngestDate        = "ingest_date"        // 服务端接收日期，用于 DATE 分区列
+	sourceGeoIPCountry      = "geoip_country"      // 客户端 IP 所在国家/地区的 ISO 代码
+	sourceSDKVersion        = "sdk_version"        // 批量请求信封中的 sdk_version
+	sourceOriginalTimestamp = "original_timestamp" // 客户端记录的事件时间（离线补发），缺失时使用接收时间
+	sourceHeader            = "header"             // 请求头，header 指定名称
+)
+
+// defaultHeaderMaxLength 请求头列未设置 max_length 时保留的最大字符数
+const defaultHeaderMaxLength = 256
+
+// credentialHeaders 携带凭证的请求头，不允许写入列
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key", "X-Signature"}
+
+// dorisDateFormat Doris DATE 格式
+const dorisDateFormat = "2006-01-02"
+
+// dorisDatetimeFormat Doris DATETIME 格式，包含毫秒精度
+const dorisDatetimeFormat = "2006-01-02 15:04:05.000"
+
+// ColumnMapping 请求字段到 Doris 列的映射
+type ColumnMapping struct {
+	Column   string `yaml:"column" json:"column"`                     // Doris 列名
+	Field    string `yaml:"field,omitempty" json:"field,omitempty"`   // 请求体字段名，默认与列名相同
+	Source   string `yaml:"source,omitempty" json:"source,omitempty"` // 取值来源：body、ingest_time
+	Type     string `yaml:"type,omitempty" json:"type,omitempty"`     // 列类型：int、float、datetime、bool、string，未设置时按原样写入
+	Required bool   `yaml:"required,omitempty" json:"required,omitempty"`
+	Default  any    `yaml:"default,omitempty" json:"default,omitempty"` // 字段缺失或无法计算时的默认值
+
+	// datetime 列的时间窗口，如 max_age: 168h、max_future: 5m
+	MaxAge     string `yaml:"max_age,omitempty" json:"max_age,omitempty"`
+	MaxFuture  string `yaml:"max_future,omitempty" json:"max_future,omitempty"`
+	OutOfRange string `yaml:"out_of_range,omitempty" json:"out_of_range,omitempty"` // 超出窗口时 reject（默认）或 clamp
+	// 按批量请求信封的 sent_at 修正客户端时钟偏差
+	CorrectSkew bool `yaml:"correct_skew,omitempty" json:"correct_skew,omitempty"`
+
+	// source 为 header 时的请求头名称（不区分大小写），值去除控制字符后截断到 max_length 个字符（默认 256）
+	Header    string `yaml:"header,omitempty" json:"header,omitempty"`
+	MaxLength int    `yaml:"max_length,omitempty" json:"max_length,omitempty"`
+
+	// 字符串值的最大字节数，默认使用端点 limits.max_field_bytes，超出时按 limits.on_exceed 处理
+	MaxBytes ByteSize `yaml:"max_bytes,omitempty" json:"max_bytes,omitempty"`
+
+	def       any // 按 Type 转换后的默认值
+	maxAge    time.Duration
+	maxFuture time.Duration
+	maxBytes  int  // 生效的字符串字节数上限，0 表示不限制
+	maxFields int  // 对象键数和数组元素数上限，0 表示不限制
+	truncate  bool // 超出时截断而不是拒绝
+	sanitize  *SanitizeConfig
+}
+
+// inputName 返回错误信息中的输入名称：请求头列为请求头名称，其他列为请求体字段名
+func (m *ColumnMapping) inputName() string {
+	if m.Source == sourceHeader {
+		return m.Header
+	}
+	return m.Field
+}
+
+// RowContext 计算列的取值依据
+type RowContext struct {
+	Now        time.Time
+	Country    string        // 客户端 IP 所在国家/地区，端点没有 geoip_country 列时不查询
+	SDKVersion string        // 批量请求信封中的 sdk_version
+	ClockSkew  time.Duration // 服务器时间减去信封的 sent_at
+	Header     http.Header   // 请求头，用于 source 为 header 的列
+}
+
+// CORSConfig 端点的跨域配置，未设置的字段沿用 CORS_* 环境变量
+type CORSConfig struct {
+	Disabled         bool     `yaml:"disabled,omitempty" json:"disabled,omitempty"` // 不输出任何 CORS 响应头
+	Origins          []string `yaml:"origins,omitempty" json:"origins,omitempty"`
+	Methods          []string `yaml:"methods,omitempty" json:"methods,omitempty"`
+	Headers          []string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	ExposeHeaders    []string `yaml:"expose_headers,omitempty" json:"expose_headers,omitempty"` // 除内置响应头外允许浏览器读取的响应头
+	AllowCredentials *bool    `yaml:"allow_credentials,omitempty" json:"allow_credentials,omitempty"`
+	MaxAge           *int     `yaml:"max_age,omitempty" json:"max_age,omitempty"` // 预检缓存时间，单位秒
+}
+
+// 成功响应的响应体
+const (
+	responseBodyMessage = "message" // {"message": "..."}（默认）
+	responseBodyEmpty   = "empty"   // 无响应体
+	responseBodyLabel   = "label"   // {"label": "...", "buffered": false}，label 为写入 Doris 使用的 Stream Load label
+)
+
+// ResponseConfig 端点接收事件后的响应，用于适配对状态码和响应体有固定要求的 SDK
+type ResponseConfig struct {
+	Status int    `yaml:"status,omitempty" json:"status,omitempty"` // 200、201、202 或 204，默认写入 Doris 时为 200、写入 WAL 时为 202
+	Body   string `yaml:"body,omitempty" json:"body,omitempty"`     // message、empty 或 label，状态码为 204 时默认 empty，否则默认 message
+
+	Signing *ResponseSigning `yaml:"signing,omitempty" json:"signing,omitempty"` // 响应签名，默认不签名
+}
+
+// Endpoint 接收端点：请求路径、目标表和字段映射
+type Endpoint struct {
+	Name      string           `yaml:"name" json:"name"`
+	Path      string           `yaml:"path" json:"path"`
+	TableName string           `yaml:"table" json:"table"`
+	Cluster   string           `yaml:"cluster,omitempty" json:"cluster,omitempty"` // 写入的 Doris 集群，默认 default（DORIS_* 环境变量）
+	Priority  string           `yaml:"priority,omitempty" json:"priority,omitempty"`
+	TimeoutMs int              `yaml:"timeout_ms,omitempty" json:"timeout_ms,omitempty"` // 请求超时，默认使用 REQUEST_TIMEOUT_MS
+	BulkPath  string           `yaml:"bulk_path,omitempty" json:"bulk_path,omitempty"`   // 批量写入路径，接收事件数组或信封
+	BulkMode  string           `yaml:"bulk_mode,omitempty" json:"bulk_mode,omitempty"`   // 批量写入模式：reject（默认）、atomic、partial
+	Inputs    []string         `yaml:"inputs,omitempty" json:"inputs,omitempty"`         // 单条写入接受的输入：json（默认）、form、query
+	Columns   []ColumnMapping  `yaml:"columns" json:"columns"`
+	Sinks     []EndpointSink   `yaml:"sinks,omitempty" json:"sinks"` // 输出目标，默认只写入 Doris
+	CORS      *CORSConfig      `yaml:"cors,omitempty" json:"cors,omitempty"`
+	Response  *ResponseConfig  `yaml:"response,omitempty" json:"response,omitempty"` // 成功响应的状态码和响应体，默认 200/202 和 message
+	Debug     *DebugConfig     `yaml:"debug,omitempty" json:"debug,omitempty"`       // 调试日志，默认随 DEBUG
+	Limits    *LimitsConfig    `yaml:"limits,omitempty" json:"limits,omitempty"`     // 事件大小和字段基数限制，默认不限制
+	Sanitize  *SanitizeConfig  `yaml:"sanitize,omitempty" json:"sanitize,omitempty"` // 字符串清理，默认不清理
+	Auth      *AuthConfig      `yaml:"auth,omitempty" json:"auth,omitempty"`         // 鉴权方式，默认不鉴权
+	Shadow    []ShadowConfig   `yaml:"shadow,omitempty" json:"shadow,omitempty"`
+	DualWrite []DualWrite      `yaml:"dual_write,omitempty" json:"dual_write,omitempty"` // 同时写入的其他表，用于版本化端点迁移表结构
+	Rollup    *RollupConfig    `yaml:"rollup,omitempty" json:"rollup,omitempty"`         // 预聚合：目标表写入按窗口和维度聚合的计数，而不是事件行
+	Late      *LateConfig      `yaml:"late,omitempty" json:"late,omitempty"`             // 迟到事件写入修正表，默认与其他事件一起写入目标表
+	Migration *MigrationConfig `yaml:"migration,omitempty" json:"migration,omitempty"`   // 修改表结构期间可通过管理接口将写入改写到临时表
+	Split     *SplitConfig     `yaml:"split,omitempty" json:"split,omitempty"`           // 按路由键将一定比例的事件写入新集群，默认全部写入目标表
+
+	// Credentials 写入目标表（含双写、迟到事件的修正表和迁移用的临时表）使用的 Doris 用户，默认使用集群的用户
+	Credentials *DorisCredentials `yaml:"credentials,omitempty" json:"credentials,omitempty"`
+
+	// Strict 严格模式：请求体中出现没有被任何列映射读取的字段时返回 422，StrictAllow 中的字段除外
+	Strict      bool     `yaml:"strict,omitempty" json:"strict,omitempty"`
+	StrictAllow []string `yaml:"strict_allow,omitempty" json:"strict_allow,omitempty"`
+
+	// Journal 请求日志：事件同步写入 WAL 后返回 202，由 WAL 回放写入 Doris，支持 Idempotency-Key 去重，需要 WAL_DIR
+	Journal bool `yaml:"journal,omitempty" json:"journal,omitempty"`
+
+	table        *dorisload.Table
+	priority     Priority
+	geoip        bool            // 是否包含 geoip_country 列
+	strictFields map[string]bool // 严格模式下允许的字段：列映射读取的字段和 strict_allow
+}
+
+// UsesGeoIP 判断端点是否包含 geoip_country 列
+func (ep *Endpoint) UsesGeoIP() bool {
+	return ep.geoip
+}
+
+// checkStrict 严格模式下检查请求体中是否有未映射的字段，有时返回 unmappedFieldsError
+func (ep *Endpoint) checkStrict(body map[string]any) error {
+	if !ep.Strict {
+		return nil
+	}
+	var unmapped []string
+	for field := range body {
+		if !ep.strictFields[field] {
+			unmapped = append(unmapped, field)
+		}
+	}
+	if len(unmapped) == 0 {
+		return nil
+	}
+	slices.Sort(unmapped)
+	return &unmappedFieldsError{Fields: unmapped}
+}
+
+// bindStrict 严格模式下按列映射和 strict_allow 建立允许的字段
+func (ep *Endpoint) bindStrict() {
+	if !ep.Strict {
+		return
+	}
+	ep.strictFields = make(map[string]bool)
+	for field := range ep.fieldReaders() {
+		ep.strictFields[field] = true
+	}
+	for _, field := range ep.StrictAllow {
+		ep.strictFields[field] = true
+	}
+}
+
+// Table 返回端点写入的目标表
+func (ep *Endpoint) Table() *dorisload.Table {
+	return ep.table
+}
+
+// DualWrite 端点同时写入的另一张表及其列映射（同一请求体按该映射转换）
+type DualWrite struct {
+	TableName string          `yaml:"table" json:"table"`
+	Columns   []ColumnMapping `yaml:"columns" json:"columns"`
+
+	table *dorisload.Table
+}
+
+// Table 返回双写的目标表
+func (dw *DualWrite) Table() *dorisload.Table {
+	return dw.table
+}
+
+// BuildRow 按双写的列映射将请求体转换为行
+func (dw *DualWrite) BuildRow(body map[string]any, rc RowContext) (map[string]any, error) {
+	return buildRow(dw.Columns, body, rc)
+}
+
+// Registry 端点和目标表注册表，校验、转换和写入 Doris 均以此为准
+type Registry struct {
+	Endpoints []*Endpoint
+	Tables    []*dorisload.Table // 按首次出现的顺序排列
+	Sinks     []*SinkConfig
+	Jobs      []*JobConfig     // 定时补录任务，由 JobScheduler 校验
+	SDK       *SDKConfig       // 客户端 SDK 的远程配置，由 SDKConfigServer 校验
+	Clusters  []*ClusterConfig // 配置文件中定义的 Doris 集群，不含 default
+
+	tables           map[string]*dorisload.Table
+	tableClusters    map[string]string            // 表名到所属集群
+	tableCredentials map[string]*DorisCredentials // 表名到写入使用的 Doris 用户，只含端点配置了 credentials 的表
+}
+
+// DorisTables 返回至少有一个端点写入 Doris 的目标表
+func (r *Registry) DorisTables() []*dorisload.Table {
+	var tables []*dorisload.Table
+	for _, t := range r.Tables {
+		for _, ep := range r.Endpoints {
+			if ep.WritesDoris() && ep.writesTable(t) {
+				tables = append(tables, t)
+				break
+			}
+		}
+	}
+	return tables
+}
+
+// UsesGeoIP 判断是否有端点需要 GeoIP 数据库
+func (r *Registry) UsesGeoIP() bool {
+	for _, ep := range r.Endpoints {
+		if ep.geoip {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointByPath 按请求路径（含批量写入路径）查找端点
+func (r *Registry) endpointByPath(path string) *Endpoint {
+	for _, ep := range r.Endpoints {
+		if ep.Path == path || (ep.BulkPath != "" && ep.BulkPath == path) {
+			return ep
+		}
+	}
+	return nil
+}
+
+// Table 按名称查找目标表
+func (r *Registry) Table(name string) (*dorisload.Table, bool) {
+	t, ok := r.tables[name]
+	return t, ok
+}
+
+// TableCluster 返回目标表所属的 Doris 集群，未注册的表（如审计表）属于 default
+func (r *Registry) TableCluster(name string) string {
+	return defaultString(r.tableClusters[name], defaultClusterName)
+}
+
+// DorisTablesIn 返回指定集群中至少有一个端点写入 Doris 的目标表
+func (r *Registry) DorisTablesIn(cluster string) []*dorisload.Table {
+	var tables []*dorisload.Table
+	for _, t := range r.DorisTables() {
+		if r.TableCluster(t.Name) == cluster {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// fileConfig 配置文件结构
+type fileConfig struct {
+	Profiles  map[string]*ProfileConfig `yaml:"profiles"` // 由 loadProfile 读取
+	Clusters  []*ClusterConfig          `yaml:"clusters"`
+	Sinks     []*SinkConfig             `yaml:"sinks"`
+	Endpoints []*Endpoint               `yaml:"endpoints"`
+	Jobs      []*JobConfig              `yaml:"jobs"`
+	SDK       *SDKConfig                `yaml:"sdk"`
+}
+
+// defaultEndpoints 未提供配置文件时的内置端点，与原 /video 接口行为一致
+func defaultEndpoints() []*Endpoint {
+	return []*Endpoint{{
+		Name:      "video",
+		Path:      "/video",
+		TableName: videoTable,
+		Priority:  getEnv("VIDEO_PRIORITY", "high"),
+		Columns: []ColumnMapping{
+			{Column: "project", Required: true},
+			{Column: "event", Required: true},
+			{Column: "user_agent", Field: "userAgent"},
+			{Column: "event_time", Source: sourceIngestTime},
+		},
+	}}
+}
+
+// loadRegistry 从 CONFIG_FILE 加载端点定义，未设置时使用内置端点
+func loadRegistry() (*Registry, error) {
+	path := getEnv("CONFIG_FILE", "")
+	if path == "" {
+		return newRegistry(defaultEndpoints(), nil, nil)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	var fc fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %w", err)
+	}
+	reg, err := newRegistry(fc.Endpoints, fc.Sinks, fc.Clusters)
+	if err != nil {
+		return nil, err
+	}
+	reg.Jobs = fc.Jobs
+	reg.SDK = fc.SDK
+	return reg, nil
+}
+
+// newRegistry 校验端点、输出目标和集群定义并建立注册表
+func newRegistry(endpoints []*Endpoint, sinks []*SinkConfig, clusters []*ClusterConfig) (*Registry, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("至少需要定义一个端点")
+	}
+	sinkNames, err := validateSinks(sinks)
+	if err != nil {
+		return nil, err
+	}
+	clusterNames, err := validateClusters(clusters)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &Registry{
+		Endpoints:        endpoints,
+		Sinks:            sinks,
+		Clusters:         clusters,
+		tables:           make(map[string]*dorisload.Table),
+		tableClusters:    make(map[string]string),
+		tableCredentials: make(map[string]*DorisCredentials),
+	}
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+
+	for i, ep := range endpoints {
+		if ep.Name == "" {
+			return nil, fmt.Errorf("endpoints[%d]: name 必须设置", i)
+		}
+		if names[ep.Name] {
+			return nil, fmt.Errorf("endpoints[%d]: 端点名称重复: %s", i, ep.Name)
+		}
+		names[ep.Name] = true
+		if !strings.HasPrefix(ep.Path, "/") {
+			return nil, fmt.Errorf("endpoint %s: path 必须以 / 开头", ep.Name)
+		}
+		if paths[ep.Path] {
+			return nil, fmt.Errorf("endpoint %s: 路径重复: %s", ep.Name, ep.Path)
+		}
+		paths[ep.Path] = true
+		if ep.BulkPath != "" {
+			if !strings.HasPrefix(ep.BulkPath, "/") {
+				return nil, fmt.Errorf("endpoint %s: bulk_path 必须以 / 开头", ep.Name)
+			}
+			if paths[ep.BulkPath] {
+				return nil, fmt.Errorf("endpoint %s: 路径重复: %s", ep.Name, ep.BulkPath)
+			}
+			paths[ep.BulkPath] = true
+		}
+		switch ep.BulkMode = defaultString(ep.BulkMode, bulkModeReject); ep.BulkMode {
+		case bulkModeReject:
+		case bulkModeAtomic, bulkModePartial:
+			if ep.BulkPath == "" {
+				return nil, fmt.Errorf("endpoint %s: bulk_mode 需要设置 bulk_path", ep.Name)
+			}
+		default:
+			return nil, fmt.Errorf("endpoint %s: bulk_mode 无效: %q（可选 reject、atomic、partial）", ep.Name, ep.BulkMode)
+		}
+		if len(ep.Inputs) == 0 {
+			ep.Inputs = []string{inputJSON}
+		}
+		for j, in := range ep.Inputs {
+			if in != inputJSON && in != inputForm && in != inputQuery {
+				return nil, fmt.Errorf("endpoint %s: inputs 无效: %q（可选 json、form、query）", ep.Name, in)
+			}
+			if slices.Contains(ep.Inputs[:j], in) {
+				return nil, fmt.Errorf("endpoint %s: inputs 重复: %s", ep.Name, in)
+			}
+		}
+		if ep.TableName == "" {
+			return nil, fmt.Errorf("endpoint %s: table 必须设置", ep.Name)
+		}
+		if len(ep.Columns) == 0 {
+			return nil, fmt.Errorf("endpoint %s: 至少需要一个列映射", ep.Name)
+		}
+		if ep.TimeoutMs < 0 {
+			return nil, fmt.Errorf("endpoint %s: timeout_ms 不能为负数", ep.Name)
+		}
+		if ep.CORS != nil && ep.CORS.MaxAge != nil && *ep.CORS.MaxAge < 0 {
+			return nil, fmt.Errorf("endpoint %s: cors.max_age 不能为负数", ep.Name)
+		}
+		if rc := ep.Response; rc != nil {
+			switch rc.Status {
+			case 0, http.StatusOK, http.StatusCreated, http.StatusAccepted:
+			case http.StatusNoContent:
+				rc.Body = defaultString(rc.Body, responseBodyEmpty)
+				if rc.Body != responseBodyEmpty {
+					return nil, fmt.Errorf("endpoint %s: response.status 为 204 时 body 只能为 empty", ep.Name)
+				}
+			default:
+				return nil, fmt.Errorf("endpoint %s: response.status 无效: %d（可选 200、201、202、204）", ep.Name, rc.Status)
+			}
+			rc.Body = defaultString(rc.Body, responseBodyMessage)
+			if rc.Body != responseBodyMessage && rc.Body != responseBodyEmpty && rc.Body != responseBodyLabel {
+				return nil, fmt.Errorf("endpoint %s: response.body 无效: %q（可选 message、empty、label）", ep.Name, rc.Body)
+			}
+		}
+		if ep.Limits != nil {
+			if err := ep.Limits.validate(); err != nil {
+				return nil, fmt.Errorf("endpoint %s: %w", ep.Name, err)
+			}
+		}
+		if ep.Sanitize != nil {
+			if err := ep.Sanitize.validate(); err != nil {
+				return nil, fmt.Errorf("endpoint %s: %w", ep.Name, err)
+			}
+		}
+		if dc := ep.Debug; dc != nil {
+			if dc.SamplePercent < 0 || dc.SamplePercent > 100 {
+				return nil, fmt.Errorf("endpoint %s: debug.sample_percent 必须在 (0, 100] 内", ep.Name)
+			}
+			if dc.MaxBytes < 0 {
+				return nil, fmt.Errorf("endpoint %s: debug.max_bytes 不能为负数", ep.Name)
+			}
+			for _, f := range dc.Fields {
+				if !slices.ContainsFunc(ep.Columns, func(m ColumnMapping) bool { return m.Column == f }) {
+					return nil, fmt.Errorf("endpoint %s: debug.fields 中的列 %s 不存在", ep.Name, f)
+				}
+			}
+		}
+		if ep.Auth != nil {
+			if err := validateAuth(ep.Auth); err != nil {
+				return nil, fmt.Errorf("endpoint %s: %w", ep.Name, err)
+			}
+		}
+
+		ep.Cluster = defaultString(ep.Cluster, defaultClusterName)
+		if !clusterNames[ep.Cluster] {
+			return nil, fmt.Errorf("endpoint %s: 未定义的集群: %q", ep.Name, ep.Cluster)
+		}
+		if isReplica(clusters, ep.Cluster) {
+			return nil, fmt.Errorf("endpoint %s: 集群 %s 是副本集群，只接收主集群的复制", ep.Name, ep.Cluster)
+		}
+
+		p, err := parsePriority(defaultString(ep.Priority, "high"))
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %s: %w", ep.Name, err)
+		}
+		ep.priority = p
+
+		if len(ep.Sinks) == 0 {
+			ep.Sinks = []EndpointSink{{Sink: dorisSinkName}}
+		}
+		usedSinks := make(map[string]bool)
+		for j := range ep.Sinks {
+			es := &ep.Sinks[j]
+			if !sinkNames[es.Sink] {
+				return nil, fmt.Errorf("endpoint %s: 未定义的输出目标: %q", ep.Name, es.Sink)
+			}
+			if usedSinks[es.Sink] {
+				return nil, fmt.Errorf("endpoint %s: 输出目标重复: %s", ep.Name, es.Sink)
+			}
+			usedSinks[es.Sink] = true
+			es.Policy = defaultString(es.Policy, sinkPolicyMustSucceed)
+			switch {
+			case es.Policy != sinkPolicyMustSucceed && es.Policy != sinkPolicyBestEffort:
+				return nil, fmt.Errorf("endpoint %s: 输出目标 %s 的 policy 无效: %q（可选 must_succeed、best_effort）", ep.Name, es.Sink, es.Policy)
+			case es.Sink == dorisSinkName && es.Policy != sinkPolicyMustSucceed:
+				return nil, fmt.Errorf("endpoint %s: doris 输出目标只支持 must_succeed", ep.Name)
+			}
+		}
+
+		for _, sc := range ep.Shadow {
+			if sc.Sink == dorisSinkName || !sinkNames[sc.Sink] {
+				return nil, fmt.Errorf("endpoint %s: 影子目标必须是已定义的输出目标: %q", ep.Name, sc.Sink)
+			}
+			if sc.Percent <= 0 || sc.Percent > 100 {
+				return nil, fmt.Errorf("endpoint %s: 影子目标 %s 的 percent 必须在 (0, 100] 内", ep.Name, sc.Sink)
+			}
+		}
+
+		table, err := reg.bindColumns(ep, ep.TableName, ep.Columns)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %s: %w", ep.Name, err)
+		}
+		ep.table = table
+
+		if rc := ep.Rollup; rc != nil {
+			if !ep.WritesDoris() {
+				return nil, fmt.Errorf("endpoint %s: rollup 需要端点写入 doris", ep.Name)
+			}
+			columns, err := rc.validate(ep)
+			if err != nil {
+				return nil, fmt.Errorf("endpoint %s: %w", ep.Name, err)
+			}
+			table.Columns = columns
+		}
+
+		if lc := ep.Late; lc != nil {
+			if !ep.WritesDoris() || ep.Rollup != nil {
+				return nil, fmt.Errorf("endpoint %s: late 需要端点写入 doris 且不能与 rollup 同时使用", ep.Name)
+			}
+			if err := lc.validate(ep); err != nil {
+				return nil, fmt.Errorf("endpoint %s: %w", ep.Name, err)
+			}
+			if lc.table, err = reg.bindColumns(ep, lc.TableName, ep.Columns); err != nil {
+				return nil, fmt.Errorf("endpoint %s: late %s: %w", ep.Name, lc.TableName, err)
+			}
+		}
+
+		if mc := ep.Migration; mc != nil {
+			if err := mc.validate(ep); err != nil {
+				return nil, fmt.Errorf("endpoint %s: %w", ep.Name, err)
+			}
+			if mc.table, err = reg.bindColumns(ep, mc.TableName, ep.Columns); err != nil {
+				return nil, fmt.Errorf("endpoint %s: migration %s: %w", ep.Name, mc.TableName, err)
+			}
+		}
+
+		if sp := ep.Split; sp != nil {
+			if err := sp.validate(ep, sinks); err != nil {
+				return nil, fmt.Errorf("endpoint %s: %w", ep.Name, err)
+			}
+		}
+
+		if len(ep.DualWrite) > 0 && !ep.WritesDoris() {
+			return nil, fmt.Errorf("endpoint %s: dual_write 需要端点写入 doris", ep.Name)
+		}
+		for j := range ep.DualWrite {
+			dw := &ep.DualWrite[j]
+			if dw.TableName == "" || dw.TableName == ep.TableName {
+				return nil, fmt.Errorf("endpoint %s: dual_write[%d].table 必须设置且不能与 table 相同", ep.Name, j)
+			}
+			if len(dw.Columns) == 0 {
+				return nil, fmt.Errorf("endpoint %s: dual_write %s 至少需要一个列映射", ep.Name, dw.TableName)
+			}
+			if dw.table, err = reg.bindColumns(ep, dw.TableName, dw.Columns); err != nil {
+				return nil, fmt.Errorf("endpoint %s: dual_write %s: %w", ep.Name, dw.TableName, err)
+			}
+		}
+
+		// 原子写入只有一次 Stream Load：事件只能写入目标表一张表
+		if ep.BulkMode == bulkModeAtomic && (!ep.WritesDoris() || ep.Rollup != nil || ep.Late != nil || len(ep.DualWrite) > 0) {
+			return nil, fmt.Errorf("endpoint %s: bulk_mode atomic 需要端点写入 doris，且不能与 rollup、late、dual_write 同时使用", ep.Name)
+		}
+
+		// 请求日志经 WAL 回放写入 Doris，预聚合端点不逐条写入，原子批量写入不经过 WAL
+		if ep.Journal && (!ep.WritesDoris() || ep.Rollup != nil || ep.BulkMode == bulkModeAtomic) {
+			return nil, fmt.Errorf("endpoint %s: journal 需要端点写入 doris，且不能与 rollup、bulk_mode atomic 同时使用", ep.Name)
+		}
+
+		if len(ep.StrictAllow) > 0 && !ep.Strict {
+			return nil, fmt.Errorf("endpoint %s: strict_allow 需要设置 strict", ep.Name)
+		}
+		ep.bindStrict()
+	}
+
+	// 预聚合的目标表只写入聚合结果，列与事件行不同，不能与其他端点或双写共用
+	for _, ep := range endpoints {
+		if ep.Rollup == nil {
+			continue
+		}
+		for _, other := range endpoints {
+			if other == ep {
+				continue
+			}
+			if other.writesTable(ep.table) {
+				return nil, fmt.Errorf("endpoint %s: rollup 的目标表 %s 不能被端点 %s 写入", ep.Name, ep.TableName, other.Name)
+			}
+		}
+		for _, dw := range ep.DualWrite {
+			if dw.table == ep.table {
+				return nil, fmt.Errorf("endpoint %s: rollup 的目标表 %s 不能用作 dual_write", ep.Name, ep.TableName)
+			}
+		}
+	}
+
+	// 凭证属于表：写入同一张表的端点不能配置不同的用户
+	owners := make(map[string]string)
+	for _, ep := range endpoints {
+		cred := ep.Credentials
+		if cred == nil {
+			continue
+		}
+		if cred.User == "" || cred.PasswordEnv == "" {
+			return nil, fmt.Errorf("endpoint %s: credentials 需要设置 user 和 password_env", ep.Name)
+		}
+		if !ep.WritesDoris() {
+			return nil, fmt.Errorf("endpoint %s: credentials 需要端点写入 doris", ep.Name)
+		}
+		for _, t := range reg.Tables {
+			if !ep.writesTable(t) {
+				continue
+			}
+			if other, ok := reg.tableCredentials[t.Name]; ok && *other != *cred {
+				return nil, fmt.Errorf("endpoint %s: 表 %s 已由端点 %s 配置了不同的 credentials", ep.Name, t.Name, owners[t.Name])
+			}
+			reg.tableCredentials[t.Name] = cred
+			owners[t.Name] = ep.Name
+		}
+	}
+	return reg, nil
+}
+
+// TableCredentials 返回集群中配置了 credentials 的表的 Doris 用户，密码从 password_env 指定的环境变量读取
+func (r *Registry) TableCredentials(cluster string) (map[string]dorisload.Credentials, error) {
+	creds := make(map[string]dorisload.Credentials)
+	for _, name := range slices.Sorted(maps.Keys(r.tableCredentials)) {
+		if r.TableCluster(name) != cluster {
+			continue
+		}
+		cred := r.tableCredentials[name]
+		passwd := os.Getenv(cred.PasswordEnv)
+		if passwd == "" {
+			return nil, fmt.Errorf("表 %s 的 credentials: 环境变量 %s 未设置", name, cred.PasswordEnv)
+		}
+		creds[name] = dorisload.Credentials{User: cred.User, Passwd: passwd}
+	}
+	return creds, nil
+}
+
+// bindColumns 校验列映射，并将列加入目标表（不存在时注册到端点的集群）
+// 同名表只能属于一个集群，WAL、批量写入和指标均按表名区分
+func (reg *Registry) bindColumns(ep *Endpoint, tableName string, columns []ColumnMapping) (*dorisload.Table, error) {
+	if err := dorisload.ValidateIdentifier(tableName); err != nil {
+		return nil, fmt.Errorf("table: %w", err)
+	}
+	table, ok := reg.tables[tableName]
+	if !ok {
+		table = &dorisload.Table{Name: tableName}
+		reg.tables[tableName] = table
+		reg.tableClusters[tableName] = ep.Cluster
+		reg.Tables = append(reg.Tables, table)
+	} else if cluster := reg.tableClusters[tableName]; cluster != ep.Cluster {
+		return nil, fmt.Errorf("表 %s 已由集群 %s 的端点写入，不能同时写入集群 %s", tableName, cluster, ep.Cluster)
+	}
+
+	seen := make(map[string]bool)
+	for j := range columns {
+		m := &columns[j]
+		if m.Column == "" {
+			return nil, fmt.Errorf("columns[%d].column 必须设置", j)
+		}
+		if seen[m.Column] {
+			return nil, fmt.Errorf("列重复: %s", m.Column)
+		}
+		seen[m.Column] = true
+
+		if !validColumnType(m.Type) {
+			return nil, fmt.Errorf("列 %s 的 type 无效: %q（可选 int、float、datetime、bool、string）", m.Column, m.Type)
+		}
+
+		m.Source = defaultString(m.Source, sourceBody)
+		switch m.Source {
+		case sourceBody:
+			m.Field = defaultString(m.Field, m.Column)
+			if m.Required && m.Default != nil {
+				return nil, fmt.Errorf("列 %s 不能同时设置 required 和 default", m.Column)
+			}
+		case sourceIngestTime, sourceIngestDate:
+			if m.Field != "" || m.Required || m.Default != nil {
+				return nil, fmt.Errorf("列 %s 的来源为 %s，不能设置 field/required/default", m.Column, m.Source)
+			}
+			if m.Type != "" && m.Type != typeDatetime {
+				return nil, fmt.Errorf("列 %s 的来源为 %s，type 只能为 datetime", m.Column, m.Source)
+			}
+		case sourceGeoIPCountry:
+			if m.Field != "" || m.Required {
+				return nil, fmt.Errorf("列 %s 的来源为 %s，不能设置 field/required", m.Column, m.Source)
+			}
+			if m.Type != "" && m.Type != typeString {
+				return nil, fmt.Errorf("列 %s 的来源为 %s，type 只能为 string", m.Column, m.Source)
+			}
+			ep.geoip = true
+		case sourceOriginalTimestamp:
+			m.Field = defaultString(m.Field, sourceOriginalTimestamp)
+			if m.Default != nil {
+				return nil, fmt.Errorf("列 %s 的来源为 %s，不能设置 default（缺失时使用接收时间）", m.Column, m.Source)
+			}
+			if m.Type != "" && m.Type != typeDatetime {
+				return nil, fmt.Errorf("列 %s 的来源为 %s，type 只能为 datetime", m.Column, m.Source)
+			}
+			m.Type = typeDatetime
+		case sourceSDKVersion:
+			if m.Field != "" || m.Required {
+				return nil, fmt.Errorf("列 %s 的来源为 %s，不能设置 field/required", m.Column, m.Source)
+			}
+			if m.Type != "" && m.Type != typeString {
+				return nil, fmt.Errorf("列 %s 的来源为 %s，type 只能为 string", m.Column, m.Source)
+			}
+		case sourceHeader:
+			if m.Header == "" || m.Field != "" {
+				return nil, fmt.Errorf("列 %s 的来源为 %s，必须设置 header 且不能设置 field", m.Column, m.Source)
+			}
+			m.Header = http.CanonicalHeaderKey(m.Header)
+			if slices.Contains(credentialHeaders, m.Header) {
+				return nil, fmt.Errorf("列 %s: 请求头 %s 携带凭证，不能写入列", m.Column, m.Header)
+			}
+			if m.Required && m.Default != nil {
+				return nil, fmt.Errorf("列 %s 不能同时设置 required 和 default", m.Column)
+			}
+			if m.Type == typeDatetime {
+				return nil, fmt.Errorf("列 %s 的来源为 %s，type 不能为 datetime", m.Column, m.Source)
+			}
+			if m.MaxLength < 0 {
+				return nil, fmt.Errorf("列 %s 的 max_length 无效: %d", m.Column, m.MaxLength)
+			}
+			if m.MaxLength == 0 {
+				m.MaxLength = defaultHeaderMaxLength
+			}
+		default:
+			return nil, fmt.Errorf("列 %s 的 source 无效: %q", m.Column, m.Source)
+		}
+
+		if m.Source != sourceHeader && (m.Header != "" || m.MaxLength != 0) {
+			return nil, fmt.Errorf("列 %s: header/max_length 只能用于来源为 header 的列", m.Column)
+		}
+		if m.MaxBytes < 0 {
+			return nil, fmt.Errorf("列 %s 的 max_bytes 无效: %d", m.Column, m.MaxBytes)
+		}
+		m.bindLimits(ep.Limits)
+		m.sanitize = ep.Sanitize
+
+		clientTime := m.Type == typeDatetime && (m.Source == sourceBody || m.Source == sourceOriginalTimestamp)
+		if m.CorrectSkew && !clientTime {
+			return nil, fmt.Errorf("列 %s: correct_skew 只能用于请求体的 datetime 列", m.Column)
+		}
+		if m.MaxAge != "" || m.MaxFuture != "" || m.OutOfRange != "" {
+			if !clientTime {
+				return nil, fmt.Errorf("列 %s: max_age/max_future/out_of_range 只能用于请求体的 datetime 列", m.Column)
+			}
+			var err error
+			if m.maxAge, err = parseWindow(m.MaxAge); err != nil {
+				return nil, fmt.Errorf("列 %s 的 max_age 无效: %q", m.Column, m.MaxAge)
+			}
+			if m.maxFuture, err = parseWindow(m.MaxFuture); err != nil {
+				return nil, fmt.Errorf("列 %s 的 max_future 无效: %q", m.Column, m.MaxFuture)
+			}
+			m.OutOfRange = defaultString(m.OutOfRange, outOfRangeReject)
+			if m.OutOfRange != outOfRangeReject && m.OutOfRange != outOfRangeClamp {
+				return nil, fmt.Errorf("列 %s 的 out_of_range 无效: %q（可选 reject、clamp）", m.Column, m.OutOfRange)
+			}
+		}
+
+		if m.Default != nil {
+			switch m.Default.(type) {
+			case string, int, float64, bool:
+			default:
+				return nil, fmt.Errorf("列 %s 的 default 必须是标量", m.Column)
+			}
+			def, err := coerce(m, yamlScalar(m.Default), RowContext{})
+			if err != nil {
+				return nil, fmt.Errorf("列 %s 的 default 无效: %w", m.Column, err)
+			}
+			m.def = def
+		}
+
+		if !slices.Contains(table.Columns, m.Column) {
+			table.Columns = append(table.Columns, m.Column)
+		}
+	}
+	return table, nil
+}
+
+// writesTable 判断端点是否写入指定表（含双写、迟到事件的修正表和迁移用的临时表）
+func (ep *Endpoint) writesTable(t *dorisload.Table) bool {
+	if ep.table == t || (ep.Late != nil && ep.Late.table == t) || (ep.Migration != nil && ep.Migration.table == t) {
+		return true
+	}
+	for _, dw := range ep.DualWrite {
+		if dw.table == t {
+			return true
+		}
+	}
+	return false
+}
+
+// WritesDoris 判断端点是否写入 Doris
+func (ep *Endpoint) WritesDoris() bool {
+	for _, es := range ep.Sinks {
+		if es.Sink == dorisSinkName {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildRow 按列映射将请求体转换为 Doris 行，计算列取自 rc
+// 字段值无法转换为列类型时返回 *coercionError
+func (ep *Endpoint) BuildRow(body map[string]any, rc RowContext) (map[string]any, error) {
+	return buildRow(ep.Columns, body, rc)
+}
+
+// buildRow 按列映射将请求体转换为行
+func buildRow(columns []ColumnMapping, body map[string]any, rc RowContext) (map[string]any, error) {
+	row := make(map[string]any, len(columns))
+	for i := range columns {
+		m := &columns[i]
+		switch m.Source {
+		case sourceIngestTime:
+			row[m.Column] = rc.Now.Format(dorisDatetimeFormat)
+		case sourceIngestDate:
+			row[m.Column] = rc.Now.Format(dorisDateFormat)
+		case sourceGeoIPCountry:
+			if rc.Country != "" {
+				row[m.Column] = rc.Country
+			} else {
+				row[m.Column] = defaultValue(m)
+			}
+		case sourceOriginalTimestamp:
+			v, ok := body[m.Field]
+			if !ok || v == nil || v == "" {
+				if m.Required {
+					return nil, fmt.Errorf("field %q is required", m.Field)
+				}
+				row[m.Column] = rc.Now.Format(dorisDatetimeFormat)
+				continue
+			}
+			v, err := coerce(m, v, rc)
+			if err != nil {
+				return nil, err
+			}
+			row[m.Column] = v
+		case sourceSDKVersion:
+			if rc.SDKVersion != "" {
+				row[m.Column] = rc.SDKVersion
+			} else {
+				row[m.Column] = defaultValue(m)
+			}
+		case sourceHeader:
+			v := sanitizeHeaderValue(rc.Header.Get(m.Header), m.MaxLength)
+			if v == "" {
+				if m.Required {
+					return nil, fmt.Errorf("header %q is required", m.Header)
+				}
+				row[m.Column] = defaultValue(m)
+				continue
+			}
+			cv, err := coerce(m, v, rc)
+			if err != nil {
+				return nil, err
+			}
+			row[m.Column] = cv
+		default:
+			v, ok := body[m.Field]
+			if !ok || v == nil || v == "" {
+				if m.Required {
+					return nil, fmt.Errorf("field %q is required", m.Field)
+				}
+				row[m.Column] = defaultValue(m)
+				continue
+			}
+			if m.sanitize != nil {
+				var err error
+				if v, err = m.sanitize.sanitizeValue(m.Field, v); err != nil {
+					return nil, err
+				}
+			}
+			v, err := coerce(m, v, rc)
+			if err != nil {
+				return nil, err
+			}
+			if v, err = m.limit(v); err != nil {
+				return nil, err
+			}
+			row[m.Column] = v
+		}
+	}
+	return row, nil
+}
+
+// sanitizeHeaderValue 清理请求头的值：去除无效 UTF-8 和控制字符，去掉首尾空白后截断到 maxLen 个字符
+func sanitizeHeaderValue(v string, maxLen int) string {
+	v = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(v, ""))
+	v = strings.TrimSpace(v)
+	if utf8.RuneCountInString(v) > maxLen {
+		v = strings.TrimSpace(string([]rune(v)[:maxLen]))
+	}
+	return v
+}
+
+// defaultValue 返回列的默认值
+// 未设置 default 时与原 VideoData 行为一致：字符串列写入空串，其他类型写入 NULL
+func defaultValue(m *ColumnMapping) any {
+	switch {
+	case m.Default != nil:
+		return m.def
+	case m.Type == "" || m.Type == typeString:
+		return ""
+	default:
+		return nil
+	}
+}
+
+// parseWindow 解析时间窗口，空串表示不限制
+func parseWindow(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// yamlScalar 将 YAML 标量转换为与 JSON 请求体相同的表示，以便复用类型转换
+func yamlScalar(v any) any {
+	switch v := v.(type) {
+	case int:
+		return json.Number(strconv.Itoa(v))
+	case float64:
+		return json.Number(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return v
+}
+
+// decodeJSONObject 解析 JSON 对象请求体，数字保留为 json.Number 以免丢失精度
+func decodeJSONObject(data []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var body map[string]any
+	if err := dec.Decode(&body); err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, fmt.Errorf("request body must be a JSON object")
+	}
+	return body, nil
+}
+
+// stringValue 返回行中字符串列的值，非字符串返回空串
+func stringValue(row map[string]any, column string) string {
+	s, _ := row[column].(string)
+	return s
+}
+
+// defaultString 返回 s，为空时返回 def
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
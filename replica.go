@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+
+	"doris-webhook/dorisload"
+)
+
+// replicaWALPrefix 副本集群积压的 WAL 子目录前缀，位于 WAL_DIR 下
+const replicaWALPrefix = "replica-"
+
+// Replicator 双集群复制：写入主集群成功的每批数据再写入其副本集群（clusters 中 replica_of 指向主集群）
+// 副本写入在后台进行，不影响响应；失败或队列已满时写入副本自己的 WAL，由后台独立回放到副本集群
+type Replicator struct {
+	replicas map[string]*replica // 按主集群名索引
+	registry *Registry
+	logger   *slog.Logger
+
+	queue   chan replicaBatch
+	mu      sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+	replays sync.WaitGroup // 积压回放
+	cancel  context.CancelFunc
+}
+
+// replica 一个副本集群及其积压和计数
+type replica struct {
+	name    string // 副本集群名
+	primary string // 主集群名
+	dc      *dorisload.Client
+	wal     *WAL // 副本的积压，位于 WAL_DIR/replica-{name}
+
+	primaryRows atomic.Int64 // 主集群提交的行数
+	replicaRows atomic.Int64 // 副本集群提交的行数（含积压回放）
+	backlogRows atomic.Int64 // 写入积压的行数
+	failures    atomic.Int64 // 副本直接写入失败的次数
+}
+
+// replicaBatch 等待写入副本集群的一批 NDJSON
+type replicaBatch struct {
+	replica *replica
+	table   *dorisload.Table
+	data    []byte
+}
+
+// ReplicaStats 副本集群的复制统计，通过 /admin/stats 查看
+type ReplicaStats struct {
+	Primary         string `json:"primary"`
+	PrimaryRows     int64  `json:"primary_rows"`
+	ReplicaRows     int64  `json:"replica_rows"`
+	Divergence      int64  `json:"divergence"` // 主集群比副本集群多提交的行数，积压回放完成后回到 0
+	BacklogRows     int64  `json:"backlog_rows"`
+	BacklogBytes    int64  `json:"backlog_bytes"`
+	BacklogSegments int    `json:"backlog_segments"`
+	Failures        int64  `json:"failures"`
+}
+
+// newReplicator 为配置了 replica_of 的集群创建复制器，没有副本集群时返回 nil
+// 副本积压需要 WAL，主 WAL 未启用时返回错误
+func newReplicator(registry *Registry, clusters *Clusters, wal *WAL, logger *slog.Logger) (*Replicator, error) {
+	var replicas []*ClusterConfig
+	for _, cc := range registry.Clusters {
+		if cc.ReplicaOf != "" {
+			replicas = append(replicas, cc)
+		}
+	}
+	if len(replicas) == 0 {
+		return nil, nil
+	}
+	if wal == nil {
+		return nil, fmt.Errorf("集群 %s 设置了 replica_of，需要设置 WAL_DIR 保存副本积压", replicas[0].Name)
+	}
+	workers, queueSize, err := loadReplicaConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replicator{
+		replicas: make(map[string]*replica, len(replicas)),
+		registry: registry,
+		logger:   logger,
+		queue:    make(chan replicaBatch, queueSize),
+	}
+	for _, cc := range replicas {
+		rep := &replica{
+			name:    cc.Name,
+			primary: cc.ReplicaOf,
+			dc:      clusters.Client(cc.Name),
+			wal:     wal.sub(replicaWALPrefix+cc.Name, logger.With("replica", cc.Name)),
+		}
+		if err := rep.wal.open(); err != nil {
+			return nil, fmt.Errorf("replica %s: %w", cc.Name, err)
+		}
+		rep.wal.committed = func(_ *dorisload.Table, data []byte) {
+			rep.replicaRows.Add(countLines(data))
+		}
+		r.replicas[cc.ReplicaOf] = rep
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	for range workers {
+		r.workers.Add(1)
+		go r.worker()
+	}
+	// 副本积压独立回放，不占用主集群的并发槽位
+	for _, rep := range r.replicas {
+		r.replays.Add(1)
+		go func() {
+			defer r.replays.Done()
+			rep.wal.Run(ctx, func(*dorisload.Table) *dorisload.Client { return rep.dc }, registry, func(context.Context) (func(), bool) {
+				return func() {}, true
+			})
+		}()
+	}
+	return r, nil
+}
+
+// loadReplicaConfig 读取 REPLICA_* 环境变量
+func loadReplicaConfig() (workers, queueSize int, err error) {
+	ints := map[string]int{
+		"REPLICA_WORKERS":    4,
+		"REPLICA_QUEUE_SIZE": 1000,
+	}
+	for key, def := range ints {
+		v, err := strconv.Atoi(getEnv(key, strconv.Itoa(def)))
+		if err != nil || v <= 0 {
+			return 0, 0, fmt.Errorf("%s 无效: %q", key, getEnv(key, ""))
+		}
+		ints[key] = v
+	}
+	return ints["REPLICA_WORKERS"], ints["REPLICA_QUEUE_SIZE"], nil
+}
+
+// Replicate 记录主集群已提交的一批数据，表所属集群有副本时排队写入副本集群
+// 不阻塞调用方：队列已满或复制器已关闭时直接写入副本积压
+func (r *Replicator) Replicate(table *dorisload.Table, data []byte) {
+	if r == nil {
+		return
+	}
+	rep := r.replicas[r.registry.TableCluster(table.Name)]
+	if rep == nil {
+		return
+	}
+	rep.primaryRows.Add(countLines(data))
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.closed {
+		select {
+		case r.queue <- replicaBatch{replica: rep, table: table, data: data}:
+			return
+		default:
+		}
+	}
+	r.spill(rep, table, data)
+}
+
+// worker 将队列中的批次写入副本集群，失败时写入积压
+func (r *Replicator) worker() {
+	defer r.workers.Done()
+	for b := range r.queue {
+		var failed bool
+		lines := dorisload.SplitNDJSON(b.data)
+		for _, ch := range b.replica.dc.WriteLinesSplit(context.Background(), b.table, "replica-"+uuid.New().String(), lines, r.logger) {
+			if ch.Err != nil {
+				failed = true
+				r.logger.Warn("写入副本集群失败，写入积压稍后回放", "replica", b.replica.name, "table", b.table.Name, "error", ch.Err)
+				r.spill(b.replica, b.table, dorisload.JoinLines(lines[ch.Start:ch.End]))
+				continue
+			}
+			b.replica.replicaRows.Add(int64(ch.End - ch.Start))
+		}
+		if failed {
+			b.replica.failures.Add(1)
+		}
+	}
+}
+
+// spill 将批次写入副本积压，失败时只记录日志（副本集群尽力而为，差异体现在 divergence 中）
+func (r *Replicator) spill(rep *replica, table *dorisload.Table, data []byte) {
+	if err := rep.wal.Append(table.Name, data); err != nil {
+		r.logger.Error("写入副本积压失败，数据未复制到副本集群", "replica", rep.name, "table", table.Name, "error", err)
+		return
+	}
+	rep.backlogRows.Add(countLines(data))
+}
+
+// Stats 返回各副本集群的复制统计，按副本集群名索引
+func (r *Replicator) Stats() map[string]ReplicaStats {
+	stats := make(map[string]ReplicaStats, len(r.replicas))
+	for _, rep := range r.replicas {
+		segments, bytes := rep.wal.Pending()
+		s := ReplicaStats{
+			Primary:         rep.primary,
+			PrimaryRows:     rep.primaryRows.Load(),
+			ReplicaRows:     rep.replicaRows.Load(),
+			BacklogRows:     rep.backlogRows.Load(),
+			BacklogBytes:    bytes,
+			BacklogSegments: segments,
+			Failures:        rep.failures.Load(),
+		}
+		s.Divergence = s.PrimaryRows - s.ReplicaRows
+		stats[rep.name] = s
+	}
+	return stats
+}
+
+// Names 返回副本集群名，按字母顺序排列
+func (r *Replicator) Names() []string {
+	names := make([]string, 0, len(r.replicas))
+	for _, rep := range r.replicas {
+		names = append(names, rep.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close 停止接收新批次，等待队列中的批次写完（失败的写入积压），停止积压回放并封存积压段
+// 未回放的积压在下次启动后继续回放
+func (r *Replicator) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	r.closed = true
+	close(r.queue)
+	r.mu.Unlock()
+	r.workers.Wait()
+
+	r.cancel()
+	r.replays.Wait()
+
+	var errs []error
+	for _, rep := range r.replicas {
+		errs = append(errs, rep.wal.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// countLines 返回 NDJSON 数据的行数
+func countLines(data []byte) int64 {
+	return int64(bytes.Count(data, []byte{'\n'}))
+}
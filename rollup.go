@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"doris-webhook/dorisload"
+)
+
+// 预聚合的默认配置
+const (
+	defaultRollupInterval    = time.Minute
+	defaultRollupTimeColumn  = "window_start"
+	defaultRollupCountColumn = "event_count"
+	defaultRollupMaxGroups   = 100000
+)
+
+// errRollupDeferred 表或端点暂停、降级模式下未启用 WAL，聚合结果保留到下次写入
+var errRollupDeferred = errors.New("rollup load deferred")
+
+// RollupConfig 端点的预聚合：事件不逐行写入，按接收时间的窗口和维度列在内存中计数，
+// 窗口结束后将每组一行写入端点的目标表，用于心跳等只需要计数的超高频事件
+type RollupConfig struct {
+	Dimensions  []string `yaml:"dimensions" json:"dimensions"`                         // 分组的列，取自端点列映射转换后的行
+	Sums        []string `yaml:"sums,omitempty" json:"sums,omitempty"`                 // 按组求和的 int/float 列，结果写入同名列
+	Interval    string   `yaml:"interval,omitempty" json:"interval,omitempty"`         // 聚合窗口，默认 1m
+	TimeColumn  string   `yaml:"time_column,omitempty" json:"time_column,omitempty"`   // 窗口起始时间列，默认 window_start
+	CountColumn string   `yaml:"count_column,omitempty" json:"count_column,omitempty"` // 事件数列，默认 event_count
+	MaxGroups   int      `yaml:"max_groups,omitempty" json:"max_groups,omitempty"`     // 内存中的最大分组数，超过时提前写入，默认 100000
+
+	interval time.Duration
+	intSums  []bool // 与 Sums 一一对应，int 列的和按整数写入
+}
+
+// validate 校验预聚合配置并返回目标表的列：时间列、维度列、事件数列、求和列
+func (rc *RollupConfig) validate(ep *Endpoint) ([]string, error) {
+	if len(rc.Dimensions) == 0 {
+		return nil, fmt.Errorf("rollup.dimensions 至少需要一个列")
+	}
+	rc.interval = defaultRollupInterval
+	if rc.Interval != "" {
+		d, err := time.ParseDuration(rc.Interval)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("rollup.interval 无效: %q（不小于 1s）", rc.Interval)
+		}
+		rc.interval = d
+	}
+	if rc.MaxGroups < 0 {
+		return nil, fmt.Errorf("rollup.max_groups 不能为负数")
+	}
+	if rc.MaxGroups == 0 {
+		rc.MaxGroups = defaultRollupMaxGroups
+	}
+	rc.TimeColumn = defaultString(rc.TimeColumn, defaultRollupTimeColumn)
+	rc.CountColumn = defaultString(rc.CountColumn, defaultRollupCountColumn)
+
+	column := func(name string) *ColumnMapping {
+		for i := range ep.Columns {
+			if ep.Columns[i].Column == name {
+				return &ep.Columns[i]
+			}
+		}
+		return nil
+	}
+	for _, d := range rc.Dimensions {
+		if column(d) == nil {
+			return nil, fmt.Errorf("rollup.dimensions 中的列 %s 不存在", d)
+		}
+	}
+	rc.intSums = make([]bool, len(rc.Sums))
+	for i, s := range rc.Sums {
+		m := column(s)
+		if m == nil || (m.Type != typeInt && m.Type != typeFloat) {
+			return nil, fmt.Errorf("rollup.sums 中的列 %s 必须是 int 或 float 列", s)
+		}
+		rc.intSums[i] = m.Type == typeInt
+	}
+
+	columns := append([]string{rc.TimeColumn}, rc.Dimensions...)
+	columns = append(columns, rc.CountColumn)
+	columns = append(columns, rc.Sums...)
+	for i, c := range columns {
+		if slices.Contains(columns[:i], c) {
+			return nil, fmt.Errorf("rollup 的列重复: %s", c)
+		}
+	}
+	return columns, nil
+}
+
+// rollupGroup 一个窗口内一组维度值的聚合结果
+type rollupGroup struct {
+	window time.Time
+	dims   []any
+	count  int64
+	sums   []float64
+}
+
+// Rollup 端点的预聚合器
+type Rollup struct {
+	ep     *Endpoint
+	cfg    *RollupConfig
+	write  func(ctx context.Context, ep *Endpoint, data []byte) error
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	groups map[string]*rollupGroup // 按窗口起始时间和维度值的 JSON 索引
+	full   chan struct{}           // 分组数达到 max_groups 时通知 Run 提前写入
+}
+
+// newRollups 为配置了 rollup 的端点创建预聚合器，没有时返回 nil
+// write 将聚合结果的 NDJSON 写入端点的目标表，失败时聚合结果保留到下次写入
+func newRollups(reg *Registry, write func(ctx context.Context, ep *Endpoint, data []byte) error, logger *slog.Logger) map[string]*Rollup {
+	var rollups map[string]*Rollup
+	for _, ep := range reg.Endpoints {
+		if ep.Rollup == nil {
+			continue
+		}
+		if rollups == nil {
+			rollups = make(map[string]*Rollup)
+		}
+		rollups[ep.Name] = &Rollup{
+			ep:     ep,
+			cfg:    ep.Rollup,
+			write:  write,
+			logger: logger.With("endpoint", ep.Name, "table", ep.TableName),
+			groups: make(map[string]*rollupGroup),
+			full:   make(chan struct{}, 1),
+		}
+	}
+	return rollups
+}
+
+// Add 将事件计入接收时间所在的窗口，分组数达到 max_groups 时通知 Run 提前写入全部分组
+func (r *Rollup) Add(now time.Time, events []eventRow) {
+	window := now.Truncate(r.cfg.interval)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ev := range events {
+		dims := make([]any, len(r.cfg.Dimensions))
+		for i, d := range r.cfg.Dimensions {
+			dims[i] = ev.Row[d]
+		}
+		key, err := rollupKey(window, dims)
+		if err != nil {
+			continue
+		}
+		g := r.groups[key]
+		if g == nil {
+			g = &rollupGroup{window: window, dims: dims, sums: make([]float64, len(r.cfg.Sums))}
+			r.groups[key] = g
+		}
+		g.count++
+		for i, s := range r.cfg.Sums {
+			switch v := ev.Row[s].(type) {
+			case int64:
+				g.sums[i] += float64(v)
+			case float64:
+				g.sums[i] += v
+			}
+		}
+	}
+	if len(r.groups) >= r.cfg.MaxGroups {
+		select {
+		case r.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// rollupKey 返回分组的索引：窗口起始时间和维度值的 JSON
+func rollupKey(window time.Time, dims []any) (string, error) {
+	raw, err := json.Marshal(dims)
+	if err != nil {
+		return "", err
+	}
+	return window.Format(time.RFC3339) + string(raw), nil
+}
+
+// Flush 写入窗口已结束的分组，all 为 true 时写入全部分组（关闭时或分组数超限时）
+// 写入失败的分组合并回内存，下次重试
+func (r *Rollup) Flush(ctx context.Context, now time.Time, all bool) {
+	r.mu.Lock()
+	var ready []*rollupGroup
+	for key, g := range r.groups {
+		if all || !g.window.Add(r.cfg.interval).After(now) {
+			ready = append(ready, g)
+			delete(r.groups, key)
+		}
+	}
+	r.mu.Unlock()
+	if len(ready) == 0 {
+		return
+	}
+
+	var lines [][]byte
+	for _, g := range ready {
+		row := map[string]any{
+			r.cfg.TimeColumn:  g.window.Format(dorisDatetimeFormat),
+			r.cfg.CountColumn: g.count,
+		}
+		for i, d := range r.cfg.Dimensions {
+			row[d] = g.dims[i]
+		}
+		for i, s := range r.cfg.Sums {
+			if r.cfg.intSums[i] {
+				row[s] = int64(g.sums[i])
+			} else {
+				row[s] = g.sums[i]
+			}
+		}
+		line, err := marshalLine(row)
+		if err != nil {
+			r.logger.Error("序列化聚合结果失败", "error", err)
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	err := r.write(ctx, r.ep, dorisload.JoinLines(lines))
+	if err == nil {
+		r.logger.Debug("写入聚合结果", "groups", len(ready))
+		return
+	}
+	if !errors.Is(err, errRollupDeferred) {
+		r.logger.Warn("写入聚合结果失败，下次重试", "groups", len(ready), "error", err)
+	}
+	r.merge(ready)
+}
+
+// merge 将未写入的分组合并回内存
+func (r *Rollup) merge(groups []*rollupGroup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, g := range groups {
+		key, _ := rollupKey(g.window, g.dims)
+		cur := r.groups[key]
+		if cur == nil {
+			r.groups[key] = g
+			continue
+		}
+		cur.count += g.count
+		for i := range cur.sums {
+			cur.sums[i] += g.sums[i]
+		}
+	}
+}
+
+// Groups 返回内存中的分组数
+func (r *Rollup) Groups() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.groups)
+}
+
+// Run 每秒写入窗口已结束的分组，分组数超限时写入全部分组，ctx 取消后写入全部分组
+func (r *Rollup) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// 关闭时最多等待一次写入
+			flushCtx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+			r.Flush(flushCtx, time.Now(), true)
+			cancel()
+			if n := r.Groups(); n > 0 {
+				r.logger.Error("关闭时未能写入的聚合结果已丢弃", "groups", n)
+			}
+			return
+		case now := <-ticker.C:
+			r.Flush(ctx, now, false)
+		case <-r.full:
+			r.logger.Warn("聚合分组数达到上限，提前写入", "max_groups", r.cfg.MaxGroups)
+			r.Flush(ctx, time.Now(), true)
+		}
+	}
+}
+
+// writeRollup 将聚合结果写入端点的目标表：降级模式或表、端点暂停时写入 WAL，未启用 WAL 时推迟
+func (app *App) writeRollup(ctx context.Context, ep *Endpoint, data []byte) error {
+	table := ep.Table()
+	if app.degraded.Load() || app.toggles.Paused(ep, table.Name) {
+		if app.wal == nil {
+			return errRollupDeferred
+		}
+		return app.wal.Append(table.Name, data)
+	}
+	return app.load(ctx, &SinkBatch{Table: table, Priority: PriorityHigh, Lines: dorisload.SplitNDJSON(data)})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// middlewareChain 有序的中间件链
+// 全局：request ID → 日志 → recovery（recovery 在日志内侧，panic 恢复后的 500 也会记录访问日志）
+// 事件端点：CORS → 滥用检测 → 超时 → 鉴权 → 审计 → 处理函数（请求体校验）
+// 新增的横切功能只需加入对应的链，不需要修改各个处理函数
+type middlewareChain []gin.HandlerFunc
+
+// With 返回追加了中间件的新链，nil 被忽略；不修改原链，可以从同一条链派生多个路由
+func (ch middlewareChain) With(handlers ...gin.HandlerFunc) middlewareChain {
+	out := make(middlewareChain, len(ch), len(ch)+len(handlers))
+	copy(out, ch)
+	for _, h := range handlers {
+		if h != nil {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// Then 返回以 h 结尾的处理函数列表，用于注册路由
+func (ch middlewareChain) Then(h gin.HandlerFunc) []gin.HandlerFunc {
+	return ch.With(h)
+}
+
+// setupRouter 设置路由
+func (app *App) setupRouter() (*gin.Engine, error) {
+	// 根据环境变量设置 Gin 模式
+	ginMode := getEnv("GIN_MODE", "release")
+	gin.SetMode(ginMode)
+
+	r := gin.New()
+	r.Use(middlewareChain{
+		requestID(),
+		app.ginLogger(),
+		// panic 恢复时转储的请求头同样脱敏
+		gin.CustomRecoveryWithWriter(newRedactor().writer(gin.DefaultErrorWriter), func(c *gin.Context, _ any) {
+			abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Internal server error", nil)
+		}),
+	}...)
+	r.NoRoute(notFound)
+
+	// 客户端 IP（封禁、GeoIP、日志和审计）只采信 TRUSTED_PROXIES 中的代理转发的 X-Forwarded-For、X-Real-IP，未设置时使用连接的对端地址
+	if err := r.SetTrustedProxies(splitList(getEnv("TRUSTED_PROXIES", ""))); err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES 无效: %w", err)
+	}
+
+	// CORS 按路由配置：CORS_* 环境变量为默认策略，端点可在配置文件中覆盖，管理接口不输出 CORS 响应头
+	policy, err := corsPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	defaultCORS, err := policy.middleware()
+	if err != nil {
+		return nil, err
+	}
+
+	// 健康检查端点
+	r.OPTIONS("/health", defaultCORS)
+	r.GET("/health", middlewareChain{defaultCORS}.Then(app.healthHandler)...)
+	r.OPTIONS("/version", defaultCORS)
+	r.GET("/version", middlewareChain{defaultCORS}.Then(app.versionHandler)...)
+
+	// 客户端 SDK 的远程配置，浏览器中的 tracker.js 跨域拉取
+	if app.sdk != nil {
+		r.OPTIONS(sdkConfigPath, defaultCORS)
+		r.GET(sdkConfigPath, middlewareChain{defaultCORS}.Then(app.sdk.handler)...)
+	}
+
+	// 请求超时：超时后取消请求上下文，进行中的 Stream Load 随之中止
+	requestTimeout, err := envDuration("REQUEST_TIMEOUT_MS", "25000", time.Millisecond, time.Millisecond, 0)
+	if err != nil {
+		return nil, err
+	}
+	maxBulkEvents, err := strconv.Atoi(getEnv("BULK_MAX_EVENTS", "1000"))
+	if err != nil || maxBulkEvents <= 0 {
+		return nil, fmt.Errorf("BULK_MAX_EVENTS 无效: %q", getEnv("BULK_MAX_EVENTS", ""))
+	}
+	// 请求体上限：在签名、校验和、鉴权读取请求体之前生效，超过时返回 413
+	maxBodyBytes, err := envByteSize("HTTP_MAX_BODY_BYTES", "10MB", 1, 1<<10, 1<<30)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按注册表注册事件写入端点，单条写入和批量写入共用端点的中间件链
+	for _, ep := range app.registry.Endpoints {
+		timeout := requestTimeout
+		if ep.TimeoutMs > 0 {
+			timeout = time.Duration(ep.TimeoutMs) * time.Millisecond
+		}
+		cors, chain, err := app.endpointChain(ep, policy, timeout, maxBodyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %s: %w", ep.Name, err)
+		}
+		routes := map[string]gin.HandlerFunc{ep.Path: app.ingestHandler(ep)}
+		if ep.BulkPath != "" {
+			routes[ep.BulkPath] = app.bulkHandler(ep, maxBulkEvents)
+		}
+		for path, h := range routes {
+			if cors != nil {
+				r.OPTIONS(path, cors)
+			}
+			r.POST(path, chain.Then(h)...)
+		}
+		// 接受查询参数的端点同时接受 GET 请求（如图片像素形式的旧版埋点）
+		if ep.accepts(inputQuery) {
+			r.GET(ep.Path, chain.Then(routes[ep.Path])...)
+		}
+	}
+
+	// 蜜罐路径：访问者直接封禁
+	if app.abuse != nil {
+		for _, path := range app.abuse.honeypots {
+			if !strings.HasPrefix(path, "/") || path == "/health" || path == "/version" || path == "/metrics" || path == uploadPath || strings.HasPrefix(path, "/admin") || app.registry.endpointByPath(path) != nil {
+				return nil, fmt.Errorf("ABUSE_HONEYPOT_PATHS 无效: %q（必须以 / 开头且不能与已有路由冲突）", path)
+			}
+			r.Any(path, app.abuse.honeypot)
+		}
+	}
+
+	// 管理接口，/metrics 与管理接口使用相同的令牌
+	if getEnv("ADMIN_TOKEN", "") == "" {
+		app.logger.Warn("未设置 ADMIN_TOKEN，管理接口只允许只读请求，修改状态的请求将被拒绝")
+	}
+	adminChain := middlewareChain{app.adminAuth(), gzipResponse()}
+	r.GET("/metrics", adminChain.Then(app.metricsHandler)...)
+	admin := r.Group("/admin", adminChain...)
+	admin.GET("/stats", app.statsHandler)
+	admin.GET("/endpoints", app.endpointsHandler)
+	admin.GET("/scaling", app.scalingHandler)
+	admin.GET("/health/history", app.healthHistoryHandler)
+	admin.GET("/toggles", app.togglesHandler)
+	admin.PATCH("/toggles", app.updateTogglesHandler)
+	admin.POST("/pause", app.pauseHandler(true))
+	admin.POST("/resume", app.pauseHandler(false))
+	admin.POST("/migration/start", app.migrationStartHandler)
+	admin.POST("/migration/finish", app.migrationFinishHandler)
+	admin.POST("/playground", app.playgroundHandler)
+	if app.watermarks != nil {
+		admin.GET("/watermarks", app.watermarksHandler)
+	}
+	if app.usage != nil {
+		admin.GET("/usage", app.usageHandler)
+	}
+	if app.wal != nil && app.wal.Queryable() {
+		admin.GET("/wal", app.walAdminHandler)
+		admin.POST("/wal/purge", app.walPurgeHandler)
+		admin.POST("/wal/replay", app.walReplayHandler)
+	}
+	if app.abuse != nil {
+		admin.GET("/bans", app.bansHandler)
+		admin.POST("/bans", app.banHandler)
+		admin.DELETE("/bans/:ip", app.unbanHandler)
+	}
+	if app.schema != nil {
+		admin.GET("/schema", app.schemaHandler)
+	}
+	if app.faults != nil {
+		app.faults.registerRoutes(admin)
+	}
+
+	// 文件上传使用管理接口的令牌，响应为逐行输出的进度流，不压缩
+	if app.uploads != nil {
+		r.POST(uploadPath, middlewareChain{app.adminAuth()}.Then(app.uploadHandler)...)
+	}
+
+	return r, nil
+}
+
+// endpointChain 组装事件端点的中间件链：CORS → 响应签名 → 滥用检测 → 请求体上限 → 超时 → 请求体校验和 → 鉴权 → 审计 → 用量 → 追踪上下文
+// 同时返回 CORS 中间件（端点禁用 CORS 时为 nil），用于注册预检请求
+func (app *App) endpointChain(ep *Endpoint, policy *corsPolicy, timeout time.Duration, maxBodyBytes int64) (gin.HandlerFunc, middlewareChain, error) {
+	var cors gin.HandlerFunc
+	if p := policy.forEndpoint(ep.CORS); p != nil {
+		var err error
+		if cors, err = p.middleware(); err != nil {
+			return nil, nil, fmt.Errorf("cors: %w", err)
+		}
+	}
+	var abuse gin.HandlerFunc
+	if app.abuse != nil {
+		abuse = app.abuse.middleware()
+	}
+	auth, err := newEndpointAuth(ep.Auth, app.nonces)
+	if err != nil {
+		return nil, nil, err
+	}
+	var audit gin.HandlerFunc
+	if app.audit != nil {
+		audit = app.audit.middleware(ep)
+	}
+	sign, err := newResponseSigner(ep.Response)
+	if err != nil {
+		return nil, nil, err
+	}
+	var usage gin.HandlerFunc
+	if app.usage != nil {
+		usage = app.usage.middleware(ep)
+	}
+	var traced gin.HandlerFunc
+	if app.config != nil && app.config.ExemplarTraceID != nil {
+		traced = traceContext()
+	}
+	return cors, middlewareChain{}.With(cors, sign, abuse, limitBody(maxBodyBytes), requestTimeout(timeout), verifyChecksum(app.logger), auth, audit, usage, traced), nil
+}
+
+// healthHandler 健康检查
+func (app *App) healthHandler(c *gin.Context) {
+	info := buildInfo()
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "ok",
+		"service":    "doris-webhook",
+		"degraded":   app.degraded.Load(),
+		"version":    info.Version,
+		"commit":     info.Commit,
+		"build_date": info.BuildDate,
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// 无效 UTF-8 的处理方式
+const (
+	invalidUTF8Replace = "replace" // 替换为 U+FFFD（默认）
+	invalidUTF8Strip   = "strip"   // 删除无效字节
+	invalidUTF8Latin1  = "latin1"  // 按 ISO-8859-1 解释无效字节，适用于 Latin-1 编码的旧版 User-Agent
+	invalidUTF8Reject  = "reject"  // 返回 400
+)
+
+// Unicode 规范化形式
+const (
+	normalizeNFC  = "nfc"
+	normalizeNone = "none"
+)
+
+// SanitizeConfig 端点的字符串清理：去除控制字符、规范化为 NFC 并处理无效 UTF-8，
+// 避免 Doris 因编码问题过滤整行（只能事后通过 ErrorURL 发现）
+type SanitizeConfig struct {
+	InvalidUTF8  string `yaml:"invalid_utf8,omitempty" json:"invalid_utf8,omitempty"`   // replace（默认）、strip、latin1 或 reject
+	Normalize    string `yaml:"normalize,omitempty" json:"normalize,omitempty"`         // nfc（默认）或 none
+	KeepControls bool   `yaml:"keep_controls,omitempty" json:"keep_controls,omitempty"` // 保留控制字符，默认去除（制表符和换行除外）
+}
+
+// validate 校验清理配置并设置默认值
+func (s *SanitizeConfig) validate() error {
+	s.InvalidUTF8 = defaultString(s.InvalidUTF8, invalidUTF8Replace)
+	switch s.InvalidUTF8 {
+	case invalidUTF8Replace, invalidUTF8Strip, invalidUTF8Latin1, invalidUTF8Reject:
+	default:
+		return fmt.Errorf("sanitize.invalid_utf8 无效: %q（可选 replace、strip、latin1、reject）", s.InvalidUTF8)
+	}
+	s.Normalize = defaultString(s.Normalize, normalizeNFC)
+	if s.Normalize != normalizeNFC && s.Normalize != normalizeNone {
+		return fmt.Errorf("sanitize.normalize 无效: %q（可选 nfc、none）", s.Normalize)
+	}
+	return nil
+}
+
+// fixUTF8 按 invalid_utf8 处理无效的 UTF-8 字节，有效的输入原样返回
+func (s *SanitizeConfig) fixUTF8(b []byte) ([]byte, error) {
+	if utf8.Valid(b) {
+		return b, nil
+	}
+	switch s.InvalidUTF8 {
+	case invalidUTF8Reject:
+		return nil, fmt.Errorf("invalid UTF-8")
+	case invalidUTF8Strip:
+		return bytes.ToValidUTF8(b, nil), nil
+	case invalidUTF8Latin1:
+		out := make([]byte, 0, len(b)+8)
+		for len(b) > 0 {
+			r, size := utf8.DecodeRune(b)
+			if r == utf8.RuneError && size <= 1 {
+				// ISO-8859-1 的字节值即码位
+				out = utf8.AppendRune(out, rune(b[0]))
+				size = 1
+			} else {
+				out = append(out, b[:size]...)
+			}
+			b = b[size:]
+		}
+		return out, nil
+	default:
+		return bytes.ToValidUTF8(b, []byte(string(utf8.RuneError))), nil
+	}
+}
+
+// sanitizeBody 在解析前处理请求体中的无效 UTF-8：JSON 解析会将无效字节替换为 U+FFFD，
+// strip、latin1 和 reject 需要在解析前处理；未配置 sanitize 时原样返回
+func (ep *Endpoint) sanitizeBody(raw []byte) ([]byte, error) {
+	if ep.Sanitize == nil {
+		return raw, nil
+	}
+	out, err := ep.Sanitize.fixUTF8(raw)
+	if err != nil {
+		return nil, fmt.Errorf("request body: %w", err)
+	}
+	return out, nil
+}
+
+// sanitizeString 清理字符串：处理无效 UTF-8，去除控制字符并规范化为 NFC
+func (s *SanitizeConfig) sanitizeString(v string) (string, error) {
+	b, err := s.fixUTF8([]byte(v))
+	if err != nil {
+		return "", err
+	}
+	v = string(b)
+	if !s.KeepControls {
+		v = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) && r != '\t' && r != '\n' {
+				return -1
+			}
+			return r
+		}, v)
+	}
+	if s.Normalize == normalizeNFC {
+		v = norm.NFC.String(v)
+	}
+	return v, nil
+}
+
+// sanitizeValue 清理字段值中的字符串，对象和数组中的字符串（含对象的键）递归清理
+func (s *SanitizeConfig) sanitizeValue(field string, v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		out, err := s.sanitizeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", field, err)
+		}
+		return out, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			key, err := s.sanitizeString(k)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %w", field, err)
+			}
+			if out[key], err = s.sanitizeValue(field, item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			var err error
+			if out[i], err = s.sanitizeValue(field, item); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"doris-webhook/dorisload"
+)
+
+// scalingQuantiles /admin/scaling 和 /metrics 输出的 Doris 写入耗时分位数
+var scalingQuantiles = []float64{50, 95, 99}
+
+// tableQueue 单个表的批量写入队列
+type tableQueue struct {
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+}
+
+// scalingSignals 扩缩容信号，供 KEDA/HPA 外部指标按写入压力而不是 CPU 扩缩容
+type scalingSignals struct {
+	QueueDepth       int                   `json:"queue_depth"`       // 批量写入队列中等待的事件数（所有表）
+	QueueCapacity    int                   `json:"queue_capacity"`    // 批量写入队列长度上限之和，未启用批量写入时为 0
+	QueueUtilization float64               `json:"queue_utilization"` // queue_depth / queue_capacity
+	Tables           map[string]tableQueue `json:"tables,omitempty"`
+
+	DorisInflight     int64   `json:"doris_inflight"`
+	DorisMaxInflight  int     `json:"doris_max_inflight"`
+	WorkerUtilization float64 `json:"worker_utilization"` // 并发 Stream Load 槽位的使用率
+
+	DorisLatencyMs      map[string]float64 `json:"doris_latency_ms"` // 最近成功写入耗时的分位数（p50、p95、p99）
+	DorisLatencySamples int                `json:"doris_latency_samples"`
+
+	WALPendingSegments int   `json:"wal_pending_segments"`
+	WALPendingBytes    int64 `json:"wal_pending_bytes"`
+	Degraded           bool  `json:"degraded"`
+
+	// Pressure 综合写入压力：队列和并发槽位使用率的较大值，降级模式下为 1
+	// 持续接近 1 说明当前副本数不足以消化写入流量
+	Pressure float64 `json:"pressure"`
+}
+
+// scalingSignals 采集当前的扩缩容信号
+func (app *App) scalingSignals() scalingSignals {
+	s := scalingSignals{
+		DorisInflight:    app.limiter.Inflight(),
+		DorisMaxInflight: app.limiter.Capacity(),
+		Degraded:         app.degraded.Load(),
+		DorisLatencyMs:   make(map[string]float64, len(scalingQuantiles)),
+	}
+	if len(app.batchers) > 0 {
+		s.Tables = make(map[string]tableQueue, len(app.batchers))
+		for name, b := range app.batchers {
+			q := tableQueue{Depth: b.QueueLength(), Capacity: b.QueueCapacity()}
+			s.Tables[name] = q
+			s.QueueDepth += q.Depth
+			s.QueueCapacity += q.Capacity
+		}
+		s.QueueUtilization = float64(s.QueueDepth) / float64(s.QueueCapacity)
+	}
+	s.WorkerUtilization = float64(s.DorisInflight) / float64(s.DorisMaxInflight)
+	for _, q := range scalingQuantiles {
+		d, n := app.clusters.Default().Latency(q)
+		s.DorisLatencyMs[fmt.Sprintf("p%g", q)] = float64(d) / float64(time.Millisecond)
+		s.DorisLatencySamples = n
+	}
+	if app.wal != nil {
+		s.WALPendingSegments, s.WALPendingBytes = app.wal.Pending()
+	}
+
+	s.Pressure = max(s.QueueUtilization, s.WorkerUtilization)
+	if s.Degraded {
+		s.Pressure = 1
+	}
+	return s
+}
+
+// scalingHandler 返回扩缩容信号，可直接用作 KEDA metrics-api scaler 的数据源
+func (app *App) scalingHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.scalingSignals())
+}
+
+// metricsHandler 以 Prometheus 文本格式输出扩缩容信号
+func (app *App) metricsHandler(c *gin.Context) {
+	// 启用 METRICS_EXEMPLARS 且抓取方接受 OpenMetrics 时，耗时直方图的桶附带最近一次写入的 trace-id
+	if app.config.ExemplarTraceID != nil && acceptsOpenMetrics(c) {
+		c.Data(http.StatusOK, openMetricsContentType, []byte(toOpenMetrics(app.renderMetrics(true))))
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(app.renderMetrics(false)))
+}
+
+// renderMetrics 以 Prometheus 文本格式输出全部指标，openMetrics 为 true 时直方图的桶附带 exemplar
+// StatsD 输出（METRICS_BACKEND）也由此取得 gauge 和 counter
+func (app *App) renderMetrics(openMetrics bool) string {
+	s := app.scalingSignals()
+	exemplar := func(exemplars []dorisload.Exemplar, i int) string {
+		if !openMetrics {
+			return ""
+		}
+		return formatExemplar(exemplars, i)
+	}
+	var b strings.Builder
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP doris_webhook_%s %s\n# TYPE doris_webhook_%s gauge\n", name, help, name)
+	}
+	boolValue := func(v bool) int {
+		if v {
+			return 1
+		}
+		return 0
+	}
+
+	info := buildInfo()
+	gauge("build_info", "Build information; always 1. Join on this metric to attach version labels to other series.")
+	fmt.Fprintf(&b, "doris_webhook_build_info{version=%q,commit=%q,build_date=%q,go_version=%q} 1\n", info.Version, info.Commit, info.BuildDate, info.GoVersion)
+
+	tables := make([]string, 0, len(s.Tables))
+	for name := range s.Tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+
+	gauge("queue_depth", "Events waiting in the batch queue.")
+	for _, name := range tables {
+		fmt.Fprintf(&b, "doris_webhook_queue_depth{table=%q} %d\n", name, s.Tables[name].Depth)
+	}
+	gauge("queue_capacity", "Batch queue capacity.")
+	for _, name := range tables {
+		fmt.Fprintf(&b, "doris_webhook_queue_capacity{table=%q} %d\n", name, s.Tables[name].Capacity)
+	}
+	gauge("queue_utilization", "Batch queue depth divided by capacity across all tables.")
+	fmt.Fprintf(&b, "doris_webhook_queue_utilization %g\n", s.QueueUtilization)
+	if keys := app.batchKeyStats(tables); len(keys) > 0 {
+		gauge("batch_project_queued_rows", "Rows waiting in the batch queue per project (BATCH_FAIRNESS).")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "doris_webhook_batch_project_queued_rows{table=\"%s\",project=\"%s\"} %d\n", labelValue(k.table), labelValue(k.Key), k.QueuedRows)
+		}
+		b.WriteString("# HELP doris_webhook_batch_project_throttled_total Requests that waited because the project's share of the batch queue was full.\n# TYPE doris_webhook_batch_project_throttled_total counter\n")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "doris_webhook_batch_project_throttled_total{table=\"%s\",project=\"%s\"} %d\n", labelValue(k.table), labelValue(k.Key), k.Throttled)
+		}
+	}
+
+	gauge("doris_inflight", "Stream Loads in flight.")
+	fmt.Fprintf(&b, "doris_webhook_doris_inflight %d\n", s.DorisInflight)
+	gauge("doris_max_inflight", "Maximum concurrent Stream Loads (DORIS_MAX_INFLIGHT).")
+	fmt.Fprintf(&b, "doris_webhook_doris_max_inflight %d\n", s.DorisMaxInflight)
+	gauge("worker_utilization", "Stream Load slots in use divided by DORIS_MAX_INFLIGHT.")
+	fmt.Fprintf(&b, "doris_webhook_worker_utilization %g\n", s.WorkerUtilization)
+
+	gauge("doris_latency_seconds", "Quantiles of recent successful Stream Load attempt latency.")
+	for _, q := range scalingQuantiles {
+		ms := s.DorisLatencyMs[fmt.Sprintf("p%g", q)]
+		fmt.Fprintf(&b, "doris_webhook_doris_latency_seconds{quantile=\"%g\"} %g\n", q/100, ms/1000)
+	}
+
+	gauge("wal_pending_segments", "WAL segments waiting to be replayed.")
+	fmt.Fprintf(&b, "doris_webhook_wal_pending_segments %d\n", s.WALPendingSegments)
+	gauge("wal_pending_bytes", "WAL bytes waiting to be replayed.")
+	fmt.Fprintf(&b, "doris_webhook_wal_pending_bytes %d\n", s.WALPendingBytes)
+	if retention := app.wal.RetentionStats(); retention != nil {
+		b.WriteString("# HELP doris_webhook_wal_expired_segments_total WAL segments deleted by the retention policy (WAL_RETENTION_*).\n# TYPE doris_webhook_wal_expired_segments_total counter\n")
+		for _, reason := range []string{walExpiredAge, walExpiredBytes, walExpiredSegments} {
+			fmt.Fprintf(&b, "doris_webhook_wal_expired_segments_total{reason=%q} %d\n", reason, retention.ExpiredSegments[reason])
+		}
+		b.WriteString("# HELP doris_webhook_wal_expired_bytes_total WAL bytes deleted by the retention policy.\n# TYPE doris_webhook_wal_expired_bytes_total counter\n")
+		for _, reason := range []string{walExpiredAge, walExpiredBytes, walExpiredSegments} {
+			fmt.Fprintf(&b, "doris_webhook_wal_expired_bytes_total{reason=%q} %d\n", reason, retention.ExpiredBytes[reason])
+		}
+		b.WriteString("# HELP doris_webhook_wal_rejected_events_total Events rejected because the WAL reached its retention limit (reject-newest).\n# TYPE doris_webhook_wal_rejected_events_total counter\n")
+		fmt.Fprintf(&b, "doris_webhook_wal_rejected_events_total %d\n", retention.RejectedEvents)
+		gauge("wal_retained_bytes", "WAL bytes counted against WAL_RETENTION_MAX_BYTES, including dead-lettered segments.")
+		fmt.Fprintf(&b, "doris_webhook_wal_retained_bytes %d\n", retention.RetainedBytes)
+	}
+	gauge("degraded", "1 when running in degraded mode (Doris unavailable, events spilled to WAL).")
+	fmt.Fprintf(&b, "doris_webhook_degraded %d\n", boolValue(s.Degraded))
+	gauge("pressure", "Ingestion pressure: max of queue and worker utilization, 1 when degraded.")
+	fmt.Fprintf(&b, "doris_webhook_pressure %g\n", s.Pressure)
+
+	// Stream Load 各阶段耗时，来自 BE 返回的 BeginTxnTimeMs、WriteDataTimeMs 等字段
+	b.WriteString("# HELP doris_webhook_stream_load_phase_seconds Per-phase timing of successful Stream Loads reported by the BE.\n# TYPE doris_webhook_stream_load_phase_seconds histogram\n")
+	for _, h := range app.clusters.PhaseTimings() {
+		for i, le := range dorisload.PhaseBuckets {
+			fmt.Fprintf(&b, "doris_webhook_stream_load_phase_seconds_bucket{table=%q,phase=%q,le=\"%g\"} %d%s\n", h.Table, h.Phase, le, h.Buckets[i], exemplar(h.Exemplars, i))
+		}
+		fmt.Fprintf(&b, "doris_webhook_stream_load_phase_seconds_bucket{table=%q,phase=%q,le=\"+Inf\"} %d%s\n", h.Table, h.Phase, h.Count, exemplar(h.Exemplars, len(dorisload.PhaseBuckets)))
+		fmt.Fprintf(&b, "doris_webhook_stream_load_phase_seconds_sum{table=%q,phase=%q} %g\n", h.Table, h.Phase, h.Sum)
+		fmt.Fprintf(&b, "doris_webhook_stream_load_phase_seconds_count{table=%q,phase=%q} %d\n", h.Table, h.Phase, h.Count)
+	}
+
+	// 发往 BE 的请求在客户端侧的各阶段耗时，来自 httptrace；复用连接时没有 dns、connect、tls
+	b.WriteString("# HELP doris_webhook_stream_load_client_seconds Client-side timing of Stream Load requests per BE (DNS, connect, TLS, 100-continue wait, time to first byte).\n# TYPE doris_webhook_stream_load_client_seconds histogram\n")
+	for _, h := range app.clusters.TraceTimings() {
+		for i, le := range dorisload.PhaseBuckets {
+			fmt.Fprintf(&b, "doris_webhook_stream_load_client_seconds_bucket{be=%q,phase=%q,le=\"%g\"} %d%s\n", h.BE, h.Phase, le, h.Buckets[i], exemplar(h.Exemplars, i))
+		}
+		fmt.Fprintf(&b, "doris_webhook_stream_load_client_seconds_bucket{be=%q,phase=%q,le=\"+Inf\"} %d%s\n", h.BE, h.Phase, h.Count, exemplar(h.Exemplars, len(dorisload.PhaseBuckets)))
+		fmt.Fprintf(&b, "doris_webhook_stream_load_client_seconds_sum{be=%q,phase=%q} %g\n", h.BE, h.Phase, h.Sum)
+		fmt.Fprintf(&b, "doris_webhook_stream_load_client_seconds_count{be=%q,phase=%q} %d\n", h.BE, h.Phase, h.Count)
+	}
+
+	// 迟到事件，只输出配置了 late 的端点
+	var late []*Endpoint
+	for _, ep := range app.registry.Endpoints {
+		if ep.Late != nil {
+			late = append(late, ep)
+		}
+	}
+	if len(late) > 0 {
+		b.WriteString("# HELP doris_webhook_late_events_total Events routed to the endpoint's late table.\n# TYPE doris_webhook_late_events_total counter\n")
+		for _, ep := range late {
+			fmt.Fprintf(&b, "doris_webhook_late_events_total{endpoint=%q,table=%q} %d\n", ep.Name, ep.Late.TableName, ep.Late.Routed())
+		}
+	}
+
+	// 集群 A/B 分流，side 为 primary（目标表）或 split（分流目标）
+	var splits []*Endpoint
+	for _, ep := range app.registry.Endpoints {
+		if ep.Split != nil {
+			splits = append(splits, ep)
+		}
+	}
+	if len(splits) > 0 {
+		b.WriteString("# HELP doris_webhook_split_rows_total Rows written to each side of the endpoint's cluster split.\n# TYPE doris_webhook_split_rows_total counter\n")
+		for _, ep := range splits {
+			s := ep.Split.Stats()
+			fmt.Fprintf(&b, "doris_webhook_split_rows_total{endpoint=%q,sink=%q,side=\"primary\"} %d\n", ep.Name, s.Sink, s.Primary.Rows)
+			fmt.Fprintf(&b, "doris_webhook_split_rows_total{endpoint=%q,sink=%q,side=\"split\"} %d\n", ep.Name, s.Sink, s.Split.Rows)
+		}
+		b.WriteString("# HELP doris_webhook_split_failures_total Failed writes on each side of the endpoint's cluster split.\n# TYPE doris_webhook_split_failures_total counter\n")
+		for _, ep := range splits {
+			s := ep.Split.Stats()
+			fmt.Fprintf(&b, "doris_webhook_split_failures_total{endpoint=%q,sink=%q,side=\"primary\"} %d\n", ep.Name, s.Sink, s.Primary.Failures)
+			fmt.Fprintf(&b, "doris_webhook_split_failures_total{endpoint=%q,sink=%q,side=\"split\"} %d\n", ep.Name, s.Sink, s.Split.Failures)
+		}
+	}
+
+	// 双集群复制，只输出副本集群；divergence 为主集群比副本集群多提交的行数
+	if app.replicas != nil {
+		stats := app.replicas.Stats()
+		b.WriteString("# HELP doris_webhook_replica_rows_total Rows committed to the primary and the replica cluster.\n# TYPE doris_webhook_replica_rows_total counter\n")
+		for _, name := range app.replicas.Names() {
+			s := stats[name]
+			fmt.Fprintf(&b, "doris_webhook_replica_rows_total{replica=%q,primary=%q,side=\"primary\"} %d\n", name, s.Primary, s.PrimaryRows)
+			fmt.Fprintf(&b, "doris_webhook_replica_rows_total{replica=%q,primary=%q,side=\"replica\"} %d\n", name, s.Primary, s.ReplicaRows)
+		}
+		gauge("replica_divergence_rows", "Rows committed to the primary but not yet to its replica cluster.")
+		for _, name := range app.replicas.Names() {
+			fmt.Fprintf(&b, "doris_webhook_replica_divergence_rows{replica=%q} %d\n", name, stats[name].Divergence)
+		}
+		gauge("replica_backlog_bytes", "Replica backlog bytes waiting to be replayed.")
+		for _, name := range app.replicas.Names() {
+			fmt.Fprintf(&b, "doris_webhook_replica_backlog_bytes{replica=%q} %d\n", name, stats[name].BacklogBytes)
+		}
+		b.WriteString("# HELP doris_webhook_replica_failures_total Failed direct writes to the replica cluster.\n# TYPE doris_webhook_replica_failures_total counter\n")
+		for _, name := range app.replicas.Names() {
+			fmt.Fprintf(&b, "doris_webhook_replica_failures_total{replica=%q} %d\n", name, stats[name].Failures)
+		}
+	}
+
+	// BE 健康检查，未启用时不输出
+	if backends := app.clusters.Health(); backends != nil {
+		gauge("be_up", "1 when the BE passes health checks and is in rotation.")
+		for _, h := range backends {
+			fmt.Fprintf(&b, "doris_webhook_be_up{be=%q} %d\n", h.URL, boolValue(h.Healthy))
+		}
+		gauge("be_probe_latency_seconds", "Latency of the last BE health check.")
+		for _, h := range backends {
+			fmt.Fprintf(&b, "doris_webhook_be_probe_latency_seconds{be=%q} %g\n", h.URL, h.LastLatencyMs/1000)
+		}
+		b.WriteString("# HELP doris_webhook_be_probe_failures_total Failed BE health checks.\n# TYPE doris_webhook_be_probe_failures_total counter\n")
+		for _, h := range backends {
+			fmt.Fprintf(&b, "doris_webhook_be_probe_failures_total{be=%q} %d\n", h.URL, h.Failures)
+		}
+		b.WriteString("# HELP doris_webhook_be_probes_total BE health checks.\n# TYPE doris_webhook_be_probes_total counter\n")
+		for _, h := range backends {
+			fmt.Fprintf(&b, "doris_webhook_be_probes_total{be=%q} %d\n", h.URL, h.Probes)
+		}
+	}
+
+	return b.String()
+}
+
+// labelValueEscaper 按 Prometheus 文本格式转义标签值：只转义反斜杠、双引号和换行，其余字符（含非 ASCII）原样输出
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue 转义来自客户端的标签值（如项目名）；%q 会按 Go 语法转义非 ASCII 和控制字符，与 Prometheus 的解析结果不一致
+func labelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+// tableBatchKey 一个表的批量写入队列中一个项目的排队情况
+type tableBatchKey struct {
+	dorisload.BatchKeyStats
+	table string
+}
+
+// batchKeyStats 返回启用公平调度的批量写入队列中各项目的排队情况，按表名排序
+func (app *App) batchKeyStats(tables []string) []tableBatchKey {
+	var keys []tableBatchKey
+	for _, name := range tables {
+		b := app.batchers[name]
+		if b == nil {
+			continue
+		}
+		for _, k := range b.KeyStats() {
+			keys = append(keys, tableBatchKey{BatchKeyStats: k, table: name})
+		}
+	}
+	return keys
+}
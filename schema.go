@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SchemaSampler 按比例采样各端点收到的请求体，统计实际出现的字段及其类型，
+// 与列映射对比找出被丢弃的字段，便于保持映射和 Doris 表结构与客户端实际发送的数据一致
+type SchemaSampler struct {
+	percent   float64
+	maxFields int // 每个端点最多统计的字段数，超出的新字段只计数
+
+	mu        sync.Mutex
+	endpoints map[string]*endpointSchema // 按端点名索引
+}
+
+// endpointSchema 一个端点的采样统计
+type endpointSchema struct {
+	samples  int64
+	overflow int64 // 超出 maxFields 未统计的字段出现次数
+	fields   map[string]*fieldSchema
+}
+
+// fieldSchema 一个字段的采样统计
+type fieldSchema struct {
+	seen  int64
+	types map[string]int64 // 按 JSON 类型计数：string、int、float、bool、object、array、null
+}
+
+// SchemaReport 端点的字段推断结果，通过 /admin/schema 查看
+type SchemaReport struct {
+	Endpoint string        `json:"endpoint"`
+	Samples  int64         `json:"samples"`
+	Fields   []FieldReport `json:"fields"`
+	Dropped  []string      `json:"dropped"`            // 请求中出现但没有被任何列映射读取的字段
+	Missing  []string      `json:"missing"`            // 列映射读取但采样中从未出现的字段
+	Overflow int64         `json:"overflow,omitempty"` // 超出 SCHEMA_MAX_FIELDS 未统计的字段出现次数
+}
+
+// FieldReport 字段的推断结果
+type FieldReport struct {
+	Field     string           `json:"field"`
+	Types     map[string]int64 `json:"types"`
+	Presence  float64          `json:"presence"`          // 出现该字段的采样比例
+	Columns   []string         `json:"columns,omitempty"` // 读取该字段的列（含 dual_write 表的列）
+	DorisType string           `json:"doris_type"`        // 按出现过的类型建议的 Doris 列类型
+	Nullable  bool             `json:"nullable"`          // 出现过 null 或有采样缺少该字段
+	Mapped    bool             `json:"mapped"`            // 是否被列映射读取
+}
+
+// newSchemaSampler 根据环境变量创建字段采样，SCHEMA_SAMPLE_PERCENT 为 0（默认）时返回 nil
+func newSchemaSampler() (*SchemaSampler, error) {
+	percent, err := strconv.ParseFloat(getEnv("SCHEMA_SAMPLE_PERCENT", "0"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("SCHEMA_SAMPLE_PERCENT 无效: %q", getEnv("SCHEMA_SAMPLE_PERCENT", ""))
+	}
+	maxFields, err := strconv.Atoi(getEnv("SCHEMA_MAX_FIELDS", "200"))
+	if err != nil || maxFields <= 0 {
+		return nil, fmt.Errorf("SCHEMA_MAX_FIELDS 无效: %q", getEnv("SCHEMA_MAX_FIELDS", ""))
+	}
+	if percent == 0 {
+		return nil, nil
+	}
+	return &SchemaSampler{percent: percent, maxFields: maxFields, endpoints: make(map[string]*endpointSchema)}, nil
+}
+
+// Observe 按比例采样一个请求体（单个事件）
+func (s *SchemaSampler) Observe(ep *Endpoint, body map[string]any) {
+	if s.percent < 100 && rand.Float64()*100 >= s.percent {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	es := s.endpoints[ep.Name]
+	if es == nil {
+		es = &endpointSchema{fields: make(map[string]*fieldSchema)}
+		s.endpoints[ep.Name] = es
+	}
+	es.samples++
+	for name, v := range body {
+		fs := es.fields[name]
+		if fs == nil {
+			if len(es.fields) >= s.maxFields {
+				es.overflow++
+				continue
+			}
+			fs = &fieldSchema{types: make(map[string]int64)}
+			es.fields[name] = fs
+		}
+		fs.seen++
+		fs.types[jsonType(v)]++
+	}
+}
+
+// Report 返回端点的字段推断结果，按字段名排列
+func (s *SchemaSampler) Report(ep *Endpoint) SchemaReport {
+	readers := ep.fieldReaders()
+	report := SchemaReport{Endpoint: ep.Name, Fields: []FieldReport{}, Dropped: []string{}, Missing: []string{}}
+
+	s.mu.Lock()
+	es := s.endpoints[ep.Name]
+	if es != nil {
+		report.Samples, report.Overflow = es.samples, es.overflow
+		for name, fs := range es.fields {
+			types := make(map[string]int64, len(fs.types))
+			for t, n := range fs.types {
+				types[t] = n
+			}
+			report.Fields = append(report.Fields, FieldReport{
+				Field:     name,
+				Types:     types,
+				Presence:  math.Round(float64(fs.seen)/float64(es.samples)*1000) / 1000,
+				Columns:   readers[name],
+				DorisType: suggestDorisType(fs.types),
+				Nullable:  fs.types["null"] > 0 || fs.seen < es.samples,
+				Mapped:    readers[name] != nil,
+			})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(report.Fields, func(i, j int) bool { return report.Fields[i].Field < report.Fields[j].Field })
+	seen := make(map[string]bool, len(report.Fields))
+	for _, f := range report.Fields {
+		seen[f.Field] = true
+		if !f.Mapped {
+			report.Dropped = append(report.Dropped, f.Field)
+		}
+	}
+	for field := range readers {
+		if !seen[field] {
+			report.Missing = append(report.Missing, field)
+		}
+	}
+	sort.Strings(report.Missing)
+	return report
+}
+
+// fieldReaders 返回端点从请求体读取的字段及读取它们的列（含 dual_write 表的列，列名前加表名）
+func (ep *Endpoint) fieldReaders() map[string][]string {
+	readers := make(map[string][]string)
+	add := func(columns []ColumnMapping, prefix string) {
+		for _, m := range columns {
+			if m.Source == sourceBody || m.Source == sourceOriginalTimestamp {
+				readers[m.Field] = append(readers[m.Field], prefix+m.Column)
+			}
+		}
+	}
+	add(ep.Columns, "")
+	for _, dw := range ep.DualWrite {
+		add(dw.Columns, dw.TableName+".")
+	}
+	return readers
+}
+
+// jsonType 返回解码后的 JSON 值的类型，整数值的数字为 int
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "int"
+		}
+		return "float"
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return "int"
+		}
+		return "float"
+	case int, int64:
+		return "int"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// suggestDorisType 按出现的类型建议 Doris 列类型：整数和小数混合时为 DOUBLE，类型不一致时为 STRING
+func suggestDorisType(types map[string]int64) string {
+	has := func(t string) bool { return types[t] > 0 }
+	var kinds int
+	for t, n := range types {
+		if t != "null" && n > 0 {
+			kinds++
+		}
+	}
+	switch {
+	case kinds == 0:
+		return "STRING"
+	case kinds == 1 && has("int"):
+		return "BIGINT"
+	case kinds == 1 && has("float"), kinds == 2 && has("int") && has("float"):
+		return "DOUBLE"
+	case kinds == 1 && has("bool"):
+		return "BOOLEAN"
+	case kinds == 1 && (has("object") || has("array")):
+		return "JSON"
+	}
+	return "STRING"
+}
+
+// schemaHandler 返回各端点（或 endpoint 参数指定的端点）的字段推断结果
+func (app *App) schemaHandler(c *gin.Context) {
+	endpoints := app.registry.Endpoints
+	if name := c.Query("endpoint"); name != "" {
+		endpoints = nil
+		for _, ep := range app.registry.Endpoints {
+			if ep.Name == name {
+				endpoints = append(endpoints, ep)
+			}
+		}
+		if len(endpoints) == 0 {
+			abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Unknown endpoint %q", name), nil)
+			return
+		}
+	}
+	reports := make([]SchemaReport, 0, len(endpoints))
+	for _, ep := range endpoints {
+		reports = append(reports, app.schema.Report(ep))
+	}
+	c.JSON(http.StatusOK, gin.H{"sample_percent": app.schema.percent, "endpoints": reports})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sdkConfigPath 客户端 SDK 拉取远程配置的路径
+const sdkConfigPath = "/sdk/config"
+
+// SDKConfig 配置文件中的 sdk：客户端 SDK（tracker.js、移动端 SDK）定期拉取的远程配置，
+// 调整采样率、批大小等客户端行为无需发布新版本应用
+type SDKConfig struct {
+	Defaults SDKSettings             `yaml:"defaults" json:"defaults"`
+	Projects map[string]*SDKSettings `yaml:"projects" json:"projects"` // 按 project 覆盖，未出现的字段使用 defaults
+	MaxAge   string                  `yaml:"max_age" json:"max_age"`   // 响应的缓存时间（如 5m），SDK 按此间隔拉取，默认 5m
+}
+
+// SDKSettings 返回给 SDK 的设置，字段为 nil 时不覆盖
+type SDKSettings struct {
+	SampleRate      *float64          `yaml:"sample_rate" json:"sample_rate,omitempty"`             // 采样率，0～1
+	BatchSize       *int              `yaml:"batch_size" json:"batch_size,omitempty"`               // 客户端攒批的事件数
+	FlushIntervalMs *int              `yaml:"flush_interval_ms" json:"flush_interval_ms,omitempty"` // 客户端攒批的最长时间
+	Endpoints       map[string]string `yaml:"endpoints" json:"endpoints,omitempty"`                 // 上报地址，按名称（如 events、bulk）合并
+	DisabledEvents  []string          `yaml:"disabled_events" json:"disabled_events,omitempty"`     // 客户端不上报的事件类型，覆盖而不是合并
+}
+
+// SDKConfigServer 按 project 合并 SDK 配置，预先序列化每个 project 的响应
+type SDKConfigServer struct {
+	maxAge    time.Duration
+	defaults  sdkResponse
+	responses map[string]sdkResponse
+}
+
+// sdkResponse 预先序列化的响应体和 ETag
+type sdkResponse struct {
+	body []byte
+	etag string
+}
+
+// newSDKConfigServer 校验配置文件中的 sdk，未配置时返回 nil
+func newSDKConfigServer(reg *Registry) (*SDKConfigServer, error) {
+	sc := reg.SDK
+	if sc == nil {
+		return nil, nil
+	}
+	if reg.endpointByPath(sdkConfigPath) != nil {
+		return nil, fmt.Errorf("sdk: 端点路径不能为 %s", sdkConfigPath)
+	}
+	s := &SDKConfigServer{maxAge: 5 * time.Minute, responses: make(map[string]sdkResponse, len(sc.Projects))}
+	if sc.MaxAge != "" {
+		d, err := time.ParseDuration(sc.MaxAge)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("sdk.max_age 无效: %q", sc.MaxAge)
+		}
+		s.maxAge = d
+	}
+	if err := sc.Defaults.validate(); err != nil {
+		return nil, fmt.Errorf("sdk.defaults: %w", err)
+	}
+	var err error
+	if s.defaults, err = newSDKResponse("", &sc.Defaults); err != nil {
+		return nil, err
+	}
+	for project, ps := range sc.Projects {
+		if ps == nil {
+			ps = &SDKSettings{}
+		}
+		if err := ps.validate(); err != nil {
+			return nil, fmt.Errorf("sdk.projects.%s: %w", project, err)
+		}
+		if s.responses[project], err = newSDKResponse(project, sc.Defaults.merge(ps)); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// validate 校验设置的取值范围
+func (st *SDKSettings) validate() error {
+	if st.SampleRate != nil && (*st.SampleRate < 0 || *st.SampleRate > 1) {
+		return fmt.Errorf("sample_rate 必须在 [0, 1] 内: %v", *st.SampleRate)
+	}
+	if st.BatchSize != nil && *st.BatchSize <= 0 {
+		return fmt.Errorf("batch_size 必须为正数: %d", *st.BatchSize)
+	}
+	if st.FlushIntervalMs != nil && *st.FlushIntervalMs <= 0 {
+		return fmt.Errorf("flush_interval_ms 必须为正数: %d", *st.FlushIntervalMs)
+	}
+	for name, raw := range st.Endpoints {
+		// 绝对地址或本服务的路径
+		if u, err := url.Parse(raw); err != nil || (!strings.HasPrefix(raw, "/") && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "")) {
+			return fmt.Errorf("endpoints.%s 必须是 http(s) 地址或以 / 开头的路径: %q", name, raw)
+		}
+	}
+	return nil
+}
+
+// merge 返回 st 被 override 覆盖后的设置
+func (st *SDKSettings) merge(override *SDKSettings) *SDKSettings {
+	out := *st
+	if override.SampleRate != nil {
+		out.SampleRate = override.SampleRate
+	}
+	if override.BatchSize != nil {
+		out.BatchSize = override.BatchSize
+	}
+	if override.FlushIntervalMs != nil {
+		out.FlushIntervalMs = override.FlushIntervalMs
+	}
+	if len(override.Endpoints) > 0 {
+		out.Endpoints = maps.Clone(st.Endpoints)
+		if out.Endpoints == nil {
+			out.Endpoints = make(map[string]string, len(override.Endpoints))
+		}
+		maps.Copy(out.Endpoints, override.Endpoints)
+	}
+	if override.DisabledEvents != nil {
+		out.DisabledEvents = override.DisabledEvents
+	}
+	return &out
+}
+
+// newSDKResponse 序列化 project 的设置，ETag 为响应体的哈希，配置不变时 SDK 收到 304
+func newSDKResponse(project string, st *SDKSettings) (sdkResponse, error) {
+	body, err := json.Marshal(struct {
+		Project string `json:"project,omitempty"`
+		*SDKSettings
+	}{project, st})
+	if err != nil {
+		return sdkResponse{}, err
+	}
+	sum := sha256.Sum256(body)
+	return sdkResponse{body: body, etag: `"` + hex.EncodeToString(sum[:8]) + `"`}, nil
+}
+
+// handler GET /sdk/config?project=...，未配置的 project 返回 defaults
+func (s *SDKConfigServer) handler(c *gin.Context) {
+	resp, ok := s.responses[c.Query("project")]
+	if !ok {
+		resp = s.defaults
+	}
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(s.maxAge/time.Second)))
+	c.Header("ETag", resp.etag)
+	if c.GetHeader("If-None-Match") == resp.etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", resp.body)
+}
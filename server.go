@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+)
+
+// serverConfig HTTP 服务的监听地址、超时和请求头上限
+type serverConfig struct {
+	network, addr   string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration // 关闭时等待进行中的请求完成的时间
+	maxHeaderBytes  int
+}
+
+// loadServerConfig 读取 LISTEN_ADDR 和 HTTP_* 环境变量，所有问题一起返回（configErrors）
+// 时长不带单位时按秒计，HTTP_WRITE_TIMEOUT 应大于 REQUEST_TIMEOUT_MS，否则超时的请求来不及返回 504
+func loadServerConfig() (*serverConfig, error) {
+	var errs configErrors
+	sc := &serverConfig{}
+	var err error
+	sc.network, sc.addr, err = listenConfig()
+	errs.add("LISTEN_ADDR", err)
+
+	durations := []struct {
+		key, def string
+		dst      *time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", "10s", &sc.readTimeout},
+		{"HTTP_WRITE_TIMEOUT", "30s", &sc.writeTimeout},
+		{"HTTP_IDLE_TIMEOUT", "120s", &sc.idleTimeout},
+		{"SHUTDOWN_TIMEOUT", "5s", &sc.shutdownTimeout},
+	}
+	for _, d := range durations {
+		*d.dst, err = envDuration(d.key, d.def, time.Second, time.Second, 24*time.Hour)
+		errs.add("", err)
+	}
+	maxHeaderBytes, err := envByteSize("HTTP_MAX_HEADER_BYTES", "1MB", 1, 4<<10, 64<<20)
+	errs.add("", err)
+	sc.maxHeaderBytes = int(maxHeaderBytes)
+
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/google/uuid"
+
+	"doris-webhook/dorisload"
+)
+
+// ShadowConfig 端点的影子流量：按比例将事件复制到另一个输出目标，不影响主写入路径和响应
+type ShadowConfig struct {
+	Sink    string  `yaml:"sink" json:"sink"`
+	Percent float64 `yaml:"percent" json:"percent"` // 复制的请求比例，0～100
+}
+
+// shadow 按比例在后台将事件写入端点的影子目标，失败只记录日志
+func (app *App) shadow(ctx context.Context, ep *Endpoint, batch *SinkBatch) {
+	for _, sc := range ep.Shadow {
+		if sc.Percent < 100 && rand.Float64()*100 >= sc.Percent {
+			continue
+		}
+		name, sink := sc.Sink, app.sinks[sc.Sink]
+		app.sinkWG.Add(1)
+		go func() {
+			defer app.sinkWG.Done()
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), bestEffortSinkTimeout)
+			defer cancel()
+			if err := sink.Write(ctx, batch); err != nil {
+				app.logger.Warn("写入影子目标失败", "sink", name, "endpoint", ep.Name, "error", err)
+			}
+		}()
+	}
+}
+
+// dorisTargetSink 写入另一张 Doris 表或另一个 Doris 集群，未设置的连接参数沿用主集群
+type dorisTargetSink struct {
+	dc     *dorisload.Client
+	table  string // 为空时使用端点的目标表名
+	logger *slog.Logger
+}
+
+// newDorisTargetSink 创建 Doris 输出目标，密码从 password_env 指定的环境变量读取
+func newDorisTargetSink(sc *SinkConfig, primary *dorisload.Config, logger *slog.Logger) *dorisTargetSink {
+	cfg := *primary
+	if len(sc.BEHTTP) > 0 {
+		cfg.BEHTTP, _ = normalizeBEAddrs(sc.BEHTTP) // 已在 validateSinks 中校验
+	}
+	cfg.DB = defaultString(sc.Database, primary.DB)
+	cfg.User = defaultString(sc.User, primary.User)
+	if sc.PasswordEnv != "" {
+		cfg.Passwd = os.Getenv(sc.PasswordEnv)
+	}
+	return &dorisTargetSink{
+		dc:     dorisload.New(&cfg, nil),
+		table:  sc.Table,
+		logger: logger.With("sink", sc.Name),
+	}
+}
+
+func (s *dorisTargetSink) Write(ctx context.Context, batch *SinkBatch) error {
+	table := &dorisload.Table{Name: defaultString(s.table, batch.Table.Name), Columns: batch.Table.Columns}
+	var errs []error
+	for _, chunk := range s.dc.WriteLinesSplit(ctx, table, uuid.New().String(), batch.Lines, s.logger) {
+		errs = append(errs, chunk.Err)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *dorisTargetSink) Close() error {
+	s.dc.Close()
+	return nil
+}
+
+// httpSink 将原始请求体转发到另一个 webhook 实例，没有原始请求体时发送 NDJSON 行
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+// newHTTPSink 创建 HTTP 转发输出目标
+func newHTTPSink(sc *SinkConfig) (*httpSink, error) {
+	u, err := url.Parse(sc.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("url 无效: %q", sc.URL)
+	}
+	return &httpSink{url: sc.URL, client: &http.Client{Timeout: defaultTimeout}}, nil
+}
+
+func (s *httpSink) Write(ctx context.Context, batch *SinkBatch) error {
+	body, contentType := batch.Body, "application/json"
+	if body == nil {
+		body, contentType = dorisload.JoinLines(batch.Lines), "application/x-ndjson"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("http 返回错误 [%d]", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSink) Close() error { return nil }
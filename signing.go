@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// 请求签名（auth.type: signed）的请求头
+const (
+	signKeyIDHeader     = "X-Key-Id"
+	signTimestampHeader = "X-Timestamp" // 毫秒时间戳
+	signNonceHeader     = "X-Nonce"
+	signSignatureHeader = "X-Signature"
+)
+
+const (
+	nonceKeyPrefix  = "doris-webhook:nonce:"
+	defaultMaxSkew  = 5 * time.Minute
+	minNonceLength  = 8
+	maxNonceLength  = 64
+	nonceSweepEvery = time.Minute
+)
+
+// NonceStore 已使用的 nonce 记录，用于防重放
+type NonceStore interface {
+	// Claim 记录 nonce，ttl 内已记录过时返回 false
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+// memoryNonceStore 进程内 nonce 记录（单实例部署或未配置 Redis 时使用）
+type memoryNonceStore struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time // nonce → 过期时间
+	lastSweep time.Time
+}
+
+func newMemoryNonceStore() *memoryNonceStore {
+	return &memoryNonceStore{seen: make(map[string]time.Time), lastSweep: time.Now()}
+}
+
+func (s *memoryNonceStore) Claim(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= nonceSweepEvery {
+		for k, exp := range s.seen {
+			if now.After(exp) {
+				delete(s.seen, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if exp, ok := s.seen[key]; ok && now.Before(exp) {
+		return false, nil
+	}
+	s.seen[key] = now.Add(ttl)
+	return true, nil
+}
+
+// redisNonceStore 基于 Redis 的 nonce 记录，多副本共享
+type redisNonceStore struct {
+	client *redis.Client
+}
+
+func (s *redisNonceStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, nonceKeyPrefix+key, 1, ttl).Result()
+}
+
+// newNonceStore 创建 nonce 记录，设置 NONCE_REDIS_ADDR 时使用 Redis
+func newNonceStore() (NonceStore, error) {
+	addr := getEnv("NONCE_REDIS_ADDR", "")
+	if addr == "" {
+		return newMemoryNonceStore(), nil
+	}
+	db, err := strconv.Atoi(getEnv("NONCE_REDIS_DB", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("NONCE_REDIS_DB 无效: %w", err)
+	}
+	return &redisNonceStore{client: redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: getEnv("NONCE_REDIS_PASSWORD", ""),
+		DB:       db,
+	})}, nil
+}
+
+// parseSigningKeys 解析签名密钥，格式：key_id:secret,...
+// 同时配置新旧密钥即可轮换：SDK 切换到新 key_id 后再移除旧密钥
+func parseSigningKeys(raw string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for i, item := range splitList(raw) {
+		id, secret, ok := strings.Cut(item, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("第 %d 个签名密钥格式错误（应为 key_id:secret）", i+1)
+		}
+		keys[id] = []byte(secret)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("未配置签名密钥")
+	}
+	return keys, nil
+}
+
+// newSignedAuth 创建请求签名鉴权中间件
+// 签名为 hex(HMAC-SHA256(secret, timestamp + "\n" + nonce + "\n" + path + "\n" + query + "\n" + body))，
+// query 为按参数名排序的查询字符串（url.Values.Encode），没有查询参数时为空串；
+// 时间戳与服务器时间相差超过 max_skew 或 nonce 在窗口内重复使用时拒绝
+func newSignedAuth(a *AuthConfig, nonces NonceStore) (gin.HandlerFunc, error) {
+	keys, err := parseSigningKeys(os.Getenv(a.KeysEnv))
+	if err != nil {
+		return nil, fmt.Errorf("环境变量 %s: %w", a.KeysEnv, err)
+	}
+	skew := defaultMaxSkew
+	if a.MaxSkew != "" {
+		if skew, err = time.ParseDuration(a.MaxSkew); err != nil || skew <= 0 {
+			return nil, fmt.Errorf("auth: max_skew 无效: %q", a.MaxSkew)
+		}
+	}
+
+	return func(c *gin.Context) {
+		secret, ok := keys[c.GetHeader(signKeyIDHeader)]
+		if !ok {
+			unauthorized(c)
+			return
+		}
+		ts := c.GetHeader(signTimestampHeader)
+		ms, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			unauthorized(c)
+			return
+		}
+		if d := time.Since(time.UnixMilli(ms)); d > skew || d < -skew {
+			unauthorized(c)
+			return
+		}
+		nonce := c.GetHeader(signNonceHeader)
+		if len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+			unauthorized(c)
+			return
+		}
+		sig, err := hex.DecodeString(c.GetHeader(signSignatureHeader))
+		if err != nil || len(sig) == 0 {
+			unauthorized(c)
+			return
+		}
+
+		body, ok := readBody(c)
+		if !ok {
+			return
+		}
+		mac := hmac.New(sha256.New, secret)
+		// 查询参数同样签名：inputs 含 query 的端点从查询参数读取事件
+		query := c.Request.URL.Query().Encode()
+		mac.Write([]byte(ts + "\n" + nonce + "\n" + c.Request.URL.Path + "\n" + query + "\n"))
+		mac.Write(body)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			unauthorized(c)
+			return
+		}
+
+		// 签名校验通过后再记录 nonce，避免伪造请求占用 nonce；窗口为时间戳允许的前后偏差
+		fresh, err := nonces.Claim(c.Request.Context(), c.GetHeader(signKeyIDHeader)+":"+nonce, 2*skew)
+		if err != nil {
+			abortWithRetry(c, http.StatusServiceUnavailable, errCodeDependencyUnavailable, "Nonce store unavailable", time.Second, retryStrategyExponential, nil)
+			return
+		}
+		if !fresh {
+			unauthorized(c)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Set(principalKey, "signed:"+c.GetHeader(signKeyIDHeader))
+		c.Next()
+	}, nil
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"doris-webhook/dorisload"
+)
+
+// 内置 Doris 输出目标名称
+const dorisSinkName = "doris"
+
+// 输出目标的错误策略
+const (
+	sinkPolicyMustSucceed = "must_succeed" // 写入失败时请求返回错误
+	sinkPolicyBestEffort  = "best_effort"  // 后台写入，失败只记录日志
+)
+
+// bestEffortSinkTimeout best_effort 输出目标的单次写入超时
+const bestEffortSinkTimeout = 30 * time.Second
+
+// SinkBatch 一批待写入输出目标的 NDJSON 行
+type SinkBatch struct {
+	Table    *dorisload.Table
+	Priority Priority
+	Lines    [][]byte    // 每行一个 JSON 对象，以换行符结尾
+	Body     []byte      // 原始请求体，供 http 输出目标转发
+	Label    string      // doris 输出目标写入使用的 Stream Load label，由写入时填写
+	Chunks   []loadChunk // doris 输出目标直接写入时拆分的各分片的结果，未拆分时为空，由写入时填写
+}
+
+// chunksBuffered 判断是否有拆分写入的分片已写入 WAL
+func (b *SinkBatch) chunksBuffered() bool {
+	return slices.ContainsFunc(b.Chunks, func(ch loadChunk) bool { return ch.Buffered })
+}
+
+// unwrittenRows 返回拆分写入后失败且未写入 WAL 的分片的事件数
+func (b *SinkBatch) unwrittenRows() int {
+	n := 0
+	for _, ch := range b.Chunks {
+		if ch.Error != "" && !ch.Buffered {
+			n += ch.Rows
+		}
+	}
+	return n
+}
+
+// Sink 事件输出目标
+type Sink interface {
+	Write(ctx context.Context, batch *SinkBatch) error
+	Close() error
+}
+
+// SinkConfig 配置文件中的输出目标定义，字段按 type 选用
+type SinkConfig struct {
+	Name string `yaml:"name" json:"name"`
+	Type string `yaml:"type" json:"type"` // kafka、s3、clickhouse、doris、http、stdout
+
+	// kafka
+	Brokers   []string `yaml:"brokers,omitempty" json:"brokers,omitempty"`
+	Topic     string   `yaml:"topic,omitempty" json:"topic,omitempty"`
+	KeyColumn string   `yaml:"key_column,omitempty" json:"key_column,omitempty"` // 消息 key 取自该列
+
+	// s3、clickhouse
+	Endpoint        string   `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	Region          string   `yaml:"region,omitempty" json:"region,omitempty"`
+	Bucket          string   `yaml:"bucket,omitempty" json:"bucket,omitempty"`
+	Prefix          string   `yaml:"prefix,omitempty" json:"prefix,omitempty"`
+	AccessKeyEnv    string   `yaml:"access_key_env,omitempty" json:"access_key_env,omitempty"` // 存放 Access Key 的环境变量名
+	SecretKeyEnv    string   `yaml:"secret_key_env,omitempty" json:"secret_key_env,omitempty"`
+	FlushIntervalMs int      `yaml:"flush_interval_ms,omitempty" json:"flush_interval_ms,omitempty"`
+	MaxBytes        ByteSize `yaml:"max_bytes,omitempty" json:"max_bytes,omitempty"`
+
+	// clickhouse、doris（未设置的连接参数沿用主集群）
+	BEHTTP      []string `yaml:"be_http,omitempty" json:"be_http,omitempty"`
+	Database    string   `yaml:"database,omitempty" json:"database,omitempty"`
+	Table       string   `yaml:"table,omitempty" json:"table,omitempty"` // 默认使用端点的目标表名
+	User        string   `yaml:"user,omitempty" json:"user,omitempty"`
+	PasswordEnv string   `yaml:"password_env,omitempty" json:"password_env,omitempty"`
+
+	// http
+	URL string `yaml:"url,omitempty" json:"url,omitempty"`
+}
+
+// EndpointSink 端点引用的输出目标及错误策略
+type EndpointSink struct {
+	Sink   string `yaml:"sink" json:"sink"`
+	Policy string `yaml:"policy,omitempty" json:"policy,omitempty"` // must_succeed（默认）或 best_effort
+}
+
+// validateSinks 校验输出目标定义，返回可引用的名称集合（含内置 doris）
+func validateSinks(configs []*SinkConfig) (map[string]bool, error) {
+	names := map[string]bool{dorisSinkName: true}
+	for i, sc := range configs {
+		if sc.Name == "" {
+			return nil, fmt.Errorf("sinks[%d]: name 必须设置", i)
+		}
+		if names[sc.Name] {
+			return nil, fmt.Errorf("sinks[%d]: 输出目标名称重复或与内置名称冲突: %s", i, sc.Name)
+		}
+		names[sc.Name] = true
+
+		switch sc.Type {
+		case "kafka":
+			if len(sc.Brokers) == 0 || sc.Topic == "" {
+				return nil, fmt.Errorf("sink %s: kafka 需要设置 brokers 和 topic", sc.Name)
+			}
+		case "s3":
+			if sc.Endpoint == "" || sc.Bucket == "" || sc.AccessKeyEnv == "" || sc.SecretKeyEnv == "" {
+				return nil, fmt.Errorf("sink %s: s3 需要设置 endpoint、bucket、access_key_env 和 secret_key_env", sc.Name)
+			}
+			if sc.FlushIntervalMs < 0 || sc.MaxBytes < 0 {
+				return nil, fmt.Errorf("sink %s: flush_interval_ms/max_bytes 不能为负数", sc.Name)
+			}
+		case "clickhouse":
+			if sc.Endpoint == "" {
+				return nil, fmt.Errorf("sink %s: clickhouse 需要设置 endpoint", sc.Name)
+			}
+		case "doris":
+			if sc.Table == "" && len(sc.BEHTTP) == 0 {
+				return nil, fmt.Errorf("sink %s: doris 需要设置 table 或 be_http", sc.Name)
+			}
+			if _, err := normalizeBEAddrs(sc.BEHTTP); err != nil {
+				return nil, fmt.Errorf("sink %s: be_http: %w", sc.Name, err)
+			}
+			if sc.Database != "" {
+				if err := dorisload.ValidateIdentifier(sc.Database); err != nil {
+					return nil, fmt.Errorf("sink %s: database: %w", sc.Name, err)
+				}
+			}
+			if sc.Table != "" {
+				if err := dorisload.ValidateIdentifier(sc.Table); err != nil {
+					return nil, fmt.Errorf("sink %s: table: %w", sc.Name, err)
+				}
+			}
+		case "http":
+			if sc.URL == "" {
+				return nil, fmt.Errorf("sink %s: http 需要设置 url", sc.Name)
+			}
+		case "stdout":
+		default:
+			return nil, fmt.Errorf("sink %s: type 无效: %q（可选 kafka、s3、clickhouse、doris、http、stdout）", sc.Name, sc.Type)
+		}
+	}
+	return names, nil
+}
+
+// newSinks 根据配置创建输出目标，内置 doris 输出目标由调用方注册
+func newSinks(configs []*SinkConfig, cfg *dorisload.Config, history dorisload.HistoryConfig, logger *slog.Logger) (map[string]Sink, error) {
+	sinks := make(map[string]Sink, len(configs)+1)
+	for _, sc := range configs {
+		var (
+			s   Sink
+			err error
+		)
+		switch sc.Type {
+		case "kafka":
+			s = newKafkaSink(sc)
+		case "s3":
+			s, err = newS3Sink(sc, logger)
+		case "clickhouse":
+			s, err = newClickHouseSink(sc)
+		case "doris":
+			s = newDorisTargetSink(sc, cfg, logger)
+		case "http":
+			s, err = newHTTPSink(sc)
+		case "stdout":
+			s = &stdoutSink{}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", sc.Name, err)
+		}
+		sinks[sc.Name] = newCountingSink(sc.Name, s, history, logger)
+	}
+	return sinks, nil
+}
+
+// countingSink 统计输出目标写入的行数和失败次数，便于双写时对比各目标的结果
+// 每次写入的结果计入健康历史：写入从成功变为失败（或相反）时记录一次日志，抖动期间不逐次记录
+type countingSink struct {
+	Sink
+	name     string
+	rows     atomic.Int64
+	failures atomic.Int64
+	history  *dorisload.HealthHistory
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	failing  bool // 最近一次写入失败
+	flapping bool
+}
+
+func newCountingSink(name string, s Sink, history dorisload.HistoryConfig, logger *slog.Logger) *countingSink {
+	return &countingSink{Sink: s, name: name, history: dorisload.NewHealthHistory(history), logger: logger}
+}
+
+func (s *countingSink) Write(ctx context.Context, batch *SinkBatch) error {
+	start := time.Now()
+	err := s.Sink.Write(ctx, batch)
+	s.observe(start, err)
+	if err != nil {
+		s.failures.Add(1)
+		return err
+	}
+	s.rows.Add(int64(len(batch.Lines)))
+	return nil
+}
+
+// observe 记录一次写入结果并更新状态
+func (s *countingSink) observe(start time.Time, err error) {
+	result := dorisload.HealthProbe{Time: start, OK: err == nil, LatencyMs: float64(time.Since(start)) / float64(time.Millisecond)}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	s.history.Record(result)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flapping := s.history.Flapping(start)
+	if failing := err != nil; failing != s.failing {
+		s.failing = failing
+		flapping = s.history.Transition(start)
+		switch {
+		case flapping:
+		case failing:
+			s.logger.Warn("输出目标写入失败", "sink", s.name, "error", err)
+		default:
+			s.logger.Info("输出目标写入恢复", "sink", s.name)
+		}
+	}
+	if flapping != s.flapping {
+		s.flapping = flapping
+		if flapping {
+			s.logger.Warn("输出目标状态频繁变化，暂停记录状态变化", "sink", s.name, "transitions", s.history.Transitions(start))
+		} else {
+			s.logger.Info("输出目标状态已稳定", "sink", s.name, "failing", s.failing)
+		}
+	}
+}
+
+// SinkHealthHistory 输出目标最近的写入结果
+type SinkHealthHistory struct {
+	Failing     bool                    `json:"failing"` // 最近一次写入失败
+	Flapping    bool                    `json:"flapping"`
+	Transitions int                     `json:"transitions"` // 抖动检测窗口内的状态变化次数
+	Writes      []dorisload.HealthProbe `json:"writes"`      // 按时间从旧到新排列
+}
+
+// healthHistory 返回写入结果历史
+func (s *countingSink) healthHistory() SinkHealthHistory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SinkHealthHistory{
+		Failing:     s.failing,
+		Flapping:    s.flapping,
+		Transitions: s.history.Transitions(time.Now()),
+		Writes:      s.history.Probes(),
+	}
+}
+
+// sinkStats 返回各输出目标的写入统计
+func (app *App) sinkStats() map[string]any {
+	stats := make(map[string]any, len(app.sinks))
+	for name, s := range app.sinks {
+		if cs, ok := s.(*countingSink); ok {
+			stats[name] = map[string]int64{
+				"rows":     cs.rows.Load(),
+				"failures": cs.failures.Load(),
+			}
+		}
+	}
+	return stats
+}
+
+// dorisSink 内置 Doris 输出目标，复用批量写入和并发限制
+type dorisSink struct {
+	app *App
+}
+
+func (s *dorisSink) Write(ctx context.Context, batch *SinkBatch) error {
+	return s.app.load(ctx, batch)
+}
+
+func (s *dorisSink) Close() error { return nil }
+
+// stdoutSink 将事件以 NDJSON 输出到标准输出，用于调试或由日志采集转发
+type stdoutSink struct {
+	mu sync.Mutex
+}
+
+func (s *stdoutSink) Write(ctx context.Context, batch *SinkBatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enc := json.NewEncoder(os.Stdout)
+	for _, line := range batch.Lines {
+		if err := enc.Encode(struct {
+			Table string          `json:"table"`
+			Row   json.RawMessage `json:"row"`
+		}{batch.Table.Name, line}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *stdoutSink) Close() error { return nil }
+
+// writeRequiredSinks 在写入 Doris 之前并发写入端点的 must_succeed 输出目标并等待结果，任一失败返回错误
+// 此时 Doris 尚未写入，客户端重试不会重复写入 Doris；已成功的输出目标会再次收到事件（至少一次）
+func (app *App) writeRequiredSinks(ctx context.Context, ep *Endpoint, batch *SinkBatch) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, es := range ep.Sinks {
+		if es.Sink == dorisSinkName || es.Policy != sinkPolicyMustSucceed {
+			continue
+		}
+		name, sink := es.Sink, app.sinks[es.Sink]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := sink.Write(ctx, batch); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("sink %s: %w", name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// writeBestEffortSinks 在 Doris 接收事件后在后台写入端点的 best_effort 输出目标，失败只记录日志
+func (app *App) writeBestEffortSinks(ctx context.Context, ep *Endpoint, batch *SinkBatch) {
+	for _, es := range ep.Sinks {
+		if es.Policy != sinkPolicyBestEffort {
+			continue
+		}
+		name, sink := es.Sink, app.sinks[es.Sink]
+		app.sinkWG.Add(1)
+		go func() {
+			defer app.sinkWG.Done()
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), bestEffortSinkTimeout)
+			defer cancel()
+			if err := sink.Write(ctx, batch); err != nil {
+				app.logger.Warn("写入输出目标失败（best_effort）", "sink", name, "table", batch.Table.Name, "error", err)
+			}
+		}()
+	}
+}
+
+// closeSinks 等待后台写入完成并关闭所有输出目标
+func (app *App) closeSinks() {
+	app.sinkWG.Wait()
+	for name, s := range app.sinks {
+		if err := s.Close(); err != nil {
+			app.logger.Error("关闭输出目标失败", "sink", name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"doris-webhook/dorisload"
+)
+
+// clickhouseSink 通过 ClickHouse HTTP 接口写入事件（INSERT ... FORMAT JSONEachRow）
+// 用于迁移期间与 Doris 双写对比，行格式与写入 Doris 的 JSON 行相同
+type clickhouseSink struct {
+	endpoint string
+	database string
+	table    string // 为空时使用端点的目标表名
+	user     string
+	password string
+	client   *http.Client
+}
+
+// newClickHouseSink 创建 ClickHouse 输出目标，密码从 password_env 指定的环境变量读取
+func newClickHouseSink(sc *SinkConfig) (*clickhouseSink, error) {
+	u, err := url.Parse(sc.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("endpoint 无效: %q", sc.Endpoint)
+	}
+	s := &clickhouseSink{
+		endpoint: strings.TrimSuffix(sc.Endpoint, "/"),
+		database: defaultString(sc.Database, "default"),
+		table:    sc.Table,
+		user:     defaultString(sc.User, "default"),
+		client:   &http.Client{Timeout: defaultTimeout},
+	}
+	if sc.PasswordEnv != "" {
+		s.password = os.Getenv(sc.PasswordEnv)
+	}
+	return s, nil
+}
+
+func (s *clickhouseSink) Write(ctx context.Context, batch *SinkBatch) error {
+	table := defaultString(s.table, batch.Table.Name)
+	query := url.Values{}
+	query.Set("query", fmt.Sprintf("INSERT INTO `%s`.`%s` FORMAT JSONEachRow", s.database, table))
+	query.Set("input_format_skip_unknown_fields", "1")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+"/?"+query.Encode(), bytes.NewReader(dorisload.JoinLines(batch.Lines)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-ClickHouse-User", s.user)
+	if s.password != "" {
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("clickhouse 连接失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("clickhouse 返回错误 [%d]: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (s *clickhouseSink) Close() error { return nil }
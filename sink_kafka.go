@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaSink 将事件写入 Kafka topic，每行一条消息
+// 设置 key_column 时以该列的值作为消息 key，同一 key 的消息进入同一分区
+type kafkaSink struct {
+	writer    *kafka.Writer
+	keyColumn string
+}
+
+// newKafkaSink 创建 Kafka 输出目标，等待所有 ISR 副本确认
+func newKafkaSink(sc *SinkConfig) *kafkaSink {
+	return &kafkaSink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(sc.Brokers...),
+			Topic:        sc.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+		},
+		keyColumn: sc.KeyColumn,
+	}
+}
+
+func (s *kafkaSink) Write(ctx context.Context, batch *SinkBatch) error {
+	msgs := make([]kafka.Message, len(batch.Lines))
+	for i, line := range batch.Lines {
+		msgs[i] = kafka.Message{
+			Value:   line[:len(line)-1], // 去掉行尾换行符
+			Headers: []kafka.Header{{Key: "table", Value: []byte(batch.Table.Name)}},
+		}
+		if s.keyColumn != "" {
+			var row map[string]any
+			if err := json.Unmarshal(line, &row); err != nil {
+				return fmt.Errorf("解析事件失败: %w", err)
+			}
+			if v, ok := row[s.keyColumn]; ok {
+				msgs[i].Key = []byte(fmt.Sprint(v))
+			}
+		}
+	}
+	return s.writer.WriteMessages(ctx, msgs...)
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
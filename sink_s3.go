@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// s3Sink 将事件归档到 S3 兼容的对象存储
+// 事件按表在内存中缓冲，达到 max_bytes 或 flush_interval_ms 时上传为一个 NDJSON 对象：
+// {prefix}/{table}/{YYYY/MM/DD}/{时间戳}-{uuid}.ndjson。
+// 写入只保证进入缓冲区，因此通常配置为 best_effort
+type s3Sink struct {
+	endpoint *url.URL
+	signer   s3Signer
+	bucket   string
+	prefix   string
+	maxBytes int
+	keys     *Keyring // 配置了 ENCRYPTION_KEYS 时逐行加密后上传，对象以 .ndjson.enc 结尾
+	client   *http.Client
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	buffers map[string]*bytes.Buffer // 按表名缓冲
+
+	done chan struct{}
+	exit chan struct{}
+}
+
+// newS3Sink 创建 S3 归档输出目标，凭证从 access_key_env/secret_key_env 指定的环境变量读取
+func newS3Sink(sc *SinkConfig, logger *slog.Logger) (*s3Sink, error) {
+	endpoint, err := url.Parse(sc.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("endpoint 无效: %q", sc.Endpoint)
+	}
+	accessKey, secretKey := os.Getenv(sc.AccessKeyEnv), os.Getenv(sc.SecretKeyEnv)
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("环境变量 %s/%s 未设置", sc.AccessKeyEnv, sc.SecretKeyEnv)
+	}
+
+	keys, err := newKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &s3Sink{
+		endpoint: endpoint,
+		signer:   s3Signer{region: defaultString(sc.Region, "us-east-1"), accessKey: accessKey, secretKey: secretKey},
+		bucket:   sc.Bucket,
+		prefix:   strings.Trim(sc.Prefix, "/"),
+		maxBytes: int(sc.MaxBytes),
+		keys:     keys,
+		client:   &http.Client{Timeout: defaultTimeout},
+		logger:   logger.With("sink", sc.Name),
+		buffers:  make(map[string]*bytes.Buffer),
+		done:     make(chan struct{}),
+		exit:     make(chan struct{}),
+	}
+	if s.maxBytes == 0 {
+		s.maxBytes = 8 << 20
+	}
+	interval := time.Minute
+	if sc.FlushIntervalMs > 0 {
+		interval = time.Duration(sc.FlushIntervalMs) * time.Millisecond
+	}
+	go s.run(interval)
+	return s, nil
+}
+
+func (s *s3Sink) Write(ctx context.Context, batch *SinkBatch) error {
+	s.mu.Lock()
+	buf := s.buffers[batch.Table.Name]
+	if buf == nil {
+		buf = new(bytes.Buffer)
+		s.buffers[batch.Table.Name] = buf
+	}
+	for _, line := range batch.Lines {
+		buf.Write(line)
+	}
+	var full []byte
+	if buf.Len() >= s.maxBytes {
+		full = bytes.Clone(buf.Bytes())
+		buf.Reset()
+	}
+	s.mu.Unlock()
+
+	if full != nil {
+		return s.upload(ctx, batch.Table.Name, full)
+	}
+	return nil
+}
+
+// run 定期上传缓冲区
+func (s *s3Sink) run(interval time.Duration) {
+	defer close(s.exit)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.done:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush 上传所有非空缓冲区，失败的数据放回缓冲区等待下次上传
+func (s *s3Sink) flush() {
+	s.mu.Lock()
+	pending := make(map[string][]byte)
+	for table, buf := range s.buffers {
+		if buf.Len() > 0 {
+			pending[table] = bytes.Clone(buf.Bytes())
+			buf.Reset()
+		}
+	}
+	s.mu.Unlock()
+
+	for table, data := range pending {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		err := s.upload(ctx, table, data)
+		cancel()
+		if err != nil {
+			s.logger.Error("上传归档对象失败，稍后重试", "table", table, "bytes", len(data), "error", err)
+			s.mu.Lock()
+			buf := s.buffers[table]
+			rest := bytes.Clone(buf.Bytes())
+			buf.Reset()
+			buf.Write(data)
+			buf.Write(rest)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// upload 上传一个 NDJSON 对象，配置了加密密钥时上传逐行加密的内容
+func (s *s3Sink) upload(ctx context.Context, table string, data []byte) error {
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%s/%s/%s-%s.ndjson", table, now.Format("2006/01/02"), now.Format("20060102T150405Z"), uuid.New().String())
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	contentType := "application/x-ndjson"
+	if s.keys != nil {
+		data = s.keys.EncryptLines(data)
+		key += ".enc"
+		contentType = "application/octet-stream"
+	}
+
+	u := *s.endpoint
+	u.Path = "/" + s.bucket + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", contentType)
+	s.signer.sign(req, data, now)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 返回错误 [%d]: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// s3Signer S3 请求的签名凭证，归档输出目标和定时补录任务共用
+type s3Signer struct {
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// sign 使用 AWS Signature Version 4 签名请求（path-style），请求没有 Content-Type 时不签名该头
+func (s *s3Signer) sign(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, payloadHash, amzDate)
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		signedHeaders = "content-type;" + signedHeaders
+		canonicalHeaders = "content-type:" + ct + "\n" + canonicalHeaders
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// Close 上传剩余缓冲并停止后台任务
+func (s *s3Sink) Close() error {
+	close(s.done)
+	<-s.exit
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// splitBuckets 分流按路由键哈希到的桶数，percent 精确到 0.01
+const splitBuckets = 10000
+
+// SplitConfig 端点的 A/B 分流：路由键（默认 project）哈希落在 percent 内的事件写入 doris 类型的输出目标（新集群），
+// 不再写入端点的目标表，其余事件照常写入目标表（旧集群）。同一路由键始终写入同一侧，调大 percent 时只有新增比例内的路由键切换到新集群
+type SplitConfig struct {
+	Sink    string  `yaml:"sink" json:"sink"`
+	Percent float64 `yaml:"percent" json:"percent"`             // 写入 sink 的路由键比例，0～100
+	Key     string  `yaml:"key,omitempty" json:"key,omitempty"` // 路由键列，默认 project；值为空的事件写入目标表
+
+	primary, split splitArm
+}
+
+// splitArm 分流一侧的写入统计
+type splitArm struct {
+	rows      atomic.Int64
+	writes    atomic.Int64
+	failures  atomic.Int64
+	latencyUs atomic.Int64 // 成功写入的累计耗时
+}
+
+// SplitArmStats 分流一侧的写入统计，通过 /admin/stats 的 splits 查看
+type SplitArmStats struct {
+	Rows         int64   `json:"rows"`
+	Writes       int64   `json:"writes"`
+	Failures     int64   `json:"failures"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"` // 成功写入的平均耗时，写入 WAL 的事件也计入
+}
+
+// SplitStats 端点两侧的写入统计
+type SplitStats struct {
+	Sink    string        `json:"sink"`
+	Percent float64       `json:"percent"`
+	Primary SplitArmStats `json:"primary"` // 端点的目标表（旧集群）
+	Split   SplitArmStats `json:"split"`   // 分流目标（新集群）
+}
+
+// validate 校验分流配置，sinks 为配置文件中的输出目标
+func (sp *SplitConfig) validate(ep *Endpoint, sinks []*SinkConfig) error {
+	if !ep.WritesDoris() || ep.Rollup != nil || ep.Late != nil || ep.Migration != nil || ep.Journal || ep.BulkMode == bulkModeAtomic {
+		return fmt.Errorf("split 需要端点写入 doris，且不能与 rollup、late、migration、journal、bulk_mode atomic 同时使用")
+	}
+	i := slices.IndexFunc(sinks, func(sc *SinkConfig) bool { return sc.Name == sp.Sink })
+	if i < 0 || sinks[i].Type != "doris" {
+		return fmt.Errorf("split.sink 必须是 doris 类型的输出目标: %q", sp.Sink)
+	}
+	if slices.ContainsFunc(ep.Sinks, func(es EndpointSink) bool { return es.Sink == sp.Sink }) {
+		return fmt.Errorf("split.sink 不能同时出现在 sinks 中: %s", sp.Sink)
+	}
+	if sp.Percent < 0 || sp.Percent > 100 {
+		return fmt.Errorf("split.percent 必须在 [0, 100] 内: %v", sp.Percent)
+	}
+	sp.Key = defaultString(sp.Key, "project")
+	if !slices.ContainsFunc(ep.Columns, func(m ColumnMapping) bool { return m.Column == sp.Key }) {
+		return fmt.Errorf("split.key 中的列 %s 不存在", sp.Key)
+	}
+	return nil
+}
+
+// routed 判断路由键是否分流到 sink
+func (sp *SplitConfig) routed(key string) bool {
+	if key == "" {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return float64(h.Sum32()%splitBuckets) < sp.Percent*splitBuckets/100
+}
+
+// partition 按路由键将事件的行分为写入目标表和写入 sink 的两部分
+func (sp *SplitConfig) partition(events []eventRow, lines [][]byte) (primary, split [][]byte) {
+	for i, ev := range events {
+		if sp.routed(stringValue(ev.Row, sp.Key)) {
+			split = append(split, lines[i])
+		} else {
+			primary = append(primary, lines[i])
+		}
+	}
+	return primary, split
+}
+
+// observe 记录一侧的一次写入
+func (a *splitArm) observe(start time.Time, rows int, ok bool) {
+	a.writes.Add(1)
+	if !ok {
+		a.failures.Add(1)
+		return
+	}
+	a.rows.Add(int64(rows))
+	a.latencyUs.Add(time.Since(start).Microseconds())
+}
+
+func (a *splitArm) stats() SplitArmStats {
+	s := SplitArmStats{Rows: a.rows.Load(), Writes: a.writes.Load(), Failures: a.failures.Load()}
+	if ok := s.Writes - s.Failures; ok > 0 {
+		s.AvgLatencyMs = float64(a.latencyUs.Load()) / float64(ok) / 1000
+	}
+	return s
+}
+
+// Stats 返回两侧的写入统计
+func (sp *SplitConfig) Stats() SplitStats {
+	return SplitStats{Sink: sp.Sink, Percent: sp.Percent, Primary: sp.primary.stats(), Split: sp.split.stats()}
+}
+
+// loadSplit 按分流配置写入：目标表一侧经 loadDoris（含批量写入、背压和 WAL），sink 一侧随后同步写入
+// 返回目标表一侧的接收状态和未能写入 sink 一侧的事件数，label 和拆分写入的分片写入 batch。目标表一侧已接收后 sink 一侧失败时不写出错误响应，
+// 否则客户端重试会重复写入目标表；其他写入失败时已写出错误响应，返回 false
+func (app *App) loadSplit(c *gin.Context, ep *Endpoint, batch *SinkBatch, events []eventRow) (int, int, bool) {
+	sp := ep.Split
+	primaryLines, splitLines := sp.partition(events, batch.Lines)
+
+	status := http.StatusOK
+	if len(primaryLines) > 0 {
+		primary := &SinkBatch{Table: batch.Table, Priority: batch.Priority, Lines: primaryLines}
+		start := time.Now()
+		var ok bool
+		status, ok = app.loadDoris(c, ep, primary)
+		sp.primary.observe(start, len(primaryLines), ok)
+		if !ok {
+			return 0, 0, false
+		}
+		batch.Label, batch.Chunks = primary.Label, primary.Chunks
+	}
+
+	if len(splitLines) > 0 {
+		start := time.Now()
+		err := app.sinks[sp.Sink].Write(c.Request.Context(), &SinkBatch{Table: batch.Table, Priority: batch.Priority, Lines: splitLines})
+		sp.split.observe(start, len(splitLines), err == nil)
+		if err != nil {
+			if len(primaryLines) > 0 {
+				app.logger.Error("目标表已接收，写入分流目标失败，分流的事件未写入", "endpoint", ep.Name, "sink", sp.Sink, "rows", len(splitLines), "error", err)
+				return status, len(splitLines), true
+			}
+			app.logger.Error("写入分流目标失败", "endpoint", ep.Name, "sink", sp.Sink, "error", err)
+			abortWithError(c, http.StatusBadGateway, errCodeSinkFailed, fmt.Sprintf("Sink write failed: sink %s: %v", sp.Sink, err), nil)
+			return 0, 0, false
+		}
+	}
+	return status, 0, true
+}
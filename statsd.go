@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"doris-webhook/dorisload"
+)
+
+// METRICS_BACKEND 的取值
+const (
+	metricsBackendPrometheus = "prometheus" // 只提供 /metrics 供抓取
+	metricsBackendStatsD     = "statsd"     // 推送到 StatsD，标签值拼接到指标名
+	metricsBackendDogStatsD  = "dogstatsd"  // 推送到 DogStatsD，标签以 |#k:v 附加
+)
+
+// statsdMaxPacket 单个 UDP 包的最大字节数，避免在常见 MTU 下分片
+const statsdMaxPacket = 1432
+
+// StatsD 将指标以 UDP 推送到 StatsD/DogStatsD，供没有 Prometheus 的环境使用
+// gauge 和 counter 与 /metrics 相同，按 STATSD_INTERVAL 定期推送（counter 推送两次之间的增量）；
+// 直方图改为 timer，每次 Stream Load 尝试和发往 BE 的请求结束后推送
+type StatsD struct {
+	conn     net.Conn
+	prefix   string
+	dog      bool
+	tags     []string // STATSD_TAGS：附加到每个指标的标签（k:v），仅 DogStatsD
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	buf  []byte
+	last map[string]float64 // counter 上次推送时的累计值，按指标名和标签索引
+}
+
+// newStatsD 按 METRICS_BACKEND 创建 StatsD 输出，为 prometheus（默认）时返回 nil
+func newStatsD(logger *slog.Logger) (*StatsD, error) {
+	backend := getEnv("METRICS_BACKEND", metricsBackendPrometheus)
+	switch backend {
+	case metricsBackendPrometheus:
+		return nil, nil
+	case metricsBackendStatsD, metricsBackendDogStatsD:
+	default:
+		return nil, fmt.Errorf("METRICS_BACKEND 无效: %q（可选 prometheus、statsd、dogstatsd）", backend)
+	}
+	interval, err := envDuration("STATSD_INTERVAL", "10s", time.Second, time.Second, 0)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, tag := range splitList(getEnv("STATSD_TAGS", "")) {
+		if k, _, ok := strings.Cut(tag, ":"); !ok || k == "" {
+			return nil, fmt.Errorf("STATSD_TAGS 无效: %q（格式为 key:value，逗号分隔）", tag)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) > 0 && backend != metricsBackendDogStatsD {
+		return nil, fmt.Errorf("STATSD_TAGS 只在 METRICS_BACKEND=dogstatsd 时可用")
+	}
+	addr := getEnv("STATSD_ADDR", "127.0.0.1:8125")
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("STATSD_ADDR 无效: %q: %w", addr, err)
+	}
+	return &StatsD{
+		conn:     conn,
+		prefix:   getEnv("STATSD_PREFIX", "doris_webhook."),
+		dog:      backend == metricsBackendDogStatsD,
+		tags:     tags,
+		interval: interval,
+		logger:   logger,
+		last:     make(map[string]float64),
+	}, nil
+}
+
+// statsdTag 一个标签
+type statsdTag struct{ key, value string }
+
+// emit 追加一条指标，缓冲超过单个 UDP 包时先发送已缓冲的指标
+func (s *StatsD) emit(name string, value float64, typ string, tags []statsdTag) {
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	if !s.dog {
+		// 普通 StatsD 没有标签，标签值作为指标名的后续段
+		for _, t := range tags {
+			b.WriteByte('.')
+			b.WriteString(statsdSegment(t.value))
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	b.WriteByte('|')
+	b.WriteString(typ)
+	if s.dog && len(tags)+len(s.tags) > 0 {
+		b.WriteString("|#")
+		all := make([]string, 0, len(tags)+len(s.tags))
+		for _, t := range tags {
+			all = append(all, t.key+":"+strings.NewReplacer(",", "_", "|", "_", "#", "_").Replace(t.value))
+		}
+		b.WriteString(strings.Join(append(all, s.tags...), ","))
+	}
+	line := b.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > statsdMaxPacket {
+		s.sendLocked()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+// statsdSegment 将标签值转为指标名中的一段：字母、数字、- 和 _ 以外的字符替换为 _
+func statsdSegment(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, v)
+}
+
+// sendLocked 发送缓冲的指标，UDP 发送失败（如没有进程监听）只记录 Debug 日志
+func (s *StatsD) sendLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	if _, err := s.conn.Write(s.buf); err != nil {
+		s.logger.Debug("发送 StatsD 指标失败", "error", err)
+	}
+	s.buf = s.buf[:0]
+}
+
+func (s *StatsD) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendLocked()
+}
+
+// timing 追加一个 timer，单位毫秒
+func (s *StatsD) timing(name string, d time.Duration, tags ...statsdTag) {
+	s.emit(name, float64(d.Microseconds())/1000, "ms", tags)
+}
+
+// Attempt 推送一次 Stream Load 尝试的耗时，成功时还推送 BE 报告的各阶段耗时，作为 dorisload.Config.OnAttempt
+func (s *StatsD) Attempt(ctx context.Context, at *dorisload.Attempt) {
+	outcome := auditOutcomeSuccess
+	switch {
+	case errors.Is(at.Err, dorisload.ErrLabelAlreadyExists):
+		outcome = auditOutcomeDuplicate
+	case at.Err != nil:
+		outcome = auditOutcomeFailed
+	}
+	s.timing("stream_load_attempt", at.Duration, statsdTag{"table", at.Table}, statsdTag{"outcome", outcome})
+	if at.Err != nil || at.Resp == nil {
+		return
+	}
+	r := at.Resp
+	for _, p := range []struct {
+		phase string
+		ms    int64
+	}{
+		{dorisload.PhaseBeginTxn, r.BeginTxnTimeMs},
+		{dorisload.PhaseStreamLoadPut, r.StreamLoadPutTimeMs},
+		{dorisload.PhaseReadData, r.ReadDataTimeMs},
+		{dorisload.PhaseWriteData, r.WriteDataTimeMs},
+		{dorisload.PhaseCommitAndPublish, r.CommitAndPublishTimeMs},
+	} {
+		s.timing("stream_load_phase", time.Duration(p.ms)*time.Millisecond, statsdTag{"table", at.Table}, statsdTag{"phase", p.phase})
+	}
+}
+
+// Trace 推送一次发往 BE 的请求在客户端侧的各阶段耗时，没有经历的阶段不推送，作为 dorisload.Config.OnTrace
+func (s *StatsD) Trace(ctx context.Context, t *dorisload.ConnTrace) {
+	for _, p := range []struct {
+		phase string
+		d     time.Duration
+	}{
+		{dorisload.TracePhaseDNS, t.DNS},
+		{dorisload.TracePhaseConnect, t.Connect},
+		{dorisload.TracePhaseTLS, t.TLS},
+		{dorisload.TracePhaseContinueWait, t.ContinueWait},
+		{dorisload.TracePhaseFirstByte, t.FirstByte},
+	} {
+		if p.d > 0 {
+			s.timing("stream_load_client", p.d, statsdTag{"be", t.BE}, statsdTag{"phase", p.phase})
+		}
+	}
+}
+
+// Run 按 STATSD_INTERVAL 推送 render 输出（Prometheus 文本格式）中的 gauge 和 counter，直到 ctx 取消；
+// 退出前推送最后一次并关闭连接
+func (s *StatsD) Run(ctx context.Context, render func() string) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.push(render())
+			s.conn.Close()
+			return
+		case <-ticker.C:
+			s.push(render())
+		}
+	}
+}
+
+// push 将 Prometheus 文本格式的 gauge 和 counter 转为 StatsD 指标并发送，直方图由 timer 代替，不推送
+func (s *StatsD) push(text string) {
+	types := make(map[string]string)
+	for _, line := range strings.Split(text, "\n") {
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, typ, _ := strings.Cut(rest, " ")
+			types[name] = typ
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, tags, value, err := parseSample(line)
+		if err != nil {
+			s.logger.Debug("解析指标失败", "line", line, "error", err)
+			continue
+		}
+		short := strings.TrimPrefix(name, "doris_webhook_")
+		switch types[name] {
+		case "gauge":
+			s.emit(short, value, "g", tags)
+		case "counter":
+			// StatsD 的 counter 是增量，按上次推送的累计值计算；累计值变小（重启、重置）时推送当前值
+			key := line[:strings.LastIndexByte(line, ' ')]
+			delta := value - s.last[key]
+			if delta < 0 {
+				delta = value
+			}
+			s.last[key] = value
+			if delta > 0 {
+				s.emit(short, delta, "c", tags)
+			}
+		}
+	}
+	s.flush()
+}
+
+// parseSample 解析一行 Prometheus 文本格式的样本：name{k="v",...} value
+func parseSample(line string) (string, []statsdTag, float64, error) {
+	i := strings.LastIndexByte(line, ' ')
+	if i < 0 {
+		return "", nil, 0, fmt.Errorf("缺少值")
+	}
+	value, err := strconv.ParseFloat(line[i+1:], 64)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	series := line[:i]
+	name, labels, ok := strings.Cut(series, "{")
+	if !ok {
+		return name, nil, value, nil
+	}
+	labels = strings.TrimSuffix(labels, "}")
+	var tags []statsdTag
+	for labels != "" {
+		k, rest, ok := strings.Cut(labels, "=")
+		if !ok {
+			return "", nil, 0, fmt.Errorf("标签无效: %q", labels)
+		}
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return "", nil, 0, fmt.Errorf("标签 %s 的值无效: %w", k, err)
+		}
+		v, _ := strconv.Unquote(quoted)
+		tags = append(tags, statsdTag{k, v})
+		labels = strings.TrimPrefix(rest[len(quoted):], ",")
+	}
+	return name, tags, value, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// sdListenFdsStart systemd 传递的第一个文件描述符编号
+	sdListenFdsStart = 3
+)
+
+// systemdListener 获取 systemd socket activation 传入的监听 socket
+// 未通过 systemd 启动（LISTEN_PID/LISTEN_FDS 未设置）时返回 nil
+func systemdListener() (net.Listener, error) {
+	defer func() {
+		// 避免子进程误继承 socket activation 环境变量
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil, nil
+	}
+	if nfds > 1 {
+		// 只使用第一个 socket，其余关闭避免泄漏
+		for fd := sdListenFdsStart + 1; fd < sdListenFdsStart+nfds; fd++ {
+			syscall.Close(fd)
+		}
+	}
+
+	syscall.CloseOnExec(sdListenFdsStart)
+	f := os.NewFile(uintptr(sdListenFdsStart), "LISTEN_FD_3")
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("无法使用 systemd 传入的 socket: %w", err)
+	}
+	return ln, nil
+}
+
+// sdNotify 向 systemd 发送状态通知（READY=1、STOPPING=1、WATCHDOG=1 等）
+// NOTIFY_SOCKET 未设置时不做任何操作，返回 false
+func sdNotify(state string) (bool, error) {
+	socketAddr := os.Getenv("NOTIFY_SOCKET")
+	if socketAddr == "" {
+		return false, nil
+	}
+
+	// 以 @ 开头的是 Linux 抽象命名空间 socket
+	if socketAddr[0] == '@' {
+		socketAddr = "\x00" + socketAddr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketAddr, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("连接 NOTIFY_SOCKET 失败: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("发送 sd_notify 失败: %w", err)
+	}
+	return true, nil
+}
+
+// sdWatchdogInterval 返回 systemd watchdog 的超时时间
+// 未启用 watchdog（WATCHDOG_USEC 未设置或不属于当前进程）时返回 0
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pidStr := os.Getenv("WATCHDOG_PID"); pidStr != "" {
+		if pid, err := strconv.Atoi(pidStr); err != nil || pid != os.Getpid() {
+			return 0
+		}
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// startSystemdWatchdog 按 watchdog 超时时间的一半定期发送 WATCHDOG=1
+// 返回的函数用于停止 watchdog
+func startSystemdWatchdog(logger *slog.Logger) func() {
+	interval := sdWatchdogInterval()
+	if interval == 0 {
+		return func() {}
+	}
+
+	logger.Info("systemd watchdog 已启用", "timeout", interval)
+	ticker := time.NewTicker(interval / 2)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := sdNotify("WATCHDOG=1"); err != nil {
+					logger.Warn("systemd watchdog 通知失败", "error", err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
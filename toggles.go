@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// paused_tables、paused_endpoints 中的表或端点不在注册表内
+var (
+	errUnknownTable    = errors.New("unknown table")
+	errUnknownEndpoint = errors.New("unknown endpoint")
+)
+
+// 暂停写入时的处理策略
+const (
+	pausePolicySpill  = "spill"  // 写入 WAL，恢复后回放
+	pausePolicyReject = "reject" // 返回 503
+)
+
+// RuntimeToggles 运行时开关，通过 /admin/toggles 修改，值班时无需重新部署即可应对故障
+type RuntimeToggles struct {
+	Debug           bool     `json:"debug"`             // 日志级别临时提升为 debug，端点的调试日志随之输出
+	DryRun          bool     `json:"dry_run"`           // 事件校验和转换后直接返回成功，不写入 Doris 和其他输出目标，不计入配额
+	ShedLowPriority bool     `json:"shed_low_priority"` // 低优先级事件不论是否背压都按 LOW_PRIORITY_POLICY 落盘或拒绝
+	PausedTables    []string `json:"paused_tables"`     // 暂停写入的表：按 PAUSE_POLICY 写入 WAL 或返回 503，WAL 也不回放这些表
+	PausedEndpoints []string `json:"paused_endpoints"`  // 暂停写入的端点：端点（含双写表）的 Doris 写入按 PAUSE_POLICY 处理
+
+	Migrations []MigrationToggle `json:"migrations"` // 正在迁移表结构的端点：Doris 写入改写到 migration.table
+}
+
+// togglesPatch 修改开关的请求体，未出现的字段保持不变
+type togglesPatch struct {
+	Debug           *bool     `json:"debug"`
+	DryRun          *bool     `json:"dry_run"`
+	ShedLowPriority *bool     `json:"shed_low_priority"`
+	PausedTables    *[]string `json:"paused_tables"`
+	PausedEndpoints *[]string `json:"paused_endpoints"`
+
+	Migrations *[]MigrationToggle `json:"migrations"`
+}
+
+// toggleState 一份不可变的开关快照，写入路径无锁读取
+type toggleState struct {
+	RuntimeToggles
+	paused          map[string]bool
+	pausedEndpoints map[string]bool
+	migrations      map[string]MigrationToggle // 按端点名索引
+}
+
+// Toggles 运行时开关，设置 TOGGLES_FILE 时每次修改都写入该文件，服务重启或平滑升级后恢复
+type Toggles struct {
+	path        string          // 为空时只保存在进程内
+	tables      map[string]bool // 注册表中写入 Doris 的表，用于校验 paused_tables
+	endpoints   map[string]bool // 注册表中的端点名，用于校验 paused_endpoints
+	migratable  map[string]bool // 配置了 migration 的端点名，用于校验 migrations
+	pausePolicy string          // PAUSE_POLICY
+	baseLevel   slog.Level      // LOG_LEVEL 配置的日志级别，关闭 debug 时恢复
+
+	mu    sync.Mutex // 串行化修改和持久化
+	state atomic.Pointer[toggleState]
+}
+
+// newToggles 创建运行时开关，TOGGLES_FILE 存在时从中恢复上次的状态
+// 文件中已不在注册表内的表和端点被忽略，避免配置变更后服务无法启动
+func newToggles(reg *Registry, walEnabled bool, logger *slog.Logger) (*Toggles, error) {
+	t := &Toggles{
+		path:        getEnv("TOGGLES_FILE", ""),
+		tables:      make(map[string]bool),
+		endpoints:   make(map[string]bool),
+		migratable:  make(map[string]bool),
+		pausePolicy: pausePolicyReject,
+		baseLevel:   logLevel.Level(),
+	}
+	for _, table := range reg.DorisTables() {
+		t.tables[table.Name] = true
+	}
+	for _, ep := range reg.Endpoints {
+		t.endpoints[ep.Name] = true
+		if ep.Migration != nil {
+			t.migratable[ep.Name] = true
+		}
+	}
+
+	defaultPolicy := pausePolicyReject
+	if walEnabled {
+		defaultPolicy = pausePolicySpill
+	}
+	switch policy := getEnv("PAUSE_POLICY", defaultPolicy); policy {
+	case pausePolicyReject:
+	case pausePolicySpill:
+		if !walEnabled {
+			return nil, fmt.Errorf("PAUSE_POLICY=spill 需要设置 WAL_DIR")
+		}
+		t.pausePolicy = policy
+	default:
+		return nil, fmt.Errorf("PAUSE_POLICY 无效: %q（可选 spill、reject）", policy)
+	}
+
+	// 没有保存的开关时，dry_run 的初始值取自 DRY_RUN
+	saved := RuntimeToggles{DryRun: getEnv("DRY_RUN", "false") == "true"}
+	if t.path != "" {
+		raw, err := os.ReadFile(t.path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("读取 TOGGLES_FILE 失败: %w", err)
+		default:
+			saved = RuntimeToggles{}
+			if err := json.Unmarshal(raw, &saved); err != nil {
+				return nil, fmt.Errorf("TOGGLES_FILE 格式无效: %w", err)
+			}
+		}
+	}
+	saved.PausedTables = slices.DeleteFunc(saved.PausedTables, func(name string) bool {
+		if !t.tables[name] {
+			logger.Warn("暂停写入的表未注册，忽略", "table", name)
+			return true
+		}
+		return false
+	})
+	saved.PausedEndpoints = slices.DeleteFunc(saved.PausedEndpoints, func(name string) bool {
+		if !t.endpoints[name] {
+			logger.Warn("暂停写入的端点未注册，忽略", "endpoint", name)
+			return true
+		}
+		return false
+	})
+	saved.Migrations = slices.DeleteFunc(saved.Migrations, func(m MigrationToggle) bool {
+		if !t.migratable[m.Endpoint] {
+			logger.Warn("迁移中的端点未注册或未配置 migration，忽略", "endpoint", m.Endpoint)
+			return true
+		}
+		return false
+	})
+	t.apply(saved)
+	if saved.Debug || saved.DryRun || saved.ShedLowPriority || len(saved.PausedTables) > 0 || len(saved.PausedEndpoints) > 0 || len(saved.Migrations) > 0 {
+		logger.Warn("已恢复运行时开关", "debug", saved.Debug, "dry_run", saved.DryRun, "shed_low_priority", saved.ShedLowPriority,
+			"paused_tables", saved.PausedTables, "paused_endpoints", saved.PausedEndpoints, "migrations", saved.Migrations)
+	}
+	return t, nil
+}
+
+// apply 发布新的开关快照并调整日志级别
+func (t *Toggles) apply(rt RuntimeToggles) {
+	if rt.PausedTables == nil {
+		rt.PausedTables = []string{}
+	}
+	if rt.PausedEndpoints == nil {
+		rt.PausedEndpoints = []string{}
+	}
+	if rt.Migrations == nil {
+		rt.Migrations = []MigrationToggle{}
+	}
+	s := &toggleState{
+		RuntimeToggles:  rt,
+		paused:          make(map[string]bool, len(rt.PausedTables)),
+		pausedEndpoints: make(map[string]bool, len(rt.PausedEndpoints)),
+		migrations:      make(map[string]MigrationToggle, len(rt.Migrations)),
+	}
+	for _, m := range rt.Migrations {
+		s.migrations[m.Endpoint] = m
+	}
+	for _, name := range rt.PausedTables {
+		s.paused[name] = true
+	}
+	for _, name := range rt.PausedEndpoints {
+		s.pausedEndpoints[name] = true
+	}
+	t.state.Store(s)
+
+	if rt.Debug {
+		logLevel.Set(slog.LevelDebug)
+	} else {
+		logLevel.Set(t.baseLevel)
+	}
+}
+
+// Current 返回当前的开关
+func (t *Toggles) Current() RuntimeToggles {
+	return t.state.Load().RuntimeToggles
+}
+
+// DryRun 是否只校验不写入
+func (t *Toggles) DryRun() bool {
+	return t.state.Load().DryRun
+}
+
+// ShedLowPriority 是否不论背压都丢弃或落盘低优先级事件
+func (t *Toggles) ShedLowPriority() bool {
+	return t.state.Load().ShedLowPriority
+}
+
+// TablePaused 表的写入是否已暂停
+func (t *Toggles) TablePaused(table string) bool {
+	return t.state.Load().paused[table]
+}
+
+// Paused 端点写入 table 时是否已暂停：表或端点任一暂停即暂停
+func (t *Toggles) Paused(ep *Endpoint, table string) bool {
+	s := t.state.Load()
+	return s.paused[table] || s.pausedEndpoints[ep.Name]
+}
+
+// Migration 返回端点的迁移状态，不在迁移中时 ok 为 false
+func (t *Toggles) Migration(endpoint string) (m MigrationToggle, ok bool) {
+	m, ok = t.state.Load().migrations[endpoint]
+	return m, ok
+}
+
+// Update 合并修改并持久化，持久化失败时不生效
+func (t *Toggles) Update(p togglesPatch) (RuntimeToggles, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.updateLocked(p)
+}
+
+// SetPaused 暂停或恢复一张表或一个端点的写入，table 和 endpoint 只设置其一
+func (t *Toggles) SetPaused(table, endpoint string, paused bool) (RuntimeToggles, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cur := t.Current()
+	list, name := &cur.PausedTables, table
+	if endpoint != "" {
+		list, name = &cur.PausedEndpoints, endpoint
+	}
+	names := slices.DeleteFunc(slices.Clone(*list), func(n string) bool { return n == name })
+	if paused {
+		names = append(names, name)
+	}
+	p := togglesPatch{PausedTables: &names}
+	if endpoint != "" {
+		p = togglesPatch{PausedEndpoints: &names}
+	}
+	return t.updateLocked(p)
+}
+
+// SetMigration 开始（m 不为 nil）或结束端点的迁移；pauseTable 不为空时在同一次修改中暂停该表，
+// 切回目标表和暂停同时生效，不会有事件在两者之间写入目标表
+func (t *Toggles) SetMigration(endpoint string, m *MigrationToggle, pauseTable string) (RuntimeToggles, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.endpoints[endpoint] {
+		return RuntimeToggles{}, fmt.Errorf("migrations: %w %q", errUnknownEndpoint, endpoint)
+	}
+	cur := t.Current()
+	migrations := slices.DeleteFunc(slices.Clone(cur.Migrations), func(old MigrationToggle) bool { return old.Endpoint == endpoint })
+	if m != nil {
+		migrations = append(migrations, *m)
+	}
+	p := togglesPatch{Migrations: &migrations}
+	if pauseTable != "" {
+		tables := append(slices.Clone(cur.PausedTables), pauseTable)
+		p.PausedTables = &tables
+	}
+	return t.updateLocked(p)
+}
+
+// updateLocked 合并修改并持久化，调用方持有 t.mu
+func (t *Toggles) updateLocked(p togglesPatch) (RuntimeToggles, error) {
+	rt := t.Current()
+	if p.Debug != nil {
+		rt.Debug = *p.Debug
+	}
+	if p.DryRun != nil {
+		rt.DryRun = *p.DryRun
+	}
+	if p.ShedLowPriority != nil {
+		rt.ShedLowPriority = *p.ShedLowPriority
+	}
+	if p.PausedTables != nil {
+		tables := []string{}
+		for _, name := range *p.PausedTables {
+			if !t.tables[name] {
+				return RuntimeToggles{}, fmt.Errorf("paused_tables: %w %q", errUnknownTable, name)
+			}
+			if !slices.Contains(tables, name) {
+				tables = append(tables, name)
+			}
+		}
+		rt.PausedTables = tables
+	}
+	if p.PausedEndpoints != nil {
+		endpoints := []string{}
+		for _, name := range *p.PausedEndpoints {
+			if !t.endpoints[name] {
+				return RuntimeToggles{}, fmt.Errorf("paused_endpoints: %w %q", errUnknownEndpoint, name)
+			}
+			if !slices.Contains(endpoints, name) {
+				endpoints = append(endpoints, name)
+			}
+		}
+		rt.PausedEndpoints = endpoints
+	}
+	if p.Migrations != nil {
+		migrations := []MigrationToggle{}
+		seen := make(map[string]bool)
+		for _, m := range *p.Migrations {
+			if !t.endpoints[m.Endpoint] {
+				return RuntimeToggles{}, fmt.Errorf("migrations: %w %q", errUnknownEndpoint, m.Endpoint)
+			}
+			if !t.migratable[m.Endpoint] {
+				return RuntimeToggles{}, fmt.Errorf("migrations: %w: %q", errNoMigration, m.Endpoint)
+			}
+			if !seen[m.Endpoint] {
+				seen[m.Endpoint] = true
+				migrations = append(migrations, m)
+			}
+		}
+		rt.Migrations = migrations
+	}
+
+	if err := t.save(rt); err != nil {
+		return RuntimeToggles{}, err
+	}
+	t.apply(rt)
+	return t.Current(), nil
+}
+
+// save 原子地写入 TOGGLES_FILE：写入临时文件并同步后重命名
+func (t *Toggles) save(rt RuntimeToggles) error {
+	if t.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(rt, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// togglesHandler 返回当前的运行时开关
+func (app *App) togglesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.toggles.Current())
+}
+
+// updateTogglesHandler 修改运行时开关，请求体中未出现的字段保持不变
+func (app *App) updateTogglesHandler(c *gin.Context) {
+	var p togglesPatch
+	if err := c.ShouldBindJSON(&p); err != nil {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body: "+err.Error(), nil)
+		return
+	}
+	rt, err := app.toggles.Update(p)
+	app.respondToggles(c, rt, err)
+}
+
+// pauseHandler 返回暂停（paused 为 true）或恢复一张表或一个端点写入的处理函数
+// 请求体为 {"table": "..."} 或 {"endpoint": "..."}
+func (app *App) pauseHandler(paused bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Table    string `json:"table"`
+			Endpoint string `json:"endpoint"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Table == "") == (req.Endpoint == "") {
+			abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body: exactly one of table and endpoint is required", nil)
+			return
+		}
+		rt, err := app.toggles.SetPaused(req.Table, req.Endpoint, paused)
+		app.respondToggles(c, rt, err)
+	}
+}
+
+// respondToggles 记录开关修改并返回修改后的开关，修改失败时写出错误响应
+func (app *App) respondToggles(c *gin.Context, rt RuntimeToggles, err error) {
+	switch {
+	case errors.Is(err, errUnknownTable), errors.Is(err, errUnknownEndpoint), errors.Is(err, errNoMigration):
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body: "+err.Error(), nil)
+		return
+	case err != nil:
+		app.logger.Error("保存运行时开关失败", "path", app.toggles.path, "error", err)
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to persist toggles", nil)
+		return
+	}
+	app.logger.Warn("运行时开关已修改", "ip", c.ClientIP(), "debug", rt.Debug, "dry_run", rt.DryRun, "shed_low_priority", rt.ShedLowPriority,
+		"paused_tables", rt.PausedTables, "paused_endpoints", rt.PausedEndpoints, "migrations", rt.Migrations)
+	c.JSON(http.StatusOK, rt)
+}
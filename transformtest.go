@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// testTransformsCommand 校验列映射的子命令
+const testTransformsCommand = "test-transforms"
+
+// goldenSuffix 期望输出文件的后缀，与夹具文件同名
+const goldenSuffix = ".golden.ndjson"
+
+// transformCase 一个夹具文件及其期望输出
+type transformCase struct {
+	ep      *Endpoint
+	fixture string
+	golden  string
+}
+
+// runTestTransforms 子命令 test-transforms：用夹具请求体运行端点的列映射（含 sanitize、strict、limits 和双写表），
+// 与 golden NDJSON 文件比较，供 CI 在发布前校验配置文件的修改；有用例不一致或出错时返回 1
+// 夹具位于 {dir}/{端点名}/ 下：*.ndjson 每行一个请求体，*.json 为一个请求体；期望输出为同名的 *.golden.ndjson
+func runTestTransforms(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet(testTransformsCommand, flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	dir := fs.String("dir", "testdata/transforms", "夹具目录，按端点名分子目录")
+	update := fs.Bool("update", false, "用当前输出重写 golden 文件")
+	nowFlag := fs.String("now", "2024-01-01T00:00:00+08:00", "ingest_time、ingest_date 等列使用的固定时间（RFC3339）")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	now, err := time.Parse(time.RFC3339, *nowFlag)
+	if err != nil {
+		fmt.Fprintf(stdout, "-now 无效: %q\n", *nowFlag)
+		return 2
+	}
+
+	registry, err := loadRegistry()
+	if err != nil {
+		fmt.Fprintf(stdout, "加载端点配置失败: %v\n", err)
+		return 1
+	}
+	cases, err := findTransformCases(registry, *dir)
+	if err != nil {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+	if len(cases) == 0 {
+		fmt.Fprintf(stdout, "%s 下没有夹具文件\n", *dir)
+		return 1
+	}
+
+	failed := 0
+	for _, tc := range cases {
+		name := filepath.Join(tc.ep.Name, filepath.Base(tc.fixture))
+		got, err := tc.run(now)
+		if err != nil {
+			fmt.Fprintf(stdout, "FAIL %s: %v\n", name, err)
+			failed++
+			continue
+		}
+		if *update {
+			if err := os.WriteFile(tc.golden, got, 0o644); err != nil {
+				fmt.Fprintf(stdout, "FAIL %s: %v\n", name, err)
+				failed++
+				continue
+			}
+			fmt.Fprintf(stdout, "updated %s\n", name)
+			continue
+		}
+		want, err := os.ReadFile(tc.golden)
+		if err != nil {
+			fmt.Fprintf(stdout, "FAIL %s: 读取 golden 文件失败（可用 -update 生成）: %v\n", name, err)
+			failed++
+			continue
+		}
+		if diff := diffLines(want, got); diff != "" {
+			fmt.Fprintf(stdout, "FAIL %s\n%s", name, diff)
+			failed++
+			continue
+		}
+		fmt.Fprintf(stdout, "ok   %s\n", name)
+	}
+	if failed > 0 {
+		fmt.Fprintf(stdout, "%d/%d 个用例未通过\n", failed, len(cases))
+		return 1
+	}
+	if *update {
+		fmt.Fprintf(stdout, "已更新 %d 个 golden 文件\n", len(cases))
+		return 0
+	}
+	fmt.Fprintf(stdout, "%d 个用例全部通过\n", len(cases))
+	return 0
+}
+
+// findTransformCases 查找夹具文件，子目录名必须是已定义的端点
+func findTransformCases(registry *Registry, dir string) ([]transformCase, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取夹具目录失败: %w", err)
+	}
+	var cases []transformCase
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		i := slices.IndexFunc(registry.Endpoints, func(ep *Endpoint) bool { return ep.Name == e.Name() })
+		if i < 0 {
+			return nil, fmt.Errorf("夹具目录 %s 不对应任何端点", filepath.Join(dir, e.Name()))
+		}
+		files, err := os.ReadDir(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("读取夹具目录失败: %w", err)
+		}
+		for _, f := range files {
+			name := f.Name()
+			if f.IsDir() || strings.HasSuffix(name, goldenSuffix) || (filepath.Ext(name) != ".ndjson" && filepath.Ext(name) != ".json") {
+				continue
+			}
+			path := filepath.Join(dir, e.Name(), name)
+			cases = append(cases, transformCase{
+				ep:      registry.Endpoints[i],
+				fixture: path,
+				golden:  strings.TrimSuffix(path, filepath.Ext(name)) + goldenSuffix,
+			})
+		}
+	}
+	return cases, nil
+}
+
+// run 转换夹具中的每个请求体，每个请求体输出各目标表的行（{"table":...,"row":...}），被拒绝时输出 {"error":...}
+// 不查询 GeoIP，请求头为空，geoip_country 和 header 来源的列取默认值
+func (tc *transformCase) run(now time.Time) ([]byte, error) {
+	raw, err := os.ReadFile(tc.fixture)
+	if err != nil {
+		return nil, err
+	}
+	bodies := [][]byte{raw}
+	if filepath.Ext(tc.fixture) == ".ndjson" {
+		bodies = nil
+		for _, line := range bytes.Split(raw, []byte{'\n'}) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				bodies = append(bodies, line)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	for _, b := range bodies {
+		rows, err := tc.transform(b, now)
+		if err != nil {
+			if err := enc.Encode(map[string]string{"error": err.Error()}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, r := range rows {
+			if err := enc.Encode(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// transformedRow golden 文件中的一行
+type transformedRow struct {
+	Table string         `json:"table"`
+	Row   map[string]any `json:"row"`
+}
+
+// transform 按与写入接口相同的顺序转换一个请求体
+func (tc *transformCase) transform(raw []byte, now time.Time) ([]transformedRow, error) {
+	ep := tc.ep
+	raw, err := ep.sanitizeBody(raw)
+	if err != nil {
+		return nil, err
+	}
+	body, err := decodeJSONObject(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := ep.checkStrict(body); err != nil {
+		return nil, err
+	}
+	ev, err := convertEvent(ep, body, RowContext{Now: now})
+	if err != nil {
+		return nil, err
+	}
+	rows := []transformedRow{{Table: ep.TableName, Row: ev.Row}}
+	for j, dw := range ep.DualWrite {
+		rows = append(rows, transformedRow{Table: dw.TableName, Row: ev.Dual[j]})
+	}
+	return rows, nil
+}
+
+// diffLines 逐行比较期望和实际输出，相同时返回空串
+func diffLines(want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	wl := strings.Split(strings.TrimSuffix(string(want), "\n"), "\n")
+	gl := strings.Split(strings.TrimSuffix(string(got), "\n"), "\n")
+	var b strings.Builder
+	for i := range max(len(wl), len(gl)) {
+		var w, g string
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if w == g {
+			continue
+		}
+		fmt.Fprintf(&b, "  line %d:\n", i+1)
+		if i < len(wl) {
+			fmt.Fprintf(&b, "    - %s\n", w)
+		}
+		if i < len(gl) {
+			fmt.Fprintf(&b, "    + %s\n", g)
+		}
+	}
+	return b.String()
+}
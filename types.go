@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 列类型，未设置时按原样写入
+const (
+	typeString   = "string"
+	typeInt      = "int"
+	typeFloat    = "float"
+	typeDatetime = "datetime"
+	typeBool     = "bool"
+)
+
+// epochMillisThreshold 数值型 datetime 不小于该值时按毫秒解析，否则按秒解析
+// 1e11 秒约为 5138 年，1e11 毫秒约为 1973 年
+const epochMillisThreshold = 1e11
+
+// datetimeLayouts datetime 列接受的字符串格式，不带时区的按服务时区解析
+var datetimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// coercionError 字段值无法转换为列类型，返回 422
+type coercionError struct {
+	Field string
+	Type  string
+	Value any
+}
+
+func (e *coercionError) Error() string {
+	return fmt.Sprintf("field %q: cannot convert %s to %s", e.Field, describeValue(e.Value), e.Type)
+}
+
+// unmappedFieldsError 严格模式的端点收到没有被列映射读取的字段，返回 422
+type unmappedFieldsError struct {
+	Fields []string
+}
+
+func (e *unmappedFieldsError) Error() string {
+	return fmt.Sprintf("unmapped fields: %s", strings.Join(e.Fields, ", "))
+}
+
+// datetime 列超出时间窗口时的处理方式
+const (
+	outOfRangeReject = "reject" // 返回 422（默认）
+	outOfRangeClamp  = "clamp"  // 修正为窗口边界
+)
+
+// windowError datetime 字段超出允许的时间窗口，返回 422
+type windowError struct {
+	Field string
+	Value time.Time
+	Limit string // 超出的限制：max_age 或 max_future
+	Bound time.Duration
+}
+
+func (e *windowError) Error() string {
+	if e.Limit == "max_future" {
+		return fmt.Sprintf("field %q: timestamp %s is more than %s in the future", e.Field, e.Value.Format(dorisDatetimeFormat), e.Bound)
+	}
+	return fmt.Sprintf("field %q: timestamp %s is older than %s", e.Field, e.Value.Format(dorisDatetimeFormat), e.Bound)
+}
+
+// validColumnType 判断列类型是否有效
+func validColumnType(t string) bool {
+	switch t {
+	case "", typeString, typeInt, typeFloat, typeDatetime, typeBool:
+		return true
+	}
+	return false
+}
+
+// coerce 将请求字段值转换为列类型：
+//   - int："42"、42、42.0 → 42，有小数部分或超出 int64 范围时报错
+//   - float："1.5"、1.5 → 1.5
+//   - bool：true/false、"true"/"false"/"1"/"0"、1/0
+//   - datetime：日期时间字符串或 epoch 秒/毫秒 → "2006-01-02 15:04:05.000"，
+//     设置 correct_skew 时按 rc.ClockSkew 修正；rc.Now 非零时按 max_age/max_future 检查时间窗口
+//   - string：数字和布尔值转为字符串
+func coerce(m *ColumnMapping, v any, rc RowContext) (any, error) {
+	var (
+		out any
+		ok  bool
+	)
+	switch m.Type {
+	case "":
+		return v, nil
+	case typeString:
+		out, ok = coerceString(v)
+	case typeInt:
+		out, ok = coerceInt(v)
+	case typeFloat:
+		out, ok = coerceFloat(v)
+	case typeBool:
+		out, ok = coerceBool(v)
+	case typeDatetime:
+		var t time.Time
+		if t, ok = parseDatetime(v); ok {
+			if m.CorrectSkew {
+				t = t.Add(rc.ClockSkew)
+			}
+			if !rc.Now.IsZero() {
+				var err error
+				if t, err = m.checkWindow(t, rc.Now); err != nil {
+					return nil, err
+				}
+			}
+			out = t.Format(dorisDatetimeFormat)
+		}
+	}
+	if !ok {
+		return nil, &coercionError{Field: m.inputName(), Type: m.Type, Value: v}
+	}
+	return out, nil
+}
+
+// checkWindow 检查时间是否在 [now-max_age, now+max_future] 内，超出时按 out_of_range 拒绝或修正
+func (m *ColumnMapping) checkWindow(t, now time.Time) (time.Time, error) {
+	var (
+		limit string
+		bound time.Duration
+		edge  time.Time
+	)
+	switch {
+	case m.maxAge > 0 && t.Before(now.Add(-m.maxAge)):
+		limit, bound, edge = "max_age", m.maxAge, now.Add(-m.maxAge)
+	case m.maxFuture > 0 && t.After(now.Add(m.maxFuture)):
+		limit, bound, edge = "max_future", m.maxFuture, now.Add(m.maxFuture)
+	default:
+		return t, nil
+	}
+	if m.OutOfRange == outOfRangeClamp {
+		return edge, nil
+	}
+	return t, &windowError{Field: m.inputName(), Value: t, Limit: limit, Bound: bound}
+}
+
+func coerceString(v any) (any, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return nil, false
+}
+
+func coerceInt(v any) (any, bool) {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = strings.TrimSpace(v)
+	default:
+		return nil, false
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, true
+	}
+	// 42.0、1e3 等没有小数部分的数值
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return nil, false
+	}
+	return int64(f), true
+}
+
+func coerceFloat(v any) (any, bool) {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = strings.TrimSpace(v)
+	default:
+		return nil, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, false
+	}
+	return f, true
+}
+
+func coerceBool(v any) (any, bool) {
+	switch v := v.(type) {
+	case bool:
+		return v, true
+	case json.Number:
+		switch v.String() {
+		case "1":
+			return true, true
+		case "0":
+			return false, true
+		}
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// parseDatetime 解析日期时间字符串或 epoch 秒/毫秒
+func parseDatetime(v any) (time.Time, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		return parseEpoch(v.String())
+	case string:
+		s := strings.TrimSpace(v)
+		for _, layout := range datetimeLayouts {
+			if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+				return t.In(time.Local), true
+			}
+		}
+		return parseEpoch(s)
+	}
+	return time.Time{}, false
+}
+
+// parseEpoch 解析 epoch 秒或毫秒
+func parseEpoch(s string) (time.Time, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) {
+		return time.Time{}, false
+	}
+	if f >= epochMillisThreshold {
+		return time.UnixMilli(int64(f)), true
+	}
+	return time.UnixMilli(int64(f * 1000)), true
+}
+
+// describeValue 返回错误信息中的字段值描述
+func describeValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 大小的单位，KB、MB、GB 与 KiB、MiB、GiB 相同，均按 1024 进制（与 Doris 的 streaming_load_max_mb 一致）
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// parseDuration 解析时长：Go 的时长格式（500ms、30s、5m、1h30m），或不带单位的整数，按 unit 计
+// 不带单位的整数兼容按数字配置的 *_MS、*_SECONDS 等环境变量
+func parseDuration(s string, unit time.Duration) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(n) * unit, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// parseByteSize 解析大小：带单位（512KB、16MB、1GB，单位不区分大小写），或不带单位的整数，按 unit 计
+func parseByteSize(s string, unit int64) (int64, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n * unit, nil
+	}
+	upper := strings.ToUpper(s)
+	for _, u := range byteUnits {
+		if num, ok := strings.CutSuffix(upper, u.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil || n < 0 {
+				break
+			}
+			return int64(n * float64(u.size)), nil
+		}
+	}
+	return 0, fmt.Errorf("大小无效: %q（如 512KB、16MB、1GB）", s)
+}
+
+// formatByteSize 以最大的整数单位输出大小，用于错误信息
+func formatByteSize(n int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if n >= u.size && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
+
+// envDuration 读取时长环境变量（格式见 parseDuration），值需在 [min, max] 之间，max 为 0 时不限上限
+// 返回的错误为 *configError，Field 为 key
+func envDuration(key, def string, unit, min, max time.Duration) (time.Duration, error) {
+	raw := getEnv(key, def)
+	d, err := parseDuration(raw, unit)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("无效: %q（如 500ms、30s、5m，或以 %s 为单位的整数）", raw, unit)
+	case max > 0 && (d < min || d > max):
+		err = fmt.Errorf("超出范围: %q（应在 %s 到 %s 之间）", raw, min, max)
+	case d < min:
+		err = fmt.Errorf("超出范围: %q（不能小于 %s）", raw, min)
+	default:
+		return d, nil
+	}
+	return 0, &configError{Field: key, Err: err}
+}
+
+// envByteSize 读取大小环境变量（格式见 parseByteSize），值需在 [min, max] 之间，max 为 0 时不限上限
+// 返回的错误为 *configError，Field 为 key
+func envByteSize(key, def string, unit, min, max int64) (int64, error) {
+	raw := getEnv(key, def)
+	n, err := parseByteSize(raw, unit)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("无效: %q（如 512KB、16MB、1GB，或以 %s 为单位的整数）", raw, formatByteSize(unit))
+	case max > 0 && (n < min || n > max):
+		err = fmt.Errorf("超出范围: %q（应在 %s 到 %s 之间）", raw, formatByteSize(min), formatByteSize(max))
+	case n < min:
+		err = fmt.Errorf("超出范围: %q（不能小于 %s）", raw, formatByteSize(min))
+	default:
+		return n, nil
+	}
+	return 0, &configError{Field: key, Err: err}
+}
+
+// ByteSize 配置文件中的字节数，可写为整数（字节）或带单位的字符串（如 16KB、1MB）
+type ByteSize int
+
+func (b *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	n, err := parseByteSize(s, 1)
+	if err != nil {
+		return fmt.Errorf("第 %d 行: %w", node.Line, err)
+	}
+	*b = ByteSize(n)
+	return nil
+}
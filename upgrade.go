@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// 平滑升级：旧进程收到 SIGHUP 后启动新的可执行文件，通过 ExtraFiles 传递监听 socket 和就绪管道，
+// 新进程完成初始化并开始接收连接后写入就绪管道，旧进程随后停止接收新连接，处理完进行中的请求、
+// 写入批次中剩余的事件并封存 WAL 后退出。升级期间监听 socket 始终处于打开状态，连接不会被拒绝。
+const (
+	upgradeParentEnv  = "UPGRADE_PARENT_PID" // 新进程的环境变量：发起升级的旧进程 PID
+	upgradeListenerFd = 3                    // ExtraFiles[0]：监听 socket
+	upgradeReadyFd    = 4                    // ExtraFiles[1]：就绪管道的写端
+)
+
+// upgradeParentPID 返回发起升级的旧进程 PID，不是由平滑升级启动时返回 0
+func upgradeParentPID() int {
+	pid, err := strconv.Atoi(os.Getenv(upgradeParentEnv))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return pid
+}
+
+// upgradeListener 获取旧进程传入的监听 socket
+// 不是由平滑升级启动时返回 nil
+func upgradeListener() (net.Listener, error) {
+	if upgradeParentPID() == 0 {
+		return nil, nil
+	}
+	syscall.CloseOnExec(upgradeListenerFd)
+	f := os.NewFile(uintptr(upgradeListenerFd), "upgrade-listener")
+	defer f.Close()
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("无法使用旧进程传入的 socket: %w", err)
+	}
+	return ln, nil
+}
+
+// notifyUpgradeReady 通知旧进程新进程已就绪，旧进程随后开始退出
+// 由 systemd 管理时同时通过 MAINPID= 将主进程切换为当前进程（需要 NotifyAccess=all）
+func notifyUpgradeReady() error {
+	parent := upgradeParentPID()
+	os.Unsetenv(upgradeParentEnv)
+
+	// watchdog 由新的主进程发送
+	if os.Getenv("WATCHDOG_PID") == strconv.Itoa(parent) {
+		os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	}
+	if _, err := sdNotify(fmt.Sprintf("MAINPID=%d\nREADY=1", os.Getpid())); err != nil {
+		return err
+	}
+
+	f := os.NewFile(uintptr(upgradeReadyFd), "upgrade-ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		return fmt.Errorf("通知旧进程失败: %w", err)
+	}
+	return nil
+}
+
+// upgrader 在旧进程中启动新进程并等待其就绪
+type upgrader struct {
+	executable string        // 启动时解析的可执行文件路径，升级时执行该路径上的新版本
+	timeout    time.Duration // 等待新进程就绪的时间
+	logger     *slog.Logger
+}
+
+// newUpgrader 创建平滑升级器，需要在替换可执行文件之前（启动时）调用
+func newUpgrader(logger *slog.Logger) (*upgrader, error) {
+	timeout, err := parsePositiveSeconds("UPGRADE_TIMEOUT", "60")
+	if err != nil {
+		return nil, err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("无法获取可执行文件路径: %w", err)
+	}
+	return &upgrader{executable: exe, timeout: timeout, logger: logger}, nil
+}
+
+// Upgrade 启动新进程并传递监听 socket，新进程就绪后返回 nil，调用方随后优雅关闭
+// 新进程启动失败、提前退出或超时未就绪时返回错误，当前进程继续提供服务
+func (u *upgrader) Upgrade(listener net.Listener) error {
+	fl, ok := listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return fmt.Errorf("监听 socket 不支持传递: %T", listener)
+	}
+	lnFile, err := fl.File()
+	if err != nil {
+		return fmt.Errorf("复制监听 socket 失败: %w", err)
+	}
+	defer lnFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("创建就绪管道失败: %w", err)
+	}
+	defer readyR.Close()
+
+	cmd := exec.Command(u.executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", upgradeParentEnv, os.Getpid()))
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.ExtraFiles = []*os.File{lnFile, readyW}
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return fmt.Errorf("启动新进程失败: %w", err)
+	}
+	u.logger.Info("已启动新进程，等待就绪", "pid", cmd.Process.Pid, "executable", u.executable)
+
+	// 新进程就绪时写入一个字节；提前退出时管道写端随之关闭，读取返回 EOF
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+		if errors.Is(err, io.EOF) {
+			err = errors.New("新进程在就绪前退出")
+		}
+	case <-time.After(u.timeout):
+		err = fmt.Errorf("等待新进程就绪超时（%s）", u.timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	// 新进程不再由当前进程管理，当前进程退出后由 init/systemd 接管
+	go cmd.Wait()
+	u.logger.Info("新进程已就绪，开始退出", "pid", cmd.Process.Pid)
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const uploadPath = "/upload"
+
+// Uploader 文件上传配置：分析人员通过 /upload 上传 NDJSON/CSV 文件补录历史数据，
+// 每行按端点的列映射校验和转换，与实时写入使用相同的校验规则
+type Uploader struct {
+	maxBytes  int64         // 请求体大小上限
+	chunkRows int           // 每次 Stream Load 的行数
+	timeout   time.Duration // 单次上传的读写时间上限，覆盖服务器默认的读写超时
+}
+
+// newUploader 创建文件上传配置，未启用时返回 nil
+// 上传接口使用管理接口的令牌鉴权，启用时必须设置 ADMIN_TOKEN
+func newUploader(registry *Registry) (*Uploader, error) {
+	if getEnv("UPLOAD_ENABLED", "false") != "true" {
+		return nil, nil
+	}
+	if getEnv("ADMIN_TOKEN", "") == "" {
+		return nil, fmt.Errorf("UPLOAD_ENABLED=true 时必须设置 ADMIN_TOKEN")
+	}
+	if registry.endpointByPath(uploadPath) != nil {
+		return nil, fmt.Errorf("端点路径 %s 与文件上传接口冲突", uploadPath)
+	}
+	maxBytes, err := envByteSize("UPLOAD_MAX_MB", "512", 1<<20, 1<<10, 0)
+	if err != nil {
+		return nil, err
+	}
+	chunkRows, err := strconv.Atoi(getEnv("UPLOAD_CHUNK_ROWS", "10000"))
+	if err != nil || chunkRows <= 0 {
+		return nil, fmt.Errorf("UPLOAD_CHUNK_ROWS 无效: %q", getEnv("UPLOAD_CHUNK_ROWS", ""))
+	}
+	timeout, err := parsePositiveSeconds("UPLOAD_TIMEOUT", "1800")
+	if err != nil {
+		return nil, err
+	}
+	return &Uploader{maxBytes: maxBytes, chunkRows: chunkRows, timeout: timeout}, nil
+}
+
+// uploadProgress 上传响应中的一行进度：每写入一个分块输出一行，最后一行带 done 或 error
+type uploadProgress struct {
+	UploadID      string         `json:"upload_id"`
+	Chunk         int            `json:"chunk,omitempty"`          // 刚写入的分块序号（从 1 开始）
+	Rows          int            `json:"rows"`                     // 已写入的行数
+	SkippedChunks int            `json:"skipped_chunks,omitempty"` // 此前已提交而被跳过的分块数（相同 upload_id 重新上传）
+	BytesRead     int64          `json:"bytes_read"`
+	BytesTotal    int64          `json:"bytes_total,omitempty"` // 请求体大小，客户端未声明时省略
+	Done          bool           `json:"done,omitempty"`
+	Error         *errorResponse `json:"error,omitempty"`
+}
+
+// uploadHandler 处理文件上传：multipart 请求中的 file 部分按行校验后分块写入端点对应的表（含双写表）
+// endpoint、upload_id、format 可以作为查询参数，或作为 file 之前的表单字段。
+// 每个分块使用由 upload_id 派生的固定 label，失败后以相同 upload_id 重新上传时已提交的分块被 Doris 去重。
+// 响应为 NDJSON 进度流，首个分块写入前出错时返回普通的错误响应
+func (app *App) uploadHandler(c *gin.Context) {
+	u := app.uploads
+	deadline := time.Now().Add(u.timeout)
+	// HTTP/2 连接不支持设置单个请求的读写截止时间，此时沿用服务器的超时设置
+	rc := http.NewResponseController(c.Writer)
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+	ctx, cancel := context.WithDeadline(c.Request.Context(), deadline)
+	defer cancel()
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, u.maxBytes)
+	mr, err := c.Request.MultipartReader()
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Expected a multipart/form-data request", nil)
+		return
+	}
+
+	params := map[string]string{
+		"endpoint":  c.Query("endpoint"),
+		"upload_id": c.Query("upload_id"),
+		"format":    c.Query("format"),
+	}
+	var file *multipart.Part
+	for file == nil {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Missing file part", nil)
+			return
+		}
+		if err != nil {
+			status, code, message, details := app.uploads.readFailure(err)
+			abortWithError(c, status, code, message, optionalDetails(details))
+			return
+		}
+		name := part.FormName()
+		if name == "file" {
+			file = part
+			break
+		}
+		if _, ok := params[name]; ok {
+			value, err := io.ReadAll(io.LimitReader(part, 256))
+			if err != nil {
+				status, code, message, details := app.uploads.readFailure(err)
+				abortWithError(c, status, code, message, optionalDetails(details))
+				return
+			}
+			params[name] = strings.TrimSpace(string(value))
+		}
+		part.Close()
+	}
+	defer file.Close()
+
+	var ep *Endpoint
+	for _, e := range app.registry.Endpoints {
+		if e.Name == params["endpoint"] {
+			ep = e
+		}
+	}
+	if ep == nil {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Unknown endpoint %q", params["endpoint"]), nil)
+		return
+	}
+	if !ep.WritesDoris() {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Endpoint %q does not write to Doris", ep.Name), nil)
+		return
+	}
+	if ep.Rollup != nil {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Endpoint %q writes rollups and cannot be backfilled", ep.Name), nil)
+		return
+	}
+	uploadID := params["upload_id"]
+	if uploadID == "" {
+		uploadID = uuid.New().String()
+	} else if !labelPartPattern.MatchString(uploadID) {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid upload_id: must be 1-64 letters, digits, '-' or '_'", nil)
+		return
+	}
+	format := fileFormat(file.FileName(), params["format"])
+	body := &countingReader{r: file}
+	next, err := newFileRows(format, body)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request: "+err.Error(), nil)
+		return
+	}
+
+	progress := uploadProgress{UploadID: uploadID, BytesTotal: max(c.Request.ContentLength, 0)}
+	started := false
+	emit := func() {
+		if !started {
+			started = true
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(http.StatusOK)
+		}
+		progress.BytesRead = body.n
+		json.NewEncoder(c.Writer).Encode(progress)
+		c.Writer.Flush()
+	}
+	// fail 首个分块写入前出错时返回错误响应，之后以进度流的最后一行报告错误
+	fail := func(status int, code, message string, details gin.H) {
+		app.logger.Warn("文件上传失败", "endpoint", ep.Name, "upload_id", uploadID, "rows", progress.Rows, "error", message)
+		if !started {
+			abortWithError(c, status, code, message, optionalDetails(details))
+			return
+		}
+		progress.Chunk = 0
+		progress.Error = &errorResponse{Code: code, Message: message, Details: optionalDetails(details), RequestID: c.GetString(requestIDKey)}
+		emit()
+		c.Abort()
+	}
+
+	app.logger.Info("开始文件上传", "endpoint", ep.Name, "upload_id", uploadID, "format", format, "file", file.FileName())
+	loader := &chunkLoader{
+		app:       app,
+		ep:        ep,
+		label:     fmt.Sprintf("upload-%s-%s", ep.Name, uploadID),
+		chunkRows: u.chunkRows,
+		build: func(body map[string]any) (eventRow, error) {
+			return app.buildEvent(c, ep, body, RowContext{Now: time.Now()})
+		},
+		onChunk: func(chunk, rows int, skipped bool) {
+			progress.Chunk, progress.Rows = chunk, rows
+			if skipped {
+				progress.SkippedChunks++
+			}
+			emit()
+		},
+	}
+	rows, err := loader.run(withLoadSource(ctx, loadSource{RequestID: c.GetString(requestIDKey), Principal: "admin", Endpoint: ep.Name}), next)
+	var (
+		le *fileLineError
+		ce *chunkLoadError
+	)
+	switch {
+	case errors.As(err, &le):
+		status, code, message, details := classifyInvalid(err)
+		details["line"] = le.Line
+		fail(status, code, message, details)
+	case errors.As(err, &ce) && errors.Is(err, errLoadDegraded):
+		fail(http.StatusServiceUnavailable, errCodeDorisUnavailable, "Doris unavailable, retry the upload with the same upload_id later", gin.H{"chunk": ce.Chunk})
+	case ce != nil:
+		fail(http.StatusBadGateway, errCodeDorisUnavailable, fmt.Sprintf("Doris load failed: %v", ce.Err), gin.H{"chunk": ce.Chunk})
+	case err != nil:
+		fail(u.readFailure(err))
+	case rows == 0:
+		fail(http.StatusBadRequest, errCodeSchemaInvalid, "Invalid request body: file contains no events", nil)
+	default:
+		app.logger.Info("文件上传完成", "endpoint", ep.Name, "upload_id", uploadID, "rows", rows, "skipped_chunks", progress.SkippedChunks)
+		progress.Chunk = 0
+		progress.Done = true
+		emit()
+	}
+}
+
+// readFailure 将读取上传请求的错误映射为响应，超过 UPLOAD_MAX_MB 时返回 413
+func (u *Uploader) readFailure(err error) (int, string, string, gin.H) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, errCodePayloadTooLarge,
+			fmt.Sprintf("Upload too large (max %d MB)", u.maxBytes>>20), gin.H{"max_bytes": u.maxBytes}
+	}
+	return http.StatusBadRequest, errCodeInvalidRequest, "Failed to read request body: " + err.Error(), nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"doris-webhook/dorisload"
+)
+
+// acceptedRowsKey 请求接收的事件数在 gin 上下文中的键，用于用量统计
+const acceptedRowsKey = "accepted_rows"
+
+// usageColumns 用量表的列，与 UsageRecord 的 JSON 字段一致
+var usageColumns = []string{
+	"usage_date", "instance", "principal", "endpoint", "requests", "rows", "bytes", "errors", "updated_at",
+}
+
+// usageCounters 一个调用方在一个端点上一天的用量
+type usageCounters struct {
+	Requests int64 `json:"requests"`
+	Rows     int64 `json:"rows"`   // 接收的事件数
+	Bytes    int64 `json:"bytes"`  // 请求体字节数（含被拒绝的请求）
+	Errors   int64 `json:"errors"` // 返回 4xx、5xx 的请求数
+}
+
+// usageKey 用量的聚合维度
+type usageKey struct {
+	Date      string // YYYY-MM-DD，本地时区
+	Principal string // 调用方标识，见 principalKey；不鉴权的端点为空
+	Endpoint  string
+}
+
+// UsageRecord 一天的用量，/admin/usage 的返回和用量表的一行
+type UsageRecord struct {
+	Date      string `json:"usage_date"`
+	Instance  string `json:"instance,omitempty"` // 写入用量表时为本实例的标识，多副本按其区分
+	Principal string `json:"principal"`
+	Endpoint  string `json:"endpoint"`
+	usageCounters
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// usageFileState USAGE_FILE 的内容
+type usageFileState struct {
+	Records []UsageRecord `json:"records"`
+}
+
+// UsageMeter 按调用方（API Key 指纹、JWT sub、签名密钥 ID）和端点统计每天的请求数、事件数、字节数和错误数，
+// 用于向内部用户计费或分摊成本；每隔 USAGE_FLUSH_INTERVAL 持久化到 USAGE_FILE，设置 USAGE_TABLE 时同时写入 Doris
+type UsageMeter struct {
+	path      string
+	retention int // 保留的天数
+	interval  time.Duration
+	instance  string
+	logger    *slog.Logger
+
+	// doris 输出
+	table *dorisload.Table
+	dc    *dorisload.Client
+
+	mu    sync.Mutex
+	days  map[usageKey]*usageCounters
+	dirty map[usageKey]bool // 上次写入用量表之后有变化的用量
+
+	done chan struct{}
+	exit chan struct{}
+}
+
+// newUsageMeter 按 USAGE_FILE 创建用量统计并加载已持久化的用量，未设置时返回 nil
+func newUsageMeter(logger *slog.Logger) (*UsageMeter, error) {
+	u, err := loadUsageConfig(logger)
+	if u == nil || err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(u.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取用量文件失败: %w", err)
+	}
+	var state usageFileState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, fmt.Errorf("用量文件 %s 无效: %w", u.path, err)
+	}
+	for _, r := range state.Records {
+		counters := r.usageCounters
+		u.days[usageKey{Date: r.Date, Principal: r.Principal, Endpoint: r.Endpoint}] = &counters
+	}
+	return u, nil
+}
+
+// loadUsageConfig 读取 USAGE_* 环境变量，不加载用量文件；未设置 USAGE_FILE 时返回 nil
+func loadUsageConfig(logger *slog.Logger) (*UsageMeter, error) {
+	path := getEnv("USAGE_FILE", "")
+	if path == "" {
+		return nil, nil
+	}
+	retention, err := strconv.Atoi(getEnv("USAGE_RETENTION_DAYS", "90"))
+	if err != nil || retention <= 0 {
+		return nil, fmt.Errorf("USAGE_RETENTION_DAYS 无效: %q", getEnv("USAGE_RETENTION_DAYS", ""))
+	}
+	interval, err := parsePositiveSeconds("USAGE_FLUSH_INTERVAL", "60")
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	u := &UsageMeter{
+		path:      path,
+		retention: retention,
+		interval:  interval,
+		instance:  getEnv("USAGE_INSTANCE", hostname),
+		logger:    logger,
+		days:      make(map[usageKey]*usageCounters),
+		dirty:     make(map[usageKey]bool),
+		done:      make(chan struct{}),
+		exit:      make(chan struct{}),
+	}
+	if name := getEnv("USAGE_TABLE", ""); name != "" {
+		if err := dorisload.ValidateIdentifier(name); err != nil {
+			return nil, fmt.Errorf("USAGE_TABLE 无效: %w", err)
+		}
+		u.table = &dorisload.Table{Name: name, Columns: usageColumns}
+	}
+	return u, nil
+}
+
+// countingBody 统计读取的请求体字节数
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// middleware 统计请求的用量，放在鉴权之后以取得调用方标识；鉴权失败的请求不计入
+func (u *UsageMeter) middleware(ep *Endpoint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := &countingBody{ReadCloser: c.Request.Body}
+		c.Request.Body = body
+		c.Next()
+		u.add(usageKey{
+			Date:      time.Now().Format(dorisDateFormat),
+			Principal: c.GetString(principalKey),
+			Endpoint:  ep.Name,
+		}, int64(c.GetInt(acceptedRowsKey)), body.n, c.Writer.Status() >= http.StatusBadRequest)
+	}
+}
+
+// add 累加一个请求的用量
+func (u *UsageMeter) add(key usageKey, rows, bytes int64, failed bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counters := u.days[key]
+	if counters == nil {
+		counters = &usageCounters{}
+		u.days[key] = counters
+	}
+	counters.Requests++
+	counters.Rows += rows
+	counters.Bytes += bytes
+	if failed {
+		counters.Errors++
+	}
+	u.dirty[key] = true
+}
+
+// Start 启动定期持久化，dc 为写入用量表的客户端
+func (u *UsageMeter) Start(dc *dorisload.Client) {
+	u.dc = dc
+	go u.run()
+}
+
+// run 定期持久化用量
+func (u *UsageMeter) run() {
+	defer close(u.exit)
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			u.flush()
+		case <-u.done:
+			u.flush()
+			return
+		}
+	}
+}
+
+// flush 清理超出保留天数的用量，写入 USAGE_FILE，并将有变化的用量写入用量表
+func (u *UsageMeter) flush() {
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -u.retention+1).Format(dorisDateFormat)
+	u.mu.Lock()
+	for key := range u.days {
+		if key.Date < cutoff {
+			delete(u.days, key)
+			delete(u.dirty, key)
+		}
+	}
+	records := u.recordsLocked(func(usageKey) bool { return true })
+	var changed []UsageRecord
+	if u.table != nil && len(u.dirty) > 0 {
+		changed = u.recordsLocked(func(key usageKey) bool { return u.dirty[key] })
+		clear(u.dirty)
+	}
+	u.mu.Unlock()
+
+	raw, err := json.Marshal(usageFileState{Records: records})
+	if err == nil {
+		err = writeFileAtomic(u.path, raw)
+	}
+	if err != nil {
+		u.logger.Error("写入用量文件失败", "path", u.path, "error", err)
+	}
+	if len(changed) > 0 {
+		u.writeTable(changed, now)
+	}
+}
+
+// writeTable 将用量写入用量表（UNIQUE KEY 模型，同一天的用量以最新的累计值覆盖），失败时重新标记为有变化，下次重试
+func (u *UsageMeter) writeTable(records []UsageRecord, now time.Time) {
+	lines := make([][]byte, 0, len(records))
+	for _, r := range records {
+		r.Instance = u.instance
+		r.UpdatedAt = now.Format(dorisDatetimeFormat)
+		line, err := json.Marshal(r)
+		if err != nil {
+			u.logger.Error("序列化用量失败", "error", err)
+			return
+		}
+		lines = append(lines, append(line, '\n'))
+	}
+	label := "usage-" + uuid.New().String()
+	for _, ch := range u.dc.WriteLinesSplit(context.Background(), u.table, label, lines, u.logger) {
+		if ch.Err == nil || errors.Is(ch.Err, dorisload.ErrLabelAlreadyExists) {
+			continue
+		}
+		u.logger.Error("写入用量表失败，稍后重试", "table", u.table.Name, "rows", ch.End-ch.Start, "error", ch.Err)
+		u.mu.Lock()
+		for _, r := range records[ch.Start:ch.End] {
+			u.dirty[usageKey{Date: r.Date, Principal: r.Principal, Endpoint: r.Endpoint}] = true
+		}
+		u.mu.Unlock()
+	}
+}
+
+// recordsLocked 返回满足条件的用量，按日期、调用方和端点排序
+func (u *UsageMeter) recordsLocked(match func(usageKey) bool) []UsageRecord {
+	var records []UsageRecord
+	for key, counters := range u.days {
+		if match(key) {
+			records = append(records, UsageRecord{Date: key.Date, Principal: key.Principal, Endpoint: key.Endpoint, usageCounters: *counters})
+		}
+	}
+	slices.SortFunc(records, func(a, b UsageRecord) int {
+		return strings.Compare(a.Date+"\x00"+a.Principal+"\x00"+a.Endpoint, b.Date+"\x00"+b.Principal+"\x00"+b.Endpoint)
+	})
+	return records
+}
+
+// Close 停止定期持久化并写入最后一次用量
+func (u *UsageMeter) Close() {
+	close(u.done)
+	<-u.exit
+}
+
+// usageTotal 一个调用方在查询范围内的合计用量
+type usageTotal struct {
+	Principal string `json:"principal"`
+	usageCounters
+}
+
+// usageHandler GET /admin/usage?from=YYYY-MM-DD&to=YYYY-MM-DD&principal=&endpoint=：
+// 返回本实例按天、调用方和端点的用量及按调用方的合计，默认为最近 30 天
+func (app *App) usageHandler(c *gin.Context) {
+	now := time.Now()
+	from := c.DefaultQuery("from", now.AddDate(0, 0, -29).Format(dorisDateFormat))
+	to := c.DefaultQuery("to", now.Format(dorisDateFormat))
+	for _, d := range []string{from, to} {
+		if _, err := time.Parse(dorisDateFormat, d); err != nil {
+			abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid from/to: must be YYYY-MM-DD", gin.H{"value": d})
+			return
+		}
+	}
+	principal, principalSet := c.GetQuery("principal")
+	endpoint := c.Query("endpoint")
+
+	app.usage.mu.Lock()
+	records := app.usage.recordsLocked(func(key usageKey) bool {
+		return key.Date >= from && key.Date <= to &&
+			(!principalSet || key.Principal == principal) &&
+			(endpoint == "" || key.Endpoint == endpoint)
+	})
+	app.usage.mu.Unlock()
+
+	var totals []usageTotal
+	for _, r := range records {
+		i := slices.IndexFunc(totals, func(t usageTotal) bool { return t.Principal == r.Principal })
+		if i < 0 {
+			totals = append(totals, usageTotal{Principal: r.Principal})
+			i = len(totals) - 1
+		}
+		t := &totals[i].usageCounters
+		t.Requests += r.Requests
+		t.Rows += r.Rows
+		t.Bytes += r.Bytes
+		t.Errors += r.Errors
+	}
+	slices.SortFunc(totals, func(a, b usageTotal) int { return strings.Compare(a.Principal, b.Principal) })
+	if records == nil {
+		records = []UsageRecord{}
+	}
+	if totals == nil {
+		totals = []usageTotal{}
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "usage": records, "totals": totals})
+}
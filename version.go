@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 构建信息，构建时通过 -ldflags 注入：
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildInfo 服务的版本、提交和构建时间，用于将行为变化与发布对应
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// buildInfo 返回构建信息，未通过 -ldflags 注入提交和构建时间时使用 Go 工具链记录的 VCS 信息
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = s.Value
+				}
+			}
+		}
+	}
+	info.Commit = defaultString(info.Commit, "unknown")
+	info.BuildDate = defaultString(info.BuildDate, "unknown")
+	return info
+}
+
+// versionHandler 返回构建信息
+func (app *App) versionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, buildInfo())
+}
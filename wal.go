@@ -0,0 +1,968 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"doris-webhook/dorisload"
+)
+
+const (
+	walOpenSuffix       = ".open"    // 正在写入的段
+	walSealedSuffix     = ".seg"     // 已封存、等待回放的段
+	walCheckpointSuffix = ".ckpt"    // 段的回放检查点：已提交的字节偏移
+	walRetrySuffix      = ".retry"   // 段的重新投递计划：失败次数和下次回放时间
+	walPartialSuffix    = ".partial" // 启动时从段末尾截掉的不完整的行，保存在 dlq 中
+	walDLQDir           = "dlq"      // 超过 WAL_RETRY_MAX_AGE 仍未回放成功或启动时发现损坏的段移入该子目录，等待人工处理
+
+	walSealRetryDelay = 10 * time.Second // 段暂时无法封存或上传时，下次尝试前等待的时间
+)
+
+// WAL_FSYNC 的取值：写入 WAL 的事件同步到磁盘的时机，用延迟换取持久性
+const (
+	walFsyncEvent = "event" // 每个事件单独写入并同步
+	walFsyncBatch = "batch" // 每次写入（一个请求或批次的全部事件）后同步
+	walFsyncNone  = "none"  // 由操作系统缓冲，段封存时同步
+)
+
+// walFsyncTradeoffs 各持久性级别的取舍，启动时随 WAL 配置输出
+var walFsyncTradeoffs = map[string]string{
+	walFsyncEvent: "每个事件写入后 fsync：返回成功的事件在主机崩溃或断电后不丢失，延迟和磁盘 IOPS 开销最高",
+	walFsyncBatch: "每次写入后 fsync：返回成功的事件在主机崩溃或断电后不丢失，每个请求或批次一次 fsync",
+	walFsyncNone:  "由操作系统缓冲、段封存时 fsync：进程崩溃不丢失，主机崩溃或断电可能丢失未封存段中的事件（最多 WAL_SEGMENT_MAX_AGE），延迟最低",
+}
+
+// WAL 预写日志
+// 事件以 NDJSON 按目标表追加写入分段文件（文件名为 {表名}-{纳秒时间戳}），段封存后由后台回放到 Doris。
+// 段、检查点和重新投递计划保存在本地目录（WAL_DIR）、S3 兼容的对象存储（WAL_S3_BUCKET，见 walS3Store）
+// 或嵌入式 SQLite 数据库（WAL_SQLITE_PATH，见 walSQLiteStore）中。
+// 段按行对齐分片回放，每个分片的 label 由段文件名和起始偏移派生（wal-{段名}-{偏移}），
+// 分片提交后写入检查点；进程在提交和写入检查点之间崩溃时，重启后以相同 label 重放该分片，由 Doris 去重
+type WAL struct {
+	store       walStore
+	dir         string // 本地 WAL 目录（SQLite 为数据库所在的目录），使用对象存储时为空
+	maxBytes    int64
+	maxAge      time.Duration
+	replayBytes int             // 回放时单个分片的最大字节数
+	retryDelays []time.Duration // 回放失败后依次等待的时间，超出后重复最后一个；为空时下一轮立即重试
+	retryMaxAge time.Duration   // 首次失败后超过该时间仍未成功的段移入 dlq，为 0 时不移入
+	retention   walRetention    // 等待回放和 dlq 中的段的保留策略（WAL_RETENTION_*）
+	fsync       string          // 持久性级别（WAL_FSYNC）
+	logger      *slog.Logger
+	paused      func(table string) bool                   // 返回 true 的表暂不回放，为 nil 时全部回放
+	committed   func(table *dorisload.Table, data []byte) // 回放的分片提交后调用（被 Doris 去重的分片除外），为 nil 时不调用
+	keys        *Keyring                                  // 段内容的加密密钥，未配置 ENCRYPTION_KEYS 时为 nil（明文）
+
+	mu       sync.Mutex
+	segments map[string]*walSegment // 按表名索引的正在写入的段
+
+	// 积压的字节数和段数：每次检查保留策略时重新统计，之间按写入累加，用于 reject-newest
+	retainedBytes    int64
+	retainedSegments int
+
+	replayMu sync.Mutex // 回放一个段期间持有，清理和重新回放等待其完成
+
+	progressMu sync.Mutex
+	progress   WALReplayStatus
+	retries    map[string]*walRetry // 按段名索引的重新投递计划，由 progressMu 保护
+
+	retentionStats WALRetentionStats // 由 progressMu 保护
+	recovery       WALRecoveryStats  // 启动恢复的结果，由 progressMu 保护
+}
+
+// walRetry 段的重新投递计划，持久化在 {段名}.retry 中，重启后沿用
+type walRetry struct {
+	Attempts     int       `json:"attempts"`
+	FirstFailure time.Time `json:"first_failure"`
+	NextAt       time.Time `json:"next_at"`
+	LastError    string    `json:"last_error"`
+}
+
+func (r walRetry) encode() []byte {
+	raw, _ := json.Marshal(r)
+	return raw
+}
+
+// WALReplayStatus WAL 回放进度，通过 /admin/stats 查看
+type WALReplayStatus struct {
+	Segment          string `json:"segment,omitempty"` // 正在回放的段，空闲时为空
+	Offset           int64  `json:"offset"`            // 当前段已提交的字节偏移（检查点）
+	Size             int64  `json:"size"`              // 当前段的字节数
+	ReplayedSegments int64  `json:"replayed_segments"` // 启动以来回放完成的段数
+	ReplayedChunks   int64  `json:"replayed_chunks"`   // 启动以来提交的分片数
+	ReplayedBytes    int64  `json:"replayed_bytes"`
+	LastError        string `json:"last_error,omitempty"`
+	Scheduled        int    `json:"scheduled_segments"`     // 回放失败、等待重新投递的段数
+	DeadLettered     int64  `json:"dead_lettered_segments"` // 启动以来移入 dlq 的段数
+}
+
+// walSegment 正在写入的段
+type walSegment struct {
+	file    walWriter
+	name    string
+	size    int64
+	opened  time.Time
+	retryAt time.Time // 暂时无法封存时，此前不再尝试（关闭时除外）
+}
+
+// walFile 存储中的一个段、检查点或重新投递计划
+type walFile struct {
+	name string // 段名，不含后缀
+	size int64
+}
+
+// walStore WAL 的存储，文件按 {段名}{后缀} 命名；本地目录为 walDirStore，S3 兼容的对象存储为 walS3Store，SQLite 为 walSQLiteStore
+type walStore interface {
+	// open 准备存储（创建目录或检查访问权限）
+	open() error
+	// recover 封存上次运行遗留的未封存段，返回封存的段数
+	recover() (int, error)
+	// create 创建正在写入的段
+	create(name string) (walWriter, error)
+	// list 按段名顺序返回后缀为 suffix 的文件
+	list(suffix string) ([]walFile, error)
+	// read 读取文件，不存在时返回 fs.ErrNotExist
+	read(file string) ([]byte, error)
+	// write 原子地写入文件
+	write(file string, data []byte) error
+	// remove 删除文件，不存在时返回 fs.ErrNotExist 或 nil
+	remove(file string) error
+	// deadLetter 将文件移入 dlq，不存在时返回 fs.ErrNotExist
+	deadLetter(file string) error
+	// writeDeadLetter 在 dlq 中写入文件
+	writeDeadLetter(file string, data []byte) error
+	// listDeadLetter 按段名顺序返回 dlq 中后缀为 suffix 的文件
+	listDeadLetter(suffix string) ([]walFile, error)
+	// removeDeadLetter 删除 dlq 中的文件，不存在时返回 fs.ErrNotExist 或 nil
+	removeDeadLetter(file string) error
+	// sub 返回位于子目录（子前缀）中的存储
+	sub(name string) walStore
+}
+
+// walWriter 正在写入的段
+type walWriter interface {
+	io.Writer
+	// Sync 将已写入的内容持久化
+	Sync() error
+	// seal 封存段，之后不再写入；返回 errWALSealDeferred 时段保留，下次封存时重试
+	seal() error
+}
+
+// errWALSealDeferred 段暂时无法封存（如对象存储不可用），内容仍保留在写入方，稍后重试
+var errWALSealDeferred = errors.New("WAL 段暂时无法封存，稍后重试")
+
+// walDirStore 本地目录中的 WAL
+type walDirStore struct {
+	dir string
+}
+
+func (s walDirStore) path(file string) string {
+	return filepath.Join(s.dir, file)
+}
+
+func (s walDirStore) open() error {
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return fmt.Errorf("创建 WAL 目录失败: %w", err)
+	}
+	return nil
+}
+
+// recover 上次运行遗留的未封存段直接封存，等待回放
+func (s walDirStore) recover() (int, error) {
+	leftovers, err := filepath.Glob(filepath.Join(s.dir, "*"+walOpenSuffix))
+	if err != nil {
+		return 0, err
+	}
+	for _, path := range leftovers {
+		sealed := strings.TrimSuffix(path, walOpenSuffix) + walSealedSuffix
+		if err := os.Rename(path, sealed); err != nil {
+			return 0, fmt.Errorf("封存遗留 WAL 段失败: %w", err)
+		}
+	}
+	return len(leftovers), nil
+}
+
+func (s walDirStore) create(name string) (walWriter, error) {
+	f, err := os.OpenFile(s.path(name+walOpenSuffix), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	// 同步目录，新段的目录项在崩溃后仍然存在
+	if dir, err := os.Open(s.dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return &walFileWriter{File: f, sealed: s.path(name + walSealedSuffix)}, nil
+}
+
+func (s walDirStore) list(suffix string) ([]walFile, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*"+suffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	files := make([]walFile, 0, len(paths))
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			// 列出之后被回放完成并删除
+			continue
+		}
+		files = append(files, walFile{name: strings.TrimSuffix(filepath.Base(p), suffix), size: info.Size()})
+	}
+	return files, nil
+}
+
+func (s walDirStore) read(file string) ([]byte, error) {
+	return os.ReadFile(s.path(file))
+}
+
+func (s walDirStore) write(file string, data []byte) error {
+	return writeFileAtomic(s.path(file), data)
+}
+
+func (s walDirStore) remove(file string) error {
+	return os.Remove(s.path(file))
+}
+
+func (s walDirStore) deadLetter(file string) error {
+	dlq := filepath.Join(s.dir, walDLQDir)
+	if err := os.MkdirAll(dlq, 0o750); err != nil {
+		return fmt.Errorf("创建 WAL dlq 目录失败: %w", err)
+	}
+	return os.Rename(s.path(file), filepath.Join(dlq, file))
+}
+
+func (s walDirStore) writeDeadLetter(file string, data []byte) error {
+	dlq := filepath.Join(s.dir, walDLQDir)
+	if err := os.MkdirAll(dlq, 0o750); err != nil {
+		return fmt.Errorf("创建 WAL dlq 目录失败: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(dlq, file), data)
+}
+
+func (s walDirStore) listDeadLetter(suffix string) ([]walFile, error) {
+	return walDirStore{dir: filepath.Join(s.dir, walDLQDir)}.list(suffix)
+}
+
+func (s walDirStore) removeDeadLetter(file string) error {
+	return os.Remove(filepath.Join(s.dir, walDLQDir, file))
+}
+
+func (s walDirStore) sub(name string) walStore {
+	return walDirStore{dir: filepath.Join(s.dir, name)}
+}
+
+// walFileWriter 本地目录中正在写入的段
+type walFileWriter struct {
+	*os.File
+	sealed string // 封存后的路径
+}
+
+// seal 同步并关闭段，重命名为 .seg
+func (f *walFileWriter) seal() error {
+	if err := f.File.Sync(); err != nil {
+		f.File.Close()
+		return fmt.Errorf("同步 WAL 段失败: %w", err)
+	}
+	if err := f.File.Close(); err != nil {
+		return fmt.Errorf("关闭 WAL 段失败: %w", err)
+	}
+	return os.Rename(f.File.Name(), f.sealed)
+}
+
+// newWAL 根据环境变量创建 WAL，未设置 WAL_DIR、WAL_S3_BUCKET 和 WAL_SQLITE_PATH 时返回 nil
+func newWAL(logger *slog.Logger) (*WAL, error) {
+	w, err := loadWALConfig(logger)
+	if w == nil || err != nil {
+		return nil, err
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open 准备 WAL 存储并执行启动恢复：封存上次运行遗留的段，检查段的完整性（见 verify），清理孤立的检查点和重新投递计划
+func (w *WAL) open() error {
+	started := time.Now()
+	if err := w.store.open(); err != nil {
+		return err
+	}
+
+	var stats WALRecoveryStats
+	// 平滑升级时未封存段仍由旧进程写入和回放，旧进程退出时自行封存
+	if upgradeParentPID() == 0 {
+		n, err := w.store.recover()
+		if err != nil {
+			return err
+		}
+		stats.RecoveredSegments = n
+		if err := w.verify(&stats); err != nil {
+			return err
+		}
+		// 段回放完成后先删除段再删除检查点，两步之间崩溃会遗留检查点
+		if n, err = w.removeOrphans(walCheckpointSuffix); err != nil {
+			return err
+		}
+		stats.OrphanFiles += n
+	}
+	n, err := w.removeOrphans(walRetrySuffix)
+	if err != nil {
+		return err
+	}
+	stats.OrphanFiles += n
+	if err := w.loadRetries(); err != nil {
+		return err
+	}
+	stats.DurationMs = time.Since(started).Milliseconds()
+	w.recovered(stats)
+	return nil
+}
+
+// removeOrphans 删除段已不存在的检查点或重新投递计划，返回删除的文件数
+func (w *WAL) removeOrphans(suffix string) (int, error) {
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return 0, err
+	}
+	files, err := w.store.list(suffix)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, f := range files {
+		if !slices.ContainsFunc(segments, func(seg walFile) bool { return seg.name == f.name }) {
+			w.store.remove(f.name + suffix)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// loadRetries 读取上次运行留下的重新投递计划
+func (w *WAL) loadRetries() error {
+	files, err := w.store.list(walRetrySuffix)
+	if err != nil {
+		return err
+	}
+	w.progressMu.Lock()
+	defer w.progressMu.Unlock()
+	w.retries = make(map[string]*walRetry, len(files))
+	for _, f := range files {
+		raw, err := w.store.read(f.name + walRetrySuffix)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("读取 WAL 重新投递计划失败: %w", err)
+		}
+		var r walRetry
+		if err := json.Unmarshal(raw, &r); err != nil {
+			// 计划损坏时立即重试，失败次数从头计算
+			w.logger.Warn("WAL 重新投递计划无法解析，立即重试", "segment", f.name, "error", err)
+			continue
+		}
+		w.retries[f.name] = &r
+	}
+	return nil
+}
+
+// sub 返回使用相同段和回放配置、位于 WAL 子目录中的另一个 WAL，需调用 open 后使用
+func (w *WAL) sub(name string, logger *slog.Logger) *WAL {
+	sub := &WAL{
+		store:       w.store.sub(name),
+		maxBytes:    w.maxBytes,
+		maxAge:      w.maxAge,
+		replayBytes: w.replayBytes,
+		retryDelays: w.retryDelays,
+		retryMaxAge: w.retryMaxAge,
+		retention:   w.retention,
+		fsync:       w.fsync,
+		keys:        w.keys,
+		logger:      logger,
+		segments:    make(map[string]*walSegment),
+	}
+	if w.dir != "" {
+		sub.dir = filepath.Join(w.dir, name)
+	}
+	return sub
+}
+
+// storeKind 返回存储的类型（dir、s3 或 sqlite），用于输出配置
+func (w *WAL) storeKind() string {
+	switch w.store.(type) {
+	case *walS3Store:
+		return "s3"
+	case *walSQLiteStore:
+		return "sqlite"
+	}
+	return "dir"
+}
+
+// loadWALConfig 读取 WAL_* 环境变量，不访问 WAL 目录、对象存储或数据库；未设置 WAL_DIR、WAL_S3_BUCKET 和 WAL_SQLITE_PATH 时返回 nil
+func loadWALConfig(logger *slog.Logger) (*WAL, error) {
+	dir := getEnv("WAL_DIR", "")
+	var store walStore = walDirStore{dir: dir}
+	s3, err := loadWALS3Config(logger)
+	if err != nil {
+		return nil, err
+	}
+	db, err := loadWALSQLiteConfig()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case dir != "" && s3 != nil, dir != "" && db != nil, s3 != nil && db != nil:
+		return nil, fmt.Errorf("WAL_DIR、WAL_S3_BUCKET 和 WAL_SQLITE_PATH 只能设置一个")
+	case s3 != nil:
+		store = s3
+	case db != nil:
+		// 请求日志的幂等键保存在数据库所在的目录
+		store, dir = db, db.dir
+	case dir == "":
+		return nil, nil
+	}
+	maxBytes, err := envByteSize("WAL_SEGMENT_MAX_BYTES", "8MB", 1, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	maxAge, err := parsePositiveSeconds("WAL_SEGMENT_MAX_AGE", "10")
+	if err != nil {
+		return nil, err
+	}
+	replayBytes, err := envByteSize("WAL_REPLAY_CHUNK_BYTES", "1MB", 1, 1, 1<<30)
+	if err != nil {
+		return nil, err
+	}
+	var retryDelays []time.Duration
+	for _, s := range splitList(getEnv("WAL_RETRY_DELAYS", "")) {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("WAL_RETRY_DELAYS 无效: %q", s)
+		}
+		retryDelays = append(retryDelays, d)
+	}
+	retryMaxAge, err := envDuration("WAL_RETRY_MAX_AGE", "0", time.Second, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	retention, err := loadWALRetention()
+	if err != nil {
+		return nil, err
+	}
+	fsync := getEnv("WAL_FSYNC", walFsyncNone)
+	if _, ok := walFsyncTradeoffs[fsync]; !ok {
+		return nil, fmt.Errorf("WAL_FSYNC 无效: %q（可选 event、batch、none）", fsync)
+	}
+	if s3 != nil && fsync != walFsyncNone {
+		return nil, fmt.Errorf("WAL_FSYNC=%s 不支持对象存储 WAL：正在写入的段只在内存中，封存时才上传", fsync)
+	}
+	keys, err := newKeyring()
+	if err != nil {
+		return nil, err
+	}
+	return &WAL{
+		store:       store,
+		dir:         dir,
+		maxBytes:    maxBytes,
+		maxAge:      maxAge,
+		replayBytes: int(replayBytes),
+		retryDelays: retryDelays,
+		retryMaxAge: retryMaxAge,
+		retention:   retention,
+		fsync:       fsync,
+		keys:        keys,
+		logger:      logger,
+		segments:    make(map[string]*walSegment),
+	}, nil
+}
+
+// Append 向目标表的当前段追加一行 NDJSON（需以换行符结尾），配置了加密密钥时逐行加密后写入；按 WAL_FSYNC 同步到磁盘
+func (w *WAL) Append(table string, line []byte) error {
+	return w.append(table, line, w.fsync)
+}
+
+// AppendSync 同 Append，返回前至少将段同步到磁盘一次（WAL_FSYNC=none 时按 batch），
+// 用于请求日志：返回成功后主机崩溃也不会丢失
+func (w *WAL) AppendSync(table string, line []byte) error {
+	fsync := w.fsync
+	if fsync == walFsyncNone {
+		fsync = walFsyncBatch
+	}
+	return w.append(table, line, fsync)
+}
+
+// append 按持久性级别追加：event 逐行写入并同步，batch 整体写入后同步一次，none 不同步
+func (w *WAL) append(table string, data []byte, fsync string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if fsync != walFsyncEvent {
+		return w.appendSyncedLocked(table, data, fsync == walFsyncBatch)
+	}
+	for _, line := range dorisload.SplitNDJSON(data) {
+		if err := w.appendSyncedLocked(table, line, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendSyncedLocked 追加数据，sync 为 true 时同步到磁盘，调用方需持有锁
+func (w *WAL) appendSyncedLocked(table string, data []byte, sync bool) error {
+	seg, err := w.appendLocked(table, data)
+	if err != nil || seg == nil || !sync {
+		// 段已写满并封存时，封存时已同步
+		return err
+	}
+	if err := seg.file.Sync(); err != nil {
+		return fmt.Errorf("同步 WAL 段失败: %w", err)
+	}
+	return nil
+}
+
+// appendLocked 向目标表的当前段追加一行，段写满时封存并返回 nil 段，调用方需持有锁
+// 保留策略为 reject-newest 且积压已达到上限时返回 ErrWALFull
+func (w *WAL) appendLocked(table string, line []byte) (*walSegment, error) {
+	seg := w.segments[table]
+	if w.rejectLocked(int64(len(line)), seg == nil, countLines(line)) {
+		return nil, ErrWALFull
+	}
+	if seg == nil {
+		name := fmt.Sprintf("%s-%019d", table, time.Now().UnixNano())
+		f, err := w.store.create(name)
+		if err != nil {
+			return nil, fmt.Errorf("创建 WAL 段失败: %w", err)
+		}
+		seg = &walSegment{file: f, name: name, opened: time.Now()}
+		w.segments[table] = seg
+		w.retainedSegments++
+	}
+
+	n, err := seg.file.Write(w.keys.EncryptLines(line))
+	seg.size += int64(n)
+	w.retainedBytes += int64(n)
+	if err != nil {
+		return nil, fmt.Errorf("写入 WAL 失败: %w", err)
+	}
+	if seg.size >= w.maxBytes && !time.Now().Before(seg.retryAt) {
+		if err := w.sealLocked(table); err != nil && !errors.Is(err, errWALSealDeferred) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return seg, nil
+}
+
+// sealLocked 封存目标表的当前段，调用方需持有锁；暂时无法封存的段继续写入，下次封存时重试
+func (w *WAL) sealLocked(table string) error {
+	seg := w.segments[table]
+	if seg == nil {
+		return nil
+	}
+	err := seg.file.seal()
+	if errors.Is(err, errWALSealDeferred) {
+		seg.retryAt = time.Now().Add(walSealRetryDelay)
+		return err
+	}
+	delete(w.segments, table)
+	return err
+}
+
+// sealIfAged 封存已超过最大存活时间的段
+func (w *WAL) sealIfAged() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	for table, seg := range w.segments {
+		if time.Since(seg.opened) >= w.maxAge && !time.Now().Before(seg.retryAt) {
+			errs = append(errs, w.sealLocked(table))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sealedSegments 按时间顺序返回已封存的段
+func (w *WAL) sealedSegments() ([]walFile, error) {
+	return w.store.list(walSealedSuffix)
+}
+
+// Pending 返回等待回放的段数和字节数（含正在写入的段，不含已提交的部分）
+func (w *WAL) Pending() (segments int, bytes int64) {
+	sealed, _ := w.sealedSegments()
+	for _, seg := range sealed {
+		segments++
+		bytes += seg.size - min(w.readCheckpoint(seg.name), seg.size)
+	}
+	w.mu.Lock()
+	for _, seg := range w.segments {
+		segments++
+		bytes += seg.size
+	}
+	w.mu.Unlock()
+	return segments, bytes
+}
+
+// PendingTable 返回目标表等待回放的段数（含正在写入的段）
+func (w *WAL) PendingTable(table string) int {
+	sealed, _ := w.sealedSegments()
+	n := 0
+	for _, seg := range sealed {
+		if walSegmentTable(seg.name) == table {
+			n++
+		}
+	}
+	w.mu.Lock()
+	if w.segments[table] != nil {
+		n++
+	}
+	w.mu.Unlock()
+	return n
+}
+
+// Run 定期封存超时的段并回放已封存的段，直到 ctx 取消；回放失败的段按 WAL_RETRY_DELAYS 推迟到计划时间再回放；
+// 每隔 walRetentionInterval 按 WAL_RETENTION_* 删除过期的段。段的目标表从注册表中查找，clients 返回目标表所属集群的客户端；acquire 用于申请写入容量，返回 false 时本轮跳过回放（例如 Doris 处于背压状态）
+func (w *WAL) Run(ctx context.Context, clients func(*dorisload.Table) *dorisload.Client, registry *Registry, acquire func(context.Context) (func(), bool)) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var retained time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := w.sealIfAged(); err != nil {
+			w.logger.Error("封存 WAL 段失败", "error", err)
+		}
+		if time.Since(retained) >= walRetentionInterval {
+			w.enforceRetention()
+			retained = time.Now()
+		}
+
+		segments, err := w.sealedSegments()
+		if err != nil {
+			w.logger.Error("读取 WAL 目录失败", "error", err)
+			continue
+		}
+		// 回放失败的表本轮不再回放其后的段，保持表内顺序；其他表的段照常回放，一个持续失败的段不会阻塞其他表
+		failed := make(map[string]bool)
+	pass:
+		for _, seg := range segments {
+			if ctx.Err() != nil {
+				return
+			}
+			table := walSegmentTable(seg.name)
+			if failed[table] || !w.due(seg.name, time.Now()) {
+				continue
+			}
+			switch w.replay(ctx, clients, registry, acquire, seg.name) {
+			case replayFailed:
+				failed[table] = true
+			case replayStop:
+				break pass
+			}
+		}
+	}
+}
+
+// walReplayResult 单个段的回放结果
+type walReplayResult int
+
+const (
+	replayDone   walReplayResult = iota // 段已回放完成，或本轮跳过（表已暂停、冷却中等）
+	replayFailed                        // 读取或写入失败，段从检查点稍后重试
+	replayStop                          // 无法申请写入容量（背压）或 ctx 已取消，停止本轮回放
+)
+
+// replay 从检查点开始按分片回放单个段，全部提交后删除段和检查点
+func (w *WAL) replay(ctx context.Context, clients func(*dorisload.Table) *dorisload.Client, registry *Registry, acquire func(context.Context) (func(), bool), name string) walReplayResult {
+	table, ok := registry.Table(walSegmentTable(name))
+	if !ok {
+		// 目标表已从配置中移除，保留段文件以便人工处理
+		w.logger.Warn("WAL 段的目标表未注册，跳过回放", "segment", name)
+		return replayDone
+	}
+	if w.paused != nil && w.paused(table.Name) {
+		return replayDone
+	}
+	// tablet 版本数过多时回放只会加重 compaction 压力，冷却结束后再回放
+	if clients(table).CoolingDown(table.Name) {
+		return replayDone
+	}
+
+	release, ok := acquire(ctx)
+	if !ok {
+		return replayStop
+	}
+	defer release()
+	w.replayMu.Lock()
+	defer w.replayMu.Unlock()
+
+	data, err := w.store.read(name + walSealedSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		// 平滑升级或多个副本共用对象存储前缀时，段已被另一进程回放完成
+		return replayDone
+	}
+	if err != nil {
+		w.logger.Error("读取 WAL 段失败", "segment", name, "error", err)
+		return replayFailed
+	}
+	offset := w.readCheckpoint(name)
+	if offset > int64(len(data)) {
+		w.logger.Warn("WAL 检查点超出段大小，从头回放", "segment", name, "offset", offset, "size", len(data))
+		offset = 0
+	}
+	if offset > 0 {
+		w.logger.Info("从检查点继续回放 WAL 段", "segment", name, "offset", offset, "size", len(data))
+	}
+	w.setReplay(func(r *WALReplayStatus) {
+		r.Segment, r.Offset, r.Size = name, offset, int64(len(data))
+	})
+	defer w.setReplay(func(r *WALReplayStatus) { r.Segment, r.Offset, r.Size = "", 0, 0 })
+
+	for offset < int64(len(data)) {
+		end := walChunkEnd(data, int(offset), w.replayBytes)
+		// 超过单次 Stream Load 上限的分片继续拆分写入，各子分片 label 固定，重试时已提交的部分会被去重
+		label := fmt.Sprintf("wal-%s-%d", name, offset)
+		plain, err := w.keys.DecryptLines(data[offset:end])
+		if err != nil {
+			// 缺少轮换前的密钥或段已损坏，其余段照常回放
+			w.logger.Error("解密 WAL 段失败", "segment", name, "offset", offset, "error", err)
+			w.setReplay(func(r *WALReplayStatus) { r.LastError = fmt.Sprintf("%s: %v", label, err) })
+			w.scheduleRetry(name, err)
+			return replayDone
+		}
+		// 幂等键的标记行只用于重启后恢复请求日志的键，不写入 Doris
+		lines := slices.DeleteFunc(dorisload.SplitNDJSON(plain), isJournalMarker)
+		for _, ch := range clients(table).WriteLinesSplit(ctx, table, label, lines, w.logger) {
+			if ch.Err != nil && !errors.Is(ch.Err, dorisload.ErrLabelAlreadyExists) {
+				w.logger.Warn("WAL 段回放失败，稍后从检查点重试", "segment", name, "label", ch.Label, "offset", offset, "error", ch.Err)
+				w.setReplay(func(r *WALReplayStatus) { r.LastError = fmt.Sprintf("%s: %v", ch.Label, ch.Err) })
+				if ctx.Err() != nil {
+					return replayStop
+				}
+				w.scheduleRetry(name, ch.Err)
+				return replayFailed
+			}
+			if ch.Err == nil && w.committed != nil {
+				w.committed(table, dorisload.JoinLines(lines[ch.Start:ch.End]))
+			}
+		}
+		if err := w.writeCheckpoint(name, int64(end)); err != nil {
+			// 分片已提交，下次以相同 label 重放时由 Doris 去重
+			w.logger.Error("写入 WAL 检查点失败", "segment", name, "offset", end, "error", err)
+			return replayFailed
+		}
+		chunk := int64(end) - offset
+		offset = int64(end)
+		w.setReplay(func(r *WALReplayStatus) {
+			r.Offset = offset
+			r.ReplayedChunks++
+			r.ReplayedBytes += chunk
+		})
+	}
+
+	// 平滑升级期间新旧进程可能同时回放同一段，段已被另一进程删除时同样视为完成
+	if err := w.store.remove(name + walSealedSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		w.logger.Error("删除已回放的 WAL 段失败", "segment", name, "error", err)
+		return replayFailed
+	}
+	if err := w.store.remove(name + walCheckpointSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		w.logger.Warn("删除 WAL 检查点失败", "name", name, "error", err)
+	}
+	w.clearRetry(name)
+	w.setReplay(func(r *WALReplayStatus) {
+		r.ReplayedSegments++
+		r.LastError = ""
+	})
+	w.logger.Info("WAL 段回放完成", "segment", name, "bytes", len(data))
+	return replayDone
+}
+
+// due 判断段是否到了计划的回放时间，没有重新投递计划的段总是可以回放
+func (w *WAL) due(name string, now time.Time) bool {
+	w.progressMu.Lock()
+	defer w.progressMu.Unlock()
+	r := w.retries[name]
+	return r == nil || !now.Before(r.NextAt)
+}
+
+// scheduleRetry 记录段的一次回放失败：按 WAL_RETRY_DELAYS 计划下次回放时间，首次失败超过 WAL_RETRY_MAX_AGE 的段移入 dlq
+// 设置了 WAL_RETRY_MAX_AGE 时，schema 类失败（数据本身不符合表结构，重试不会成功）的段直接移入 dlq
+// 未配置两者时不记录计划，下一轮立即重试
+func (w *WAL) scheduleRetry(name string, cause error) {
+	if len(w.retryDelays) == 0 && w.retryMaxAge == 0 {
+		return
+	}
+	now := time.Now()
+	w.progressMu.Lock()
+	r := w.retries[name]
+	if r == nil {
+		r = &walRetry{FirstFailure: now}
+	}
+	r.Attempts++
+	r.LastError = cause.Error()
+	if len(w.retryDelays) > 0 {
+		r.NextAt = now.Add(w.retryDelays[min(r.Attempts, len(w.retryDelays))-1])
+	} else {
+		r.NextAt = now
+	}
+	next := *r
+	w.retries[name] = r
+	w.progressMu.Unlock()
+
+	if w.retryMaxAge > 0 && (now.Sub(next.FirstFailure) >= w.retryMaxAge || dorisload.ClassOf(cause) == dorisload.FailureSchema) {
+		w.deadLetter(name, next)
+		return
+	}
+	if err := w.store.write(name+walRetrySuffix, next.encode()); err != nil {
+		// 计划只保存在内存中，重启后立即重试
+		w.logger.Warn("写入 WAL 重新投递计划失败", "segment", name, "error", err)
+	}
+	if len(w.retryDelays) > 0 {
+		w.logger.Info("WAL 段计划重新投递", "segment", name, "attempts", next.Attempts, "next_at", next.NextAt)
+	}
+}
+
+// deadLetter 将段及其检查点和重新投递计划移入 dlq，不再回放
+func (w *WAL) deadLetter(name string, r walRetry) {
+	if err := w.moveDeadLetter(name, r); err != nil {
+		w.logger.Error("移动 WAL 段到 dlq 失败，段继续重试", "segment", name, "error", err)
+		return
+	}
+	w.progressMu.Lock()
+	delete(w.retries, name)
+	w.progress.DeadLettered++
+	w.progressMu.Unlock()
+	w.logger.Error("WAL 段回放失败，已移入 dlq", "segment", name, "attempts", r.Attempts, "first_failure", r.FirstFailure, "error", r.LastError)
+}
+
+// moveDeadLetter 将段及其检查点移入 dlq，并在 dlq 中写入重新投递计划（记录失败原因）
+func (w *WAL) moveDeadLetter(name string, r walRetry) error {
+	if err := w.store.deadLetter(name + walSealedSuffix); err != nil {
+		return err
+	}
+	// 检查点和计划随段一起移动，便于人工处理时知道已提交的偏移和失败原因
+	if err := w.store.deadLetter(name + walCheckpointSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		w.logger.Warn("移动 WAL 检查点到 dlq 失败", "segment", name, "error", err)
+	}
+	if err := w.store.writeDeadLetter(name+walRetrySuffix, r.encode()); err != nil {
+		w.logger.Warn("写入 dlq 中的重新投递计划失败", "segment", name, "error", err)
+	}
+	w.store.remove(name + walRetrySuffix)
+	return nil
+}
+
+// clearRetry 删除回放完成的段的重新投递计划
+func (w *WAL) clearRetry(name string) {
+	w.progressMu.Lock()
+	_, ok := w.retries[name]
+	delete(w.retries, name)
+	w.progressMu.Unlock()
+	if ok {
+		w.store.remove(name + walRetrySuffix)
+	}
+}
+
+// walSegmentTable 返回段的目标表名
+func walSegmentTable(name string) string {
+	return name[:max(strings.LastIndex(name, "-"), 0)]
+}
+
+// walChunkEnd 返回从 offset 开始的回放分片的结束位置：不超过 maxBytes 的最后一个行尾，
+// 单行超过 maxBytes 时到该行末尾。相同的段和偏移总是得到相同的分片，重放时 label 一致
+func walChunkEnd(data []byte, offset, maxBytes int) int {
+	if len(data)-offset <= maxBytes {
+		return len(data)
+	}
+	if i := bytes.LastIndexByte(data[offset:offset+maxBytes], '\n'); i >= 0 {
+		return offset + i + 1
+	}
+	if i := bytes.IndexByte(data[offset+maxBytes:], '\n'); i >= 0 {
+		return offset + maxBytes + i + 1
+	}
+	return len(data)
+}
+
+// readCheckpoint 读取段已提交的字节偏移，没有检查点或无法解析时返回 0
+func (w *WAL) readCheckpoint(name string) int64 {
+	raw, err := w.store.read(name + walCheckpointSuffix)
+	if err != nil {
+		return 0
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// writeCheckpoint 原子地写入检查点
+func (w *WAL) writeCheckpoint(name string, offset int64) error {
+	return w.store.write(name+walCheckpointSuffix, []byte(strconv.FormatInt(offset, 10)))
+}
+
+// writeFileAtomic 写入临时文件并同步后重命名，读取方不会看到写了一半的内容
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// setReplay 更新回放进度
+func (w *WAL) setReplay(update func(*WALReplayStatus)) {
+	w.progressMu.Lock()
+	update(&w.progress)
+	w.progressMu.Unlock()
+}
+
+// ReplayStatus 返回回放进度
+func (w *WAL) ReplayStatus() WALReplayStatus {
+	w.progressMu.Lock()
+	defer w.progressMu.Unlock()
+	status := w.progress
+	status.Scheduled = len(w.retries)
+	return status
+}
+
+// Close 封存所有正在写入的段
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	for table := range w.segments {
+		errs = append(errs, w.sealLocked(table))
+	}
+	return errors.Join(errs...)
+}
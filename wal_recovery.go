@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WALRecoveryStats 启动恢复的结果，通过 /admin/stats 查看
+type WALRecoveryStats struct {
+	Segments            int   `json:"segments"`           // 恢复后等待回放的段数
+	Bytes               int64 `json:"bytes"`              // 恢复后等待回放的段的字节数（含已提交的部分）
+	RecoveredSegments   int   `json:"recovered_segments"` // 上次运行遗留的未封存段，已封存
+	ResumedSegments     int   `json:"resumed_segments"`   // 有检查点、从检查点继续回放的段
+	ResetCheckpoints    int   `json:"reset_checkpoints"`  // 无法解析、超出段大小或不在行边界上的检查点，已删除（从头回放，已提交的分片由 Doris 去重）
+	TruncatedSegments   int   `json:"truncated_segments"` // 末尾有不完整的行（写入时崩溃）、已截断的段
+	TruncatedBytes      int64 `json:"truncated_bytes"`
+	QuarantinedSegments int   `json:"quarantined_segments"` // 内容损坏、已移入 dlq 的段
+	OrphanFiles         int   `json:"orphan_files"`         // 段已不存在、已删除的检查点和重新投递计划
+	DurationMs          int64 `json:"duration_ms"`
+}
+
+// verify 检查已封存的段：删除无效的检查点；本地目录中的段还检查内容，
+// 截掉末尾不完整的行（保存到 dlq 中的 {段名}.partial），检查点之后有损坏的行的段整段移入 dlq。
+// 对象存储和 SQLite 中的段在封存时整体写入，不会截断，只检查检查点
+func (w *WAL) verify(stats *WALRecoveryStats) error {
+	segments, err := w.sealedSegments()
+	if err != nil {
+		return fmt.Errorf("读取 WAL 段失败: %w", err)
+	}
+	_, local := w.store.(walDirStore)
+	for _, seg := range segments {
+		offset, valid := w.verifyCheckpoint(seg.name)
+		if !local {
+			if !valid || offset > seg.size {
+				w.resetCheckpoint(seg.name, offset, stats)
+				offset = 0
+			}
+			stats.Segments++
+			stats.Bytes += seg.size
+			if offset > 0 {
+				stats.ResumedSegments++
+			}
+			continue
+		}
+
+		data, err := w.store.read(seg.name + walSealedSuffix)
+		if err != nil {
+			// 段无法读取时保留，回放时按计划重试
+			w.logger.Error("读取 WAL 段失败，跳过完整性检查", "segment", seg.name, "error", err)
+			stats.Segments++
+			stats.Bytes += seg.size
+			continue
+		}
+
+		// 写入时崩溃会留下不完整的行，部分文件系统还会在末尾留下全零的数据块
+		if end := bytes.LastIndexByte(bytes.TrimRight(data, "\x00"), '\n') + 1; end < len(data) {
+			if !w.truncate(seg.name, data, end, stats) {
+				stats.Segments++
+				stats.Bytes += int64(len(data))
+				continue
+			}
+			data = data[:end]
+			if end == 0 {
+				continue
+			}
+		}
+
+		if !valid || offset > int64(len(data)) || (offset > 0 && data[offset-1] != '\n') {
+			w.resetCheckpoint(seg.name, offset, stats)
+			offset = 0
+		}
+		if at, err := w.corruptLine(data, offset); err != nil {
+			w.quarantine(seg.name, at, err, stats)
+			continue
+		}
+		stats.Segments++
+		stats.Bytes += int64(len(data))
+		if offset > 0 {
+			stats.ResumedSegments++
+		}
+	}
+	return nil
+}
+
+// verifyCheckpoint 读取段的检查点，检查点存在但无法解析时 valid 为 false
+func (w *WAL) verifyCheckpoint(name string) (offset int64, valid bool) {
+	raw, err := w.store.read(name + walCheckpointSuffix)
+	if err != nil {
+		return 0, true
+	}
+	offset, err = strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+	return offset, err == nil && offset >= 0
+}
+
+// resetCheckpoint 删除无效的检查点，段从头回放
+func (w *WAL) resetCheckpoint(name string, offset int64, stats *WALRecoveryStats) {
+	if err := w.store.remove(name + walCheckpointSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		w.logger.Error("删除无效的 WAL 检查点失败", "segment", name, "error", err)
+		return
+	}
+	stats.ResetCheckpoints++
+	w.logger.Warn("WAL 检查点无效，从头回放", "segment", name, "offset", offset)
+}
+
+// truncate 截掉段末尾从 end 开始的不完整的行，截掉的内容保存到 dlq；没有完整的行时删除段。返回是否已截断
+func (w *WAL) truncate(name string, data []byte, end int, stats *WALRecoveryStats) bool {
+	if err := w.store.writeDeadLetter(name+walPartialSuffix, data[end:]); err != nil {
+		w.logger.Error("保存 WAL 段末尾不完整的行失败，段保持不变", "segment", name, "error", err)
+		return false
+	}
+	var err error
+	if end == 0 {
+		err = w.store.remove(name + walSealedSuffix)
+		w.store.remove(name + walCheckpointSuffix)
+	} else {
+		err = w.store.write(name+walSealedSuffix, data[:end])
+	}
+	if err != nil {
+		w.logger.Error("截断 WAL 段失败，段保持不变", "segment", name, "error", err)
+		return false
+	}
+	stats.TruncatedSegments++
+	stats.TruncatedBytes += int64(len(data) - end)
+	w.logger.Warn("WAL 段末尾有不完整的行，已截断", "segment", name, "size", len(data), "truncated_bytes", len(data)-end)
+	return true
+}
+
+// corruptLine 检查 data 中从 offset 开始的每一行，返回第一个损坏的行的偏移和原因：明文行不是合法的 JSON，或加密行无法解密。
+// 缺少密钥的加密行无法检查，视为完好（回放时按计划重试）；检查点之前的行已提交，不检查
+func (w *WAL) corruptLine(data []byte, offset int64) (int64, error) {
+	for offset < int64(len(data)) {
+		line := data[offset:]
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line = line[:i]
+		}
+		at := offset
+		offset += int64(len(line)) + 1
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if bytes.HasPrefix(line, []byte(encryptedLinePrefix)) {
+			plain, err := w.keys.decryptLine(line)
+			if errors.Is(err, ErrNoEncryptionKey) {
+				continue
+			}
+			if err != nil {
+				return at, err
+			}
+			line = plain
+		}
+		if !json.Valid(line) {
+			return at, errors.New("不是合法的 JSON")
+		}
+	}
+	return 0, nil
+}
+
+// quarantine 将内容损坏的段移入 dlq，dlq 中的重新投递计划记录损坏的位置
+func (w *WAL) quarantine(name string, at int64, cause error, stats *WALRecoveryStats) {
+	now := time.Now()
+	r := walRetry{FirstFailure: now, NextAt: now, LastError: fmt.Sprintf("段内容损坏（偏移 %d）: %v", at, cause)}
+	if err := w.moveDeadLetter(name, r); err != nil {
+		w.logger.Error("移动损坏的 WAL 段到 dlq 失败，段保持不变", "segment", name, "error", err)
+		return
+	}
+	stats.QuarantinedSegments++
+	w.progressMu.Lock()
+	w.progress.DeadLettered++
+	w.progressMu.Unlock()
+	w.logger.Error("WAL 段内容损坏，已移入 dlq", "segment", name, "offset", at, "error", cause)
+}
+
+// recovered 记录并输出启动恢复的结果，截断或隔离了段时以警告级别输出
+func (w *WAL) recovered(stats WALRecoveryStats) {
+	w.progressMu.Lock()
+	w.recovery = stats
+	w.progressMu.Unlock()
+	log := w.logger.Info
+	if stats.TruncatedSegments > 0 || stats.QuarantinedSegments > 0 || stats.ResetCheckpoints > 0 {
+		log = w.logger.Warn
+	}
+	log("WAL 启动恢复完成",
+		"segments", stats.Segments,
+		"bytes", stats.Bytes,
+		"recovered_segments", stats.RecoveredSegments,
+		"resumed_segments", stats.ResumedSegments,
+		"reset_checkpoints", stats.ResetCheckpoints,
+		"truncated_segments", stats.TruncatedSegments,
+		"quarantined_segments", stats.QuarantinedSegments,
+		"orphan_files", stats.OrphanFiles,
+		"duration_ms", stats.DurationMs,
+	)
+}
+
+// RecoveryStats 返回启动恢复的结果
+func (w *WAL) RecoveryStats() WALRecoveryStats {
+	w.progressMu.Lock()
+	defer w.progressMu.Unlock()
+	return w.recovery
+}
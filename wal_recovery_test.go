@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestWALVerify(t *testing.T) {
+	const seg = "events-1700000000000000000"
+	zeros := strings.Repeat("\x00", 4096)
+
+	tests := []struct {
+		name        string
+		data        string
+		checkpoint  string // 为空时没有检查点
+		want        string // 验证后段的内容
+		removed     bool   // 段已删除
+		quarantined bool   // 段已移入 dlq
+		partial     string // dlq 中保存的截掉的内容
+		keepCkpt    bool   // 检查点保留
+		stats       WALRecoveryStats
+	}{
+		{
+			name:  "intact",
+			data:  "{\"a\":1}\n{\"b\":2}\n",
+			want:  "{\"a\":1}\n{\"b\":2}\n",
+			stats: WALRecoveryStats{Segments: 1, Bytes: 16},
+		},
+		{
+			name:    "torn tail",
+			data:    "{\"a\":1}\n{\"b\"",
+			want:    "{\"a\":1}\n",
+			partial: "{\"b\"",
+			stats:   WALRecoveryStats{Segments: 1, Bytes: 8, TruncatedSegments: 1, TruncatedBytes: 4},
+		},
+		{
+			name:    "zero-filled block",
+			data:    "{\"a\":1}\n" + zeros,
+			want:    "{\"a\":1}\n",
+			partial: zeros,
+			stats:   WALRecoveryStats{Segments: 1, Bytes: 8, TruncatedSegments: 1, TruncatedBytes: 4096},
+		},
+		{
+			name:    "torn tail before zero-filled block",
+			data:    "{\"a\":1}\n{\"b" + zeros,
+			want:    "{\"a\":1}\n",
+			partial: "{\"b" + zeros,
+			stats:   WALRecoveryStats{Segments: 1, Bytes: 8, TruncatedSegments: 1, TruncatedBytes: 4099},
+		},
+		{
+			name:       "no complete line",
+			data:       "{\"a\"",
+			checkpoint: "0",
+			removed:    true,
+			partial:    "{\"a\"",
+			stats:      WALRecoveryStats{TruncatedSegments: 1, TruncatedBytes: 4},
+		},
+		{
+			name:       "checkpoint on line boundary",
+			data:       "{\"a\":1}\n{\"b\":2}\n",
+			checkpoint: "8",
+			want:       "{\"a\":1}\n{\"b\":2}\n",
+			keepCkpt:   true,
+			stats:      WALRecoveryStats{Segments: 1, Bytes: 16, ResumedSegments: 1},
+		},
+		{
+			name:       "checkpoint past truncated end",
+			data:       "{\"a\":1}\n{\"b\"",
+			checkpoint: "12",
+			want:       "{\"a\":1}\n",
+			partial:    "{\"b\"",
+			stats:      WALRecoveryStats{Segments: 1, Bytes: 8, TruncatedSegments: 1, TruncatedBytes: 4, ResetCheckpoints: 1},
+		},
+		{
+			name:       "checkpoint inside a line",
+			data:       "{\"a\":1}\n{\"b\":2}\n",
+			checkpoint: "3",
+			want:       "{\"a\":1}\n{\"b\":2}\n",
+			stats:      WALRecoveryStats{Segments: 1, Bytes: 16, ResetCheckpoints: 1},
+		},
+		{
+			name:       "unparsable checkpoint",
+			data:       "{\"a\":1}\n",
+			checkpoint: "eight",
+			want:       "{\"a\":1}\n",
+			stats:      WALRecoveryStats{Segments: 1, Bytes: 8, ResetCheckpoints: 1},
+		},
+		{
+			name:        "corrupt line after checkpoint",
+			data:        "{\"a\":1}\nnot json\n",
+			quarantined: true,
+			stats:       WALRecoveryStats{QuarantinedSegments: 1},
+		},
+		{
+			name:       "corrupt line before checkpoint",
+			data:       "not json\n{\"a\":1}\n",
+			checkpoint: "9",
+			want:       "not json\n{\"a\":1}\n",
+			keepCkpt:   true,
+			stats:      WALRecoveryStats{Segments: 1, Bytes: 17, ResumedSegments: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := &WAL{store: walDirStore{dir: dir}, dir: dir, logger: discardLogger, retries: make(map[string]*walRetry)}
+			writeTestFile(t, filepath.Join(dir, seg+walSealedSuffix), tt.data)
+			if tt.checkpoint != "" {
+				writeTestFile(t, filepath.Join(dir, seg+walCheckpointSuffix), tt.checkpoint)
+			}
+
+			var stats WALRecoveryStats
+			if err := w.verify(&stats); err != nil {
+				t.Fatalf("verify() error = %v", err)
+			}
+			if stats != tt.stats {
+				t.Errorf("stats = %+v, want %+v", stats, tt.stats)
+			}
+
+			got, err := os.ReadFile(filepath.Join(dir, seg+walSealedSuffix))
+			switch {
+			case tt.removed || tt.quarantined:
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("segment still present (err = %v)", err)
+				}
+			case err != nil:
+				t.Fatalf("read segment: %v", err)
+			case string(got) != tt.want:
+				t.Errorf("segment = %q, want %q", got, tt.want)
+			}
+
+			_, err = os.Stat(filepath.Join(dir, seg+walCheckpointSuffix))
+			if kept := err == nil; kept != tt.keepCkpt {
+				t.Errorf("checkpoint kept = %v, want %v", kept, tt.keepCkpt)
+			}
+
+			dlq := filepath.Join(dir, walDLQDir)
+			partial, err := os.ReadFile(filepath.Join(dlq, seg+walPartialSuffix))
+			if tt.partial == "" && err == nil {
+				t.Errorf("unexpected partial %q in dlq", partial)
+			}
+			if tt.partial != "" && !bytes.Equal(partial, []byte(tt.partial)) {
+				t.Errorf("dlq partial = %q, want %q (err = %v)", partial, tt.partial, err)
+			}
+			if _, err := os.Stat(filepath.Join(dlq, seg+walSealedSuffix)); (err == nil) != tt.quarantined {
+				t.Errorf("segment in dlq = %v, want %v", err == nil, tt.quarantined)
+			}
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o640); err != nil {
+		t.Fatal(err)
+	}
+}
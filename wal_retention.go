@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WAL_RETENTION_POLICY 的取值：达到 WAL_RETENTION_MAX_BYTES 或 WAL_RETENTION_MAX_SEGMENTS 时的处理方式
+const (
+	walRetentionDropOldest   = "drop-oldest"   // 删除最早创建的段（dlq 和等待回放的段一起排序）
+	walRetentionRejectNewest = "reject-newest" // 拒绝新写入的事件，已有的段保留
+
+	walRetentionInterval = 10 * time.Second // 检查保留策略、重新统计积压的间隔
+)
+
+// 段过期的原因，/admin/stats 和指标的 reason
+const (
+	walExpiredAge      = "age"
+	walExpiredBytes    = "bytes"
+	walExpiredSegments = "segments"
+)
+
+// ErrWALFull WAL 已达到保留上限（WAL_RETENTION_POLICY=reject-newest），拒绝新写入
+var ErrWALFull = errors.New("WAL 已达到保留上限")
+
+// walRetention WAL 的保留策略，同时限制等待回放和 dlq 中的段，避免 Doris 长时间不可用时写满磁盘
+// 超过 maxAge 的段总是删除；超过字节数或段数上限时按 policy 删除最早的段或拒绝新写入。正在写入的段不删除
+type walRetention struct {
+	maxAge      time.Duration // 为 0 时不限制
+	maxBytes    int64         // 为 0 时不限制
+	maxSegments int           // 为 0 时不限制
+	policy      string
+}
+
+// WALRetentionStats 保留策略的执行情况，通过 /admin/stats 查看
+type WALRetentionStats struct {
+	Policy           string           `json:"policy"`
+	RetainedSegments int              `json:"retained_segments"` // 上次检查时的段数（等待回放、dlq 和正在写入的段），之后写入的部分按估算累加
+	RetainedBytes    int64            `json:"retained_bytes"`
+	ExpiredSegments  map[string]int64 `json:"expired_segments"` // 启动以来按原因（age、bytes、segments）删除的段数
+	ExpiredBytes     map[string]int64 `json:"expired_bytes"`
+	RejectedEvents   int64            `json:"rejected_events"` // 启动以来因达到上限被拒绝的事件数（reject-newest）
+}
+
+// loadWALRetention 读取 WAL_RETENTION_* 环境变量，都未设置时不限制
+func loadWALRetention() (walRetention, error) {
+	var r walRetention
+	var err error
+	if r.maxAge, err = envDuration("WAL_RETENTION_MAX_AGE", "0", time.Second, 0, 0); err != nil {
+		return r, err
+	}
+	if r.maxBytes, err = envByteSize("WAL_RETENTION_MAX_BYTES", "0", 1, 0, 0); err != nil {
+		return r, err
+	}
+	if r.maxSegments, err = strconv.Atoi(getEnv("WAL_RETENTION_MAX_SEGMENTS", "0")); err != nil || r.maxSegments < 0 {
+		return r, fmt.Errorf("WAL_RETENTION_MAX_SEGMENTS 无效: %q", getEnv("WAL_RETENTION_MAX_SEGMENTS", ""))
+	}
+	switch r.policy = getEnv("WAL_RETENTION_POLICY", walRetentionDropOldest); r.policy {
+	case walRetentionDropOldest:
+	case walRetentionRejectNewest:
+		if r.maxBytes == 0 && r.maxSegments == 0 {
+			return r, fmt.Errorf("WAL_RETENTION_POLICY=reject-newest 需要设置 WAL_RETENTION_MAX_BYTES 或 WAL_RETENTION_MAX_SEGMENTS")
+		}
+	default:
+		return r, fmt.Errorf("WAL_RETENTION_POLICY 无效: %q（可选 drop-oldest、reject-newest）", r.policy)
+	}
+	return r, nil
+}
+
+// enabled 是否设置了任一上限
+func (r walRetention) enabled() bool {
+	return r.maxAge > 0 || r.maxBytes > 0 || r.maxSegments > 0
+}
+
+// exceeded 判断积压是否超过字节数或段数上限，返回超过的上限对应的原因，未超过时返回空
+func (r walRetention) exceeded(bytes int64, segments int) string {
+	switch {
+	case r.maxBytes > 0 && bytes > r.maxBytes:
+		return walExpiredBytes
+	case r.maxSegments > 0 && segments > r.maxSegments:
+		return walExpiredSegments
+	}
+	return ""
+}
+
+// walSegmentTime 返回段的创建时间（段名中的纳秒时间戳）
+func walSegmentTime(name string) time.Time {
+	ts, _ := strconv.ParseInt(name[strings.LastIndex(name, "-")+1:], 10, 64)
+	return time.Unix(0, ts)
+}
+
+// rejectLocked 按 reject-newest 判断是否拒绝写入 size 字节（newSegment 为 true 时还需创建新段），调用方需持有 mu
+func (w *WAL) rejectLocked(size int64, newSegment bool, events int64) bool {
+	if w.retention.policy != walRetentionRejectNewest {
+		return false
+	}
+	segments := w.retainedSegments
+	if newSegment {
+		segments++
+	}
+	if w.retention.exceeded(w.retainedBytes+size, segments) == "" {
+		return false
+	}
+	w.progressMu.Lock()
+	w.retentionStats.RejectedEvents += events
+	w.progressMu.Unlock()
+	return true
+}
+
+// enforceRetention 删除超过 WAL_RETENTION_MAX_AGE 的段；drop-oldest 下积压超过字节数或段数上限时从最早的段开始删除。
+// 同时重新统计积压，供 reject-newest 判断新写入
+func (w *WAL) enforceRetention() {
+	if !w.retention.enabled() {
+		return
+	}
+	pending, err := w.sealedSegments()
+	if err != nil {
+		w.logger.Error("读取 WAL 段失败，跳过保留策略检查", "error", err)
+		return
+	}
+	dlq, err := w.store.listDeadLetter(walSealedSuffix)
+	if err != nil {
+		w.logger.Error("读取 WAL dlq 失败，跳过保留策略检查", "error", err)
+		return
+	}
+	type retainedSegment struct {
+		walFile
+		dlq     bool
+		created time.Time
+	}
+	segments := make([]retainedSegment, 0, len(pending)+len(dlq))
+	for _, f := range dlq {
+		segments = append(segments, retainedSegment{walFile: f, dlq: true, created: walSegmentTime(f.name)})
+	}
+	for _, f := range pending {
+		segments = append(segments, retainedSegment{walFile: f, created: walSegmentTime(f.name)})
+	}
+	slices.SortStableFunc(segments, func(a, b retainedSegment) int { return a.created.Compare(b.created) })
+
+	var bytes int64
+	for _, seg := range segments {
+		bytes += seg.size
+	}
+	count := len(segments)
+	w.mu.Lock()
+	for _, seg := range w.segments {
+		bytes += seg.size
+		count++
+	}
+	w.mu.Unlock()
+
+	now := time.Now()
+	for _, seg := range segments {
+		reason := ""
+		if w.retention.maxAge > 0 && now.Sub(seg.created) >= w.retention.maxAge {
+			reason = walExpiredAge
+		} else if w.retention.policy == walRetentionDropOldest {
+			reason = w.retention.exceeded(bytes, count)
+		}
+		if reason == "" {
+			// 段按创建时间排序，之后的段更新，积压也不会再超过上限
+			break
+		}
+		if w.expire(seg.name, seg.dlq, seg.size, reason) {
+			bytes -= seg.size
+			count--
+		}
+	}
+
+	w.mu.Lock()
+	w.retainedBytes, w.retainedSegments = bytes, count
+	w.mu.Unlock()
+}
+
+// expire 按保留策略删除一个段及其检查点和重新投递计划，返回是否已删除
+func (w *WAL) expire(name string, dlq bool, size int64, reason string) bool {
+	w.replayMu.Lock()
+	defer w.replayMu.Unlock()
+	remove := w.store.remove
+	if dlq {
+		remove = w.store.removeDeadLetter
+	}
+	if err := remove(name + walSealedSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		w.logger.Error("删除超出保留策略的 WAL 段失败", "segment", name, "dlq", dlq, "error", err)
+		return false
+	}
+	remove(name + walCheckpointSuffix)
+	if dlq {
+		remove(name + walRetrySuffix)
+	} else {
+		w.clearRetry(name)
+	}
+	w.progressMu.Lock()
+	if w.retentionStats.ExpiredSegments == nil {
+		w.retentionStats.ExpiredSegments, w.retentionStats.ExpiredBytes = make(map[string]int64), make(map[string]int64)
+	}
+	w.retentionStats.ExpiredSegments[reason]++
+	w.retentionStats.ExpiredBytes[reason] += size
+	w.progressMu.Unlock()
+	w.logger.Warn("WAL 段超出保留策略，已删除", "segment", name, "dlq", dlq, "reason", reason, "bytes", size, "created", walSegmentTime(name))
+	return true
+}
+
+// RetentionStats 返回保留策略的执行情况，未启用 WAL 或未设置上限时返回 nil
+func (w *WAL) RetentionStats() *WALRetentionStats {
+	if w == nil || !w.retention.enabled() {
+		return nil
+	}
+	w.mu.Lock()
+	bytes, segments := w.retainedBytes, w.retainedSegments
+	w.mu.Unlock()
+	w.progressMu.Lock()
+	defer w.progressMu.Unlock()
+	stats := w.retentionStats
+	stats.Policy = w.retention.policy
+	stats.RetainedBytes, stats.RetainedSegments = bytes, segments
+	stats.ExpiredSegments = make(map[string]int64, len(w.retentionStats.ExpiredSegments))
+	stats.ExpiredBytes = make(map[string]int64, len(w.retentionStats.ExpiredBytes))
+	for _, reason := range []string{walExpiredAge, walExpiredBytes, walExpiredSegments} {
+		stats.ExpiredSegments[reason] = w.retentionStats.ExpiredSegments[reason]
+		stats.ExpiredBytes[reason] = w.retentionStats.ExpiredBytes[reason]
+	}
+	return &stats
+}
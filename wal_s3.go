@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	walS3PartBytes    = 5 << 20         // 分段上传的分片大小，S3 要求除最后一个分片外不小于 5 MiB
+	walS3ListInterval = 5 * time.Second // 已封存段列表的缓存时间，避免每次查询积压都列出对象
+)
+
+// 对象存储中 WAL 的 key 前缀，位于 WAL_S3_PREFIX 之下；段和 dlq 按段的创建时间（UTC）以小时分区，
+// 可以按前缀配置生命周期规则（如 dlq/ 保留 30 天后删除）
+const (
+	walS3SegmentsPrefix = "segments" // 已封存的段：segments/{YYYY}/{MM}/{DD}/{HH}/{段名}.seg
+	walS3StatePrefix    = "state"    // 检查点和重新投递计划：state/{段名}.ckpt、state/{段名}.retry
+	walS3DLQPrefix      = "dlq"      // 移入 dlq 的段及其检查点和计划：dlq/{YYYY}/{MM}/{DD}/{HH}/{段名}.seg 等
+)
+
+// walS3Store S3 兼容对象存储（S3、MinIO、GCS 的 XML API）中的 WAL，用于只读根文件系统或临时 Pod
+// 正在写入的段保存在内存中，每满 5 MiB 以分段上传（multipart upload）的一个分片上传，封存时上传剩余部分并完成上传，
+// 完成之前对象不可见；进程崩溃时未封存的段（最近 WAL_SEGMENT_MAX_AGE 内写入的事件）丢失，
+// 遗留的未完成分段上传由存储桶的生命周期规则（AbortIncompleteMultipartUpload）清理
+type walS3Store struct {
+	endpoint *url.URL
+	signer   s3Signer
+	bucket   string
+	prefix   string // 为空或以 / 结尾
+	client   *http.Client
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	segments []walFile         // 已封存段的缓存
+	listedAt time.Time         // 为零时缓存失效
+	state    map[string][]byte // 检查点和重新投递计划的缓存（写穿），值为 nil 表示不存在
+}
+
+// loadWALS3Config 读取 WAL_S3_* 环境变量，不访问对象存储；未设置 WAL_S3_BUCKET 时返回 nil
+func loadWALS3Config(logger *slog.Logger) (*walS3Store, error) {
+	bucket := getEnv("WAL_S3_BUCKET", "")
+	if bucket == "" {
+		return nil, nil
+	}
+	endpoint, err := url.Parse(getEnv("WAL_S3_ENDPOINT", ""))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("WAL_S3_ENDPOINT 无效: %q", getEnv("WAL_S3_ENDPOINT", ""))
+	}
+	accessKey, secretKey := getEnv("WAL_S3_ACCESS_KEY", ""), getEnv("WAL_S3_SECRET_KEY", "")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("设置了 WAL_S3_BUCKET，需要设置 WAL_S3_ACCESS_KEY 和 WAL_S3_SECRET_KEY")
+	}
+	prefix := strings.Trim(getEnv("WAL_S3_PREFIX", ""), "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &walS3Store{
+		endpoint: endpoint,
+		signer:   s3Signer{region: getEnv("WAL_S3_REGION", "us-east-1"), accessKey: accessKey, secretKey: secretKey},
+		bucket:   bucket,
+		prefix:   prefix,
+		client:   &http.Client{Timeout: defaultTimeout},
+		logger:   logger,
+		state:    make(map[string][]byte),
+	}, nil
+}
+
+// open 列出已封存的段，检查存储桶可以访问
+func (s *walS3Store) open() error {
+	if _, err := s.list(walSealedSuffix); err != nil {
+		return fmt.Errorf("访问 WAL 对象存储失败: %w", err)
+	}
+	return nil
+}
+
+// recover 未封存的段只在内存中，没有需要封存的遗留段
+func (s *walS3Store) recover() (int, error) {
+	return 0, nil
+}
+
+func (s *walS3Store) create(name string) (walWriter, error) {
+	return &walS3Writer{store: s, name: name, key: s.key(walS3SegmentsPrefix, name+walSealedSuffix)}, nil
+}
+
+// key 返回文件的对象 key：段和 dlq 中的文件按段的创建时间以小时分区，检查点和计划位于 state/
+func (s *walS3Store) key(area, file string) string {
+	if area == walS3StatePrefix {
+		return s.prefix + area + "/" + file
+	}
+	name := file[:len(file)-len(path.Ext(file))]
+	ts, _ := strconv.ParseInt(name[strings.LastIndex(name, "-")+1:], 10, 64)
+	return s.prefix + area + "/" + time.Unix(0, ts).UTC().Format("2006/01/02/15") + "/" + file
+}
+
+// area 返回文件所在的前缀
+func (s *walS3Store) area(file string) string {
+	if strings.HasSuffix(file, walSealedSuffix) {
+		return walS3SegmentsPrefix
+	}
+	return walS3StatePrefix
+}
+
+func (s *walS3Store) list(suffix string) ([]walFile, error) {
+	if suffix != walSealedSuffix {
+		return s.listObjects(walS3StatePrefix, suffix)
+	}
+	s.mu.Lock()
+	if time.Since(s.listedAt) < walS3ListInterval {
+		segments := slices.Clone(s.segments)
+		s.mu.Unlock()
+		return segments, nil
+	}
+	s.mu.Unlock()
+
+	segments, err := s.listObjects(walS3SegmentsPrefix, suffix)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.segments, s.listedAt = segments, time.Now()
+	s.mu.Unlock()
+	return slices.Clone(segments), nil
+}
+
+// listObjects 列出前缀下后缀为 suffix 的对象，按段名排序
+func (s *walS3Store) listObjects(area, suffix string) ([]walFile, error) {
+	var files []walFile
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + area + "/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.request(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("解析 ListObjectsV2 响应失败: %w", err)
+		}
+		for _, obj := range result.Contents {
+			if base := path.Base(obj.Key); strings.HasSuffix(base, suffix) {
+				files = append(files, walFile{name: strings.TrimSuffix(base, suffix), size: obj.Size})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	slices.SortFunc(files, func(a, b walFile) int { return strings.Compare(a.name, b.name) })
+	return files, nil
+}
+
+func (s *walS3Store) read(file string) ([]byte, error) {
+	area := s.area(file)
+	if area == walS3StatePrefix {
+		s.mu.Lock()
+		data, ok := s.state[file]
+		s.mu.Unlock()
+		if ok && data == nil {
+			return nil, fs.ErrNotExist
+		}
+		if ok {
+			return data, nil
+		}
+	}
+	data, err := s.get(s.key(area, file))
+	if area == walS3StatePrefix && (err == nil || errors.Is(err, fs.ErrNotExist)) {
+		s.mu.Lock()
+		s.state[file] = data
+		s.mu.Unlock()
+	}
+	return data, err
+}
+
+// get 读取对象，不存在时返回 fs.ErrNotExist
+func (s *walS3Store) get(key string) ([]byte, error) {
+	resp, err := s.request(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// write 写入对象，对象存储的 PUT 是原子的
+func (s *walS3Store) write(file string, data []byte) error {
+	area := s.area(file)
+	if err := s.put(s.key(area, file), data); err != nil {
+		return err
+	}
+	s.updated(file, bytes.Clone(data))
+	return nil
+}
+
+func (s *walS3Store) put(key string, data []byte) error {
+	resp, err := s.request(http.MethodPut, key, nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// remove 删除对象，S3 删除不存在的对象同样成功
+func (s *walS3Store) remove(file string) error {
+	resp, err := s.request(http.MethodDelete, s.key(s.area(file), file), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	s.updated(file, nil)
+	return nil
+}
+
+// updated 更新缓存：检查点和计划写穿，段的列表失效
+func (s *walS3Store) updated(file string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.area(file) == walS3StatePrefix {
+		s.state[file] = data
+	} else {
+		s.listedAt = time.Time{}
+	}
+}
+
+// deadLetter 将对象复制到 dlq 后删除
+func (s *walS3Store) deadLetter(file string) error {
+	data, err := s.read(file)
+	if err != nil {
+		return err
+	}
+	if err := s.writeDeadLetter(file, data); err != nil {
+		return err
+	}
+	return s.remove(file)
+}
+
+func (s *walS3Store) writeDeadLetter(file string, data []byte) error {
+	return s.put(s.key(walS3DLQPrefix, file), data)
+}
+
+func (s *walS3Store) listDeadLetter(suffix string) ([]walFile, error) {
+	return s.listObjects(walS3DLQPrefix, suffix)
+}
+
+func (s *walS3Store) removeDeadLetter(file string) error {
+	resp, err := s.request(http.MethodDelete, s.key(walS3DLQPrefix, file), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// sub 返回位于子前缀中的存储，共用连接和凭证
+func (s *walS3Store) sub(name string) walStore {
+	return &walS3Store{
+		endpoint: s.endpoint,
+		signer:   s.signer,
+		bucket:   s.bucket,
+		prefix:   s.prefix + name + "/",
+		client:   s.client,
+		logger:   s.logger,
+		state:    make(map[string][]byte),
+	}
+}
+
+// request 发送签名的请求（path-style），key 为空时请求存储桶；对象不存在时返回 fs.ErrNotExist，其他非 2xx 响应返回错误
+func (s *walS3Store) request(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath = "/" + s.bucket + "/" + s3EscapePath(key)
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	s.signer.sign(req, body, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		resp.Body.Close()
+		cancel()
+		return nil, fs.ErrNotExist
+	}
+	if resp.StatusCode/100 != 2 {
+		defer cancel()
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 返回错误 [%d]: %s", resp.StatusCode, string(msg))
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose 关闭响应体时取消请求的 ctx
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// walS3Part 分段上传中已上传的分片
+type walS3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// walS3Writer 对象存储中正在写入的段
+type walS3Writer struct {
+	store    *walS3Store
+	name     string
+	key      string
+	size     int64
+	buf      []byte // 尚未上传的部分
+	uploadID string // 分段上传的 ID，未开始分段上传时为空
+	parts    []walS3Part
+	retryAt  time.Time // 分片上传失败后，此前不再尝试
+}
+
+// Write 追加到内存，未上传的部分达到分片大小时上传一个分片；上传失败时内容保留在内存中，稍后重试
+func (w *walS3Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	w.size += int64(len(p))
+	if len(w.buf) >= walS3PartBytes && !time.Now().Before(w.retryAt) {
+		if err := w.uploadPart(); err != nil {
+			w.retryAt = time.Now().Add(walSealRetryDelay)
+			w.store.logger.Warn("上传 WAL 段分片失败，稍后重试", "segment", w.name, "buffered", len(w.buf), "error", err)
+		}
+	}
+	return len(p), nil
+}
+
+// Sync 对象存储中的段封存之前不可见，不支持逐次同步
+func (w *walS3Writer) Sync() error {
+	return errors.New("对象存储中的 WAL 段不支持同步写入")
+}
+
+// uploadPart 将未上传的部分作为下一个分片上传，需要时先开始分段上传
+func (w *walS3Writer) uploadPart() error {
+	if w.uploadID == "" {
+		resp, err := w.store.request(http.MethodPost, w.key, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil || result.UploadID == "" {
+			return fmt.Errorf("解析 CreateMultipartUpload 响应失败: %v", err)
+		}
+		w.uploadID = result.UploadID
+	}
+	n := len(w.parts) + 1
+	resp, err := w.store.request(http.MethodPut, w.key, url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {w.uploadID}}, w.buf)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	w.parts = append(w.parts, walS3Part{PartNumber: n, ETag: resp.Header.Get("ETag")})
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// seal 上传段：没有上传过分片时直接上传整个对象，否则上传剩余部分并完成分段上传；
+// 失败时返回 errWALSealDeferred，已上传的分片保留，下次从失败的步骤继续
+func (w *walS3Writer) seal() error {
+	if err := w.complete(); err != nil {
+		return fmt.Errorf("%w: %v", errWALSealDeferred, err)
+	}
+	w.store.updated(w.name+walSealedSuffix, nil)
+	return nil
+}
+
+func (w *walS3Writer) complete() error {
+	if w.uploadID == "" {
+		return w.store.put(w.key, w.buf)
+	}
+	if len(w.buf) > 0 {
+		if err := w.uploadPart(); err != nil {
+			return err
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name    `xml:"CompleteMultipartUpload"`
+		Parts   []walS3Part `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	resp, err := w.store.request(http.MethodPost, w.key, url.Values{"uploadId": {w.uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// CompleteMultipartUpload 可能在返回 200 之后才在响应体中报告错误
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("解析 CompleteMultipartUpload 响应失败: %w", err)
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("完成分段上传失败: %s: %s", result.Code, result.Message)
+	}
+	return nil
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// walSQLiteDriver SQLite 驱动名，由 wal_sqlite_driver.go 在 sqlite 构建中注册
+const walSQLiteDriver = "sqlite"
+
+// 段的状态
+const (
+	walStateOpen   = "open"   // 正在写入
+	walStateSealed = "sealed" // 已封存，等待回放
+	walStateDLQ    = "dlq"    // 已移入 dlq
+)
+
+const walSQLiteSchema = `
+CREATE TABLE IF NOT EXISTS wal_segments (
+	id    INTEGER PRIMARY KEY AUTOINCREMENT,
+	scope TEXT NOT NULL, -- 子 WAL（副本积压 replica-{集群名}），主 WAL 为空
+	name  TEXT NOT NULL, -- 段名 {表名}-{纳秒时间戳}
+	tbl   TEXT NOT NULL,
+	state TEXT NOT NULL, -- open、sealed、dlq
+	UNIQUE (scope, name)
+);
+CREATE TABLE IF NOT EXISTS wal_events (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	segment_id  INTEGER NOT NULL,
+	pos         INTEGER NOT NULL, -- 在段内的字节偏移，与检查点比较
+	received_at INTEGER NOT NULL, -- 写入时间（Unix 纳秒）
+	line        BLOB NOT NULL     -- 一行 NDJSON，含换行符；配置了加密密钥时为加密行
+);
+CREATE INDEX IF NOT EXISTS wal_events_segment ON wal_events (segment_id, id);
+CREATE TABLE IF NOT EXISTS wal_files (
+	scope TEXT NOT NULL,
+	dlq   INTEGER NOT NULL,
+	name  TEXT NOT NULL, -- {段名}.ckpt、{段名}.retry
+	data  BLOB NOT NULL,
+	PRIMARY KEY (scope, dlq, name)
+);`
+
+// walSQLiteStore 嵌入式 SQLite 数据库中的 WAL：每个事件一行，段、检查点和重新投递计划与其他存储相同，
+// 因此可以精确统计每个表未提交的事件数和最早的写入时间，并按条件清理或重新回放（/admin/wal）
+// 每次追加在一个事务中提交（synchronous=FULL），与本地段的 fsync 相同，支持请求日志
+type walSQLiteStore struct {
+	db    *sql.DB
+	dir   string // 数据库文件所在的目录
+	scope string
+}
+
+// loadWALSQLiteConfig 按 WAL_SQLITE_PATH 打开数据库句柄，不连接数据库；未设置时返回 nil
+func loadWALSQLiteConfig() (*walSQLiteStore, error) {
+	path := getEnv("WAL_SQLITE_PATH", "")
+	if path == "" {
+		return nil, nil
+	}
+	if !slices.Contains(sql.Drivers(), walSQLiteDriver) {
+		return nil, fmt.Errorf("WAL_SQLITE_PATH 需要以 go build -tags sqlite 构建")
+	}
+	dsn := "file:" + path + "?" + url.Values{"_pragma": {"journal_mode(WAL)", "synchronous(FULL)", "busy_timeout(5000)"}}.Encode()
+	db, err := sql.Open(walSQLiteDriver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("WAL_SQLITE_PATH 无效: %w", err)
+	}
+	// SQLite 同一时间只有一个写入方，单个连接避免 SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	return &walSQLiteStore{db: db, dir: filepath.Dir(path)}, nil
+}
+
+// open 创建数据库文件所在的目录和表
+func (s *walSQLiteStore) open() error {
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return fmt.Errorf("创建 WAL 目录失败: %w", err)
+	}
+	if _, err := s.db.Exec(walSQLiteSchema); err != nil {
+		return fmt.Errorf("初始化 WAL 数据库失败: %w", err)
+	}
+	return nil
+}
+
+// recover 封存上次运行遗留的正在写入的段
+func (s *walSQLiteStore) recover() (int, error) {
+	res, err := s.db.Exec(`UPDATE wal_segments SET state = ? WHERE scope = ? AND state = ?`, walStateSealed, s.scope, walStateOpen)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *walSQLiteStore) create(name string) (walWriter, error) {
+	res, err := s.db.Exec(`INSERT INTO wal_segments (scope, name, tbl, state) VALUES (?, ?, ?, ?)`,
+		s.scope, name, walSegmentTable(name), walStateOpen)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &walSQLiteWriter{store: s, id: id}, nil
+}
+
+func (s *walSQLiteStore) list(suffix string) ([]walFile, error) {
+	return s.listFiles(suffix, false)
+}
+
+func (s *walSQLiteStore) listDeadLetter(suffix string) ([]walFile, error) {
+	return s.listFiles(suffix, true)
+}
+
+// listFiles 列出等待回放（dlq 为 false）或 dlq 中的段、检查点或计划
+func (s *walSQLiteStore) listFiles(suffix string, dlq bool) ([]walFile, error) {
+	var rows *sql.Rows
+	var err error
+	if suffix == walSealedSuffix {
+		state := walStateSealed
+		if dlq {
+			state = walStateDLQ
+		}
+		rows, err = s.db.Query(`SELECT s.name, COALESCE(SUM(LENGTH(e.line)), 0) FROM wal_segments s
+			LEFT JOIN wal_events e ON e.segment_id = s.id
+			WHERE s.scope = ? AND s.state = ? GROUP BY s.id ORDER BY s.name`, s.scope, state)
+	} else {
+		rows, err = s.db.Query(`SELECT name, LENGTH(data) FROM wal_files WHERE scope = ? AND dlq = ? AND name LIKE ? ORDER BY name`,
+			s.scope, dlq, "%"+suffix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []walFile
+	for rows.Next() {
+		var f walFile
+		if err := rows.Scan(&f.name, &f.size); err != nil {
+			return nil, err
+		}
+		f.name = strings.TrimSuffix(f.name, suffix)
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+func (s *walSQLiteStore) read(file string) ([]byte, error) {
+	name, isSegment := strings.CutSuffix(file, walSealedSuffix)
+	if !isSegment {
+		var data []byte
+		err := s.db.QueryRow(`SELECT data FROM wal_files WHERE scope = ? AND dlq = 0 AND name = ?`, s.scope, file).Scan(&data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fs.ErrNotExist
+		}
+		return data, err
+	}
+	var id int64
+	err := s.db.QueryRow(`SELECT id FROM wal_segments WHERE scope = ? AND name = ? AND state = ?`, s.scope, name, walStateSealed).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT line FROM wal_events WHERE segment_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var data bytes.Buffer
+	for rows.Next() {
+		var line []byte
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		data.Write(line)
+	}
+	return data.Bytes(), rows.Err()
+}
+
+func (s *walSQLiteStore) write(file string, data []byte) error {
+	return s.writeFile(file, data, false)
+}
+
+func (s *walSQLiteStore) writeFile(file string, data []byte, dlq bool) error {
+	_, err := s.db.Exec(`INSERT INTO wal_files (scope, dlq, name, data) VALUES (?, ?, ?, ?)
+		ON CONFLICT (scope, dlq, name) DO UPDATE SET data = excluded.data`, s.scope, dlq, file, data)
+	return err
+}
+
+func (s *walSQLiteStore) remove(file string) error {
+	return s.removeFile(file, false)
+}
+
+func (s *walSQLiteStore) removeDeadLetter(file string) error {
+	return s.removeFile(file, true)
+}
+
+// removeFile 删除等待回放（dlq 为 false）或 dlq 中的段（含其事件）、检查点或计划
+func (s *walSQLiteStore) removeFile(file string, dlq bool) error {
+	name, isSegment := strings.CutSuffix(file, walSealedSuffix)
+	if !isSegment {
+		_, err := s.db.Exec(`DELETE FROM wal_files WHERE scope = ? AND dlq = ? AND name = ?`, s.scope, dlq, file)
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM wal_events WHERE segment_id IN (SELECT id FROM wal_segments WHERE scope = ? AND name = ? AND (state = ?) = ?)`,
+		s.scope, name, walStateDLQ, dlq); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM wal_segments WHERE scope = ? AND name = ? AND (state = ?) = ?`, s.scope, name, walStateDLQ, dlq); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *walSQLiteStore) deadLetter(file string) error {
+	var res sql.Result
+	var err error
+	if name, isSegment := strings.CutSuffix(file, walSealedSuffix); isSegment {
+		res, err = s.db.Exec(`UPDATE wal_segments SET state = ? WHERE scope = ? AND name = ? AND state = ?`, walStateDLQ, s.scope, name, walStateSealed)
+	} else {
+		res, err = s.db.Exec(`INSERT OR REPLACE INTO wal_files (scope, dlq, name, data)
+			SELECT scope, 1, name, data FROM wal_files WHERE scope = ? AND dlq = 0 AND name = ?`, s.scope, file)
+		if err == nil {
+			_, err = s.db.Exec(`DELETE FROM wal_files WHERE scope = ? AND dlq = 0 AND name = ?`, s.scope, file)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+func (s *walSQLiteStore) writeDeadLetter(file string, data []byte) error {
+	return s.writeFile(file, data, true)
+}
+
+// sub 返回同一数据库中以 scope 区分的子 WAL
+func (s *walSQLiteStore) sub(name string) walStore {
+	return &walSQLiteStore{db: s.db, dir: s.dir, scope: filepath.Join(s.scope, name)}
+}
+
+// walSQLiteWriter SQLite 中正在写入的段
+type walSQLiteWriter struct {
+	store *walSQLiteStore
+	id    int64
+	size  int64
+}
+
+// Write 在一个事务中逐行插入事件，提交后即已持久化
+func (w *walSQLiteWriter) Write(p []byte) (int, error) {
+	tx, err := w.store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO wal_events (segment_id, pos, received_at, line) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	now, pos := time.Now().UnixNano(), w.size
+	for rest := p; len(rest) > 0; {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = rest[:i+1], rest[i+1:]
+		} else {
+			rest = nil
+		}
+		if _, err := stmt.Exec(w.id, pos, now, line); err != nil {
+			return 0, err
+		}
+		pos += int64(len(line))
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	w.size = pos
+	return len(p), nil
+}
+
+// Sync 每次写入都已提交
+func (w *walSQLiteWriter) Sync() error {
+	return nil
+}
+
+func (w *walSQLiteWriter) seal() error {
+	_, err := w.store.db.Exec(`UPDATE wal_segments SET state = ? WHERE id = ?`, walStateSealed, w.id)
+	return err
+}
+
+// WALTableStats 一个表在 SQLite WAL 中的积压，通过 GET /admin/wal 查看
+type WALTableStats struct {
+	Table           string     `json:"table"`
+	PendingEvents   int64      `json:"pending_events"` // 等待回放的事件数（含正在写入的段，不含已提交到检查点的部分）
+	PendingBytes    int64      `json:"pending_bytes"`
+	PendingSegments int        `json:"pending_segments"`
+	OldestPending   *time.Time `json:"oldest_pending,omitempty"` // 最早的等待回放的事件的写入时间
+	DLQEvents       int64      `json:"dlq_events"`
+	DLQSegments     int        `json:"dlq_segments"`
+}
+
+// stats 按表统计积压，已提交到检查点的事件不计入
+func (s *walSQLiteStore) stats() ([]WALTableStats, error) {
+	rows, err := s.db.Query(`SELECT s.tbl, s.state = ?, COUNT(DISTINCT s.id), COUNT(*), SUM(LENGTH(e.line)), MIN(e.received_at)
+		FROM wal_segments s
+		JOIN wal_events e ON e.segment_id = s.id
+		LEFT JOIN wal_files f ON f.scope = s.scope AND f.dlq = (s.state = ?) AND f.name = s.name || ?
+		WHERE s.scope = ? AND e.pos >= COALESCE(CAST(f.data AS INTEGER), 0)
+		GROUP BY 1, 2 ORDER BY 1`, walStateDLQ, walStateDLQ, walCheckpointSuffix, s.scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []WALTableStats
+	for rows.Next() {
+		var table string
+		var dlq bool
+		var segments int
+		var events, size, oldest int64
+		if err := rows.Scan(&table, &dlq, &segments, &events, &size, &oldest); err != nil {
+			return nil, err
+		}
+		if len(stats) == 0 || stats[len(stats)-1].Table != table {
+			stats = append(stats, WALTableStats{Table: table})
+		}
+		ts := &stats[len(stats)-1]
+		if dlq {
+			ts.DLQEvents, ts.DLQSegments = events, segments
+			continue
+		}
+		t := time.Unix(0, oldest)
+		ts.PendingEvents, ts.PendingBytes, ts.PendingSegments, ts.OldestPending = events, size, segments, &t
+	}
+	return stats, rows.Err()
+}
+
+// walFilter /admin/wal/purge 和 /admin/wal/replay 的条件，为空的字段不限制
+type walFilter struct {
+	Table   string    `json:"table"`
+	Segment string    `json:"segment"`
+	From    time.Time `json:"from"`    // 写入时间不早于
+	To      time.Time `json:"to"`      // 写入时间早于
+	DLQ     bool      `json:"dlq"`     // 作用于 dlq 中的段，否则作用于等待回放的段
+	DryRun  bool      `json:"dry_run"` // 只统计，不修改
+}
+
+// timeRange 返回写入时间的范围 [from, to)，单位纳秒
+func (f walFilter) timeRange() (int64, int64) {
+	from, to := int64(0), int64(1<<63-1)
+	if !f.From.IsZero() {
+		from = f.From.UnixNano()
+	}
+	if !f.To.IsZero() {
+		to = f.To.UnixNano()
+	}
+	return from, to
+}
+
+// walMatch 条件匹配的一个段
+type walMatch struct {
+	id     int64
+	name   string
+	table  string
+	events int64 // 写入时间在范围内、尚未提交的事件数
+}
+
+// match 返回满足条件且有尚未提交的事件在时间范围内的段
+func (s *walSQLiteStore) match(tx *sql.Tx, f walFilter) ([]walMatch, error) {
+	state := walStateSealed
+	if f.DLQ {
+		state = walStateDLQ
+	}
+	from, to := f.timeRange()
+	rows, err := tx.Query(`SELECT s.id, s.name, s.tbl, COUNT(*)
+		FROM wal_segments s
+		JOIN wal_events e ON e.segment_id = s.id
+		LEFT JOIN wal_files f ON f.scope = s.scope AND f.dlq = ? AND f.name = s.name || ?
+		WHERE s.scope = ? AND s.state = ? AND (? = '' OR s.tbl = ?) AND (? = '' OR s.name = ?)
+			AND e.pos >= COALESCE(CAST(f.data AS INTEGER), 0) AND e.received_at >= ? AND e.received_at < ?
+		GROUP BY s.id ORDER BY s.name`,
+		f.DLQ, walCheckpointSuffix, s.scope, state, f.Table, f.Table, f.Segment, f.Segment, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var matches []walMatch
+	for rows.Next() {
+		var m walMatch
+		if err := rows.Scan(&m.id, &m.name, &m.table, &m.events); err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// purge 删除满足条件且尚未提交的事件，返回删除的事件数和涉及的段名
+// 段内的偏移和回放 label 随内容改变，清理后的段连同已提交的部分一起重写为新段名（删除检查点和重新投递计划），
+// 避免以旧 label 回放不同的内容；没有剩余事件的段直接删除
+func (s *walSQLiteStore) purge(f walFilter) (int64, []string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+	matches, err := s.match(tx, f)
+	if err != nil {
+		return 0, nil, err
+	}
+	var events int64
+	for _, m := range matches {
+		events += m.events
+	}
+	names := walMatchNames(matches)
+	if f.DryRun || len(matches) == 0 {
+		return events, names, nil
+	}
+
+	from, to := f.timeRange()
+	next := time.Now().UnixNano()
+	for _, m := range matches {
+		var checkpoint int64
+		var raw []byte
+		if err := tx.QueryRow(`SELECT data FROM wal_files WHERE scope = ? AND dlq = ? AND name = ?`, s.scope, f.DLQ, m.name+walCheckpointSuffix).Scan(&raw); err == nil {
+			checkpoint, _ = strconv.ParseInt(string(raw), 10, 64)
+		}
+		// 已提交的部分和满足条件的事件
+		if _, err := tx.Exec(`DELETE FROM wal_events WHERE segment_id = ? AND (pos < ? OR (received_at >= ? AND received_at < ?))`,
+			m.id, checkpoint, from, to); err != nil {
+			return 0, nil, err
+		}
+		if _, err := tx.Exec(`DELETE FROM wal_files WHERE scope = ? AND dlq = ? AND name IN (?, ?)`,
+			s.scope, f.DLQ, m.name+walCheckpointSuffix, m.name+walRetrySuffix); err != nil {
+			return 0, nil, err
+		}
+		if err := s.rewrite(tx, m, &next); err != nil {
+			return 0, nil, err
+		}
+	}
+	return events, names, tx.Commit()
+}
+
+// rewrite 以新段名重写段内剩余的事件并重新计算偏移，没有剩余事件时删除段
+func (s *walSQLiteStore) rewrite(tx *sql.Tx, m walMatch, next *int64) error {
+	rows, err := tx.Query(`SELECT id, LENGTH(line) FROM wal_events WHERE segment_id = ? ORDER BY id`, m.id)
+	if err != nil {
+		return err
+	}
+	type event struct{ id, size int64 }
+	var remaining []event
+	for rows.Next() {
+		var e event
+		if err := rows.Scan(&e.id, &e.size); err != nil {
+			rows.Close()
+			return err
+		}
+		remaining = append(remaining, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(remaining) == 0 {
+		_, err := tx.Exec(`DELETE FROM wal_segments WHERE id = ?`, m.id)
+		return err
+	}
+
+	*next++
+	if _, err := tx.Exec(`UPDATE wal_segments SET name = ? WHERE id = ?`, fmt.Sprintf("%s-%019d", m.table, *next), m.id); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`UPDATE wal_events SET pos = ? WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	var pos int64
+	for _, e := range remaining {
+		if _, err := stmt.Exec(pos, e.id); err != nil {
+			return err
+		}
+		pos += e.size
+	}
+	return nil
+}
+
+// restore 将满足条件的 dlq 中的段移回等待回放（保留检查点，删除重新投递计划），
+// 对等待回放的段删除重新投递计划；返回涉及的段名
+func (s *walSQLiteStore) restore(f walFilter) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	matches, err := s.match(tx, f)
+	if err != nil || f.DryRun {
+		return walMatchNames(matches), err
+	}
+	for _, m := range matches {
+		if _, err := tx.Exec(`DELETE FROM wal_files WHERE scope = ? AND name = ?`, s.scope, m.name+walRetrySuffix); err != nil {
+			return nil, err
+		}
+		if !f.DLQ {
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE wal_segments SET state = ? WHERE id = ?`, walStateSealed, m.id); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO wal_files (scope, dlq, name, data)
+			SELECT scope, 0, name, data FROM wal_files WHERE scope = ? AND dlq = 1 AND name = ?`, s.scope, m.name+walCheckpointSuffix); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`DELETE FROM wal_files WHERE scope = ? AND dlq = 1 AND name = ?`, s.scope, m.name+walCheckpointSuffix); err != nil {
+			return nil, err
+		}
+	}
+	return walMatchNames(matches), tx.Commit()
+}
+
+func walMatchNames(matches []walMatch) []string {
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+// Queryable 返回 WAL 是否保存在 SQLite 中，支持 /admin/wal 的查询、清理和重新回放
+func (w *WAL) Queryable() bool {
+	_, ok := w.store.(*walSQLiteStore)
+	return ok
+}
+
+// Purge 按条件清理尚未提交的事件；等待回放的段先封存正在写入的段，正在回放的段完成本轮回放后执行
+func (w *WAL) Purge(f walFilter) (int64, []string, error) {
+	if !f.DLQ && !f.DryRun {
+		if err := w.Close(); err != nil {
+			return 0, nil, err
+		}
+	}
+	w.replayMu.Lock()
+	defer w.replayMu.Unlock()
+	events, names, err := w.store.(*walSQLiteStore).purge(f)
+	if err == nil && !f.DryRun {
+		for _, name := range names {
+			w.clearRetry(name)
+		}
+	}
+	return events, names, err
+}
+
+// Restore 按条件将 dlq 中的段移回等待回放，或让等待重新投递的段在下一轮立即回放
+func (w *WAL) Restore(f walFilter) ([]string, error) {
+	w.replayMu.Lock()
+	defer w.replayMu.Unlock()
+	names, err := w.store.(*walSQLiteStore).restore(f)
+	if err == nil && !f.DryRun {
+		for _, name := range names {
+			w.clearRetry(name)
+		}
+	}
+	return names, err
+}
+
+// walAdminHandler GET /admin/wal：按表返回 SQLite WAL 中等待回放和 dlq 中的事件数、字节数及最早的写入时间
+func (app *App) walAdminHandler(c *gin.Context) {
+	stats, err := app.wal.store.(*walSQLiteStore).stats()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to query WAL", gin.H{"error": err.Error()})
+		return
+	}
+	if stats == nil {
+		stats = []WALTableStats{}
+	}
+	c.JSON(http.StatusOK, gin.H{"tables": stats})
+}
+
+// bindWALFilter 解析清理和重新回放的条件，清理需要指定 table 或 segment
+func bindWALFilter(c *gin.Context, requireTarget bool) (walFilter, bool) {
+	var f walFilter
+	if err := c.ShouldBindJSON(&f); err != nil || requireTarget && f.Table == "" && f.Segment == "" {
+		abortWithError(c, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request body: table or segment is required, from/to must be RFC3339", nil)
+		return f, false
+	}
+	return f, true
+}
+
+// walPurgeHandler POST /admin/wal/purge：按表、段和写入时间清理尚未提交的事件，dry_run 时只返回将被清理的事件数
+func (app *App) walPurgeHandler(c *gin.Context) {
+	f, ok := bindWALFilter(c, true)
+	if !ok {
+		return
+	}
+	events, segments, err := app.wal.Purge(f)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to purge WAL", gin.H{"error": err.Error()})
+		return
+	}
+	if !f.DryRun {
+		app.logger.Warn("已清理 WAL 中的事件", "table", f.Table, "segment", f.Segment, "from", f.From, "to", f.To, "dlq", f.DLQ, "events", events, "segments", len(segments))
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events, "segments": segments, "dry_run": f.DryRun})
+}
+
+// walReplayHandler POST /admin/wal/replay：dlq=true 时将满足条件的 dlq 中的段移回等待回放，否则取消等待回放的段的重新投递计划，下一轮立即回放
+func (app *App) walReplayHandler(c *gin.Context) {
+	f, ok := bindWALFilter(c, false)
+	if !ok {
+		return
+	}
+	segments, err := app.wal.Restore(f)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, errCodeInternal, "Failed to replay WAL", gin.H{"error": err.Error()})
+		return
+	}
+	if !f.DryRun {
+		app.logger.Info("已安排重新回放 WAL 段", "table", f.Table, "segment", f.Segment, "dlq", f.DLQ, "segments", len(segments))
+	}
+	c.JSON(http.StatusOK, gin.H{"segments": segments, "dry_run": f.DryRun})
+}
@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package main
+
+// SQLite WAL（WAL_SQLITE_PATH）使用纯 Go 的 SQLite 驱动，不需要 cgo；默认构建不包含，以 go build -tags sqlite 构建
+import _ "modernc.org/sqlite"
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"doris-webhook/dorisload"
+)
+
+// Watermarks 各目标表已提交事件的最大事件时间（WATERMARKS_ENABLED=true），通过 /admin/watermarks 查看，
+// 下游批处理据此判断数据是否完整，而不是按固定延迟猜测
+// 只统计本实例提交的写入（含批量写入、WAL 回放、原子批量写入和文件导入），进程重启后从零开始
+type Watermarks struct {
+	columns map[string]string // 表名到事件时间列
+	since   time.Time
+
+	mu     sync.Mutex
+	tables map[string]*tableWatermark
+}
+
+// tableWatermark 单张表的水位
+type tableWatermark struct {
+	maxEventTime  string // dorisDatetimeFormat 格式，定长，可直接按字符串比较
+	committedAt   time.Time
+	committedRows int64
+}
+
+// TableWatermark /admin/watermarks 中单张表的水位
+type TableWatermark struct {
+	Column        string `json:"column"`                   // 事件时间列
+	MaxEventTime  string `json:"max_event_time,omitempty"` // 已提交事件的最大事件时间，尚无提交时为空
+	CommittedAt   string `json:"committed_at,omitempty"`   // 最近一次提交的时间
+	CommittedRows int64  `json:"committed_rows"`           // 启动以来提交的行数
+	WALPending    int    `json:"wal_pending_segments"`     // 等待回放的 WAL 段数，未启用 WAL 时为 0
+	Queued        int    `json:"queued_events"`            // 批量写入队列中等待的事件数，未启用批量写入时为 0
+	Pending       bool   `json:"pending"`                  // 是否还有已接收但尚未提交的事件（WAL 或批量写入队列中）
+}
+
+// newWatermarks 根据环境变量创建水位统计，未设置 WATERMARKS_ENABLED=true 时返回 nil
+// 每张表的事件时间列：迟到事件路由的列，否则为第一个来自请求体的 datetime 列，否则为第一个 ingest_time 列；没有 datetime 列的表不统计
+func newWatermarks(reg *Registry) *Watermarks {
+	if getEnv("WATERMARKS_ENABLED", "false") != "true" {
+		return nil
+	}
+	w := &Watermarks{
+		columns: make(map[string]string),
+		since:   time.Now(),
+		tables:  make(map[string]*tableWatermark),
+	}
+	bind := func(table *dorisload.Table, column string) {
+		if table != nil && column != "" && w.columns[table.Name] == "" {
+			w.columns[table.Name] = column
+		}
+	}
+	for _, ep := range reg.Endpoints {
+		if !ep.WritesDoris() || ep.Rollup != nil {
+			continue
+		}
+		column := eventTimeColumn(ep.Columns)
+		if ep.Late != nil {
+			column = ep.Late.Column
+			bind(ep.Late.Table(), column)
+		}
+		bind(ep.Table(), column)
+		if ep.Migration != nil {
+			bind(ep.Migration.Table(), column)
+		}
+		for _, dw := range ep.DualWrite {
+			bind(dw.Table(), eventTimeColumn(dw.Columns))
+		}
+	}
+	return w
+}
+
+// eventTimeColumn 返回列映射中的事件时间列，没有 datetime 列时返回空
+func eventTimeColumn(columns []ColumnMapping) string {
+	ingest := ""
+	for _, m := range columns {
+		switch {
+		case m.Type == typeDatetime && (m.Source == sourceBody || m.Source == sourceOriginalTimestamp):
+			return m.Column
+		case m.Source == sourceIngestTime && ingest == "":
+			ingest = m.Column
+		}
+	}
+	return ingest
+}
+
+// Observe 记录已提交的 NDJSON 数据，data 为本服务序列化的行（键值之间没有空格）
+func (w *Watermarks) Observe(table *dorisload.Table, data []byte) {
+	if w == nil {
+		return
+	}
+	column, ok := w.columns[table.Name]
+	if !ok {
+		return
+	}
+	key := []byte(`"` + column + `":"`)
+	maxTime := ""
+	var rows int64
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if len(line) == 0 {
+			continue
+		}
+		rows++
+		i := bytes.Index(line, key)
+		if i < 0 {
+			continue
+		}
+		value := line[i+len(key):]
+		if end := bytes.IndexByte(value, '"'); end == len(dorisDatetimeFormat) && string(value[:end]) > maxTime {
+			maxTime = string(value[:end])
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tw := w.tables[table.Name]
+	if tw == nil {
+		tw = &tableWatermark{}
+		w.tables[table.Name] = tw
+	}
+	tw.maxEventTime = max(tw.maxEventTime, maxTime)
+	tw.committedAt = time.Now()
+	tw.committedRows += rows
+}
+
+// watermarksHandler 返回各表的水位和尚未提交的事件
+func (app *App) watermarksHandler(c *gin.Context) {
+	w := app.watermarks
+	tables := make(map[string]TableWatermark, len(w.columns))
+	w.mu.Lock()
+	for name, column := range w.columns {
+		tw := TableWatermark{Column: column}
+		if s := w.tables[name]; s != nil {
+			tw.MaxEventTime = s.maxEventTime
+			tw.CommittedAt = s.committedAt.Format(dorisDatetimeFormat)
+			tw.CommittedRows = s.committedRows
+		}
+		tables[name] = tw
+	}
+	w.mu.Unlock()
+
+	for name, tw := range tables {
+		if app.wal != nil {
+			tw.WALPending = app.wal.PendingTable(name)
+		}
+		if b := app.batchers[name]; b != nil {
+			tw.Queued = b.QueueLength()
+		}
+		tw.Pending = tw.WALPending > 0 || tw.Queued > 0
+		tables[name] = tw
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"since":  w.since.Format(dorisDatetimeFormat),
+		"tables": tables,
+	})
+}